@@ -0,0 +1,108 @@
+package DomainSentinel
+
+import (
+    "fmt"
+    "net/netip"
+    "strings"
+)
+
+// ACL actions.
+const (
+    aclAllow = "allow"
+    aclDeny  = "deny"
+)
+
+// acl is an ordered allow/deny list, evaluated top-down; the first entry
+// matching the client decides.
+type acl struct {
+    entries      []aclEntry
+    defaultAllow bool // outcome when no entry matches
+}
+
+// aclEntry is a single "allow <target>" or "deny <target>" line. The target
+// is anything a source IP list accepts, or "all".
+type aclEntry struct {
+    text  string
+    allow bool
+    all   bool
+    list  *ipList
+}
+
+// compileACL parses the acl entries of a rule. It returns nil if there are none.
+func (c *compiler) compileACL(lines []string, defaultAction string) (*acl, error) {
+    var entries []string
+    for _, line := range lines {
+        entries = append(entries, splitListEntry(line)...)
+    }
+    if len(entries) == 0 {
+        if defaultAction != "" {
+            return nil, fmt.Errorf("aclDefault is set without acl entries")
+        }
+        return nil, nil
+    }
+
+    a := &acl{}
+    switch defaultAction {
+    case "", aclDeny:
+    case aclAllow:
+        a.defaultAllow = true
+    default:
+        return nil, fmt.Errorf("invalid aclDefault %q: must be %q or %q", defaultAction, aclAllow, aclDeny)
+    }
+
+    for i, line := range entries {
+        fields := strings.Fields(line)
+        if len(fields) != 2 {
+            return nil, fmt.Errorf("acl entry %d %q: must be \"allow <address>\" or \"deny <address>\"", i, line)
+        }
+        entry := aclEntry{text: fields[0] + " " + fields[1]}
+        switch strings.ToLower(fields[0]) {
+        case aclAllow:
+            entry.allow = true
+        case aclDeny:
+        default:
+            return nil, fmt.Errorf("acl entry %d %q: unknown action %q, must be %q or %q", i, line, fields[0], aclAllow, aclDeny)
+        }
+        if strings.EqualFold(fields[1], "all") {
+            entry.all = true
+        } else {
+            list, err := c.parseIPList(stringEntries([]string{fields[1]}))
+            if err != nil {
+                return nil, fmt.Errorf("acl entry %d %q: %w", i, line, err)
+            }
+            entry.list = list
+        }
+        a.entries = append(a.entries, entry)
+    }
+    return a, nil
+}
+
+// match returns the first entry matching ip, which must be normalized.
+func (a *acl) match(ip netip.Addr) (*aclEntry, bool) {
+    for i := range a.entries {
+        e := &a.entries[i]
+        if e.all || e.list.contains(ip) {
+            return e, true
+        }
+    }
+    return nil, false
+}
+
+// allowsAll reports whether an allow entry covers every IPv4 or IPv6 address.
+func (a *acl) allowsAll() bool {
+    for _, e := range a.entries {
+        if e.allow && (e.all || e.list.allowsAll()) {
+            return true
+        }
+    }
+    return false
+}
+
+// truncateIPv6 applies ipv6SubnetLength to the lists of all entries.
+func (a *acl) truncateIPv6(bits int) {
+    for _, e := range a.entries {
+        if e.list != nil {
+            e.list.truncateIPv6(bits)
+        }
+    }
+}
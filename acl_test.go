@@ -0,0 +1,71 @@
+package DomainSentinel
+
+import (
+    "context"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestACLFirstMatchWins(t *testing.T) {
+    handler := newTestSentinel(t, domainConfig("example.com", DomainConfig{
+        ACL: []string{
+            "allow 10.0.0.5/32",
+            "deny 10.0.0.0/24",
+            "allow 10.0.0.0/16",
+        },
+        PathRules: []PathConfig{
+            // The same entries in the other order: the broad allow comes
+            // first and shadows the deny.
+            {Path: "/reordered", ACL: []string{"allow 10.0.0.0/16", "deny 10.0.0.0/24"}},
+            {Path: "/open", ACL: []string{"deny 192.0.2.0/24"}, ACLDefault: aclAllow},
+            {Path: "/all", ACL: []string{"allow 10.0.0.5", "deny all", "allow 10.0.0.6"}},
+        },
+    }))
+    checkStatuses(t, handler, []statusCase{
+        {"http://example.com/", "10.0.0.5:1234", http.StatusOK},
+        {"http://example.com/", "10.0.0.6:1234", http.StatusForbidden},
+        {"http://example.com/", "10.0.1.6:1234", http.StatusOK},
+        {"http://example.com/", "10.1.0.1:1234", http.StatusForbidden}, // default deny
+        {"http://example.com/reordered", "10.0.0.6:1234", http.StatusOK},
+        {"http://example.com/open", "192.0.2.1:1234", http.StatusForbidden},
+        {"http://example.com/open", "198.51.100.1:1234", http.StatusOK},
+        {"http://example.com/all", "10.0.0.5:1234", http.StatusOK},
+        {"http://example.com/all", "10.0.0.6:1234", http.StatusForbidden},
+    })
+}
+
+func TestACLWithDeniedIPs(t *testing.T) {
+    handler := newTestSentinel(t, domainConfig("example.com", DomainConfig{
+        ACL:       []string{"allow 10.0.0.0/8"},
+        DeniedIPs: []string{"10.9.0.0/16"},
+    }))
+    checkStatuses(t, handler, []statusCase{
+        {"http://example.com/", "10.1.0.1:1234", http.StatusOK},
+        {"http://example.com/", "10.9.0.1:1234", http.StatusForbidden},
+    })
+}
+
+func TestACLErrors(t *testing.T) {
+    tests := []struct {
+        name string
+        rule DomainConfig
+        want string
+    }{
+        {"with sourceIPs", DomainConfig{SourceIPs: ips("10.0.0.1"), ACL: []string{"allow 10.0.0.2"}}, "acl"},
+        {"path rule with sourceIPs", DomainConfig{SourceIPs: ips("10.0.0.1"), PathRules: []PathConfig{
+            {Path: "/a", SourceIPs: ips("10.0.0.1"), ACL: []string{"allow 10.0.0.2"}},
+        }}, "acl"},
+        {"unknown action", DomainConfig{ACL: []string{"permit 10.0.0.1"}}, "unknown action"},
+        {"missing address", DomainConfig{ACL: []string{"allow"}}, `"allow <address>"`},
+        {"invalid address", DomainConfig{ACL: []string{"allow 10.0.0.300"}}, "acl entry 0"},
+        {"invalid default", DomainConfig{ACL: []string{"allow 10.0.0.1"}, ACLDefault: "maybe"}, "aclDefault"},
+        {"default without entries", DomainConfig{SourceIPs: ips("10.0.0.1"), ACLDefault: aclAllow}, "aclDefault"},
+    }
+    for _, tt := range tests {
+        _, err := New(context.Background(), okHandler, domainConfig("example.com", tt.rule), "test")
+        if err == nil || !strings.Contains(err.Error(), tt.want) {
+            t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+        }
+    }
+}
@@ -0,0 +1,227 @@
+package DomainSentinel
+
+import (
+    "fmt"
+    "net/netip"
+    "sort"
+)
+
+// overlap describes a redundant list entry: one that duplicates an earlier
+// entry, or whose networks are all covered by other entries.
+type overlap struct {
+    entry     int
+    coveredBy int
+    duplicate bool
+}
+
+// overlaps finds duplicate and fully shadowed entries. Ranges and hostnames
+// are only checked for exact duplicates.
+func (l *ipList) overlaps() []overlap {
+    var found []overlap
+    duplicate := make(map[int]bool)
+    seen := make(map[string]int, len(l.raw))
+    for i, raw := range l.raw {
+        if first, ok := seen[raw]; ok {
+            found = append(found, overlap{entry: i, coveredBy: first, duplicate: true})
+            duplicate[i] = true
+            continue
+        }
+        seen[raw] = i
+    }
+
+    // The networks each entry stands for; keywords expand to several.
+    prefixes := make(map[int][]netip.Prefix)
+    owner := make(map[netip.Prefix]int, len(l.nets))
+    for _, n := range l.nets {
+        prefixes[n.index] = append(prefixes[n.index], n.Prefix)
+        if _, ok := owner[n.Prefix]; !ok {
+            owner[n.Prefix] = n.index
+        }
+    }
+    for ip, index := range l.ips {
+        prefixes[index] = append(prefixes[index], netip.PrefixFrom(ip, ip.BitLen()))
+    }
+    for _, r := range l.ranges {
+        delete(prefixes, r.index)
+    }
+    for _, h := range l.hosts {
+        delete(prefixes, h.index)
+    }
+
+    for index, nets := range prefixes {
+        if duplicate[index] {
+            continue
+        }
+        coveredBy := -1
+        for _, p := range nets {
+            other, ok := coveringEntry(owner, p, index, duplicate)
+            if !ok {
+                coveredBy = -1
+                break
+            }
+            if coveredBy < 0 {
+                coveredBy = other
+            }
+        }
+        if coveredBy >= 0 {
+            found = append(found, overlap{entry: index, coveredBy: coveredBy})
+        }
+    }
+
+    sort.Slice(found, func(i, j int) bool { return found[i].entry < found[j].entry })
+    return found
+}
+
+// coveringEntry returns an entry other than index, and not a duplicate,
+// whose network contains p.
+func coveringEntry(owner map[netip.Prefix]int, p netip.Prefix, index int, duplicate map[int]bool) (int, bool) {
+    for bits := 0; bits <= p.Bits(); bits++ {
+        q, _ := p.Addr().Prefix(bits)
+        if other, ok := owner[q]; ok && other != index && !duplicate[other] {
+            return other, true
+        }
+    }
+    return 0, false
+}
+
+// aggregate collapses the networks and exact addresses of the list into the
+// minimal set of prefixes covering the same addresses: covered prefixes are
+// dropped and adjacent halves are merged into their parent. Entries with a
+// validUntil are left alone, so expiry behaves as before. The merged prefix
+// reports the entry of its first half in logs. It returns the number of
+// networks and addresses before and after.
+func (l *ipList) aggregate() (int, int) {
+    before := len(l.nets) + len(l.ips)
+
+    var keep []ipPrefix
+    var items []ipPrefix
+    for _, n := range l.nets {
+        if l.validUntil != nil && !l.validUntil[n.index].IsZero() {
+            keep = append(keep, n)
+            continue
+        }
+        items = append(items, n)
+    }
+    keepIPs := make(map[netip.Addr]int)
+    for ip, index := range l.ips {
+        if l.validUntil != nil && !l.validUntil[index].IsZero() {
+            keepIPs[ip] = index
+            continue
+        }
+        items = append(items, ipPrefix{Prefix: netip.PrefixFrom(ip, ip.BitLen()), index: index})
+    }
+
+    // Broader prefixes first, so every prefix is checked against the set of
+    // prefixes that can cover it; ties keep the lowest entry index.
+    sort.Slice(items, func(i, j int) bool {
+        if items[i].Bits() != items[j].Bits() {
+            return items[i].Bits() < items[j].Bits()
+        }
+        if c := items[i].Addr().Compare(items[j].Addr()); c != 0 {
+            return c < 0
+        }
+        return items[i].index < items[j].index
+    })
+    set := make(map[netip.Prefix]int, len(items))
+    for _, item := range items {
+        if !coveredBy(set, item.Prefix) {
+            set[item.Prefix] = item.index
+        }
+    }
+
+    // Merge sibling halves, longest prefixes first, so that merged parents
+    // are considered again one level up. Because covered prefixes are gone,
+    // a merged parent never overlaps another member.
+    var levels [129][]netip.Prefix
+    for p := range set {
+        levels[p.Bits()] = append(levels[p.Bits()], p)
+    }
+    for bits := 128; bits > 0; bits-- {
+        for _, p := range levels[bits] {
+            parent, _ := p.Addr().Prefix(bits - 1)
+            left, right := halves(parent)
+            li, lok := set[left]
+            ri, rok := set[right]
+            if !lok || !rok {
+                continue
+            }
+            delete(set, left)
+            delete(set, right)
+            if ri < li {
+                li = ri
+            }
+            set[parent] = li
+            levels[bits-1] = append(levels[bits-1], parent)
+        }
+    }
+
+    l.nets = keep
+    l.ips = keepIPs
+    for p, index := range set {
+        if p.IsSingleIP() {
+            if prev, dup := l.ips[p.Addr()]; !dup || index < prev {
+                l.ips[p.Addr()] = index
+            }
+            continue
+        }
+        l.nets = append(l.nets, ipPrefix{Prefix: p, index: index})
+    }
+    sort.Slice(l.nets, func(i, j int) bool { return l.nets[i].index < l.nets[j].index })
+    l.trie = newPrefixTrie(l.nets)
+    return before, len(l.nets) + len(l.ips)
+}
+
+// coveredBy reports whether set holds p or a prefix containing it.
+func coveredBy(set map[netip.Prefix]int, p netip.Prefix) bool {
+    for bits := 0; bits <= p.Bits(); bits++ {
+        q, _ := p.Addr().Prefix(bits)
+        if _, ok := set[q]; ok {
+            return true
+        }
+    }
+    return false
+}
+
+// halves returns the two prefixes one bit longer than parent.
+func halves(parent netip.Prefix) (netip.Prefix, netip.Prefix) {
+    bits := parent.Bits() + 1
+    b, offset := addrBits(parent.Addr())
+    b[(offset+bits-1)/8] |= 0x80 >> uint((offset+bits-1)%8)
+    upper := netip.AddrFrom16(b)
+    if parent.Addr().Is4() {
+        upper = upper.Unmap()
+    }
+    return netip.PrefixFrom(parent.Addr(), bits), netip.PrefixFrom(upper, bits)
+}
+
+// analyzeLists reports redundant entries in the IP lists of rule and, if
+// aggregation is enabled, collapses the lists. Findings are appended to
+// report, prefixed with where the rule is defined.
+func (c *compiler) analyzeLists(where string, rule *accessRule, report []string) []string {
+    lists := []struct {
+        name string
+        list *ipList
+    }{
+        {"sourceIPs", rule.sourceIPs},
+        {"deniedIPs", rule.deniedIPs},
+        {"exceptIPs", rule.exceptIPs},
+    }
+    for _, l := range lists {
+        for _, o := range l.list.overlaps() {
+            if o.duplicate {
+                report = append(report, fmt.Sprintf("%s%s: %s is a duplicate of entry %d",
+                    where, l.name, l.list.describe(o.entry), o.coveredBy))
+            } else {
+                report = append(report, fmt.Sprintf("%s%s: %s is covered by %s",
+                    where, l.name, l.list.describe(o.entry), l.list.describe(o.coveredBy)))
+            }
+        }
+        if c.aggregate {
+            if before, after := l.list.aggregate(); after < before {
+                report = append(report, fmt.Sprintf("%s%s: aggregated %d networks and addresses into %d",
+                    where, l.name, before, after))
+            }
+        }
+    }
+    return report
+}
@@ -0,0 +1,149 @@
+package DomainSentinel
+
+import (
+    "fmt"
+    "math/rand"
+    "net/http"
+    "net/netip"
+    "strings"
+    "testing"
+)
+
+// clusteredEntries returns n random list entries drawn from a few small
+// networks, so that duplicates, nested and adjacent prefixes are common.
+func clusteredEntries(rng *rand.Rand, n int) []string {
+    entries := make([]string, n)
+    for i := range entries {
+        if rng.Intn(2) == 0 {
+            addr := netip.AddrFrom4([4]byte{10, byte(rng.Intn(2)), byte(rng.Intn(4)), byte(rng.Intn(256))})
+            bits := 20 + rng.Intn(13)
+            entries[i] = netip.PrefixFrom(addr, bits).Masked().String()
+            continue
+        }
+        addr := netip.AddrFrom16([16]byte{0x20, 0x01, 0x0d, 0xb8, 15: byte(rng.Intn(256))})
+        bits := 112 + rng.Intn(17)
+        entries[i] = netip.PrefixFrom(addr, bits).Masked().String()
+    }
+    return entries
+}
+
+// probeAddrs returns addresses in and around the networks of
+// clusteredEntries.
+func probeAddrs(rng *rand.Rand, n int) []netip.Addr {
+    addrs := make([]netip.Addr, n)
+    for i := range addrs {
+        if rng.Intn(2) == 0 {
+            addrs[i] = netip.AddrFrom4([4]byte{10, byte(rng.Intn(3)), byte(rng.Intn(5)), byte(rng.Intn(256))})
+        } else {
+            addrs[i] = netip.AddrFrom16([16]byte{0x20, 0x01, 0x0d, 0xb8, 14: byte(rng.Intn(2)), 15: byte(rng.Intn(256))})
+        }
+    }
+    return addrs
+}
+
+// TestAggregateKeepsMatches checks the property aggregation relies on:
+// every address is matched by the aggregated list exactly if it is
+// matched by the original one.
+func TestAggregateKeepsMatches(t *testing.T) {
+    c, err := newCompiler(CreateConfig())
+    if err != nil {
+        t.Fatal(err)
+    }
+    rng := rand.New(rand.NewSource(1))
+    for round := 0; round < 200; round++ {
+        entries := clusteredEntries(rng, 1+rng.Intn(40))
+        original, err := c.buildIPList(stringEntries(entries))
+        if err != nil {
+            t.Fatal(err)
+        }
+        aggregated, err := c.buildIPList(stringEntries(entries))
+        if err != nil {
+            t.Fatal(err)
+        }
+        before, after := aggregated.aggregate()
+        if after > before {
+            t.Fatalf("%v: aggregated %d networks into %d", entries, before, after)
+        }
+        for _, ip := range probeAddrs(rng, 500) {
+            if got, want := aggregated.contains(ip), original.contains(ip); got != want {
+                t.Fatalf("%v: aggregated list contains(%s) = %v, original %v", entries, ip, got, want)
+            }
+        }
+    }
+}
+
+// TestAggregateDecisions compares the decisions of the middleware with and
+// without the aggregate option.
+func TestAggregateDecisions(t *testing.T) {
+    rng := rand.New(rand.NewSource(2))
+    for round := 0; round < 20; round++ {
+        rule := DomainConfig{
+            SourceIPs: ips(clusteredEntries(rng, 30)...),
+            DeniedIPs: clusteredEntries(rng, 5),
+            PathRules: []PathConfig{{Path: "/admin", SourceIPs: ips(clusteredEntries(rng, 10)...)}},
+        }
+        plain := newTestSentinel(t, domainConfig("example.com", rule))
+        config := domainConfig("example.com", rule)
+        config.Aggregate = true
+        aggregated := newTestSentinel(t, config)
+        for _, ip := range probeAddrs(rng, 200) {
+            for _, target := range []string{"http://example.com/", "http://example.com/admin"} {
+                remoteAddr := netip.AddrPortFrom(ip, 1234).String()
+                if got, want := serve(aggregated, target, remoteAddr).Code, serve(plain, target, remoteAddr).Code; got != want {
+                    t.Fatalf("round %d, GET %s from %s: status %d with aggregate, %d without", round, target, ip, got, want)
+                }
+            }
+        }
+    }
+}
+
+func TestAggregateMergesSiblings(t *testing.T) {
+    c, err := newCompiler(CreateConfig())
+    if err != nil {
+        t.Fatal(err)
+    }
+    list, err := c.buildIPList(stringEntries([]string{"10.0.0.0/25", "10.0.0.128/25", "10.0.1.0/24", "10.0.0.7", "192.0.2.1", "192.0.2.0"}))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if before, after := list.aggregate(); before != 6 || after != 2 {
+        t.Errorf("aggregate() = %d, %d; want 6, 2", before, after)
+    }
+    var got []string
+    for _, n := range list.nets {
+        got = append(got, n.String())
+    }
+    if want := "[10.0.0.0/23 192.0.2.0/31]"; fmt.Sprint(got) != want {
+        t.Errorf("aggregated networks %v, want %s", got, want)
+    }
+}
+
+func TestOverlapReport(t *testing.T) {
+    config := domainConfig("example.com", DomainConfig{
+        SourceIPs: ips("10.0.0.0/8", "10.1.2.0/24", "192.0.2.1", "192.0.2.1", "192.0.2.0/24"),
+        PathRules: []PathConfig{{Path: "/admin", SourceIPs: ips("172.16.0.0/12", "172.16.5.5")}},
+    })
+    out := captureOutput(t, func() { newTestSentinel(t, config) })
+    for _, want := range []string{
+        `domain "example.com" has redundant IP list entries`,
+        "sourceIPs: 10.1.2.0/24 is covered by 10.0.0.0/8",
+        "sourceIPs: 192.0.2.1 is a duplicate of entry 2",
+        "sourceIPs: 192.0.2.1 is covered by 192.0.2.0/24",
+        `path rule 0 ("/admin") sourceIPs: 172.16.5.5 is covered by 172.16.0.0/12`,
+    } {
+        if !strings.Contains(out, want) {
+            t.Errorf("report lacks %q:\n%s", want, out)
+        }
+    }
+
+    // Aggregation does not change what the lists allow.
+    config.Aggregate = true
+    handler := newTestSentinel(t, config)
+    checkStatuses(t, handler, []statusCase{
+        {"http://example.com/", "10.1.2.3:1234", http.StatusOK},
+        {"http://example.com/", "192.0.2.9:1234", http.StatusOK},
+        {"http://example.com/", "192.0.3.1:1234", http.StatusForbidden},
+        {"http://example.com/admin", "172.16.5.5:1234", http.StatusOK},
+        {"http://example.com/admin", "10.1.2.3:1234", http.StatusForbidden},
+    })
+}
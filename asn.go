@@ -0,0 +1,54 @@
+package DomainSentinel
+
+import (
+    "fmt"
+    "net/netip"
+    "strconv"
+    "strings"
+)
+
+// Actions for clients whose autonomous system cannot be determined.
+const (
+    unknownASNDeny  = "deny"
+    unknownASNAllow = "allow"
+)
+
+// asnDB resolves client addresses to autonomous system numbers using a
+// MaxMind-format ASN database (e.g. GeoLite2-ASN).
+type asnDB struct {
+    reader *mmdbReader
+}
+
+// asn returns the autonomous system number of ip, or false if it is unknown.
+func (a *asnDB) asn(ip netip.Addr) (uint32, bool) {
+    record, err := a.reader.lookup(ip)
+    if err != nil {
+        fmt.Println("Error looking up ASN:", ip, err)
+        return 0, false
+    }
+    n, ok := mmdbUint(mmdbPath(record, "autonomous_system_number"))
+    if !ok || n == 0 {
+        return 0, false
+    }
+    return uint32(n), true
+}
+
+// parseASNs validates a list of AS numbers written as "13335" or "AS13335".
+func parseASNs(values []string) (map[uint32]struct{}, error) {
+    if len(values) == 0 {
+        return nil, nil
+    }
+    asns := make(map[uint32]struct{}, len(values))
+    for _, value := range values {
+        s := strings.TrimSpace(value)
+        if len(s) > 2 && strings.EqualFold(s[:2], "AS") {
+            s = s[2:]
+        }
+        n, err := strconv.ParseUint(s, 10, 32)
+        if err != nil || n == 0 {
+            return nil, fmt.Errorf("invalid AS number %q", value)
+        }
+        asns[uint32(n)] = struct{}{}
+    }
+    return asns, nil
+}
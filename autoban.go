@@ -0,0 +1,177 @@
+package DomainSentinel
+
+import (
+    "container/list"
+    "context"
+    "fmt"
+    "net/netip"
+    "sync"
+    "time"
+)
+
+// AutoBanConfig bans a client address for BanDuration once it has been
+// denied MaxDenials times within Window. Banned addresses are rejected
+// before any rule is evaluated.
+type AutoBanConfig struct {
+    MaxDenials  int    `json:"maxDenials,omitempty"`  // default 10
+    Window      string `json:"window,omitempty"`      // default 1m
+    BanDuration string `json:"banDuration,omitempty"` // default 10m
+    MaxTracked  int    `json:"maxTracked,omitempty"`  // default 10000
+}
+
+// Defaults for autoBan.
+const (
+    defaultAutoBanMaxDenials = 10
+    defaultAutoBanWindow     = time.Minute
+    defaultAutoBanDuration   = 10 * time.Minute
+    defaultAutoBanMaxTracked = 10000
+
+    // autoBanSweepInterval is how often expired bans are removed and logged.
+    autoBanSweepInterval = time.Minute
+)
+
+// banTracker counts recent denials per client address and holds the active
+// bans of one domain. It is safe for concurrent use.
+type banTracker struct {
+    maxDenials int
+    window     time.Duration
+    duration   time.Duration
+    maxTracked int
+
+    mu      sync.Mutex
+    entries map[netip.Addr]*list.Element // values are *banEntry
+    order   *list.List                   // least recently updated first
+}
+
+// banEntry is the tracking state of a single address.
+type banEntry struct {
+    ip          netip.Addr
+    denials     []time.Time // within the window, oldest first
+    bannedUntil time.Time   // zero if not banned
+}
+
+// newBanTracker validates an autoBan block and applies its defaults.
+func newBanTracker(config *AutoBanConfig) (*banTracker, error) {
+    t := &banTracker{
+        maxDenials: config.MaxDenials,
+        maxTracked: config.MaxTracked,
+        entries:    make(map[netip.Addr]*list.Element),
+        order:      list.New(),
+    }
+    if t.maxDenials < 0 || t.maxTracked < 0 {
+        return nil, fmt.Errorf("maxDenials and maxTracked must not be negative")
+    }
+    if t.maxDenials == 0 {
+        t.maxDenials = defaultAutoBanMaxDenials
+    }
+    if t.maxTracked == 0 {
+        t.maxTracked = defaultAutoBanMaxTracked
+    }
+
+    var err error
+    if t.window, err = parseDuration("window", config.Window, defaultAutoBanWindow); err != nil {
+        return nil, err
+    }
+    if t.duration, err = parseDuration("banDuration", config.BanDuration, defaultAutoBanDuration); err != nil {
+        return nil, err
+    }
+    return t, nil
+}
+
+// banned reports whether ip is currently banned.
+func (t *banTracker) banned(ip netip.Addr, now time.Time) bool {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    elem, ok := t.entries[ip]
+    if !ok {
+        return false
+    }
+    return now.Before(elem.Value.(*banEntry).bannedUntil)
+}
+
+// recordDenial counts a denial of ip and bans it once the limit is reached
+// within the window. It reports whether ip was banned by this denial.
+func (t *banTracker) recordDenial(ip netip.Addr, now time.Time) bool {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    var e *banEntry
+    if elem, ok := t.entries[ip]; ok {
+        e = elem.Value.(*banEntry)
+        t.order.MoveToBack(elem)
+    } else {
+        if t.order.Len() >= t.maxTracked {
+            t.evictOldest()
+        }
+        e = &banEntry{ip: ip}
+        t.entries[ip] = t.order.PushBack(e)
+    }
+
+    cutoff := now.Add(-t.window)
+    kept := e.denials[:0]
+    for _, d := range e.denials {
+        if d.After(cutoff) {
+            kept = append(kept, d)
+        }
+    }
+    e.denials = append(kept, now)
+
+    if len(e.denials) < t.maxDenials || now.Before(e.bannedUntil) {
+        return false
+    }
+    e.bannedUntil = now.Add(t.duration)
+    e.denials = nil
+    return true
+}
+
+// evictOldest drops the least recently updated address. The caller holds mu.
+func (t *banTracker) evictOldest() {
+    elem := t.order.Front()
+    if elem == nil {
+        return
+    }
+    e := t.order.Remove(elem).(*banEntry)
+    delete(t.entries, e.ip)
+}
+
+// sweep removes expired bans and addresses without recent denials, and
+// returns the addresses whose ban expired.
+func (t *banTracker) sweep(now time.Time) []netip.Addr {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    var expired []netip.Addr
+    cutoff := now.Add(-t.window)
+    for elem := t.order.Front(); elem != nil; {
+        next := elem.Next()
+        e := elem.Value.(*banEntry)
+        if !e.bannedUntil.IsZero() && !now.Before(e.bannedUntil) {
+            expired = append(expired, e.ip)
+            e.bannedUntil = time.Time{}
+        }
+        if e.bannedUntil.IsZero() && (len(e.denials) == 0 || !e.denials[len(e.denials)-1].After(cutoff)) {
+            t.order.Remove(elem)
+            delete(t.entries, e.ip)
+        }
+        elem = next
+    }
+    return expired
+}
+
+// sweepLoop runs sweep every interval until ctx is done and logs expired bans.
+func (t *banTracker) sweepLoop(ctx context.Context, domain string, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case now := <-ticker.C:
+            for _, ip := range t.sweep(now) {
+                fmt.Printf("Auto-ban expired for %s on domain %q\n", ip, domain)
+            }
+        }
+    }
+}
@@ -0,0 +1,119 @@
+package DomainSentinel
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "net/netip"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestAutoBan(t *testing.T) {
+    config := domainConfig("example.com", DomainConfig{
+        SourceIPs: ips("10.0.0.0/8"),
+        PathRules: []PathConfig{{Path: "/admin", SourceIPs: ips("10.1.0.0/16")}},
+        AutoBan:   &AutoBanConfig{MaxDenials: 3, Window: "1m", BanDuration: "1h"},
+    })
+    handler := newTestSentinel(t, config)
+
+    out := captureOutput(t, func() {
+        for i := 0; i < 3; i++ {
+            serve(handler, "http://example.com/admin", "203.0.113.9:1234")
+        }
+    })
+    if !strings.Contains(out, "Auto-banned 203.0.113.9") {
+        t.Errorf("scanner not banned after 3 denials:\n%s", out)
+    }
+    out = captureOutput(t, func() {
+        checkStatuses(t, handler, []statusCase{{"http://example.com/", "203.0.113.9:1234", http.StatusForbidden}})
+    })
+    if !strings.Contains(out, "Rejecting banned client 203.0.113.9") {
+        t.Errorf("banned client was not rejected before the rules:\n%s", out)
+    }
+
+    // An allowlisted client denied on one path is never banned.
+    out = captureOutput(t, func() {
+        for i := 0; i < 5; i++ {
+            serve(handler, "http://example.com/admin", "10.2.0.1:1234")
+        }
+    })
+    if strings.Contains(out, "Auto-banned") {
+        t.Errorf("allowlisted client banned:\n%s", out)
+    }
+    checkStatuses(t, handler, []statusCase{
+        {"http://example.com/", "10.2.0.1:1234", http.StatusOK},
+        {"http://example.com/admin", "10.1.0.1:1234", http.StatusOK},
+    })
+
+    for _, ban := range []*AutoBanConfig{{MaxDenials: -1}, {MaxTracked: -1}, {Window: "soon"}, {BanDuration: "-1m"}} {
+        config := domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.0/8"), AutoBan: ban})
+        if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+            t.Errorf("autoBan %+v accepted", *ban)
+        }
+    }
+}
+
+func TestBanTracker(t *testing.T) {
+    tracker, err := newBanTracker(&AutoBanConfig{MaxDenials: 2, Window: "1m", BanDuration: "10m", MaxTracked: 2})
+    if err != nil {
+        t.Fatal(err)
+    }
+    start := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+    scanner := netip.MustParseAddr("203.0.113.9")
+
+    // Denials further apart than the window do not add up.
+    if tracker.recordDenial(scanner, start) || tracker.recordDenial(scanner, start.Add(2*time.Minute)) {
+        t.Fatal("banned after denials outside the window")
+    }
+    if !tracker.recordDenial(scanner, start.Add(2*time.Minute+time.Second)) {
+        t.Fatal("not banned after two denials within the window")
+    }
+    if !tracker.banned(scanner, start.Add(5*time.Minute)) {
+        t.Error("ban ended early")
+    }
+    if expired := tracker.sweep(start.Add(5 * time.Minute)); len(expired) != 0 {
+        t.Errorf("sweep during the ban expired %v", expired)
+    }
+    end := start.Add(12*time.Minute + time.Second)
+    if tracker.banned(scanner, end) {
+        t.Error("ban outlived banDuration")
+    }
+    if expired := tracker.sweep(end); len(expired) != 1 || expired[0] != scanner {
+        t.Errorf("sweep after the ban expired %v, want [%s]", expired, scanner)
+    }
+
+    // The table holds maxTracked addresses and drops the oldest first.
+    for i := 1; i <= 3; i++ {
+        tracker.recordDenial(netip.AddrFrom4([4]byte{192, 0, 2, byte(i)}), end)
+    }
+    if _, ok := tracker.entries[netip.MustParseAddr("192.0.2.1")]; ok || len(tracker.entries) != 2 {
+        t.Errorf("tracking %d addresses after eviction, want the 2 newest", len(tracker.entries))
+    }
+}
+
+func TestBanTrackerConcurrent(t *testing.T) {
+    tracker, err := newBanTracker(&AutoBanConfig{MaxDenials: 50, MaxTracked: 16})
+    if err != nil {
+        t.Fatal(err)
+    }
+    now := time.Now()
+    var wg sync.WaitGroup
+    for g := 0; g < 8; g++ {
+        wg.Add(1)
+        go func(g int) {
+            defer wg.Done()
+            for i := 0; i < 200; i++ {
+                ip := netip.MustParseAddr(fmt.Sprintf("192.0.2.%d", (g*200+i)%32))
+                tracker.recordDenial(ip, now)
+                tracker.banned(ip, now)
+            }
+        }(g)
+    }
+    wg.Wait()
+    if len(tracker.entries) > 16 || tracker.order.Len() != len(tracker.entries) {
+        t.Errorf("tracking %d addresses in a list of %d, want at most 16 in both", len(tracker.entries), tracker.order.Len())
+    }
+}
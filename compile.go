@@ -0,0 +1,1265 @@
+package DomainSentinel
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "net/netip"
+    "net/url"
+    "regexp"
+    "sort"
+    "strings"
+)
+
+// accessRule holds the compiled checks shared by domains and path rules.
+type accessRule struct {
+    sourceIPs *ipList
+    deniedIPs *ipList
+    exceptIPs *ipList
+
+    allowedCountries map[string]struct{}
+    deniedCountries  map[string]struct{}
+
+    hostSuffixes []string // sourceHostSuffixes, verified by reverse DNS
+
+    acl *acl // replaces sourceIPs if set
+
+    minTier *tier // nil if the rule does not allow by tier
+
+    // inheritSources makes a path rule without sources use those of its
+    // domain (inheritDomainIPs).
+    inheritSources bool
+}
+
+// compiledDomain is the pre-parsed form of a DomainConfig.
+type compiledDomain struct {
+    accessRule
+    name      string // name, or the domain key
+    pathRules []compiledPathRule
+    router    *pathRouter // indexes pathRules
+
+    disabled bool // enabled is false
+
+    foldPaths  bool // caseInsensitivePaths
+    rawPath    bool // matchRawPath
+    auditOnly  bool
+    denyStatus int    // 0 to follow the plugin-level denyStatus
+    extends    string // the template merged into the rule
+    override   string // the overrideMode of the override rule applied, if any
+
+    publicPaths      []pathPattern
+    publicPathsFirst bool // public paths win over path rules and inherited rules
+
+    skipGlobal map[string]bool // names of the globalPathRules that do not apply
+
+    schemes schemeRule
+
+    allowedASNs map[uint32]struct{}
+    deniedASNs  map[uint32]struct{}
+
+    bans *banTracker // nil without autoBan
+
+    ipv6Bits int // ipv6SubnetLength, 0 if IPv6 clients are matched exactly
+
+    requireBoth bool // requireBothAddresses
+
+    enforceSNI        bool // enforceHostSNIMatch
+    requireSNI        bool // a TLS request without SNI is a mismatch
+    sniMismatchStatus int
+
+    ipStrategy *clientIPStrategy // overrides the plugin-level strategy if set
+
+    clientIPHeader string // header carrying the evaluated address, empty if disabled
+
+    matchApex bool // a wildcard key also matches its apex domain
+
+    includeSubdomains bool
+    inherit           bool
+    parent            *compiledDomain // rules inherited via inherit, nil if none
+    parentKey         string
+
+    priority int // breaks ties between rules of the same precedence
+
+    hosts []string // matched instead of the key if set
+    group string   // the key of a rule with hosts, reported in logs
+
+    // allowOnEmpty is set when emptyListAction is allowAll.
+    allowOnEmpty bool
+}
+
+// compiledPathRule is the pre-parsed form of a PathConfig.
+type compiledPathRule struct {
+    pathPattern
+    name     string        // name, or "<domain>/pathRules[<index>]"
+    except   []pathPattern // exceptPaths
+    priority int
+    schemes  schemeRule
+    methods  methodRule
+    query    queryRule
+    accessRule
+
+    auditOnly  *bool // nil to follow the domain
+    denyStatus int   // 0 to follow the domain
+
+    // tenants are the rules of the tenants of tenantIPs, keyed by the first
+    // path segment. They differ from accessRule in sourceIPs only.
+    tenants           map[string]*accessRule
+    denyUnknownTenant bool
+}
+
+// pathPattern is a compiled path of a rule or of its exceptPaths.
+type pathPattern struct {
+    path   string
+    re     *regexp.Regexp // set for "~pattern" paths
+    glob   *pathGlob      // set for templates and wildcards other than a trailing "/*"
+    suffix bool           // a "*.php" pattern, matched against the whole path
+    loose  bool           // loosePathPrefix
+
+    // pattern is the path as matched: lower-cased for caseInsensitivePaths
+    // and without trailing slash if trimSlash is set for an exact path.
+    pattern   string
+    trimSlash bool
+}
+
+// compilePathPattern compiles a path pattern, lower-cased if fold is set.
+func compilePathPattern(path string, fold, loose, trimSlash bool) (pathPattern, error) {
+    p := pathPattern{path: path, loose: loose, pattern: path}
+    if fold {
+        p.pattern = strings.ToLower(path)
+    }
+    re, err := compilePathRegex(path, fold)
+    if err != nil {
+        return p, err
+    }
+    p.re = re
+    if re == nil && isSuffixPattern(path) {
+        if strings.ContainsAny(path, "{}") {
+            return p, errors.New(`placeholders are not supported in suffix patterns such as "*.php"`)
+        }
+        p.pattern = strings.ToLower(path)
+        p.suffix = true
+        return p, nil
+    }
+    if re == nil && isPathGlob(p.pattern) {
+        if p.glob, err = compilePathGlob(p.pattern, fold); err != nil {
+            return p, err
+        }
+    }
+    if trimSlash && pathKind(p.pattern) == pathKindExact {
+        p.pattern = trimTrailingSlash(p.pattern)
+        p.trimSlash = true
+    }
+    return p, nil
+}
+
+// sortPathRules orders path rules by descending priority and then from
+// the most to the least specific, so that the first match is the most
+// specific one whatever the configured order: exact paths, then regex
+// paths in configured order, then globs and then suffix patterns with
+// more literal characters first, then trailing "/*" wildcards with longer
+// prefixes before shorter ones. Rules of the same path and priority keep their order, except that
+// rules with query conditions come first; a later copy is only reachable
+// if the earlier one is skipped for the request scheme, method or query.
+// With strict, two rules with the same non-zero priority are an error.
+// owner names the rules in messages, as in `domain "a.com"`.
+func sortPathRules(owner string, rules []compiledPathRule, strict bool) error {
+    if strict {
+        seen := make(map[int]string)
+        for _, r := range rules {
+            if r.priority == 0 {
+                continue
+            }
+            if other, ok := seen[r.priority]; ok {
+                return fmt.Errorf("%s: path rules %q and %q have the same priority %d (strictPathPriority)", owner, other, r.path, r.priority)
+            }
+            seen[r.priority] = r.path
+        }
+    }
+    sort.SliceStable(rules, func(i, j int) bool {
+        if rules[i].priority != rules[j].priority {
+            return rules[i].priority > rules[j].priority
+        }
+        return morePathSpecific(rules[i].path, rules[j].path)
+    })
+    for i := range rules {
+        if len(rules[i].query) == 0 {
+            continue
+        }
+        for j := 0; j < i; j++ {
+            if rules[j].key() == rules[i].key() && rules[j].priority == rules[i].priority && len(rules[j].query) == 0 {
+                rule := rules[i]
+                copy(rules[j+1:i+1], rules[j:i])
+                rules[j] = rule
+                break
+            }
+        }
+    }
+    for i := 1; i < len(rules); i++ {
+        if prev := &rules[i-1]; rules[i].key() == prev.key() && len(prev.schemes.schemes) == 0 && len(prev.methods) == 0 &&
+            len(prev.query) == 0 && len(prev.except) == 0 {
+            fmt.Printf("Warning: %s has several path rules for %q; only the first one is used\n", owner, rules[i].path)
+        }
+    }
+    return nil
+}
+
+// logPathRuleOrder prints the order in which the path rules of a domain
+// are evaluated if any of them has a priority, since the order then
+// differs from what specificity alone would give.
+func logPathRuleOrder(owner string, rules []compiledPathRule) {
+    prioritized := false
+    for _, r := range rules {
+        prioritized = prioritized || r.priority != 0
+    }
+    if !prioritized {
+        return
+    }
+    fmt.Printf("The path rules of %s are evaluated in this order:\n", owner)
+    for i, r := range rules {
+        fmt.Printf("  %d. %s (%s, priority %d)\n", i+1, r.path, r.name, r.priority)
+    }
+}
+
+// key returns the path a rule matches, after normalization.
+func (r *pathPattern) key() string {
+    return r.pattern
+}
+
+// morePathSpecific reports whether path pattern a is more specific than b.
+func morePathSpecific(a, b string) bool {
+    if ka, kb := pathKind(a), pathKind(b); ka != kb {
+        return ka < kb
+    } else if ka == pathKindRegex {
+        return false
+    } else if ka == pathKindGlob || ka == pathKindSuffix {
+        return globLiterals(a) > globLiterals(b)
+    }
+    return len(a) > len(b)
+}
+
+// Kinds of path patterns, in order of precedence.
+const (
+    pathKindExact = iota
+    pathKindRegex
+    pathKindGlob
+    pathKindSuffix
+    pathKindWildcard
+)
+
+// isSuffixPattern reports whether a path pattern starts with "*", as in
+// "*.php" or "*/.git/*". Such patterns are matched against the whole path,
+// where "*" matches any characters including slashes.
+func isSuffixPattern(pattern string) bool {
+    return strings.HasPrefix(pattern, "*")
+}
+
+func pathKind(pattern string) int {
+    switch {
+    case strings.HasPrefix(pattern, regexPrefix):
+        return pathKindRegex
+    case isSuffixPattern(pattern):
+        return pathKindSuffix
+    case isPathGlob(pattern):
+        return pathKindGlob
+    case strings.HasSuffix(pattern, "/*"):
+        return pathKindWildcard
+    }
+    return pathKindExact
+}
+
+// regexFlags returns the flags prefix for a path regular expression.
+func regexFlags(fold bool) string {
+    if fold {
+        return "(?i)"
+    }
+    return ""
+}
+
+// trimTrailingSlash removes a single trailing slash from a path other than
+// "/", so "/admin/" becomes "/admin" and "/admin//" becomes "/admin/".
+func trimTrailingSlash(path string) string {
+    if len(path) > 1 && strings.HasSuffix(path, "/") {
+        return path[:len(path)-1]
+    }
+    return path
+}
+
+// compilePathRegex compiles a "~pattern" path, anchored at both ends and
+// case-insensitive if fold is set, or returns nil for other paths.
+func compilePathRegex(path string, fold bool) (*regexp.Regexp, error) {
+    pattern, ok := regexPattern(path)
+    if !ok {
+        return nil, nil
+    }
+    if pattern == "" {
+        return nil, errors.New("empty regular expression")
+    }
+    if len(pattern) > maxPathRegexLength {
+        return nil, fmt.Errorf("regular expression longer than %d characters", maxPathRegexLength)
+    }
+    re, err := regexp.Compile(regexFlags(fold) + "^(?:" + pattern + ")$")
+    if err != nil {
+        return nil, fmt.Errorf("invalid regular expression: %w", err)
+    }
+    return re, nil
+}
+
+// exception returns the exceptPaths entry that path matches, if any.
+func (r *compiledPathRule) exception(path string) string {
+    for i := range r.except {
+        if ok, _ := r.except[i].matches(path); ok {
+            return r.except[i].path
+        }
+    }
+    return ""
+}
+
+// matches reports whether the pattern matches the request path, and
+// returns the values of the placeholders of a template.
+func (r *pathPattern) matches(path string) (bool, []string) {
+    switch {
+    case r.re != nil:
+        return r.re.MatchString(path), nil
+    case r.glob != nil:
+        return r.glob.match(path)
+    case r.suffix:
+        return matchSegment(r.pattern, strings.ToLower(path)), nil
+    case r.trimSlash:
+        return trimTrailingSlash(path) == r.pattern, nil
+    }
+    return isPathAllowed(path, r.pattern, r.loose), nil
+}
+
+// schemeRule restricts a rule to some request schemes.
+type schemeRule struct {
+    schemes []string // all schemes if empty
+    deny    bool     // schemeMismatchAction is "deny", else the rule is skipped
+}
+
+// compileSchemeRule validates the schemes and schemeMismatchAction of a rule.
+func compileSchemeRule(schemes []string, action string) (schemeRule, error) {
+    var rule schemeRule
+    for _, entry := range schemes {
+        for _, scheme := range splitListEntry(entry) {
+            scheme = strings.ToLower(strings.TrimSpace(scheme))
+            if scheme != schemeHTTP && scheme != schemeHTTPS {
+                return rule, fmt.Errorf("invalid scheme %q: must be %q or %q", scheme, schemeHTTP, schemeHTTPS)
+            }
+            rule.schemes = append(rule.schemes, scheme)
+        }
+    }
+    switch action {
+    case "", schemeMismatchSkip:
+    case schemeMismatchDeny:
+        rule.deny = true
+    default:
+        return rule, fmt.Errorf("invalid schemeMismatchAction %q: must be %q or %q", action, schemeMismatchSkip, schemeMismatchDeny)
+    }
+    return rule, nil
+}
+
+// allows reports whether the rule applies to requests over scheme.
+func (r schemeRule) allows(scheme string) bool {
+    if len(r.schemes) == 0 {
+        return true
+    }
+    for _, s := range r.schemes {
+        if s == scheme {
+            return true
+        }
+    }
+    return false
+}
+
+func (r schemeRule) String() string {
+    return strings.Join(r.schemes, ", ")
+}
+
+// methodRule restricts a path rule to some request methods, upper-cased.
+// It applies to all methods if empty.
+type methodRule []string
+
+// standardMethods are the methods of RFC 9110 and RFC 5789, and WebDAV's.
+// Other method names are accepted with a warning, since they are more
+// likely to be typos than extension methods.
+var standardMethods = map[string]bool{
+    http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+    http.MethodPatch: true, http.MethodDelete: true, http.MethodConnect: true, http.MethodOptions: true,
+    http.MethodTrace: true, "PROPFIND": true, "PROPPATCH": true, "MKCOL": true, "COPY": true,
+    "MOVE": true, "LOCK": true, "UNLOCK": true, "REPORT": true, "SEARCH": true,
+}
+
+// compileMethodRule validates the methods of a path rule. Names are
+// case-insensitive and must be HTTP tokens.
+func compileMethodRule(owner string, i int, path string, methods []string) (methodRule, error) {
+    var rule methodRule
+    for _, entry := range methods {
+        for _, method := range splitListEntry(entry) {
+            method = strings.ToUpper(strings.TrimSpace(method))
+            if !isHTTPToken(method) {
+                return nil, fmt.Errorf("invalid method %q: must be an HTTP method name such as %q", method, http.MethodGet)
+            }
+            if !standardMethods[method] {
+                fmt.Printf("Warning: %s, path rule %d (%q) lists the non-standard method %q\n", owner, i, path, method)
+            }
+            rule = append(rule, method)
+        }
+    }
+    return rule, nil
+}
+
+// isHTTPToken reports whether s is a non-empty token of RFC 9110.
+func isHTTPToken(s string) bool {
+    if s == "" {
+        return false
+    }
+    for i := 0; i < len(s); i++ {
+        c := s[i]
+        if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0) {
+            return false
+        }
+    }
+    return true
+}
+
+// allows reports whether the rule applies to requests with method.
+func (r methodRule) allows(method string) bool {
+    if len(r) == 0 {
+        return true
+    }
+    for _, m := range r {
+        if m == method {
+            return true
+        }
+    }
+    return false
+}
+
+func (r methodRule) String() string {
+    return strings.Join(r, ", ")
+}
+
+// queryAny is the value of a query condition that only requires the
+// parameter to be present.
+const queryAny = "*"
+
+// queryRule is the set of query conditions of a path rule, sorted by
+// parameter name. The rule applies only if all of them hold.
+type queryRule []queryCondition
+
+// queryCondition requires a query parameter to be present, with value
+// unless any is set.
+type queryCondition struct {
+    name  string
+    value string
+    any   bool
+}
+
+// compileQueryRule validates the query conditions of a path rule.
+func compileQueryRule(query map[string]string) (queryRule, error) {
+    var rule queryRule
+    for name, value := range query {
+        if name == "" {
+            return nil, errors.New("query condition with an empty parameter name")
+        }
+        rule = append(rule, queryCondition{name: name, value: value, any: value == queryAny})
+    }
+    sort.Slice(rule, func(i, j int) bool { return rule[i].name < rule[j].name })
+    return rule, nil
+}
+
+// holds reports whether all conditions hold for the query parameters.
+// With repeated parameters, any of the values can match.
+func (r queryRule) holds(query url.Values) bool {
+    for _, c := range r {
+        values, ok := query[c.name]
+        if !ok {
+            return false
+        }
+        if c.any {
+            continue
+        }
+        found := false
+        for _, v := range values {
+            if v == c.value {
+                found = true
+                break
+            }
+        }
+        if !found {
+            return false
+        }
+    }
+    return true
+}
+
+func (r queryRule) String() string {
+    conditions := make([]string, len(r))
+    for i, c := range r {
+        if c.any {
+            conditions[i] = c.name + " present"
+        } else {
+            conditions[i] = c.name + "=" + c.value
+        }
+    }
+    return strings.Join(conditions, ", ")
+}
+
+// ruleFields are the configuration fields an accessRule is compiled from.
+type ruleFields struct {
+    sourceIPs        []interface{}
+    deniedIPs        []string
+    exceptIPs        []string
+    allowedCountries []string
+    deniedCountries  []string
+    hostSuffixes     []string
+    acl              []string
+    aclDefault       string
+    minTier          string
+}
+
+// compiler turns the plugin configuration into its compiled form. It carries
+// the plugin-level settings that individual rules refer to.
+type compiler struct {
+    groups          map[string][]string
+    emptyListAction string
+    confirmAllowAll bool // RequireAllowAllConfirmation
+    hosts           *hostSet
+    selfNets        []netip.Prefix // networks of the "self" keyword, discovered on first use
+    geo             *geoDB         // nil without a geoIPDatabase
+    asn             *asnDB         // nil without an asnDatabase
+    rdns            *rdnsVerifier
+    expiring        []expiringEntry
+    aggregate       bool // collapse IP lists to their minimal covering set
+    tiers           []*tier
+    ipStrategy      *clientIPStrategy
+    clientIPHeader  string
+    loosePathPrefix bool
+
+    normalizeTrailingSlash bool
+    strictPathPriority     bool
+
+    defaultSourceIPs []string // defaultSourceIPs
+    defaultsForPaths bool     // defaultSourceIPsForPathRules
+}
+
+// newCompiler validates the plugin-level settings used while compiling rules.
+func newCompiler(config *Config) (*compiler, error) {
+    if err := validateEmptyListAction(config.EmptyListAction); err != nil {
+        return nil, err
+    }
+    for name := range config.IPGroups {
+        if name == "" || strings.ContainsAny(name, "@ \t") {
+            return nil, fmt.Errorf("invalid ipGroups name %q", name)
+        }
+    }
+
+    dnsTimeout, err := parseDuration("dnsTimeout", config.DNSTimeout, defaultDNSTimeout)
+    if err != nil {
+        return nil, err
+    }
+
+    reverseDNSCacheTTL, err := parseDuration("reverseDNSCacheTTL", config.ReverseDNSCacheTTL, defaultReverseDNSCacheTTL)
+    if err != nil {
+        return nil, err
+    }
+
+    c := &compiler{
+        groups:          config.IPGroups,
+        emptyListAction: config.EmptyListAction,
+        confirmAllowAll: config.RequireAllowAllConfirmation,
+        hosts:           newHostSet(dnsTimeout),
+        rdns:            newRDNSVerifier(reverseDNSCacheTTL, dnsTimeout),
+        aggregate:       config.Aggregate,
+        loosePathPrefix: config.LoosePathPrefix,
+
+        normalizeTrailingSlash: config.NormalizeTrailingSlash,
+        strictPathPriority:     config.StrictPathPriority,
+
+        defaultSourceIPs: config.DefaultSourceIPs,
+        defaultsForPaths: config.DefaultSourceIPsForPathRules,
+    }
+    if config.DefaultSourceIPsForPathRules && len(config.DefaultSourceIPs) == 0 {
+        fmt.Println("Warning: defaultSourceIPsForPathRules is set, but defaultSourceIPs is empty")
+    }
+    if config.LoosePathPrefix {
+        fmt.Println("Warning: loosePathPrefix is set, so \"/api/*\" path rules also match paths such as \"/apiv2\" and \"/api-docs\"")
+    }
+
+    if config.GeoIPDatabase != "" {
+        reader, err := openMMDB(config.GeoIPDatabase)
+        if err != nil {
+            return nil, fmt.Errorf("geoIPDatabase: %w", err)
+        }
+        fmt.Printf("Loaded GeoIP database %s (%s)\n", config.GeoIPDatabase, reader.databaseType)
+        c.geo = &geoDB{reader: reader}
+    }
+    if config.ASNDatabase != "" {
+        reader, err := openMMDB(config.ASNDatabase)
+        if err != nil {
+            return nil, fmt.Errorf("asnDatabase: %w", err)
+        }
+        fmt.Printf("Loaded ASN database %s (%s)\n", config.ASNDatabase, reader.databaseType)
+        c.asn = &asnDB{reader: reader}
+    }
+    if c.tiers, err = c.compileTiers(config.Tiers); err != nil {
+        return nil, err
+    }
+    if c.ipStrategy, err = c.compileIPStrategy(config.IPStrategy); err != nil {
+        return nil, fmt.Errorf("ipStrategy: %w", err)
+    }
+    if c.clientIPHeader, err = config.ClientIPHeader.headerName(); err != nil {
+        return nil, fmt.Errorf("clientIPHeader: %w", err)
+    }
+    return c, nil
+}
+
+// compileIPStrategy applies the platform preset of an ipStrategy block,
+// parses its trusted proxies and validates its settings.
+func (c *compiler) compileIPStrategy(config IPStrategy) (*clientIPStrategy, error) {
+    if config.Platform != "" {
+        var err error
+        if config, err = applyPlatform(config); err != nil {
+            return nil, err
+        }
+    }
+    trustedProxies, err := c.parseIPList(stringEntries(config.TrustedProxies))
+    if err != nil {
+        return nil, fmt.Errorf("trustedProxies: %w", err)
+    }
+    var excludedIPs *ipList
+    if len(config.ExcludedIPs) > 0 {
+        if excludedIPs, err = c.parseIPList(stringEntries(config.ExcludedIPs)); err != nil {
+            return nil, fmt.Errorf("excludedIPs: %w", err)
+        }
+    }
+    return newClientIPStrategy(config, trustedProxies, excludedIPs)
+}
+
+// compileDomains parses the source IPs of every domain and path rule of
+// section once at startup.
+func (c *compiler) compileDomains(section string, rules map[string]DomainConfig) (map[string]*compiledDomain, error) {
+    domains := make(map[string]*compiledDomain, len(rules))
+    // In key order, so that the first error is the same on every start.
+    for _, domain := range sortedConfigKeys(rules) {
+        compiled, err := c.compileDomain(domain, rules[domain])
+        if err != nil {
+            return nil, entryError(section, domain, err)
+        }
+        domains[domain] = compiled
+    }
+    return domains, nil
+}
+
+func (c *compiler) compileDomain(domain string, domainConfig DomainConfig) (*compiledDomain, error) {
+    if err := validateDomainKey(domain, domainConfig); err != nil {
+        return nil, err
+    }
+    action := c.emptyListAction
+    if domainConfig.EmptyListAction != "" {
+        if err := validateEmptyListAction(domainConfig.EmptyListAction); err != nil {
+            return nil, fmt.Errorf("domain %q: %w", domain, err)
+        }
+        action = domainConfig.EmptyListAction
+    }
+
+    defaults := inheritsDefaults(domainConfig)
+    fields, merged := c.withDefaults(ruleFields{
+        sourceIPs:        domainConfig.SourceIPs,
+        deniedIPs:        domainConfig.DeniedIPs,
+        exceptIPs:        domainConfig.ExceptIPs,
+        allowedCountries: domainConfig.AllowedCountries,
+        deniedCountries:  domainConfig.DeniedCountries,
+        hostSuffixes:     domainConfig.SourceHostSuffixes,
+        acl:              domainConfig.ACL,
+        aclDefault:       domainConfig.ACLDefault,
+        minTier:          domainConfig.MinTier,
+    }, defaults, action == emptyListAllowAll)
+    rule, err := c.compileAccessRule(fields)
+    if err != nil {
+        return nil, fmt.Errorf("domain %q: %w", domain, err)
+    }
+    if merged {
+        logEffectiveSources(fmt.Sprintf("domain %q", domain), &rule)
+    }
+    if c.confirmAllowAll && !isTrue(domainConfig.AllowAllConfirmed) && rule.allowsAll() {
+        return nil, fmt.Errorf("domain %q: sourceIPs allow every address; set allowAllConfirmed: true if this is intended", domain)
+    }
+
+    if domainConfig.IPv6SubnetLength < 0 || domainConfig.IPv6SubnetLength > 128 {
+        return nil, fmt.Errorf("domain %q: invalid ipv6SubnetLength %d: must be 0 (off) or 1–128", domain, domainConfig.IPv6SubnetLength)
+    }
+    compiled := &compiledDomain{
+        accessRule:   rule,
+        name:         domainConfig.Name,
+        allowOnEmpty: action == emptyListAllowAll,
+        ipv6Bits:     domainConfig.IPv6SubnetLength,
+        requireBoth:  isTrue(domainConfig.RequireBothAddresses),
+        matchApex:    isTrue(domainConfig.MatchApex),
+
+        includeSubdomains: isTrue(domainConfig.IncludeSubdomains),
+        inherit:           isTrue(domainConfig.Inherit),
+
+        disabled:   domainConfig.Enabled != nil && !*domainConfig.Enabled,
+        priority:   domainConfig.Priority,
+        enforceSNI: isTrue(domainConfig.EnforceHostSNIMatch),
+        requireSNI: isTrue(domainConfig.RequireSNI),
+    }
+    if compiled.name == "" {
+        compiled.name = domain
+    }
+    names := map[string]bool{compiled.name: true}
+    if compiled.schemes, err = compileSchemeRule(domainConfig.Schemes, domainConfig.SchemeMismatchAction); err != nil {
+        return nil, fmt.Errorf("domain %q: %w", domain, err)
+    }
+    if compiled.sniMismatchStatus, err = compileSNIMismatchStatus(domainConfig); err != nil {
+        return nil, fmt.Errorf("domain %q: %w", domain, err)
+    }
+    if err = checkDenyStatus("denyStatus", domainConfig.DenyStatus); err != nil {
+        return nil, fmt.Errorf("domain %q: %w", domain, err)
+    }
+    compiled.denyStatus = domainConfig.DenyStatus
+    if compiled.disabled {
+        fmt.Printf("Warning: domain %q is disabled (enabled=false), its requests pass without checks\n", domain)
+    }
+    if (domainConfig.HostSNIMismatchStatus != 0 || isTrue(domainConfig.RequireSNI)) && !isTrue(domainConfig.EnforceHostSNIMatch) {
+        fmt.Printf("Warning: domain %q sets hostSNIMismatchStatus or requireSNI, which have no effect without enforceHostSNIMatch\n", domain)
+    }
+    if len(domainConfig.Hosts) > 0 {
+        compiled.hosts = hostEntries(domainConfig.Hosts)
+        compiled.group = domain
+    }
+    compiled.truncateIPv6(&compiled.accessRule)
+    report := c.analyzeLists("", &compiled.accessRule, nil)
+    if compiled.allowedASNs, err = parseASNs(domainConfig.AllowedASNs); err != nil {
+        return nil, fmt.Errorf("domain %q: allowedASNs: %w", domain, err)
+    }
+    if compiled.deniedASNs, err = parseASNs(domainConfig.DeniedASNs); err != nil {
+        return nil, fmt.Errorf("domain %q: deniedASNs: %w", domain, err)
+    }
+    if (compiled.allowedASNs != nil || compiled.deniedASNs != nil) && c.asn == nil {
+        return nil, fmt.Errorf("domain %q: ASN rules require asnDatabase to be set", domain)
+    }
+    if domainConfig.IPStrategy != nil {
+        if compiled.ipStrategy, err = c.compileIPStrategy(*domainConfig.IPStrategy); err != nil {
+            return nil, fmt.Errorf("domain %q: ipStrategy: %w", domain, err)
+        }
+        if compiled.ipStrategy.mode != ipStrategyRemoteAddr && compiled.ipStrategy.trustedProxies.empty() {
+            return nil, fmt.Errorf("domain %q: ipStrategy: mode %q reads client address headers but trustedProxies is empty; "+
+                "list the proxies or use mode %q", domain, compiled.ipStrategy.mode, ipStrategyRemoteAddr)
+        }
+    }
+    compiled.clientIPHeader = c.clientIPHeader
+    if domainConfig.ClientIPHeader != nil {
+        if compiled.clientIPHeader, err = domainConfig.ClientIPHeader.headerName(); err != nil {
+            return nil, fmt.Errorf("domain %q: clientIPHeader: %w", domain, err)
+        }
+    }
+    if domainConfig.AutoBan != nil {
+        if compiled.bans, err = newBanTracker(domainConfig.AutoBan); err != nil {
+            return nil, fmt.Errorf("domain %q: autoBan: %w", domain, err)
+        }
+    }
+    if rule.noSources() {
+        fmt.Printf("Warning: domain %q has an empty sourceIPs list (emptyListAction=%s)\n", domain, actionOrDefault(action))
+    }
+    compiled.foldPaths = isTrue(domainConfig.CaseInsensitivePaths)
+    trimSlash := c.normalizeTrailingSlash
+    if domainConfig.NormalizeTrailingSlash != nil {
+        trimSlash = *domainConfig.NormalizeTrailingSlash
+    }
+    if report, err = c.compilePathRules(fmt.Sprintf("domain %q", domain), domain+"/pathRules", compiled, domainConfig, action, trimSlash, names, report); err != nil {
+        return nil, err
+    }
+    for _, path := range domainConfig.PublicPaths {
+        if path == "" {
+            return nil, fmt.Errorf("domain %q: empty publicPaths entry", domain)
+        }
+        p, err := compilePathPattern(path, compiled.foldPaths, c.loosePathPrefix, trimSlash)
+        if err != nil {
+            return nil, fmt.Errorf("domain %q: publicPaths %q: %w", domain, path, err)
+        }
+        for j := range compiled.pathRules {
+            if compiled.pathRules[j].key() == p.key() && !isTrue(domainConfig.PublicPathsFirst) {
+                fmt.Printf("Warning: domain %q has a path rule for its public path %q, which takes precedence; set publicPathsFirst to make the path public\n", domain, path)
+                break
+            }
+        }
+        compiled.publicPaths = append(compiled.publicPaths, p)
+    }
+    compiled.publicPathsFirst = isTrue(domainConfig.PublicPathsFirst)
+    compiled.rawPath = isTrue(domainConfig.MatchRawPath)
+    compiled.auditOnly = isTrue(domainConfig.AuditOnly)
+    compiled.extends = domainConfig.Extends
+    if compiled.auditOnly {
+        fmt.Printf("Domain %q is in audit mode (auditOnly): its denials are logged but not enforced\n", domain)
+    }
+    if len(domainConfig.SkipGlobalRules) > 0 {
+        compiled.skipGlobal = make(map[string]bool, len(domainConfig.SkipGlobalRules))
+        for _, name := range domainConfig.SkipGlobalRules {
+            compiled.skipGlobal[name] = true
+        }
+    }
+    if len(report) > 0 {
+        fmt.Printf("Warning: domain %q has redundant IP list entries:\n", domain)
+        for _, line := range report {
+            fmt.Println("  -", line)
+        }
+    }
+    return compiled, nil
+}
+
+// compilePathRules compiles the path rules of config into compiled, sorts
+// them and indexes them. owner prefixes messages, as in `domain "a.com"`,
+// and namePrefix the names of unnamed rules. It returns report with the
+// redundant list entries of the rules appended.
+func (c *compiler) compilePathRules(owner, namePrefix string, compiled *compiledDomain, domainConfig DomainConfig, action string,
+    trimSlash bool, names map[string]bool, report []string) ([]string, error) {
+    defaults := c.defaultsForPaths && inheritsDefaults(domainConfig)
+    for i, pathRule := range domainConfig.PathRules {
+        inheritSources := isTrue(domainConfig.InheritDomainIPs)
+        if pathRule.InheritDomainIPs != nil {
+            inheritSources = *pathRule.InheritDomainIPs
+        }
+        fields, merged := c.withDefaults(ruleFields{
+            sourceIPs:        pathRule.SourceIPs,
+            deniedIPs:        pathRule.DeniedIPs,
+            exceptIPs:        pathRule.ExceptIPs,
+            allowedCountries: pathRule.AllowedCountries,
+            deniedCountries:  pathRule.DeniedCountries,
+            hostSuffixes:     pathRule.SourceHostSuffixes,
+            acl:              pathRule.ACL,
+            aclDefault:       pathRule.ACLDefault,
+            minTier:          pathRule.MinTier,
+        }, defaults, inheritSources || action == emptyListAllowAll)
+        rule, err := c.compileAccessRule(fields)
+        if err != nil {
+            return report, pathRuleError(i, "", -1, err)
+        }
+        if merged {
+            logEffectiveSources(fmt.Sprintf("%s, path rule %d (%q)", owner, i, pathRule.Path), &rule)
+        }
+        if c.confirmAllowAll && !isTrue(domainConfig.AllowAllConfirmed) && rule.allowsAll() {
+            return report, pathRuleError(i, "", -1, fmt.Errorf("sourceIPs of path %q allow every address; set allowAllConfirmed: true on the domain if this is intended", pathRule.Path))
+        }
+        rule.inheritSources = inheritSources
+        if rule.noSources() && !rule.inheritSources && len(pathRule.TenantIPs) == 0 {
+            fmt.Printf("Warning: %s, path rule %d (%q) has an empty sourceIPs list (emptyListAction=%s)\n",
+                owner, i, pathRule.Path, actionOrDefault(action))
+        }
+        compiled.truncateIPv6(&rule)
+        report = c.analyzeLists(fmt.Sprintf("path rule %d (%q) ", i, pathRule.Path), &rule, report)
+        schemes, err := compileSchemeRule(pathRule.Schemes, pathRule.SchemeMismatchAction)
+        if err != nil {
+            return report, pathRuleError(i, "schemes", -1, err)
+        }
+        if err := checkDenyStatus("denyStatus", pathRule.DenyStatus); err != nil {
+            return report, pathRuleError(i, "denyStatus", -1, err)
+        }
+        methods, err := compileMethodRule(owner, i, pathRule.Path, pathRule.Methods)
+        if err != nil {
+            return report, pathRuleError(i, "methods", -1, err)
+        }
+        query, err := compileQueryRule(pathRule.Query)
+        if err != nil {
+            return report, pathRuleError(i, "query", -1, err)
+        }
+        pattern, err := compilePathPattern(pathRule.Path, compiled.foldPaths, c.loosePathPrefix, trimSlash)
+        if err != nil {
+            return report, pathRuleError(i, "path", -1, err)
+        }
+        var except []pathPattern
+        for j, path := range pathRule.ExceptPaths {
+            if path == "" {
+                return report, pathRuleError(i, "exceptPaths", j, errors.New("empty exceptPaths entry"))
+            }
+            p, err := compilePathPattern(path, compiled.foldPaths, c.loosePathPrefix, trimSlash)
+            if err != nil {
+                return report, pathRuleError(i, "exceptPaths", j, fmt.Errorf("exceptPaths %q: %w", path, err))
+            }
+            except = append(except, p)
+        }
+        name := pathRule.Name
+        if name == "" {
+            name = fmt.Sprintf("%s[%d]", namePrefix, i)
+        }
+        if names[name] {
+            return report, pathRuleError(i, "name", -1, fmt.Errorf("duplicate rule name %q", name))
+        }
+        names[name] = true
+        compiledRule := compiledPathRule{
+            pathPattern: pattern,
+            name:        name,
+            except:      except,
+            priority:    pathRule.Priority,
+            schemes:     schemes,
+            methods:     methods,
+            query:       query,
+            accessRule:  rule,
+            auditOnly:   pathRule.AuditOnly,
+            denyStatus:  pathRule.DenyStatus,
+        }
+        if err := c.compileTenants(compiled, &compiledRule, pathRule, defaults); err != nil {
+            return report, pathRuleError(i, "", -1, err)
+        }
+        compiled.pathRules = append(compiled.pathRules, compiledRule)
+    }
+    if err := sortPathRules(owner, compiled.pathRules, c.strictPathPriority); err != nil {
+        return report, err
+    }
+    logPathRuleOrder(owner, compiled.pathRules)
+    compiled.router = newPathRouter(compiled.pathRules, c.loosePathPrefix)
+    return report, nil
+}
+
+// pathRuleError locates err at the field of path rule i, and at its entry
+// unless entry is -1. compileDomains adds the domain.
+func pathRuleError(i int, field string, entry int, err error) error {
+    return &ConfigError{PathIndex: i, Field: field, Entry: entry, Reason: err.Error(), Err: err}
+}
+
+// globalRulesOwner names the global path rules in messages and prefixes
+// the names of unnamed ones.
+const globalRulesOwner = "globalPathRules"
+
+// compileGlobalPathRules compiles the globalPathRules into a domain without
+// domain-wide lists, or returns nil if there are none. They follow the
+// plugin-level emptyListAction and normalizeTrailingSlash.
+func (c *compiler) compileGlobalPathRules(rules []PathConfig) (*compiledDomain, error) {
+    if len(rules) == 0 {
+        return nil, nil
+    }
+    rule, err := c.compileAccessRule(ruleFields{})
+    if err != nil {
+        return nil, err
+    }
+    global := &compiledDomain{accessRule: rule, name: globalRulesOwner, allowOnEmpty: c.emptyListAction == emptyListAllowAll}
+    report, err := c.compilePathRules(globalRulesOwner, globalRulesOwner, global, DomainConfig{PathRules: rules}, c.emptyListAction,
+        c.normalizeTrailingSlash, make(map[string]bool), nil)
+    if err != nil {
+        return nil, entryError("globalPathRules", "", err)
+    }
+    if len(report) > 0 {
+        fmt.Printf("Warning: %s have redundant IP list entries:\n", globalRulesOwner)
+        for _, line := range report {
+            fmt.Println("  -", line)
+        }
+    }
+    return global, nil
+}
+
+// validateSkipGlobal checks that the skipGlobalRules of a domain name rules
+// of global, which is nil without globalPathRules.
+func validateSkipGlobal(global *compiledDomain, domain string, skip map[string]bool) error {
+    for name := range skip {
+        found := false
+        if global != nil {
+            for i := range global.pathRules {
+                found = found || global.pathRules[i].name == name
+            }
+        }
+        if !found {
+            return fmt.Errorf("domain %q: skipGlobalRules: no global path rule is named %q", domain, name)
+        }
+    }
+    return nil
+}
+
+// publicPath returns the public path entry that path matches, if any. The
+// path is compared as given, so it must already be folded for foldPaths.
+func (cd *compiledDomain) publicPath(path string) string {
+    for i := range cd.publicPaths {
+        if ok, _ := cd.publicPaths[i].matches(path); ok {
+            return cd.publicPaths[i].path
+        }
+    }
+    return ""
+}
+
+// clientFor returns client as seen by the rules of cd, whose
+// ipv6SubnetLength may differ from the domain client was created for.
+func (cd *compiledDomain) clientFor(client *clientInfo) *clientInfo {
+    if client == nil {
+        return nil
+    }
+    c := *client
+    c.listIP = cd.listAddr(client.ip)
+    return &c
+}
+
+// listAddr returns the address ip is matched as against the domain's IP
+// lists: IPv6 addresses are truncated to ipv6SubnetLength, if set.
+func (cd *compiledDomain) listAddr(ip netip.Addr) netip.Addr {
+    if cd.ipv6Bits == 0 || !ip.Is6() {
+        return ip
+    }
+    return netip.PrefixFrom(ip, cd.ipv6Bits).Masked().Addr()
+}
+
+// truncateIPv6 adapts the IP lists of rule to the domain's ipv6SubnetLength.
+func (cd *compiledDomain) truncateIPv6(rule *accessRule) {
+    if cd.ipv6Bits == 0 {
+        return
+    }
+    for _, list := range []*ipList{rule.sourceIPs, rule.deniedIPs, rule.exceptIPs} {
+        list.truncateIPv6(cd.ipv6Bits)
+    }
+    if rule.acl != nil {
+        rule.acl.truncateIPv6(cd.ipv6Bits)
+    }
+}
+
+// allowlisted reports whether ip is on the sourceIPs list of the domain or
+// of any of its path rules.
+func (cd *compiledDomain) allowlisted(ip netip.Addr) bool {
+    if cd.accessRule.allowlists(ip) {
+        return true
+    }
+    for i := range cd.pathRules {
+        if cd.pathRules[i].allowlists(ip) {
+            return true
+        }
+        for _, t := range cd.pathRules[i].tenants {
+            if t.allowlists(ip) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// isTrue reports whether an optional flag is set to true. The flags of
+// DomainConfig are pointers so that an entry can set false over a template.
+func isTrue(flag *bool) bool {
+    return flag != nil && *flag
+}
+
+// inheritsDefaults reports whether defaultSourceIPs apply to a domain.
+func inheritsDefaults(config DomainConfig) bool {
+    return config.InheritDefaults == nil || *config.InheritDefaults
+}
+
+// withDefaults prepends defaultSourceIPs to the allowlist of f if defaults
+// is set, and reports whether it did. A rule without sources of its own is
+// left alone if fallback is set, i.e. it is decided by an allowAll
+// emptyListAction or the inherited domain lists, which already admit the
+// defaults. Rules with an acl get them as leading "allow" entries.
+func (c *compiler) withDefaults(f ruleFields, defaults, fallback bool) (ruleFields, bool) {
+    if !defaults || len(c.defaultSourceIPs) == 0 {
+        return f, false
+    }
+    own := len(f.sourceIPs) > 0 || len(f.acl) > 0 || len(f.hostSuffixes) > 0 || f.minTier != ""
+    if !own && fallback {
+        return f, false
+    }
+    if len(f.acl) > 0 {
+        acl := make([]string, 0, len(c.defaultSourceIPs)+len(f.acl))
+        for _, entry := range stringEntries(c.defaultSourceIPs) {
+            acl = append(acl, aclAllow+" "+entry.value)
+        }
+        f.acl = append(acl, f.acl...)
+        return f, true
+    }
+    sourceIPs := make([]interface{}, 0, len(c.defaultSourceIPs)+len(f.sourceIPs))
+    for _, entry := range c.defaultSourceIPs {
+        sourceIPs = append(sourceIPs, entry)
+    }
+    f.sourceIPs = append(sourceIPs, f.sourceIPs...)
+    return f, true
+}
+
+// logEffectiveSources logs the allowlist of a rule after defaultSourceIPs
+// were merged into it, with group references expanded.
+func logEffectiveSources(owner string, rule *accessRule) {
+    if rule.acl != nil {
+        entries := make([]string, 0, len(rule.acl.entries))
+        for _, entry := range rule.acl.entries {
+            entries = append(entries, entry.text)
+        }
+        fmt.Printf("Effective acl of %s (with defaultSourceIPs): %v\n", owner, entries)
+        return
+    }
+    fmt.Printf("Effective sourceIPs of %s (with defaultSourceIPs): %v\n", owner, rule.sourceIPs.raw)
+}
+
+// compileTenants compiles the tenantIPs of a path rule, whose path must be
+// a template with a placeholder as its first segment.
+func (c *compiler) compileTenants(cd *compiledDomain, rule *compiledPathRule, config PathConfig, defaults bool) error {
+    switch config.UnknownTenantAction {
+    case "", unknownTenantDeny:
+        rule.denyUnknownTenant = true
+    case unknownTenantSourceIPs:
+    default:
+        return fmt.Errorf("invalid unknownTenantAction %q: must be %q or %q", config.UnknownTenantAction, unknownTenantDeny, unknownTenantSourceIPs)
+    }
+    if len(config.TenantIPs) == 0 {
+        return nil
+    }
+    if rule.glob == nil || len(rule.glob.segments) < 2 || rule.glob.segments[1].param == "" {
+        return errors.New(`tenantIPs requires a path whose first segment is a placeholder, as in "/{tenant}/admin/**"`)
+    }
+    rule.tenants = make(map[string]*accessRule, len(config.TenantIPs))
+    for tenant, ips := range config.TenantIPs {
+        if strings.TrimSpace(strings.Join(ips, "")) == "" {
+            return fmt.Errorf("tenantIPs %q: empty list", tenant)
+        }
+        if defaults {
+            ips = append(append([]string(nil), c.defaultSourceIPs...), ips...)
+        }
+        list, err := c.parseIPList(stringEntries(ips))
+        if err != nil {
+            return fmt.Errorf("tenantIPs %q: %w", tenant, err)
+        }
+        t := rule.accessRule
+        t.sourceIPs = list
+        t.acl, t.hostSuffixes, t.minTier, t.inheritSources = nil, nil, nil, false
+        list.truncateIPv6(cd.ipv6Bits)
+        if cd.foldPaths {
+            tenant = strings.ToLower(tenant)
+        }
+        rule.tenants[tenant] = &t
+    }
+    return nil
+}
+
+// allowlists reports whether ip is on the rule's sourceIPs or matches an
+// allow entry of its acl.
+func (r *accessRule) allowlists(ip netip.Addr) bool {
+    if r.sourceIPs.contains(ip) {
+        return true
+    }
+    if r.acl != nil {
+        e, ok := r.acl.match(ip)
+        return ok && e.allow
+    }
+    return false
+}
+
+// noSources reports whether the rule has nothing that can allow a client,
+// so that emptyListAction decides.
+func (r *accessRule) noSources() bool {
+    return r.sourceIPs.empty() && r.hostSuffixes == nil && r.acl == nil && r.minTier == nil
+}
+
+// allowsAll reports whether the rule's sourceIPs or acl allow every address.
+func (r *accessRule) allowsAll() bool {
+    return r.sourceIPs.allowsAll() || (r.acl != nil && r.acl.allowsAll())
+}
+
+// compileAccessRule parses the IP lists and country lists of a domain or path rule.
+func (c *compiler) compileAccessRule(f ruleFields) (accessRule, error) {
+    var rule accessRule
+    var err error
+    if rule.sourceIPs, err = c.parseSourceIPs(f.sourceIPs); err != nil {
+        return rule, err
+    }
+    if rule.deniedIPs, err = c.parseIPList(stringEntries(f.deniedIPs)); err != nil {
+        return rule, fmt.Errorf("deniedIPs: %w", err)
+    }
+    if rule.exceptIPs, err = c.parseIPList(stringEntries(f.exceptIPs)); err != nil {
+        return rule, fmt.Errorf("exceptIPs: %w", err)
+    }
+
+    if rule.allowedCountries, err = parseCountries(f.allowedCountries); err != nil {
+        return rule, fmt.Errorf("allowedCountries: %w", err)
+    }
+    if rule.deniedCountries, err = parseCountries(f.deniedCountries); err != nil {
+        return rule, fmt.Errorf("deniedCountries: %w", err)
+    }
+    if (rule.allowedCountries != nil || rule.deniedCountries != nil) && c.geo == nil {
+        return rule, fmt.Errorf("country rules require geoIPDatabase to be set")
+    }
+    if rule.hostSuffixes, err = parseHostSuffixes(f.hostSuffixes); err != nil {
+        return rule, fmt.Errorf("sourceHostSuffixes: %w", err)
+    }
+    if rule.acl, err = c.compileACL(f.acl, f.aclDefault); err != nil {
+        return rule, err
+    }
+    if rule.acl != nil && !rule.sourceIPs.empty() {
+        return rule, fmt.Errorf("acl and sourceIPs are mutually exclusive, use one or the other")
+    }
+    if rule.minTier, err = c.lookupTier(f.minTier); err != nil {
+        return rule, err
+    }
+    return rule, nil
+}
+
+// parseSourceIPs parses a sourceIPs list of strings and SourceIP objects.
+func (c *compiler) parseSourceIPs(values []interface{}) (*ipList, error) {
+    entries, err := sourceIPEntries(values)
+    if err != nil {
+        return nil, err
+    }
+    return c.parseIPList(entries)
+}
+
+// parseIPList resolves group references in entries and parses the result.
+func (c *compiler) parseIPList(entries []ipEntry) (*ipList, error) {
+    resolved, err := c.resolveGroups(entries, nil)
+    if err != nil {
+        return nil, err
+    }
+    return c.buildIPList(resolved)
+}
+
+// resolveGroups replaces "@name" entries with the members of the named IP
+// group. Groups may reference other groups; stack holds the chain of groups
+// currently being expanded so that cycles are reported instead of recursing
+// forever. Members inherit the referencing entry's label, or the group
+// reference itself when it has none, and its validUntil.
+func (c *compiler) resolveGroups(entries []ipEntry, stack []string) ([]ipEntry, error) {
+    var resolved []ipEntry
+    for _, entry := range entries {
+        value := strings.TrimSpace(entry.value)
+        if !strings.HasPrefix(value, "@") {
+            resolved = append(resolved, entry)
+            continue
+        }
+
+        name := strings.TrimPrefix(value, "@")
+        members, ok := c.groups[name]
+        if !ok {
+            return nil, fmt.Errorf("unknown ipGroups reference %q", value)
+        }
+        for _, s := range stack {
+            if s == name {
+                return nil, fmt.Errorf("ipGroups cycle: %s -> @%s", strings.Join(stack, " -> @"), name)
+            }
+        }
+
+        label := entry.label
+        if label == "" {
+            label = value
+        }
+        groupEntries := stringEntries(members)
+        for i := range groupEntries {
+            if groupEntries[i].label == "" {
+                groupEntries[i].label = label
+            }
+            groupEntries[i].validUntil = entry.validUntil
+        }
+        expanded, err := c.resolveGroups(groupEntries, append(stack, name))
+        if err != nil {
+            return nil, err
+        }
+        resolved = append(resolved, expanded...)
+    }
+    return resolved, nil
+}
+
+// validateEmptyListAction checks an emptyListAction value; empty means the default.
+func validateEmptyListAction(action string) error {
+    switch action {
+    case "", emptyListDenyAll, emptyListAllowAll:
+        return nil
+    }
+    return fmt.Errorf("invalid emptyListAction %q: must be %q or %q", action, emptyListDenyAll, emptyListAllowAll)
+}
+
+// actionOrDefault returns the effective emptyListAction for logging.
+func actionOrDefault(action string) string {
+    if action == "" {
+        return emptyListDenyAll
+    }
+    return action
+}
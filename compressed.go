@@ -0,0 +1,81 @@
+package DomainSentinel
+
+import (
+    "bytes"
+    "compress/gzip"
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "strings"
+)
+
+// maxCompressedRulesSize bounds the decompressed size of compressedRules,
+// so that a corrupt or hostile blob cannot exhaust memory.
+const maxCompressedRulesSize = 32 << 20
+
+// newCompressedSource returns the source for the compressedRules of config:
+// the base64 encoding of the gzip-compressed JSON of a domainPathRules
+// object, as CompressRules produces it. The rules are decoded once and
+// never change.
+func newCompressedSource(config *Config) (*rulesSource, error) {
+    if len(config.DomainPathRules) > 0 {
+        return nil, errors.New("compressedRules cannot be combined with domainPathRules; move the inline domains into the blob")
+    }
+    src := &rulesSource{name: "compressedRules"}
+    src.fetch = func(context.Context) (map[string]DomainConfig, bool, error) {
+        data, err := decompressRules(config.CompressedRules)
+        if err != nil {
+            return nil, false, fmt.Errorf("compressedRules: %w", err)
+        }
+        rules, err := decodeRules(src.name, data)
+        return rules, err == nil, err
+    }
+    return src, nil
+}
+
+// decompressRules returns the JSON of a compressedRules blob. Whitespace
+// is ignored, as the blob may have been wrapped.
+func decompressRules(blob string) ([]byte, error) {
+    blob = strings.Join(strings.Fields(blob), "")
+    compressed, err := base64.StdEncoding.DecodeString(blob)
+    if err != nil {
+        return nil, fmt.Errorf("not valid base64: %w", err)
+    }
+    zr, err := gzip.NewReader(bytes.NewReader(compressed))
+    if err != nil {
+        return nil, fmt.Errorf("not gzip data, the blob must be base64(gzip(JSON)): %w", err)
+    }
+    defer zr.Close()
+    data, err := io.ReadAll(io.LimitReader(zr, maxCompressedRulesSize+1))
+    if err != nil {
+        return nil, fmt.Errorf("corrupt gzip data: %w", err)
+    }
+    if len(data) > maxCompressedRulesSize {
+        return nil, fmt.Errorf("decompressed rules exceed %d bytes", maxCompressedRulesSize)
+    }
+    return data, nil
+}
+
+// CompressRules returns rules, in the form of domainPathRules, as a
+// compressedRules value: the base64 encoding of their gzip-compressed JSON.
+func CompressRules(rules map[string]DomainConfig) (string, error) {
+    data, err := json.Marshal(rules)
+    if err != nil {
+        return "", err
+    }
+    var buf bytes.Buffer
+    zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+    if err != nil {
+        return "", err
+    }
+    if _, err := zw.Write(data); err != nil {
+        return "", err
+    }
+    if err := zw.Close(); err != nil {
+        return "", err
+    }
+    return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
@@ -0,0 +1,138 @@
+package DomainSentinel
+
+import (
+    "bytes"
+    "compress/gzip"
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "net/http"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestCompressRulesRoundTrip(t *testing.T) {
+    rules := map[string]DomainConfig{
+        "example.com": {
+            SourceIPs: ips("192.0.2.0/24"),
+            PathRules: []PathConfig{{Path: "/admin", SourceIPs: ips("192.0.2.1")}},
+        },
+        "*.example.org": {SourceIPs: ips("10.0.0.0/8"), DeniedIPs: []string{"10.0.0.1"}},
+    }
+    blob, err := CompressRules(rules)
+    if err != nil {
+        t.Fatal(err)
+    }
+    data, err := decompressRules(blob)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var got map[string]DomainConfig
+    if err := json.Unmarshal(data, &got); err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(got, rules) {
+        t.Errorf("round trip = %+v, want %+v", got, rules)
+    }
+
+    // A blob wrapped over several lines decodes the same.
+    var wrapped strings.Builder
+    for i := 0; i < len(blob); i += 40 {
+        end := i + 40
+        if end > len(blob) {
+            end = len(blob)
+        }
+        wrapped.WriteString(blob[i:end] + "\n  ")
+    }
+    if again, err := decompressRules(wrapped.String()); err != nil || !bytes.Equal(again, data) {
+        t.Errorf("wrapped blob: %v", err)
+    }
+}
+
+func TestCompressedRulesExclusive(t *testing.T) {
+    blob, err := CompressRules(map[string]DomainConfig{"example.com": {SourceIPs: ips("192.0.2.0/24")}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    for name, config := range map[string]*Config{
+        "domainPathRules": domainConfig("other.example.com", DomainConfig{SourceIPs: ips("10.0.0.1")}),
+        "rulesFile":       {RulesFile: "/etc/traefik/rules.json"},
+        "rulesURL":        {RulesURL: "https://rules.example.com/rules.json"},
+    } {
+        config.CompressedRules = blob
+        if _, err := New(context.Background(), okHandler, config, "test"); err == nil || !strings.Contains(err.Error(), "compressedRules") {
+            t.Errorf("compressedRules with %s: got %v, want an error naming compressedRules", name, err)
+        }
+    }
+}
+
+func TestCompressedRulesLimit(t *testing.T) {
+    compress := func(size int) string {
+        var buf bytes.Buffer
+        zw := gzip.NewWriter(&buf)
+        if _, err := zw.Write(bytes.Repeat([]byte{' '}, size)); err != nil {
+            t.Fatal(err)
+        }
+        if err := zw.Close(); err != nil {
+            t.Fatal(err)
+        }
+        return base64.StdEncoding.EncodeToString(buf.Bytes())
+    }
+
+    // A blob of well under a megabyte that inflates past the limit is refused.
+    bomb := compress(maxCompressedRulesSize + 1)
+    if len(bomb) > 128<<10 {
+        t.Fatalf("test blob is %d bytes, want a small one", len(bomb))
+    }
+    if _, err := decompressRules(bomb); err == nil || !strings.Contains(err.Error(), "exceed") {
+        t.Errorf("blob inflating past the limit: got %v, want a size error", err)
+    }
+    if data, err := decompressRules(compress(maxCompressedRulesSize)); err != nil || len(data) != maxCompressedRulesSize {
+        t.Errorf("blob inflating to the limit: got %d bytes, %v", len(data), err)
+    }
+}
+
+func TestCompressedRules(t *testing.T) {
+    blob, err := CompressRules(map[string]DomainConfig{
+        "example.com": {
+            SourceIPs: ips("192.0.2.0/24"),
+            PathRules: []PathConfig{{Path: "/admin", SourceIPs: ips("192.0.2.1")}},
+        },
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    config := CreateConfig()
+    config.CompressedRules = blob
+    checkStatuses(t, newTestSentinel(t, config), []statusCase{
+        {"http://example.com/", "192.0.2.5:1234", http.StatusOK},
+        {"http://example.com/", "198.51.100.1:1234", http.StatusForbidden},
+        {"http://example.com/admin", "192.0.2.1:1234", http.StatusOK},
+        {"http://example.com/admin", "192.0.2.5:1234", http.StatusForbidden},
+    })
+
+    gzipped := func(data string) string {
+        var buf bytes.Buffer
+        zw := gzip.NewWriter(&buf)
+        zw.Write([]byte(data))
+        zw.Close()
+        return base64.StdEncoding.EncodeToString(buf.Bytes())
+    }
+    truncated, _ := base64.StdEncoding.DecodeString(blob)
+    for _, tt := range []struct {
+        name, blob, want string
+    }{
+        {"bad base64", "not base64!", "not valid base64"},
+        {"not gzip", base64.StdEncoding.EncodeToString([]byte(`{"example.com": {}}`)), "not gzip data"},
+        {"truncated gzip", base64.StdEncoding.EncodeToString(truncated[:len(truncated)-8]), "corrupt gzip data"},
+        {"invalid JSON", gzipped(`{"example.com": {"sourceIPs": [`), "compressedRules"},
+        {"invalid rules", gzipped(`{"example.com": {"sourceIPs": ["192.0.2.0/33"]}}`), "192.0.2.0/33"},
+    } {
+        config := CreateConfig()
+        config.CompressedRules = tt.blob
+        if _, err := New(context.Background(), okHandler, config, "test"); err == nil || !strings.Contains(err.Error(), tt.want) {
+            t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+        }
+    }
+}
@@ -0,0 +1,398 @@
+package DomainSentinel
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+)
+
+// debugEndpoint serves the effective configuration at debugPath to the
+// addresses of debugSourceIPs.
+type debugEndpoint struct {
+    path   string
+    host   string // empty for every host, else in the form of lookup keys
+    admins *ipList
+}
+
+// compileDebugEndpoint returns the debug endpoint of config, or nil if
+// debugPath is not set. It requires debugSourceIPs, so that the endpoint
+// is never open to everyone.
+func (c *compiler) compileDebugEndpoint(config *Config) (*debugEndpoint, error) {
+    if config.DebugPath == "" {
+        if config.DebugHost != "" || len(config.DebugSourceIPs) > 0 {
+            return nil, fmt.Errorf("debugHost and debugSourceIPs are set without a debugPath")
+        }
+        return nil, nil
+    }
+    if !strings.HasPrefix(config.DebugPath, "/") {
+        return nil, fmt.Errorf("invalid debugPath %q: must start with \"/\"", config.DebugPath)
+    }
+    if len(config.DebugSourceIPs) == 0 {
+        return nil, fmt.Errorf("debugPath %q requires debugSourceIPs", config.DebugPath)
+    }
+    admins, err := c.parseIPList(stringEntries(config.DebugSourceIPs))
+    if err != nil {
+        return nil, fmt.Errorf("debugSourceIPs: %w", err)
+    }
+    if admins.allowsAll() {
+        return nil, fmt.Errorf("debugSourceIPs must not allow every address")
+    }
+    d := &debugEndpoint{path: config.DebugPath, admins: admins}
+    if config.DebugHost != "" {
+        if d.host, err = toASCII(strings.TrimSuffix(strings.ToLower(config.DebugHost), ".")); err != nil {
+            return nil, fmt.Errorf("invalid debugHost %q: %w", config.DebugHost, err)
+        }
+    }
+    host := d.host
+    if host == "" {
+        host = "every host"
+    }
+    fmt.Printf("Debug endpoint %s enabled on %s for %v\n", d.path, host, admins.raw)
+    return d, nil
+}
+
+// serveDebug answers a request for the debug endpoint on host with the
+// effective configuration. It reports false for requests that are not for
+// the endpoint, or that come from an address outside debugSourceIPs; those
+// are handled like any other request, so the endpoint stays invisible.
+func (ds *DomainSentinel) serveDebug(rw http.ResponseWriter, req *http.Request, host string) bool {
+    d := ds.debug
+    if req.URL.Path != d.path || d.host != "" && host != d.host {
+        return false
+    }
+    socketIP, err := clientIP(req)
+    if err != nil {
+        return false
+    }
+    ip, _, status := ds.ipStrategy.clientAddr(req, socketIP)
+    if status != 0 || !d.admins.contains(ip) {
+        fmt.Printf("Debug endpoint requested by %s, which is not in debugSourceIPs; handling it as a regular request\n", socketIP)
+        return false
+    }
+    fmt.Printf("Serving the effective configuration to %s (debugPath)\n", ip)
+    body, err := json.MarshalIndent(ds.effectiveConfig(), "", "  ")
+    if err != nil {
+        http.Error(rw, "DS: Internal Server Error", http.StatusInternalServerError)
+        return true
+    }
+    rw.Header().Set("Content-Type", "application/json")
+    rw.Header().Set("Cache-Control", "no-store")
+    rw.Write(append(body, '\n'))
+    return true
+}
+
+// debugConfig is the document served at debugPath.
+type debugConfig struct {
+    Options         debugOptions    `json:"options"`
+    Domains         []debugDomain   `json:"domains"`
+    GlobalPathRules []debugPathRule `json:"globalPathRules,omitempty"`
+}
+
+// debugOptions are the plugin-level settings in effect, with defaults
+// filled in.
+type debugOptions struct {
+    DefaultAction                string      `json:"defaultAction"`
+    DenyStatus                   int         `json:"denyStatus"`
+    DefaultDenyStatus            int         `json:"defaultDenyStatus"`
+    AuditMode                    bool        `json:"auditMode"`
+    HostSource                   string      `json:"hostSource"`
+    StrictHost                   bool        `json:"strictHost"`
+    DecodeEncodedSlashes         bool        `json:"decodeEncodedSlashes"`
+    LoosePathPrefix              bool        `json:"loosePathPrefix"`
+    NormalizeTrailingSlash       bool        `json:"normalizeTrailingSlash"`
+    OnAddressError               string      `json:"onAddressError"`
+    EmptyListAction              string      `json:"emptyListAction"`
+    CountryMatch                 string      `json:"countryMatch"`
+    UnknownCountryAction         string      `json:"unknownCountryAction"`
+    UnknownASNAction             string      `json:"unknownASNAction"`
+    ReverseDNSFailureAction      string      `json:"reverseDNSFailureAction"`
+    IPStrategy                   string      `json:"ipStrategy"`
+    TrustedProxies               []string    `json:"trustedProxies,omitempty"`
+    DefaultSourceIPs             []string    `json:"defaultSourceIPs,omitempty"`
+    DefaultSourceIPsForPathRules bool        `json:"defaultSourceIPsForPathRules"`
+    Tiers                        []debugTier `json:"tiers,omitempty"`
+}
+
+type debugTier struct {
+    Name      string   `json:"name"`
+    Level     int      `json:"level"`
+    SourceIPs []string `json:"sourceIPs"`
+}
+
+// debugDomain is a domain rule as the lookup sees it: Key is the
+// normalized key it is found under, and Match the kind of key.
+type debugDomain struct {
+    Key   string   `json:"key"`
+    Match string   `json:"match"` // exact, wildcard, zone, regex, catchAll or ordered
+    Rule  string   `json:"rule"`
+    Hosts []string `json:"hosts,omitempty"`
+
+    Extends           string   `json:"extends,omitempty"`
+    Override          string   `json:"override,omitempty"` // overrideMode of the override rule applied
+    Disabled          bool     `json:"disabled,omitempty"`
+    AuditOnly         bool     `json:"auditOnly,omitempty"`
+    DenyStatus        int      `json:"denyStatus,omitempty"`
+    Priority          int      `json:"priority,omitempty"`
+    IncludeSubdomains bool     `json:"includeSubdomains,omitempty"`
+    MatchApex         bool     `json:"matchApex,omitempty"`
+    Inherits          string   `json:"inherits,omitempty"`
+    Schemes           string   `json:"schemes,omitempty"`
+    PublicPaths       []string `json:"publicPaths,omitempty"`
+    SkipGlobalRules   []string `json:"skipGlobalRules,omitempty"`
+    EmptyListAllowAll bool     `json:"emptyListAllowAll,omitempty"`
+    debugAccess
+    PathRules []debugPathRule `json:"pathRules,omitempty"`
+}
+
+// debugPathRule is a path rule, listed in evaluation order.
+type debugPathRule struct {
+    Name        string              `json:"name"`
+    Path        string              `json:"path"`
+    Priority    int                 `json:"priority,omitempty"`
+    Methods     string              `json:"methods,omitempty"`
+    Schemes     string              `json:"schemes,omitempty"`
+    Query       string              `json:"query,omitempty"`
+    ExceptPaths []string            `json:"exceptPaths,omitempty"`
+    AuditOnly   *bool               `json:"auditOnly,omitempty"`
+    DenyStatus  int                 `json:"denyStatus,omitempty"`
+    TenantIPs   map[string][]string `json:"tenantIPs,omitempty"`
+    debugAccess
+}
+
+// debugAccess holds the checks of an accessRule, with groups and defaults
+// expanded.
+type debugAccess struct {
+    SourceIPs          []string `json:"sourceIPs,omitempty"`
+    ACL                []string `json:"acl,omitempty"`
+    DeniedIPs          []string `json:"deniedIPs,omitempty"`
+    ExceptIPs          []string `json:"exceptIPs,omitempty"`
+    AllowedCountries   []string `json:"allowedCountries,omitempty"`
+    DeniedCountries    []string `json:"deniedCountries,omitempty"`
+    SourceHostSuffixes []string `json:"sourceHostSuffixes,omitempty"`
+    MinTier            string   `json:"minTier,omitempty"`
+    InheritDomainIPs   bool     `json:"inheritDomainIPs,omitempty"`
+}
+
+// effectiveConfig describes the rules currently in effect, including those
+// of the last reload.
+func (ds *DomainSentinel) effectiveConfig() *debugConfig {
+    config := ds.config
+    out := &debugConfig{
+        Options: debugOptions{
+            DefaultAction:                config.DefaultAction,
+            DenyStatus:                   ds.denyStatus,
+            DefaultDenyStatus:            ds.defaultStatus,
+            AuditMode:                    ds.auditMode,
+            HostSource:                   ds.hostSource,
+            StrictHost:                   ds.strictHost,
+            DecodeEncodedSlashes:         ds.decodeSlashes,
+            LoosePathPrefix:              config.LoosePathPrefix,
+            NormalizeTrailingSlash:       config.NormalizeTrailingSlash,
+            OnAddressError:               ds.onAddressError,
+            EmptyListAction:              actionOrDefault(config.EmptyListAction),
+            CountryMatch:                 config.CountryMatch,
+            UnknownCountryAction:         config.UnknownCountryAction,
+            UnknownASNAction:             config.UnknownASNAction,
+            ReverseDNSFailureAction:      config.ReverseDNSFailureAction,
+            IPStrategy:                   ds.ipStrategy.mode,
+            TrustedProxies:               debugIPs(ds.ipStrategy.trustedProxies),
+            DefaultSourceIPs:             config.DefaultSourceIPs,
+            DefaultSourceIPsForPathRules: config.DefaultSourceIPsForPathRules,
+        },
+        Domains: ds.table().debugDomains(),
+    }
+    if out.Options.DefaultAction == "" {
+        out.Options.DefaultAction = defaultActionAllow
+    }
+    for _, t := range ds.tiers {
+        out.Options.Tiers = append(out.Options.Tiers, debugTier{Name: t.name, Level: t.level, SourceIPs: debugIPs(t.list)})
+    }
+    if ds.global != nil {
+        out.GlobalPathRules = debugPathRules(ds.global)
+    }
+    return out
+}
+
+// debugDomains lists the rules of the table in the order lookup tries
+// them: port-specific keys, exact and wildcard keys, zones, regex keys and
+// the catch-all, or the rules list in its own order.
+func (t *domainTable) debugDomains() []debugDomain {
+    domains := []debugDomain{}
+    if t.ordered != nil {
+        for _, rule := range t.ordered {
+            d := newDebugDomain(rule.key, "ordered", rule.cd)
+            for _, m := range rule.hosts {
+                d.Hosts = append(d.Hosts, m.pattern)
+            }
+            domains = append(domains, d)
+        }
+        return domains
+    }
+    ports := make([]string, 0, len(t.ports))
+    for port := range t.ports {
+        ports = append(ports, port)
+    }
+    sort.Strings(ports)
+    for _, port := range ports {
+        for _, d := range t.ports[port].keyedDomains() {
+            d.Key += ":" + port
+            domains = append(domains, d)
+        }
+    }
+    domains = append(domains, t.keyedDomains()...)
+    for _, zone := range sortedDomainKeys(t.zones) {
+        domains = append(domains, newDebugDomain(zone, "zone", t.zones[zone]))
+    }
+    for _, r := range t.regexes {
+        domains = append(domains, newDebugDomain(r.key, "regex", r.cd))
+    }
+    if t.fallback != nil {
+        domains = append(domains, newDebugDomain(catchAllKey, "catchAll", t.fallback))
+    }
+    return domains
+}
+
+// keyedDomains lists the exact and wildcard keys of t, sorted.
+func (t *domainTable) keyedDomains() []debugDomain {
+    var domains []debugDomain
+    for _, key := range sortedDomainKeys(t.exact) {
+        domains = append(domains, newDebugDomain(key, "exact", t.exact[key]))
+    }
+    for _, key := range sortedDomainKeys(t.wildcards) {
+        domains = append(domains, newDebugDomain(wildcardPrefix+key, "wildcard", t.wildcards[key]))
+    }
+    return domains
+}
+
+func sortedDomainKeys(m map[string]*compiledDomain) []string {
+    keys := make([]string, 0, len(m))
+    for key := range m {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+func newDebugDomain(key, match string, cd *compiledDomain) debugDomain {
+    d := debugDomain{
+        Key:               key,
+        Match:             match,
+        Rule:              cd.name,
+        Extends:           cd.extends,
+        Override:          cd.override,
+        Disabled:          cd.disabled,
+        AuditOnly:         cd.auditOnly,
+        DenyStatus:        cd.denyStatus,
+        Priority:          cd.priority,
+        IncludeSubdomains: cd.includeSubdomains,
+        MatchApex:         cd.matchApex,
+        Inherits:          cd.parentKey,
+        Schemes:           debugSchemes(cd.schemes),
+        EmptyListAllowAll: cd.allowOnEmpty,
+        debugAccess:       newDebugAccess(&cd.accessRule),
+        PathRules:         debugPathRules(cd),
+    }
+    for _, p := range cd.publicPaths {
+        d.PublicPaths = append(d.PublicPaths, p.path)
+    }
+    for name := range cd.skipGlobal {
+        d.SkipGlobalRules = append(d.SkipGlobalRules, name)
+    }
+    sort.Strings(d.SkipGlobalRules)
+    return d
+}
+
+func debugPathRules(cd *compiledDomain) []debugPathRule {
+    var rules []debugPathRule
+    for i := range cd.pathRules {
+        rule := &cd.pathRules[i]
+        r := debugPathRule{
+            Name:        rule.name,
+            Path:        rule.path,
+            Priority:    rule.priority,
+            Schemes:     debugSchemes(rule.schemes),
+            AuditOnly:   rule.auditOnly,
+            DenyStatus:  rule.denyStatus,
+            debugAccess: newDebugAccess(&rule.accessRule),
+        }
+        if len(rule.methods) > 0 {
+            r.Methods = rule.methods.String()
+        }
+        if len(rule.query) > 0 {
+            r.Query = rule.query.String()
+        }
+        for _, except := range rule.except {
+            r.ExceptPaths = append(r.ExceptPaths, except.path)
+        }
+        if rule.tenants != nil {
+            r.TenantIPs = make(map[string][]string, len(rule.tenants))
+            for tenant, t := range rule.tenants {
+                r.TenantIPs[tenant] = debugIPs(t.sourceIPs)
+            }
+        }
+        rules = append(rules, r)
+    }
+    return rules
+}
+
+func newDebugAccess(rule *accessRule) debugAccess {
+    a := debugAccess{
+        SourceIPs:          debugIPs(rule.sourceIPs),
+        DeniedIPs:          debugIPs(rule.deniedIPs),
+        ExceptIPs:          debugIPs(rule.exceptIPs),
+        AllowedCountries:   sortedSet(rule.allowedCountries),
+        DeniedCountries:    sortedSet(rule.deniedCountries),
+        SourceHostSuffixes: rule.hostSuffixes,
+        InheritDomainIPs:   rule.inheritSources,
+    }
+    if rule.acl != nil {
+        for _, e := range rule.acl.entries {
+            a.ACL = append(a.ACL, e.text)
+        }
+        if rule.acl.defaultAllow {
+            a.ACL = append(a.ACL, "default allow")
+        } else {
+            a.ACL = append(a.ACL, "default deny")
+        }
+    }
+    if rule.minTier != nil {
+        a.MinTier = rule.minTier.name
+    }
+    return a
+}
+
+// debugIPs lists the entries of l in canonical form, with their labels.
+func debugIPs(l *ipList) []string {
+    if l == nil {
+        return nil
+    }
+    entries := make([]string, len(l.raw))
+    for i := range l.raw {
+        entries[i] = l.describe(i)
+    }
+    return entries
+}
+
+func debugSchemes(r schemeRule) string {
+    switch {
+    case len(r.schemes) == 0:
+        return ""
+    case r.deny:
+        return r.String() + " (schemeMismatchAction=deny)"
+    }
+    return r.String()
+}
+
+func sortedSet(set map[string]struct{}) []string {
+    if len(set) == 0 {
+        return nil
+    }
+    keys := make([]string, 0, len(set))
+    for key := range set {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
@@ -0,0 +1,75 @@
+package DomainSentinel
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestDebugEndpoint(t *testing.T) {
+    newConfig := func(host string) *Config {
+        config := domainConfig("example.com", DomainConfig{SourceIPs: ips("192.0.2.0/24")})
+        config.DebugPath = "/_domainsentinel/config"
+        config.DebugHost = host
+        config.DebugSourceIPs = []string{"10.0.0.0/24"}
+        return config
+    }
+    isDebug := func(rw *httptest.ResponseRecorder) bool {
+        return rw.Header().Get("Content-Type") == "application/json"
+    }
+
+    handler := newTestSentinel(t, newConfig(""))
+    rw := serve(handler, "http://example.com/_domainsentinel/config", "10.0.0.5:1234")
+    var doc debugConfig
+    if rw.Code != http.StatusOK || !isDebug(rw) || rw.Header().Get("Cache-Control") != "no-store" {
+        t.Fatalf("admin: status %d, headers %v, want the configuration", rw.Code, rw.Header())
+    }
+    if err := json.Unmarshal(rw.Body.Bytes(), &doc); err != nil {
+        t.Fatalf("admin: invalid JSON: %v", err)
+    }
+    if len(doc.Domains) != 1 || doc.Domains[0].Key != "example.com" || len(doc.Domains[0].SourceIPs) != 1 {
+        t.Errorf("admin: domains = %+v, want example.com with its sourceIPs", doc.Domains)
+    }
+
+    // Anyone else gets the path evaluated like any other request.
+    for _, tt := range []statusCase{
+        {"http://example.com/_domainsentinel/config", "192.0.2.5:1234", http.StatusOK},
+        {"http://example.com/_domainsentinel/config", "198.51.100.1:1234", http.StatusForbidden},
+    } {
+        rw := serve(handler, tt.target, tt.remoteAddr)
+        if rw.Code != tt.want || isDebug(rw) {
+            t.Errorf("%s from %s: status %d, Content-Type %q, want %d without the configuration",
+                tt.target, tt.remoteAddr, rw.Code, rw.Header().Get("Content-Type"), tt.want)
+        }
+    }
+
+    // With debugHost, the path is the endpoint on that host only.
+    handler = newTestSentinel(t, newConfig("Admin.Example.com."))
+    if rw := serve(handler, "http://admin.example.com/_domainsentinel/config", "10.0.0.5:1234"); rw.Code != http.StatusOK || !isDebug(rw) {
+        t.Errorf("admin on debugHost: status %d, want the configuration", rw.Code)
+    }
+    if rw := serve(handler, "http://example.com/_domainsentinel/config", "10.0.0.5:1234"); rw.Code != http.StatusForbidden || isDebug(rw) {
+        t.Errorf("admin on another host: status %d, want 403 without the configuration", rw.Code)
+    }
+
+    // Without debugPath there is no endpoint.
+    config := domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.0/24")})
+    if rw := serve(newTestSentinel(t, config), "http://example.com/_domainsentinel/config", "10.0.0.5:1234"); isDebug(rw) {
+        t.Error("debug endpoint served without debugPath")
+    }
+}
+
+func TestDebugEndpointErrors(t *testing.T) {
+    for name, config := range map[string]*Config{
+        "without debugSourceIPs": {DebugPath: "/_domainsentinel/config"},
+        "relative debugPath":     {DebugPath: "config", DebugSourceIPs: []string{"10.0.0.1"}},
+        "allow-all admins":       {DebugPath: "/_domainsentinel/config", DebugSourceIPs: []string{"0.0.0.0/0", "::/0"}},
+        "debugHost alone":        {DebugHost: "admin.example.com"},
+    } {
+        if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+            t.Errorf("%s: accepted", name)
+        }
+    }
+}
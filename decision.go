@@ -0,0 +1,274 @@
+package DomainSentinel
+
+import (
+    "context"
+    "fmt"
+    "net/netip"
+)
+
+// clientInfo is what is known about the client of a request. Attributes
+// that need a database lookup are resolved on first use.
+type clientInfo struct {
+    ip     netip.Addr
+    listIP netip.Addr      // ip as matched against IP lists, see ipv6SubnetLength
+    ctx    context.Context // of the request, bounds DNS lookups
+
+    country         string
+    countryKnown    bool
+    countryResolved bool
+
+    asn         uint32
+    asnKnown    bool
+    asnResolved bool
+
+    tier         *tier // nil if no tier matches
+    tierResolved bool
+
+    deniedBy     string // the rule that denied the client, set by decided
+    deniedAudit  *bool  // auditOnly of the path rule that denied the client, if set
+    deniedStatus int    // denyStatus of the path rule that denied the client, if set
+}
+
+// deniedAs records on c, the client that other was copied from by
+// clientFor, the rule that denied other.
+func (c *clientInfo) deniedAs(other *clientInfo) *clientInfo {
+    c.deniedBy, c.deniedAudit, c.deniedStatus = other.deniedBy, other.deniedAudit, other.deniedStatus
+    return c
+}
+
+// countryOf returns the client's country code, looking it up once.
+func (ds *DomainSentinel) countryOf(client *clientInfo) (string, bool) {
+    if !client.countryResolved {
+        client.country, client.countryKnown = ds.geo.country(client.ip)
+        client.countryResolved = true
+    }
+    return client.country, client.countryKnown
+}
+
+// asnOf returns the client's autonomous system number, looking it up once.
+func (ds *DomainSentinel) asnOf(client *clientInfo) (uint32, bool) {
+    if !client.asnResolved {
+        client.asn, client.asnKnown = ds.asn.asn(client.ip)
+        client.asnResolved = true
+        if client.asnKnown {
+            fmt.Printf("Resolved ASN of %s: AS%d\n", client.ip, client.asn)
+        }
+    }
+    return client.asn, client.asnKnown
+}
+
+// evaluate checks the socket client and, with requireBothAddresses, the
+// forwarded client against rule. It returns the client that was denied, or
+// nil if the request may pass. forwarded is nil if the request carries no
+// forwarded address, in which case the socket address alone decides.
+func (ds *DomainSentinel) evaluate(cd *compiledDomain, rule *accessRule, client, forwarded *clientInfo) *clientInfo {
+    if !ds.isAllowed(cd, rule, client) {
+        if forwarded != nil {
+            fmt.Println("requireBothAddresses: socket address", client.ip, "is not allowed")
+        }
+        return client
+    }
+    if forwarded == nil {
+        return nil
+    }
+    if !ds.isAllowed(cd, rule, forwarded) {
+        fmt.Println("requireBothAddresses: forwarded address", forwarded.ip, "is not allowed, socket address", client.ip, "is")
+        return forwarded
+    }
+    return nil
+}
+
+// tierOf returns the highest tier whose addresses include the client, or nil.
+func (ds *DomainSentinel) tierOf(client *clientInfo) *tier {
+    if !client.tierResolved {
+        for _, t := range ds.tiers {
+            if t.list.contains(client.ip) {
+                client.tier = t
+                break
+            }
+        }
+        client.tierResolved = true
+        fmt.Println("Client tier of", client.ip, "is", client.tier)
+    }
+    return client.tier
+}
+
+// isAllowed decides whether the client may pass rule and the domain-wide
+// ASN restrictions of cd.
+func (ds *DomainSentinel) isAllowed(cd *compiledDomain, rule *accessRule, client *clientInfo) bool {
+    if cd.allowedASNs == nil && cd.deniedASNs == nil {
+        return ds.isRuleAllowed(cd, rule, client)
+    }
+
+    asn, known := ds.asnOf(client)
+    switch {
+    case !known && !ds.allowUnknownASN:
+        fmt.Println("ASN of", client.ip, "is unknown, denying access (unknownASNAction=deny)")
+        return false
+    case known && cd.deniedASNs != nil:
+        if _, denied := cd.deniedASNs[asn]; denied {
+            fmt.Printf("ASN is explicitly denied: %s AS%d\n", client.ip, asn)
+            return false
+        }
+    }
+    if known && cd.allowedASNs != nil {
+        if _, ok := cd.allowedASNs[asn]; !ok {
+            fmt.Printf("No ASN match found, denying access: %s AS%d\n", client.ip, asn)
+            return false
+        }
+    }
+
+    allowed := ds.isRuleAllowed(cd, rule, client)
+    if known {
+        fmt.Printf("Decision for %s (AS%d): allowed=%v\n", client.ip, asn, allowed)
+    } else {
+        fmt.Printf("Decision for %s (ASN unknown): allowed=%v\n", client.ip, allowed)
+    }
+    return allowed
+}
+
+// isRuleAllowed decides whether the client may pass rule, which is either the
+// domain-wide rule of cd or one of its path rules. The checks run in this
+// order:
+//
+//  1. deniedIPs: a path rule's deniedIPs before the domain-wide deniedIPs. A
+//     client on any of them is rejected, no matter which allow list applies.
+//  2. deniedCountries, in the same order.
+//  3. exceptIPs of the rule being evaluated. Exceptions only carve holes
+//     into their own allow list: a domain-level exception does not affect a
+//     matching path rule, which has its own exceptIPs.
+//  4. minTier, then sourceIPs or the acl in its place, then
+//     sourceHostSuffixes verified by reverse DNS. If none is set, the
+//     domain's emptyListAction applies.
+//  5. allowedCountries, combined with the result of 4 according to
+//     countryMatch ("and" requires both, "or" either).
+//
+// Clients whose country is unknown follow unknownCountryAction in steps 2
+// and 5.
+func (ds *DomainSentinel) isRuleAllowed(cd *compiledDomain, rule *accessRule, client *clientInfo) bool {
+    rules := []*accessRule{rule}
+    if rule != &cd.accessRule {
+        rules = append(rules, &cd.accessRule)
+    }
+
+    for _, r := range rules {
+        if index, ok := r.deniedIPs.lookup(client.listIP); ok {
+            fmt.Println("IP is explicitly denied:", client.ip, "matched", r.deniedIPs.describe(index))
+            return false
+        }
+    }
+    for _, r := range rules {
+        if r.deniedCountries == nil {
+            continue
+        }
+        country, known := ds.countryOf(client)
+        if !known && !ds.allowUnknownCountry {
+            fmt.Println("Country of", client.ip, "is unknown, denying access (unknownCountryAction=deny)")
+            return false
+        }
+        if _, denied := r.deniedCountries[country]; known && denied {
+            fmt.Println("Country is explicitly denied:", client.ip, country)
+            return false
+        }
+    }
+
+    if index, ok := rule.exceptIPs.lookup(client.listIP); ok {
+        fmt.Println("IP is excepted from the source IP list:", client.ip, "matched", rule.exceptIPs.describe(index))
+        return false
+    }
+
+    ipAllowed := ds.isSourceAllowed(cd, rule, client)
+    if rule.allowedCountries == nil {
+        return ipAllowed
+    }
+    if ipAllowed == ds.countryMatchOr {
+        // "or" with an allowed IP, or "and" with a rejected IP: the country
+        // cannot change the outcome.
+        return ipAllowed
+    }
+
+    country, known := ds.countryOf(client)
+    if !known {
+        fmt.Println("Country of", client.ip, "is unknown, unknownCountryAction allows:", ds.allowUnknownCountry)
+        return ds.allowUnknownCountry
+    }
+    if _, ok := rule.allowedCountries[country]; ok {
+        fmt.Println("Country match found:", client.ip, country)
+        return true
+    }
+    fmt.Println("No country match found, denying access:", client.ip, country)
+    return false
+}
+
+// isSourceAllowed checks the client against the rule's sourceIPs and, if
+// they do not match, its sourceHostSuffixes. A path rule without sources
+// and with inheritDomainIPs uses the sources of the domain.
+func (ds *DomainSentinel) isSourceAllowed(cd *compiledDomain, rule *accessRule, client *clientInfo) bool {
+    if rule.inheritSources && rule != &cd.accessRule && rule.noSources() {
+        fmt.Println("Path rule has no sourceIPs, using the domain's (inheritDomainIPs):", cd.sourceIPs.raw)
+        rule = &cd.accessRule
+    }
+    if rule.minTier != nil {
+        t := ds.tierOf(client)
+        if t != nil && t.level >= rule.minTier.level {
+            fmt.Println("Tier match found:", client.ip, "has tier", t, "minTier", rule.minTier)
+            return true
+        }
+        fmt.Println("Client", client.ip, "with tier", t, "is below minTier", rule.minTier)
+        if rule.sourceIPs.empty() && rule.acl == nil && rule.hostSuffixes == nil {
+            return false
+        }
+    }
+    if rule.acl != nil {
+        if e, ok := rule.acl.match(client.listIP); ok {
+            fmt.Printf("ACL entry %q matched %s, allowed=%v\n", e.text, client.ip, e.allow)
+            return e.allow
+        }
+        if rule.hostSuffixes == nil {
+            fmt.Println("No ACL entry matched", client.ip, "aclDefault allows:", rule.acl.defaultAllow)
+            return rule.acl.defaultAllow
+        }
+    } else if rule.hostSuffixes == nil {
+        return cd.isIPAllowed(client.listIP, rule.sourceIPs)
+    } else if index, ok := rule.sourceIPs.lookup(client.listIP); ok {
+        fmt.Println("IP match found:", client.ip, "matched", rule.sourceIPs.describe(index))
+        return true
+    }
+
+    names, ok := ds.rdns.names(client.ctx, client.ip)
+    if name, matched := matchHostSuffix(names, rule.hostSuffixes); matched {
+        fmt.Println("Reverse DNS match found:", client.ip, "verified as", name)
+        return true
+    }
+    if !ok {
+        fmt.Println("Reverse DNS lookup failed for", client.ip, "reverseDNSFailureAction allows:", ds.allowOnReverseDNSFail)
+        return ds.allowOnReverseDNSFail
+    }
+    if rule.acl != nil {
+        fmt.Println("No ACL entry or reverse DNS match for", client.ip, "aclDefault allows:", rule.acl.defaultAllow)
+        return rule.acl.defaultAllow
+    }
+    fmt.Println("No IP or reverse DNS match found, denying access:", client.ip, names)
+    return false
+}
+
+// isIPAllowed checks ip against an allow list. An empty list follows the
+// domain's emptyListAction.
+func (cd *compiledDomain) isIPAllowed(ip netip.Addr, allowedIPs *ipList) bool {
+    if allowedIPs.empty() {
+        if cd.allowOnEmpty {
+            fmt.Println("Empty source IP list, allowing access (emptyListAction=allowAll)")
+            return true
+        }
+        fmt.Println("Empty source IP list, denying access (emptyListAction=denyAll)")
+        return false
+    }
+
+    if index, ok := allowedIPs.lookup(ip); ok {
+        fmt.Println("IP match found:", ip, "matched", allowedIPs.describe(index))
+        return true
+    }
+
+    fmt.Println("No IP match found, denying access")
+    return false
+}
@@ -0,0 +1,109 @@
+package DomainSentinel
+
+import (
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestDeniedIPsPrecedence(t *testing.T) {
+    handler := newTestSentinel(t, domainConfig("example.com", DomainConfig{
+        SourceIPs: ips("10.0.0.0/8"),
+        DeniedIPs: []string{"10.0.0.5", "10.1.0.0/16"},
+        PathRules: []PathConfig{
+            {Path: "/admin", SourceIPs: ips("10.2.0.0/16", "10.1.2.0/24"), DeniedIPs: []string{"10.2.3.0/24"}},
+            {Path: "/public", SourceIPs: ips("0.0.0.0/0")},
+        },
+    }))
+    checkStatuses(t, handler, []statusCase{
+        // Domain-wide: deny wins over the overlapping allow.
+        {"http://example.com/", "10.0.0.4:1234", http.StatusOK},
+        {"http://example.com/", "10.0.0.5:1234", http.StatusForbidden},
+        {"http://example.com/", "10.1.200.1:1234", http.StatusForbidden},
+        {"http://example.com/", "192.0.2.1:1234", http.StatusForbidden},
+        // Path rule: its own denials win over its allow list.
+        {"http://example.com/admin", "10.2.4.1:1234", http.StatusOK},
+        {"http://example.com/admin", "10.2.3.1:1234", http.StatusForbidden},
+        // The domain's denials apply inside path rules, even when the path
+        // rule allows the address.
+        {"http://example.com/admin", "10.1.2.3:1234", http.StatusForbidden},
+        {"http://example.com/public", "10.0.0.5:1234", http.StatusForbidden},
+        {"http://example.com/public", "192.0.2.1:1234", http.StatusOK},
+    })
+}
+
+func TestExceptIPsPrecedence(t *testing.T) {
+    handler := newTestSentinel(t, domainConfig("example.com", DomainConfig{
+        SourceIPs: ips("10.0.0.0/8"),
+        ExceptIPs: []string{"10.99.0.0/16"},
+        DeniedIPs: []string{"10.98.0.1"},
+        PathRules: []PathConfig{
+            // The path rule's allow overlaps the domain-level exception.
+            {Path: "/wifi", SourceIPs: ips("10.99.0.0/16")},
+            {Path: "/admin", SourceIPs: ips("10.0.0.0/8"), ExceptIPs: []string{"10.1.0.0/16"}},
+            {Path: "/guests", SourceIPs: ips("10.98.0.0/16")},
+        },
+    }))
+    checkStatuses(t, handler, []statusCase{
+        {"http://example.com/", "10.1.2.3:1234", http.StatusOK},
+        {"http://example.com/", "10.99.1.1:1234", http.StatusForbidden},
+        // Exceptions only apply to the list they belong to: the path rule
+        // decides with its own lists.
+        {"http://example.com/wifi", "10.99.1.1:1234", http.StatusOK},
+        {"http://example.com/admin", "10.99.1.1:1234", http.StatusOK},
+        {"http://example.com/admin", "10.1.2.3:1234", http.StatusForbidden},
+        {"http://example.com/admin", "10.2.2.3:1234", http.StatusOK},
+        // DeniedIPs of the domain apply everywhere, exceptions do not
+        // revive them.
+        {"http://example.com/guests", "10.98.0.1:1234", http.StatusForbidden},
+        {"http://example.com/guests", "10.98.0.2:1234", http.StatusOK},
+    })
+}
+
+func TestAuditOnly(t *testing.T) {
+    config := CreateConfig()
+    config.DomainPathRules["legacy.example.com"] = DomainConfig{
+        SourceIPs: ips("10.0.0.0/8"),
+        AuditOnly: boolFlag(true),
+        PathRules: []PathConfig{{Path: "/strict", SourceIPs: ips("10.0.0.0/8"), AuditOnly: boolFlag(false)}},
+    }
+    config.DomainPathRules["admin.example.com"] = DomainConfig{
+        SourceIPs: ips("10.0.0.0/8"),
+        PathRules: []PathConfig{{Path: "/beta", SourceIPs: ips("10.0.0.0/8"), AuditOnly: boolFlag(true)}},
+    }
+    tests := []struct {
+        target string
+        want   int
+        marker string
+    }{
+        {"http://legacy.example.com/", http.StatusOK, "AUDIT: would deny with 403"},
+        {"http://legacy.example.com/strict", http.StatusForbidden, "DENY: denied with 403"},
+        {"http://admin.example.com/", http.StatusForbidden, "DENY: denied with 403"},
+        {"http://admin.example.com/beta", http.StatusOK, "AUDIT: would deny with 403"},
+    }
+    check := func(handler http.Handler, auditMode bool) {
+        t.Helper()
+        for _, tt := range tests {
+            want, marker := tt.want, tt.marker
+            if auditMode {
+                // The most permissive setting wins.
+                want, marker = http.StatusOK, "AUDIT: would deny with 403"
+            }
+            var code int
+            out := captureOutput(t, func() { code = serve(handler, tt.target, "192.0.2.1:1234").Code })
+            if code != want {
+                t.Errorf("auditMode %v, GET %s: status %d, want %d", auditMode, tt.target, code, want)
+            }
+            if !strings.Contains(out, marker) {
+                t.Errorf("auditMode %v, GET %s: log lacks %q:\n%s", auditMode, tt.target, marker, out)
+            }
+            // Allowed requests pass in every mode.
+            if got := serve(handler, tt.target, "10.0.0.1:1234").Code; got != http.StatusOK {
+                t.Errorf("auditMode %v, GET %s from an allowed address: status %d", auditMode, tt.target, got)
+            }
+        }
+    }
+    check(newTestSentinel(t, config), false)
+    config.AuditMode = true
+    check(newTestSentinel(t, config), true)
+}
@@ -0,0 +1,174 @@
+package DomainSentinel
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "net/netip"
+    "sort"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// Defaults for resolving hostnames listed in source IP lists.
+const (
+    defaultDNSRefreshInterval = 5 * time.Minute
+    defaultDNSTimeout         = 5 * time.Second
+)
+
+// hostSet holds every hostname referenced by the source IP lists, so that a
+// name used in several rules is resolved only once.
+type hostSet struct {
+    hosts   map[string]*resolvedHost
+    timeout time.Duration
+}
+
+// resolvedHost is a hostname together with the addresses it last resolved to.
+type resolvedHost struct {
+    name  string
+    addrs atomic.Value // map[netip.Addr]struct{}
+}
+
+func newHostSet(timeout time.Duration) *hostSet {
+    return &hostSet{hosts: make(map[string]*resolvedHost), timeout: timeout}
+}
+
+// get returns the shared entry for name, creating it if needed.
+func (s *hostSet) get(name string) *resolvedHost {
+    name = strings.ToLower(strings.TrimSuffix(name, "."))
+    h, ok := s.hosts[name]
+    if !ok {
+        h = &resolvedHost{name: name}
+        h.addrs.Store(map[netip.Addr]struct{}{})
+        s.hosts[name] = h
+    }
+    return h
+}
+
+// resolveAll resolves every hostname once. Failures are logged and leave the
+// previous addresses in place.
+func (s *hostSet) resolveAll(ctx context.Context) {
+    for _, h := range s.hosts {
+        h.resolve(ctx, s.timeout)
+    }
+}
+
+// refreshLoop re-resolves all hostnames every interval until ctx is done.
+func (s *hostSet) refreshLoop(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            s.resolveAll(ctx)
+        }
+    }
+}
+
+// resolve looks up the A and AAAA records of the host. On failure the stale
+// result is kept, so a DNS outage does not lock clients out.
+func (h *resolvedHost) resolve(ctx context.Context, timeout time.Duration) {
+    ctx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+
+    addrs, err := net.DefaultResolver.LookupIPAddr(ctx, h.name)
+    if err != nil {
+        fmt.Printf("Error resolving source IP hostname %q, keeping previous addresses: %v\n", h.name, err)
+        return
+    }
+
+    resolved := make(map[netip.Addr]struct{}, len(addrs))
+    var list []string
+    for _, addr := range addrs {
+        ip, ok := netip.AddrFromSlice(addr.IP)
+        if !ok {
+            continue
+        }
+        ip = normalizeAddr(ip)
+        resolved[ip] = struct{}{}
+        list = append(list, ip.String())
+    }
+
+    if !sameAddrs(h.addrs.Load().(map[netip.Addr]struct{}), resolved) {
+        sort.Strings(list)
+        fmt.Printf("Source IP hostname %q resolved to %v\n", h.name, list)
+    }
+    h.addrs.Store(resolved)
+}
+
+// contains reports whether ip is one of the addresses the host resolved to.
+func (h *resolvedHost) contains(ip netip.Addr) bool {
+    _, ok := h.addrs.Load().(map[netip.Addr]struct{})[ip]
+    return ok
+}
+
+func sameAddrs(a, b map[netip.Addr]struct{}) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for k := range a {
+        if _, ok := b[k]; !ok {
+            return false
+        }
+    }
+    return true
+}
+
+// isHostLabel reports whether entry is a single-label host name such as
+// "gateway": letters, digits and hyphens, with at least one letter and no
+// hyphen at either end. Such names resolve through the search domains of
+// the resolver.
+func isHostLabel(entry string) bool {
+    if entry == "" || entry[0] == '-' || entry[len(entry)-1] == '-' {
+        return false
+    }
+    hasLetter := false
+    for _, c := range entry {
+        switch {
+        case c == '-' || (c >= '0' && c <= '9'):
+        case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+            hasLetter = true
+        default:
+            return false
+        }
+    }
+    return hasLetter
+}
+
+// isHostname reports whether entry is written as a DNS name: letters, digits,
+// dots and hyphens only, with at least one dot and one letter. IP addresses,
+// CIDRs, ranges and keywords never satisfy this.
+func isHostname(entry string) bool {
+    hasDot, hasLetter := false, false
+    for _, c := range entry {
+        switch {
+        case c == '.':
+            hasDot = true
+        case c == '-' || (c >= '0' && c <= '9'):
+        case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+            hasLetter = true
+        default:
+            return false
+        }
+    }
+    return hasDot && hasLetter
+}
+
+// parseDuration parses an optional duration option, falling back to def when empty.
+func parseDuration(option, value string, def time.Duration) (time.Duration, error) {
+    if value == "" {
+        return def, nil
+    }
+    d, err := time.ParseDuration(value)
+    if err != nil {
+        return 0, fmt.Errorf("invalid %s %q: %w", option, value, err)
+    }
+    if d <= 0 {
+        return 0, fmt.Errorf("invalid %s %q: must be positive", option, value)
+    }
+    return d, nil
+}
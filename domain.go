@@ -0,0 +1,762 @@
+package DomainSentinel
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "net/netip"
+    "regexp"
+    "regexp/syntax"
+    "sort"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+)
+
+// wildcardPrefix marks domainPathRules keys that match subdomains, and
+// regexPrefix keys holding a regular expression. The catchAllKey rule
+// applies to hosts no other key matches.
+const (
+    wildcardPrefix = "*."
+    regexPrefix    = "~"
+    catchAllKey    = "*"
+)
+
+// Sources of the host name matched against domainPathRules.
+const (
+    hostSourceHost            = "host"
+    hostSourceXForwardedHost  = "xForwardedHost"
+    hostSourcePreferForwarded = "preferForwarded"
+    hostSourceSNI             = "sni"
+)
+
+// Request schemes, and the actions for requests over a scheme a rule does
+// not list.
+const (
+    schemeHTTP         = "http"
+    schemeHTTPS        = "https"
+    schemeMismatchSkip = "skip"
+    schemeMismatchDeny = "deny"
+)
+
+// Actions for TLS requests without SNI under hostSource sni.
+const (
+    emptySNIHost = "host"
+    emptySNIDeny = "deny"
+)
+
+// Limits for regex domain keys, which are tried for every request whose
+// host has no exact or wildcard key, and for regex paths.
+const (
+    maxDomainRegexLength = 512
+    maxPathRegexLength   = 512
+    domainRegexWarnInsts = 2000 // compiled program size worth a warning
+)
+
+// domainTable finds the rule for a request host. The precedence is:
+//  1. an exact key,
+//  2. the closest parent domain: for each parent from the most specific
+//     one up, a wildcard key ("*.example.com") or an exact key with
+//     includeSubdomains ("example.com"), so "*.eu.example.com" is
+//     preferred over "*.example.com",
+//  3. the zone of the host's registrable domain, determined by the public
+//     suffix list ("example.co.uk" for "a.b.example.co.uk"),
+//  4. the first matching regex key,
+//  5. the catch-all key "*".
+//
+// The priority of a rule only breaks the ties within a level: regex keys
+// are tried by descending priority, then in lexical order, and a wildcard
+// and an includeSubdomains key for the same domain need different
+// priorities. Ties that priority does not resolve fail newDomainTable.
+//
+// Exact and wildcard keys may carry a port ("example.com:8443"). They only
+// match requests on that port and take precedence over all portless keys.
+type domainTable struct {
+    exact     map[string]*compiledDomain
+    wildcards map[string]*compiledDomain // keyed by the suffix after "*."
+    regexes   []domainRegex              // in order of precedence
+    fallback  *compiledDomain            // the "*" rule, nil if not configured
+    ports     map[string]*domainTable    // exact and wildcard keys with a port, by port
+
+    zones    map[string]*compiledDomain // keyed by registrable domain
+    suffixes *suffixList                // set if there are zones
+
+    ordered []orderedRule // the rules list, which replaces all of the above
+}
+
+// domainRegex is a compiled "~pattern" key.
+type domainRegex struct {
+    key string
+    re  *regexp.Regexp
+    cd  *compiledDomain
+}
+
+// newDomainTable sorts the compiled domains by key type and compiles the
+// regex keys. Host names are case-insensitive, so exact and wildcard keys
+// are lower-cased and regex keys match case-insensitively, and Unicode keys
+// are converted to punycode; lookup expects a host in the same form. A rule
+// with a hosts list is added under each of its hosts instead of its key.
+// Zone keys must be registrable domains according to suffixes.
+func newDomainTable(domains, zones map[string]*compiledDomain, suffixes *suffixList) (*domainTable, error) {
+    t := &domainTable{exact: make(map[string]*compiledDomain), wildcards: make(map[string]*compiledDomain)}
+    seen := make(map[string]tableSource)
+    for _, key := range sortedDomainKeys(domains) {
+        cd := domains[key]
+        if len(cd.hosts) == 0 {
+            if err := t.add(tableSource{name: key, key: key}, cd, seen); err != nil {
+                return nil, err
+            }
+            continue
+        }
+        for _, host := range cd.hosts {
+            if err := t.add(tableSource{name: host, key: key, grouped: true}, cd, seen); err != nil {
+                return nil, err
+            }
+        }
+    }
+    if err := t.addZones(zones, suffixes); err != nil {
+        return nil, err
+    }
+    if err := t.orderRules(); err != nil {
+        return nil, err
+    }
+    if err := t.resolveParents(); err != nil {
+        return nil, err
+    }
+    return t, nil
+}
+
+// orderRules sorts the regex keys by precedence and rejects configurations
+// in which two rules would match the same hosts at the same precedence.
+func (t *domainTable) orderRules() error {
+    tables := []*domainTable{t}
+    for _, sub := range t.ports {
+        tables = append(tables, sub)
+    }
+    for _, table := range tables {
+        sort.SliceStable(table.regexes, func(i, j int) bool {
+            return table.regexes[i].cd.priority > table.regexes[j].cd.priority
+        })
+        patterns := make(map[string]domainRegex, len(table.regexes))
+        for _, r := range table.regexes {
+            pattern := strings.ToLower(r.re.String())
+            other, ok := patterns[pattern]
+            if !ok {
+                patterns[pattern] = r
+                continue
+            }
+            if other.cd.priority == r.cd.priority {
+                return fmt.Errorf("domains %q and %q have the same regular expression; set different priorities", other.key, r.key)
+            }
+            fmt.Printf("Warning: domain %q has the same regular expression as %q, which has a higher priority, and is never used\n", r.key, other.key)
+        }
+        suffixes := make([]string, 0, len(table.wildcards))
+        for suffix := range table.wildcards {
+            suffixes = append(suffixes, suffix)
+        }
+        sort.Strings(suffixes)
+        for _, suffix := range suffixes {
+            if e, ok := table.exact[suffix]; ok && e.includeSubdomains && e.priority == table.wildcards[suffix].priority {
+                return fmt.Errorf("the subdomains of %q are matched by both %q and %q with includeSubdomains; set different priorities",
+                    suffix, wildcardPrefix+suffix, suffix)
+            }
+        }
+    }
+    return nil
+}
+
+// tableSource is where a host pattern of the domain table was configured:
+// a domainPathRules key, or an entry of the hosts list of key.
+type tableSource struct {
+    name    string
+    key     string
+    grouped bool
+}
+
+func (s tableSource) String() string {
+    if s.grouped {
+        return fmt.Sprintf("the hosts of domain %q", s.key)
+    }
+    return fmt.Sprintf("domain %q", s.key)
+}
+
+// add inserts the rule cd under the host pattern of src. seen holds the
+// source of every normalized pattern added so far, and a host configured
+// twice is an error. Keys that only differ in case or encoding have been
+// merged or rejected by mergeDuplicateDomains already, so this is a host
+// both in a hosts list and elsewhere.
+func (t *domainTable) add(src tableSource, cd *compiledDomain, seen map[string]tableSource) error {
+    pattern, isRegex := regexPattern(src.name)
+    normalized, port := src.name, ""
+    if !isRegex && src.name != catchAllKey {
+        name, p := splitKeyPort(src.name)
+        name = strings.TrimSuffix(name, ".")
+        var err error
+        if normalized, err = toASCII(name); err != nil {
+            return fmt.Errorf("%s: invalid internationalized domain name %q: %w", src, src.name, err)
+        }
+        port = p
+    }
+    seenKey := normalized
+    if port != "" {
+        seenKey = net.JoinHostPort(normalized, port)
+    }
+    if other, ok := seen[seenKey]; ok {
+        return fmt.Errorf("host %q is configured by both %s and %s", seenKey, other, src)
+    }
+    seen[seenKey] = src
+
+    if port != "" {
+        if t.ports == nil {
+            t.ports = make(map[string]*domainTable)
+        }
+        sub, ok := t.ports[port]
+        if !ok {
+            sub = &domainTable{exact: make(map[string]*compiledDomain), wildcards: make(map[string]*compiledDomain)}
+            t.ports[port] = sub
+        }
+        t = sub
+    }
+    switch {
+    case src.name == catchAllKey:
+        t.fallback = cd
+    case isRegex:
+        re, err := compileDomainRegex(src.name, pattern)
+        if err != nil {
+            return err
+        }
+        t.regexes = append(t.regexes, domainRegex{key: src.name, re: re, cd: cd})
+    default:
+        if suffix, ok := wildcardSuffix(normalized); ok {
+            t.wildcards[suffix] = cd
+        } else {
+            t.exact[normalized] = cd
+        }
+    }
+    return nil
+}
+
+// addZones adds the zone rules, which must be keyed by a registrable
+// domain, not by a public suffix or one of its subdomains.
+func (t *domainTable) addZones(zones map[string]*compiledDomain, suffixes *suffixList) error {
+    if len(zones) == 0 {
+        return nil
+    }
+    t.zones = make(map[string]*compiledDomain, len(zones))
+    t.suffixes = suffixes
+    seen := make(map[string]string, len(zones))
+    for _, key := range sortedDomainKeys(zones) {
+        name, err := toASCII(strings.TrimSuffix(key, "."))
+        if err != nil {
+            return fmt.Errorf("zone %q: invalid internationalized domain name: %w", key, err)
+        }
+        switch registrable := suffixes.registrable(name); registrable {
+        case name:
+        case "":
+            return fmt.Errorf("zone %q is a public suffix, not a registrable domain", key)
+        default:
+            return fmt.Errorf("zone %q is not a registrable domain; use the zone %q or a domainPathRules key with includeSubdomains", key, registrable)
+        }
+        if other, ok := seen[name]; ok {
+            return fmt.Errorf("zones %q and %q are the same domain %q", other, key, name)
+        }
+        seen[name] = key
+        t.zones[name] = zones[key]
+    }
+    return nil
+}
+
+// lookupZone returns the zone rule of the registrable domain of host.
+func (t *domainTable) lookupZone(host string) (*compiledDomain, string, bool) {
+    if len(t.zones) == 0 {
+        return nil, "", false
+    }
+    zone := t.suffixes.registrable(host)
+    cd, ok := t.zones[zone]
+    return cd, zone, ok
+}
+
+// compileDomainRegex compiles the pattern of a regex key, anchored at both
+// ends. Go regular expressions run in linear time, but large patterns are
+// still slow, so overly long ones are rejected and big ones logged.
+func compileDomainRegex(key, pattern string) (*regexp.Regexp, error) {
+    if len(pattern) > maxDomainRegexLength {
+        return nil, fmt.Errorf("domain %q: regular expression longer than %d characters", key, maxDomainRegexLength)
+    }
+    anchored := "(?i)^(?:" + pattern + ")$"
+    re, err := regexp.Compile(anchored)
+    if err != nil {
+        return nil, fmt.Errorf("domain %q: invalid regular expression: %w", key, err)
+    }
+    parsed, err := syntax.Parse(anchored, syntax.Perl)
+    if err == nil {
+        if prog, err := syntax.Compile(parsed.Simplify()); err == nil && len(prog.Inst) > domainRegexWarnInsts {
+            fmt.Printf("Warning: domain %q: regular expression compiles to %d instructions and is evaluated for every unmatched host\n",
+                key, len(prog.Inst))
+        }
+    }
+    return re, nil
+}
+
+// lookup returns the rule for host, requested on port (empty if unknown),
+// and the key it is configured under: the matching host pattern, or the
+// domainPathRules key of a hosts list.
+func (t *domainTable) lookup(host, port string) (*compiledDomain, string, bool) {
+    if t.ordered != nil {
+        return t.lookupOrdered(host, port)
+    }
+    if sub, ok := t.ports[port]; ok && port != "" {
+        if cd, ok := sub.exact[host]; ok {
+            return cd, cd.ruleKey(net.JoinHostPort(host, port)), true
+        }
+        if cd, key, ok := sub.lookupParent(host); ok {
+            return cd, cd.ruleKey(net.JoinHostPort(key, port)), true
+        }
+    }
+    if cd, ok := t.exact[host]; ok {
+        return cd, cd.ruleKey(host), true
+    }
+    if cd, key, ok := t.lookupParent(host); ok {
+        return cd, cd.ruleKey(key), true
+    }
+    if cd, key, ok := t.lookupZone(host); ok {
+        return cd, key, true
+    }
+    for _, r := range t.regexes {
+        if r.re.MatchString(host) {
+            return r.cd, r.cd.ruleKey(r.key), true
+        }
+    }
+    if t.fallback != nil {
+        return t.fallback, t.fallback.ruleKey(catchAllKey), true
+    }
+    return nil, "", false
+}
+
+// ruleKey returns the key to report for a rule matched by pattern.
+func (cd *compiledDomain) ruleKey(pattern string) string {
+    if cd.group != "" {
+        return cd.group
+    }
+    return pattern
+}
+
+// lookupParent returns the rule of the closest parent domain of host, via
+// a wildcard key or an exact key with includeSubdomains.
+func (t *domainTable) lookupParent(host string) (*compiledDomain, string, bool) {
+    if cd, ok := t.wildcards[host]; ok && cd.matchApex {
+        return cd, wildcardPrefix + host, true
+    }
+    // Try the parent domains from the most specific one up, with one or
+    // two map lookups per label.
+    for suffix := host; ; {
+        i := strings.IndexByte(suffix, '.')
+        if i < 0 {
+            return nil, "", false
+        }
+        suffix = suffix[i+1:]
+        w, wok := t.wildcards[suffix]
+        if e, ok := t.exact[suffix]; ok && e.includeSubdomains && (!wok || e.priority > w.priority) {
+            return e, suffix, true
+        }
+        if wok {
+            return w, wildcardPrefix + suffix, true
+        }
+    }
+}
+
+// resolveParents links every exact entry with inherit to the parent domain
+// or zone rule its host would fall back to without its own entry.
+func (t *domainTable) resolveParents() error {
+    tables := []*domainTable{t}
+    for _, sub := range t.ports {
+        tables = append(tables, sub)
+    }
+    for _, table := range tables {
+        for host, cd := range table.exact {
+            if !cd.inherit || cd.parent != nil {
+                continue
+            }
+            parent, key, ok := table.lookupParent(host)
+            if !ok && table != t {
+                parent, key, ok = t.lookupParent(host)
+            }
+            if !ok {
+                parent, key, ok = t.lookupZone(host)
+            }
+            if !ok {
+                return fmt.Errorf("domain %q: inherit is set, but no parent domain rule with includeSubdomains or zone covers it", host)
+            }
+            cd.parent, cd.parentKey = parent, parent.ruleKey(key)
+        }
+    }
+    return nil
+}
+
+// regexPattern returns the regular expression of a "~pattern" key.
+func regexPattern(key string) (string, bool) {
+    if !strings.HasPrefix(key, regexPrefix) {
+        return "", false
+    }
+    return key[len(regexPrefix):], true
+}
+
+// requestHost returns the host name of req, cleaned up by cleanHost, taken
+// from the Host header, X-Forwarded-Host or the TLS server name as
+// configured by hostSource, and the name of the source used.
+// X-Forwarded-Host is only honored from the plugin-level trusted proxies;
+// plaintext requests have no server name and always use Host. An error is
+// returned if the chosen value is rejected by cleanHost, if hostSource is
+// xForwardedHost and the request has no usable X-Forwarded-Host, or if
+// hostSource is sni and a TLS client sent no server name under
+// emptySNIAction deny.
+//
+// The port is only determined if a key has one. In order of precedence it
+// is taken from the chosen host value, from X-Forwarded-Port sent by a
+// trusted proxy, or from the local address the request arrived on.
+func (ds *DomainSentinel) requestHost(req *http.Request) (host, port, source string, err error) {
+    field, value := "Host", req.Host
+    source = field
+    switch ds.hostSource {
+    case hostSourceHost:
+    case hostSourceSNI:
+        switch {
+        case req.TLS == nil:
+            source = "Host, plaintext request"
+        case req.TLS.ServerName != "":
+            field, value = "SNI", req.TLS.ServerName
+            source = field
+        case ds.denyEmptySNI:
+            return "", "", "SNI", errors.New("the TLS client sent no server name (emptySNIAction=deny)")
+        default:
+            source = "Host, no SNI sent"
+        }
+    default:
+        if forwarded, ok := ds.forwardedHost(req); ok {
+            field, value = "X-Forwarded-Host", forwarded
+            source = field
+        } else if ds.hostSource == hostSourceXForwardedHost {
+            return "", "", "X-Forwarded-Host", errors.New("no X-Forwarded-Host from a trusted proxy (hostSource=xForwardedHost)")
+        }
+    }
+    if host, port, err = cleanHost(value, ds.strictHost); err != nil {
+        return "", "", source, fmt.Errorf("invalid %s %q: %w", field, value, err)
+    }
+    if field == "SNI" {
+        port = "" // a server name has no port
+    }
+    if port == "" && len(ds.table().ports) > 0 {
+        port = ds.requestPort(req)
+    }
+    return host, port, source, nil
+}
+
+// cleanHost sanitizes a host value before the domain lookup: surrounding
+// whitespace and a trailing dot are removed and the name is normalized by
+// hostName. Values with userinfo ("user@example.com") or slashes are
+// always rejected; with strict, so is anything that is not a valid host
+// name or IP address with an optional valid port.
+func cleanHost(value string, strict bool) (host, port string, err error) {
+    value = strings.TrimSpace(value)
+    if strings.Contains(value, "@") {
+        return "", "", errors.New("userinfo is not allowed")
+    }
+    if strings.ContainsAny(value, "/\\") {
+        return "", "", errors.New("slashes are not allowed")
+    }
+    host, port = hostName(value)
+    if !strict {
+        return host, port, nil
+    }
+    if _, p, err := net.SplitHostPort(value); err == nil && !validPort(p) {
+        return "", "", fmt.Errorf("invalid port %q", p)
+    }
+    if host == "" {
+        return "", "", errors.New("empty host")
+    }
+    if _, err := netip.ParseAddr(host); err == nil {
+        return host, port, nil
+    }
+    if !isASCII(host) {
+        return "", "", errors.New("not a valid internationalized domain name")
+    }
+    if err := validateHostName(host); err != nil {
+        return "", "", err
+    }
+    return host, port, nil
+}
+
+// requestPort returns the port a request without port in its host value
+// was sent to, or an empty string if it is unknown.
+func (ds *DomainSentinel) requestPort(req *http.Request) string {
+    if values := headerEntries(req, "X-Forwarded-Port"); len(values) > 0 && ds.fromTrustedProxy(req) {
+        if port := values[len(values)-1]; validPort(port) {
+            return port
+        }
+    }
+    if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+        if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+            return port
+        }
+    }
+    return ""
+}
+
+// requestScheme returns "https" or "http" for req: from X-Forwarded-Proto
+// if a trusted proxy sent it, else by whether the connection uses TLS.
+// WebSocket schemes count as their HTTP equivalent.
+func (ds *DomainSentinel) requestScheme(req *http.Request) string {
+    scheme := schemeHTTP
+    if req.TLS != nil {
+        scheme = schemeHTTPS
+    }
+    values := headerEntries(req, "X-Forwarded-Proto")
+    if len(values) == 0 {
+        return scheme
+    }
+    forwarded := ""
+    switch strings.ToLower(values[len(values)-1]) {
+    case schemeHTTPS, "wss":
+        forwarded = schemeHTTPS
+    case schemeHTTP, "ws":
+        forwarded = schemeHTTP
+    }
+    switch {
+    case forwarded == "" || forwarded == scheme:
+    case ds.fromTrustedProxy(req):
+        return forwarded
+    default:
+        fmt.Printf("Ignoring X-Forwarded-Proto %q from untrusted peer %s\n", strings.Join(values, ", "), req.RemoteAddr)
+    }
+    return scheme
+}
+
+// fromTrustedProxy reports whether the socket peer of req is one of the
+// plugin-level trusted proxies.
+func (ds *DomainSentinel) fromTrustedProxy(req *http.Request) bool {
+    peer, err := clientIP(req)
+    return err == nil && ds.ipStrategy.trustedProxies.contains(peer)
+}
+
+// forwardedHost returns the X-Forwarded-Host of a request from a trusted
+// proxy. Of several comma-separated or repeated values the last one is
+// used, as it was added by the proxy that connected to Traefik.
+func (ds *DomainSentinel) forwardedHost(req *http.Request) (string, bool) {
+    entries := headerEntries(req, "X-Forwarded-Host")
+    if len(entries) == 0 {
+        return "", false
+    }
+    if !ds.fromTrustedProxy(req) {
+        fmt.Printf("Ignoring X-Forwarded-Host %q from untrusted peer %s\n", strings.Join(entries, ", "), req.RemoteAddr)
+        return "", false
+    }
+    value := entries[len(entries)-1]
+    return value, value != ""
+}
+
+// hostSNIMismatch compares the Host header of a TLS request with its
+// server name, both normalized like domain keys. Plaintext requests never
+// mismatch; a TLS request without server name only does if requireSNI.
+func hostSNIMismatch(req *http.Request, requireSNI bool) (host, sni string, mismatch bool) {
+    if req.TLS == nil {
+        return "", "", false
+    }
+    host, _ = hostName(req.Host)
+    if req.TLS.ServerName == "" {
+        return host, "", requireSNI
+    }
+    sni, _ = hostName(req.TLS.ServerName)
+    return host, sni, host != sni
+}
+
+// compileSNIMismatchStatus returns the status for requests rejected by
+// enforceHostSNIMatch.
+func compileSNIMismatchStatus(config DomainConfig) (int, error) {
+    status := config.HostSNIMismatchStatus
+    if status == 0 {
+        return http.StatusMisdirectedRequest, nil
+    }
+    if status < 400 || status > 599 {
+        return 0, fmt.Errorf("invalid hostSNIMismatchStatus %d: must be a 4xx or 5xx status", status)
+    }
+    return status, nil
+}
+
+// checkDenyStatus checks the value of a denyStatus option; 0 leaves the
+// status to the next level up.
+func checkDenyStatus(option string, status int) error {
+    if status != 0 && (status < 400 || status > 599) {
+        return fmt.Errorf("invalid %s %d: must be a 4xx or 5xx status", option, status)
+    }
+    return nil
+}
+
+// hostName splits a Host header value into host name and port, converting
+// the name to the form of the domain table keys: lower case, without a
+// trailing dot, with Unicode labels in punycode. A name that cannot be
+// converted is only lower-cased.
+func hostName(hostport string) (host, port string) {
+    host = hostport
+    if strings.Contains(hostport, ":") {
+        if name, p, err := net.SplitHostPort(hostport); err == nil {
+            host = name
+            if validPort(p) {
+                port = p
+            }
+        }
+    }
+    host = strings.ToLower(strings.TrimSuffix(host, "."))
+    if !isASCII(host) {
+        if ascii, err := toASCII(host); err == nil {
+            host = ascii
+        }
+    }
+    return host, port
+}
+
+// splitKeyPort splits "example.com:8443" into host pattern and port. The
+// port is empty for keys without one.
+func splitKeyPort(key string) (string, string) {
+    i := strings.LastIndexByte(key, ':')
+    if i < 0 {
+        return key, ""
+    }
+    return key[:i], key[i+1:]
+}
+
+// validPort reports whether s is a decimal port number from 1 to 65535.
+func validPort(s string) bool {
+    port, err := strconv.Atoi(s)
+    return err == nil && port >= 1 && port <= 65535 && s[0] != '+'
+}
+
+// wildcardSuffix returns the domain of a "*.example.com" key.
+func wildcardSuffix(key string) (string, bool) {
+    if !strings.HasPrefix(key, wildcardPrefix) {
+        return "", false
+    }
+    return key[len(wildcardPrefix):], true
+}
+
+// validateDomainKey checks the form of a domainPathRules key, or of the
+// entries of its hosts list if it has one.
+func validateDomainKey(key string, config DomainConfig) error {
+    patterns := []string{key}
+    if len(config.Hosts) > 0 {
+        if key == catchAllKey || strings.HasPrefix(key, regexPrefix) || strings.HasPrefix(key, wildcardPrefix) {
+            return fmt.Errorf("domain %q: a rule with hosts must have a plain name as key", key)
+        }
+        patterns = hostEntries(config.Hosts)
+    }
+    wildcard, plain := false, true
+    for _, pattern := range patterns {
+        if err := validateHostPattern(pattern); err != nil {
+            if len(config.Hosts) > 0 {
+                return fmt.Errorf("domain %q: invalid host %q: %w", key, pattern, err)
+            }
+            return fmt.Errorf("invalid domain key %q: %w", key, err)
+        }
+        if name, _ := splitKeyPort(pattern); strings.HasPrefix(name, wildcardPrefix) {
+            wildcard = true
+        }
+    }
+    if key == catchAllKey || strings.HasPrefix(key, regexPrefix) || wildcard {
+        plain = false
+    }
+    if (isTrue(config.IncludeSubdomains) || isTrue(config.Inherit)) && !plain {
+        return fmt.Errorf("domain %q: includeSubdomains and inherit are only used by plain host names", key)
+    }
+    if isTrue(config.MatchApex) && !wildcard {
+        return fmt.Errorf("domain %q: matchApex is only used by wildcard keys", key)
+    }
+    return nil
+}
+
+// validateZoneKey checks that a zones key is a plain domain name without
+// the options that only apply to domainPathRules keys.
+func validateZoneKey(key string, config DomainConfig) error {
+    if key == catchAllKey || strings.HasPrefix(key, regexPrefix) || strings.HasPrefix(key, wildcardPrefix) || strings.Contains(key, ":") {
+        return fmt.Errorf("invalid zone %q: must be a domain name without wildcard, regex or port", key)
+    }
+    if err := validateHostPattern(key); err != nil {
+        return fmt.Errorf("invalid zone %q: %w", key, err)
+    }
+    if len(config.Hosts) > 0 || isTrue(config.IncludeSubdomains) || isTrue(config.Inherit) || isTrue(config.MatchApex) {
+        return fmt.Errorf("zone %q: hosts, includeSubdomains, inherit and matchApex are not used by zones", key)
+    }
+    return nil
+}
+
+// validateHostPattern checks a host name, wildcard, regex or catch-all
+// pattern. A wildcard is only allowed as the whole leftmost label; regular
+// expressions are checked when the domain table is built. Host names and
+// wildcards may end in a port.
+func validateHostPattern(pattern string) error {
+    if pattern == catchAllKey {
+        return nil
+    }
+    if regex, ok := regexPattern(pattern); ok {
+        if regex == "" {
+            return errors.New("empty regular expression")
+        }
+        return nil
+    }
+    switch {
+    case strings.Contains(pattern, "://"):
+        return errors.New("must be a host name, not a URL; remove the scheme")
+    case strings.ContainsAny(pattern, "/?#"):
+        return errors.New("must be a host name without path")
+    case strings.ContainsAny(pattern, " \t\r\n"):
+        return errors.New("must not contain whitespace")
+    }
+    name, port := splitKeyPort(pattern)
+    if strings.Contains(pattern, ":") && !validPort(port) {
+        return fmt.Errorf("invalid port %q: must be a number from 1 to 65535", port)
+    }
+    if suffix, ok := wildcardSuffix(name); ok {
+        name = suffix
+    }
+    if name == "" || strings.Contains(name, "*") {
+        return errors.New(`a wildcard must be the leftmost label, as in "*.example.com"`)
+    }
+    return validateHostName(strings.TrimSuffix(name, "."))
+}
+
+// validateHostName checks the labels of a host name. Underscores, common in
+// container and service names, are accepted; non-ASCII letters are checked
+// when the name is converted to punycode.
+func validateHostName(name string) error {
+    if len(name) > 253 {
+        return errors.New("longer than 253 characters")
+    }
+    for _, label := range strings.Split(name, ".") {
+        if label == "" {
+            return errors.New("empty label")
+        }
+        if len(label) > 63 {
+            return fmt.Errorf("label %q is longer than 63 characters", label)
+        }
+        if label[0] == '-' || label[len(label)-1] == '-' {
+            return fmt.Errorf("label %q must not start or end with a hyphen", label)
+        }
+        for _, r := range label {
+            if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r >= utf8.RuneSelf {
+                continue
+            }
+            return fmt.Errorf("label %q: character %q is not allowed in a host name", label, r)
+        }
+    }
+    return nil
+}
+
+// hostEntries expands the entries of a hosts list.
+func hostEntries(values []string) []string {
+    var hosts []string
+    for _, value := range values {
+        for _, host := range splitListEntry(value) {
+            hosts = append(hosts, strings.TrimSpace(host))
+        }
+    }
+    return hosts
+}
@@ -0,0 +1,543 @@
+package DomainSentinel
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "sort"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+// ruleProbe tells which rule a request matched: every domainPathRules and
+// zones entry of its configuration allows one address of its own, so the
+// rule can be told from the responses.
+type ruleProbe struct {
+    addrs map[string]string // key to the address it allows
+}
+
+// zoneProbeKey is the probe key of the zone rule for zone.
+func zoneProbeKey(zone string) string {
+    return "zone " + zone
+}
+
+// newRuleProbe creates the middleware for rules, which are given without
+// sourceIPs, after applying configure to the configuration.
+func newRuleProbe(t *testing.T, rules map[string]DomainConfig, configure ...func(*Config)) (http.Handler, *ruleProbe) {
+    t.Helper()
+    config := CreateConfig()
+    for key, rule := range rules {
+        config.DomainPathRules[key] = rule
+    }
+    for _, f := range configure {
+        f(config)
+    }
+    probe := &ruleProbe{addrs: make(map[string]string)}
+    var keys []string
+    for key := range config.DomainPathRules {
+        keys = append(keys, key)
+    }
+    for zone := range config.Zones {
+        keys = append(keys, zoneProbeKey(zone))
+    }
+    sort.Strings(keys)
+    for i, key := range keys {
+        addr := fmt.Sprintf("10.0.%d.%d", i/250, i%250+1)
+        probe.addrs[key] = addr
+        if zone := strings.TrimPrefix(key, zoneProbeKey("")); zone != key {
+            rule := config.Zones[zone]
+            rule.SourceIPs = ips(addr)
+            config.Zones[zone] = rule
+            continue
+        }
+        rule := config.DomainPathRules[key]
+        rule.SourceIPs = ips(addr)
+        config.DomainPathRules[key] = rule
+    }
+    return newTestSentinel(t, config), probe
+}
+
+// match returns the key whose rule applies to target, or "" if no rule
+// does and every address is let through.
+func (p *ruleProbe) match(handler http.Handler, target string) string {
+    return p.matchRequest(handler, func() *http.Request { return httptest.NewRequest(http.MethodGet, target, nil) })
+}
+
+// matchRequest is match for the requests newRequest returns.
+func (p *ruleProbe) matchRequest(handler http.Handler, newRequest func() *http.Request) string {
+    var allowed []string
+    for key, addr := range p.addrs {
+        req := newRequest()
+        req.RemoteAddr = addr + ":1234"
+        rw := httptest.NewRecorder()
+        handler.ServeHTTP(rw, req)
+        if rw.Code == http.StatusOK {
+            allowed = append(allowed, key)
+        }
+    }
+    switch len(allowed) {
+    case 1:
+        return allowed[0]
+    case len(p.addrs):
+        return ""
+    }
+    return fmt.Sprintf("ambiguous %v", allowed)
+}
+
+func checkMatches(t *testing.T, handler http.Handler, probe *ruleProbe, want map[string]string) {
+    t.Helper()
+    for target, key := range want {
+        if got := probe.match(handler, target); got != key {
+            t.Errorf("GET %s matched %q, want %q", target, got, key)
+        }
+    }
+}
+
+func TestWildcardDomainKeys(t *testing.T) {
+    handler, probe := newRuleProbe(t, map[string]DomainConfig{
+        "*.example.com":    {},
+        "*.eu.example.com": {},
+        "www.example.com":  {},
+        "*.example.org":    {MatchApex: boolFlag(true)},
+    })
+    checkMatches(t, handler, probe, map[string]string{
+        "http://a.example.com/":        "*.example.com",
+        "http://a.b.example.com/":      "*.example.com",
+        "http://www.example.com/":      "www.example.com", // exact wins
+        "http://x.www.example.com/":    "*.example.com",
+        "http://a.eu.example.com/":     "*.eu.example.com", // more specific wildcard wins
+        "http://eu.example.com/":       "*.example.com",
+        "http://example.com/":          "", // apex not included
+        "http://evilexample.com/":      "",
+        "http://a.evilexample.com/":    "",
+        "http://example.com.evil.net/": "",
+        "http://example.org/":          "*.example.org", // matchApex
+        "http://a.example.org/":        "*.example.org",
+        "http://evilexample.org/":      "",
+    })
+}
+
+func TestWildcardDomainKeyErrors(t *testing.T) {
+    for _, key := range []string{"a.*.example.com", "*example.com", "*.", "*.*.example.com"} {
+        config := domainConfig(key, DomainConfig{SourceIPs: ips("10.0.0.1")})
+        if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+            t.Errorf("key %q: New succeeded, want an error", key)
+        }
+    }
+}
+
+func TestCaseInsensitiveHost(t *testing.T) {
+    handler, probe := newRuleProbe(t, map[string]DomainConfig{
+        "example.com":           {},
+        "Admin.Example.NET":     {},
+        "*.Tenants.Example.com": {},
+    })
+    // httptest keeps the host of the target as written in req.Host.
+    checkMatches(t, handler, probe, map[string]string{
+        // The mixed-case bypass is closed.
+        "http://Example.COM/":    "example.com",
+        "http://EXAMPLE.com:80/": "example.com",
+        "http://example.com./":   "example.com",
+        // Keys written with upper case keep working.
+        "http://admin.example.net/":     "Admin.Example.NET",
+        "http://ADMIN.example.NET/":     "Admin.Example.NET",
+        "http://a.TENANTS.example.com/": "*.Tenants.Example.com",
+    })
+}
+
+func TestFoldedDomainKeyCollision(t *testing.T) {
+    config := CreateConfig()
+    config.DomainPathRules["Example.COM"] = DomainConfig{SourceIPs: ips("10.0.0.1")}
+    config.DomainPathRules["example.com"] = DomainConfig{SourceIPs: ips("10.0.0.2")}
+    _, err := New(context.Background(), okHandler, config, "test")
+    if err == nil || !strings.Contains(err.Error(), "Example.COM") || !strings.Contains(err.Error(), "example.com") {
+        t.Errorf("got %v, want an error listing both spellings", err)
+    }
+}
+
+func TestDefaultAction(t *testing.T) {
+    newConfig := func(action string) *Config {
+        config := domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.1")})
+        config.DefaultAction = action
+        return config
+    }
+    checkStatuses(t, newTestSentinel(t, newConfig(defaultActionAllow)), []statusCase{
+        {"http://example.com/", "192.0.2.1:1234", http.StatusForbidden},
+        {"http://unknown.example.org/", "192.0.2.1:1234", http.StatusOK},
+    })
+
+    config := newConfig(defaultActionDeny)
+    checkStatuses(t, newTestSentinel(t, config), []statusCase{
+        {"http://example.com/", "10.0.0.1:1234", http.StatusOK},
+        {"http://unknown.example.org/", "10.0.0.1:1234", http.StatusForbidden},
+        // A typo in a key no longer disables protection.
+        {"http://exampel.com/", "10.0.0.1:1234", http.StatusForbidden},
+    })
+
+    config.DefaultDenyStatus = http.StatusNotFound
+    config.DefaultDenyMessage = "unknown host"
+    rw := serve(newTestSentinel(t, config), "http://unknown.example.org/", "10.0.0.1:1234")
+    if rw.Code != http.StatusNotFound || !strings.Contains(rw.Body.String(), "unknown host") {
+        t.Errorf("custom default deny: status %d, body %q; want 404, %q", rw.Code, rw.Body.String(), "unknown host")
+    }
+
+    // The catch-all rule applies before defaultAction, which is left for
+    // hosts no rule at all covers.
+    config = newConfig(defaultActionDeny)
+    config.DomainPathRules[catchAllKey] = DomainConfig{SourceIPs: ips("192.0.2.0/24")}
+    checkStatuses(t, newTestSentinel(t, config), []statusCase{
+        {"http://unknown.example.org/", "192.0.2.1:1234", http.StatusOK},
+        {"http://unknown.example.org/", "10.0.0.1:1234", http.StatusForbidden},
+        {"http://example.com/", "10.0.0.1:1234", http.StatusOK},
+        {"http://example.com/", "192.0.2.1:1234", http.StatusForbidden},
+    })
+
+    config = newConfig("block")
+    if _, err := New(context.Background(), okHandler, config, "test"); err == nil || !strings.Contains(err.Error(), "defaultAction") {
+        t.Errorf(`defaultAction "block": got %v, want an error naming the option`, err)
+    }
+}
+
+func TestPortAwareDomainKeys(t *testing.T) {
+    handler, probe := newRuleProbe(t, map[string]DomainConfig{
+        "example.com":        {},
+        "example.com:8443":   {},
+        "*.example.com:8443": {},
+    }, func(config *Config) { config.IPStrategy.TrustedProxies = []string{"10.0.0.0/24"} })
+    checkMatches(t, handler, probe, map[string]string{
+        "http://example.com/":        "example.com",
+        "http://example.com:443/":    "example.com",
+        "http://example.com:8443/":   "example.com:8443",
+        "http://a.example.com:8443/": "*.example.com:8443",
+        "http://a.example.com:443/":  "",
+        "http://example.com.:8443/":  "example.com:8443",
+        "http://EXAMPLE.com:8443/":   "example.com:8443",
+    })
+
+    // Without a port in Host, the port comes from X-Forwarded-Port sent by a
+    // trusted proxy, then from the local address.
+    local := func(port string) context.Context {
+        return context.WithValue(context.Background(), http.LocalAddrContextKey, &net.TCPAddr{IP: net.IPv4(10, 9, 9, 9), Port: mustAtoi(t, port)})
+    }
+    tests := []struct {
+        name      string
+        host      string
+        localPort string
+        xfp       string
+        want      string
+    }{
+        {"local address", "example.com", "8443", "", "example.com:8443"},
+        {"other local port", "example.com", "443", "", "example.com"},
+        {"no port known", "example.com", "", "", "example.com"},
+        {"X-Forwarded-Port over local address", "example.com", "443", "8443", "example.com:8443"},
+        {"Host port over X-Forwarded-Port", "example.com:443", "8443", "8443", "example.com"},
+        {"invalid X-Forwarded-Port ignored", "example.com", "8443", "99999", "example.com:8443"},
+    }
+    for _, tt := range tests {
+        got := probe.matchRequest(handler, func() *http.Request {
+            req := httptest.NewRequest(http.MethodGet, "http://"+tt.host+"/", nil)
+            if tt.localPort != "" {
+                req = req.WithContext(local(tt.localPort))
+            }
+            if tt.xfp != "" {
+                req.Header.Set("X-Forwarded-Port", tt.xfp)
+            }
+            return req
+        })
+        if got != tt.want {
+            t.Errorf("%s: matched %q, want %q", tt.name, got, tt.want)
+        }
+    }
+}
+
+func mustAtoi(t *testing.T, s string) int {
+    t.Helper()
+    n, err := strconv.Atoi(s)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return n
+}
+
+func TestIncludeSubdomains(t *testing.T) {
+    handler, probe := newRuleProbe(t, map[string]DomainConfig{
+        "example.com":          {IncludeSubdomains: boolFlag(true)},
+        "internal.example.com": {IncludeSubdomains: boolFlag(true)},
+        "plain.example.com":    {},
+        "other.com":            {},
+    })
+    checkMatches(t, handler, probe, map[string]string{
+        "http://example.com/":                 "example.com",
+        "http://api.example.com/":             "example.com",
+        "http://internal.example.com/":        "internal.example.com",
+        "http://deep.internal.example.com/":   "internal.example.com",
+        "http://a.deep.internal.example.com/": "internal.example.com",
+        "http://plain.example.com/":           "plain.example.com",
+        // A parent without the flag is skipped for the next one up.
+        "http://x.plain.example.com/": "example.com",
+        // ... and does not leak its rules downward.
+        "http://other.com/":      "other.com",
+        "http://sub.other.com/":  "",
+        "http://notexample.com/": "",
+    })
+}
+
+func TestInheritParentRules(t *testing.T) {
+    config := CreateConfig()
+    config.DomainPathRules["example.com"] = DomainConfig{IncludeSubdomains: boolFlag(true), SourceIPs: ips("10.0.0.0/8")}
+    config.DomainPathRules["internal.example.com"] = DomainConfig{IncludeSubdomains: boolFlag(true), Inherit: boolFlag(true), SourceIPs: ips("10.1.0.0/16", "192.0.2.1")}
+    config.DomainPathRules["deep.internal.example.com"] = DomainConfig{Inherit: boolFlag(true), SourceIPs: ips("10.1.2.0/24", "10.2.0.1")}
+    config.DomainPathRules["own.example.com"] = DomainConfig{SourceIPs: ips("192.0.2.0/24")}
+    checkStatuses(t, newTestSentinel(t, config), []statusCase{
+        {"http://internal.example.com/", "10.1.9.9:1234", http.StatusOK},
+        {"http://internal.example.com/", "192.0.2.1:1234", http.StatusForbidden}, // the parent denies it
+        // Three levels: every ancestor up the chain must allow the client.
+        {"http://deep.internal.example.com/", "10.1.2.3:1234", http.StatusOK},
+        {"http://deep.internal.example.com/", "10.2.0.1:1234", http.StatusForbidden},
+        {"http://deep.internal.example.com/", "10.1.9.9:1234", http.StatusForbidden},
+        // Without inherit, an entry fully overrides its parent.
+        {"http://own.example.com/", "192.0.2.5:1234", http.StatusOK},
+        {"http://own.example.com/", "10.0.0.1:1234", http.StatusForbidden},
+    })
+
+    config = domainConfig("admin.example.com", DomainConfig{Inherit: boolFlag(true), SourceIPs: ips("10.0.0.1")})
+    config.DomainPathRules["example.com"] = DomainConfig{SourceIPs: ips("10.0.0.0/8")}
+    if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+        t.Error("inherit without a parent covering the subdomain: New succeeded, want an error")
+    }
+}
+
+func TestDomainPrecedence(t *testing.T) {
+    // Map iteration order varies between runs; the outcome must not.
+    for round := 0; round < 5; round++ {
+        testDomainPrecedence(t)
+    }
+}
+
+func testDomainPrecedence(t *testing.T) {
+    t.Helper()
+    handler, probe := newRuleProbe(t, map[string]DomainConfig{
+        "app.example.com":                 {},
+        "*.example.com":                   {},
+        "*.eu.example.com":                {},
+        "eu.example.com":                  {IncludeSubdomains: boolFlag(true), Priority: 1},
+        "shop.example.com":                {IncludeSubdomains: boolFlag(true)},
+        `~^app-.*\.example\.com$`:         {},
+        `~^app-.*-staging\.example\.net$`: {Priority: 5},
+        `~^app-.*\.example\.net$`:         {Priority: 2},
+        `~^[a-z0-9-]+\.example\.net$`:     {Priority: 1},
+        `~^[a-z]+\.example\.net$`:         {Priority: 1},
+        catchAllKey:                       {},
+    })
+    checkMatches(t, handler, probe, map[string]string{
+        // An exact key beats everything.
+        "http://app.example.com/": "app.example.com",
+        // The closest parent wins: the longest wildcard suffix ...
+        "http://a.example.com/":     "*.example.com",
+        "http://app-x.example.com/": "*.example.com", // wildcards before regexes
+        // ... and at the same suffix, the higher priority of a wildcard
+        // and an includeSubdomains key.
+        "http://a.eu.example.com/":   "eu.example.com",
+        "http://eu.example.com/":     "eu.example.com",
+        "http://a.shop.example.com/": "shop.example.com",
+        // Regex keys by descending priority, then in lexical order.
+        "http://app-eu1-staging.example.net/": `~^app-.*-staging\.example\.net$`,
+        "http://app-eu1.example.net/":         `~^app-.*\.example\.net$`,
+        "http://web.example.net/":             `~^[a-z0-9-]+\.example\.net$`, // lexically first
+        "http://web-1.example.net/":           `~^[a-z0-9-]+\.example\.net$`,
+        // Everything else falls back to the catch-all.
+        "http://example.org/":     catchAllKey,
+        "http://a.b.example.org/": catchAllKey,
+    })
+}
+
+func TestAmbiguousDomainRules(t *testing.T) {
+    tests := []struct {
+        name  string
+        rules map[string]DomainConfig
+    }{
+        {"wildcard and includeSubdomains", map[string]DomainConfig{
+            "*.example.com": {},
+            "example.com":   {IncludeSubdomains: boolFlag(true)},
+        }},
+        {"same regex", map[string]DomainConfig{
+            `~^a\.example\.com$`: {},
+            `~^A\.EXAMPLE\.com$`: {},
+        }},
+    }
+    for _, tt := range tests {
+        config := CreateConfig()
+        for key, rule := range tt.rules {
+            rule.SourceIPs = ips("10.0.0.1")
+            config.DomainPathRules[key] = rule
+        }
+        if _, err := New(context.Background(), okHandler, config, "test"); err == nil || !strings.Contains(err.Error(), "priorit") {
+            t.Errorf("%s: got %v, want an error asking for priorities", tt.name, err)
+        }
+        // Different priorities resolve it.
+        first := true
+        for key, rule := range config.DomainPathRules {
+            if first {
+                rule.Priority = 1
+                config.DomainPathRules[key] = rule
+                first = false
+            }
+        }
+        if _, err := New(context.Background(), okHandler, config, "test"); err != nil {
+            t.Errorf("%s with different priorities: %v", tt.name, err)
+        }
+    }
+}
+
+func TestSchemeConditionalRules(t *testing.T) {
+    config := domainConfig("device.example.com", DomainConfig{
+        SourceIPs: ips("192.0.2.0/24"),
+        Schemes:   []string{schemeHTTPS},
+    })
+    config.DomainPathRules["strict.example.com"] = DomainConfig{
+        SourceIPs:            ips("0.0.0.0/0"),
+        Schemes:              []string{schemeHTTPS},
+        SchemeMismatchAction: schemeMismatchDeny,
+    }
+    config.DomainPathRules["paths.example.com"] = DomainConfig{
+        SourceIPs: ips("0.0.0.0/0"),
+        PathRules: []PathConfig{{Path: "/admin", SourceIPs: ips("192.0.2.0/24"), Schemes: []string{schemeHTTPS}}},
+    }
+    config.IPStrategy.TrustedProxies = []string{"10.0.0.1"}
+    handler := newTestSentinel(t, config)
+
+    tests := []struct {
+        name, target, remoteAddr string
+        header                   []string
+        want                     int
+    }{
+        {"https, allowed", "https://device.example.com/", "192.0.2.5:1234", nil, http.StatusOK},
+        {"https, denied", "https://device.example.com/", "198.51.100.1:1234", nil, http.StatusForbidden},
+        {"http skips the rule", "http://device.example.com/", "198.51.100.1:1234", nil, http.StatusOK},
+        // An untrusted peer cannot claim another scheme to skip the rule.
+        {"spoofed proto from untrusted peer", "https://device.example.com/", "198.51.100.1:1234", []string{"X-Forwarded-Proto", "http"}, http.StatusForbidden},
+        {"spoofed ws from untrusted peer", "https://device.example.com/", "198.51.100.1:1234", []string{"X-Forwarded-Proto", "ws"}, http.StatusForbidden},
+        // A trusted proxy terminating TLS is believed.
+        {"trusted proxy, https", "http://device.example.com/", "10.0.0.1:1234", []string{"X-Forwarded-Proto", "https"}, http.StatusForbidden},
+        {"trusted proxy, http", "http://device.example.com/", "10.0.0.1:1234", []string{"X-Forwarded-Proto", "http"}, http.StatusOK},
+        {"trusted proxy, wss", "http://device.example.com/", "10.0.0.1:1234", []string{"X-Forwarded-Proto", "wss"}, http.StatusForbidden},
+        {"schemeMismatchAction deny", "http://strict.example.com/", "192.0.2.5:1234", nil, http.StatusForbidden},
+        {"schemeMismatchAction deny, https", "https://strict.example.com/", "192.0.2.5:1234", nil, http.StatusOK},
+        {"path rule, https", "https://paths.example.com/admin", "198.51.100.1:1234", nil, http.StatusForbidden},
+        {"path rule skipped for http", "http://paths.example.com/admin", "198.51.100.1:1234", nil, http.StatusOK},
+        {"path rule, spoofed proto", "https://paths.example.com/admin", "198.51.100.1:1234", []string{"X-Forwarded-Proto", "http"}, http.StatusForbidden},
+    }
+    for _, tt := range tests {
+        if got := serve(handler, tt.target, tt.remoteAddr, tt.header...).Code; got != tt.want {
+            t.Errorf("%s: status %d, want %d", tt.name, got, tt.want)
+        }
+    }
+
+    config = domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.1"), Schemes: []string{"ftp"}})
+    if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+        t.Error(`schemes ["ftp"]: New succeeded, want an error`)
+    }
+}
+
+func TestOrderedRules(t *testing.T) {
+    const (
+        wildcard = `"*.example.com": {"sourceIPs": ["10.1.0.0/16"]}`
+        exact    = `"api.example.com": {"sourceIPs": ["10.2.0.0/16"]}`
+        regex    = `"~api\\..*": {"sourceIPs": ["10.3.0.0/16"]}`
+    )
+    entry := func(rule string) string {
+        host, fields, _ := strings.Cut(rule, ": ")
+        return `{"hosts": [` + host + `], ` + strings.TrimPrefix(fields, "{")
+    }
+    // allowed returns which of the three lists the rule deciding a request
+    // for api.example.com uses.
+    allowed := func(config *Config) string {
+        handler := newTestSentinel(t, config)
+        var matched []string
+        for _, addr := range []string{"10.1.0.1:1234", "10.2.0.1:1234", "10.3.0.1:1234"} {
+            if serve(handler, "http://api.example.com/", addr).Code == http.StatusOK {
+                matched = append(matched, addr)
+            }
+        }
+        return strings.Join(matched, " ")
+    }
+
+    orders := [][]string{{wildcard, exact, regex}, {exact, regex, wildcard}, {regex, wildcard, exact}}
+    for _, order := range orders {
+        got := allowed(decodeTestConfig(t, `{"domainPathRules": {`+strings.Join(order, ", ")+`}}`))
+        if got != "10.2.0.1:1234" {
+            t.Errorf("domainPathRules in the order %v: allowed %q, want the exact key deciding", order, got)
+        }
+    }
+    for i, want := range []string{"10.1.0.1:1234", "10.2.0.1:1234", "10.3.0.1:1234"} {
+        order := orders[i]
+        list := make([]string, len(order))
+        for j, rule := range order {
+            list[j] = entry(rule)
+        }
+        if got := allowed(decodeTestConfig(t, `{"rules": [`+strings.Join(list, ", ")+`]}`)); got != want {
+            t.Errorf("rules in the order %v: allowed %q, want %q from the first entry", order, got, want)
+        }
+    }
+
+    config := decodeTestConfig(t, `{"domainPathRules": {`+exact+`}, "rules": [`+entry(wildcard)+`]}`)
+    if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+        t.Error("rules and domainPathRules: New succeeded, want an error")
+    }
+}
+
+func TestEnforceHostSNIMatch(t *testing.T) {
+    config := domainConfig("admin.example.com", DomainConfig{SourceIPs: ips("192.0.2.0/24"), EnforceHostSNIMatch: boolFlag(true)})
+    config.DomainPathRules["public.example.com"] = DomainConfig{SourceIPs: ips("0.0.0.0/0")}
+    config.DomainPathRules["strict.example.com"] = DomainConfig{
+        SourceIPs:             ips("192.0.2.0/24"),
+        EnforceHostSNIMatch:   boolFlag(true),
+        HostSNIMismatchStatus: http.StatusForbidden,
+        RequireSNI:            boolFlag(true),
+    }
+    config.DomainPathRules["loose.example.com"] = DomainConfig{SourceIPs: ips("192.0.2.0/24")}
+    handler := newTestSentinel(t, config)
+
+    send := func(host, sni string, tls bool) (int, string) {
+        req := httptest.NewRequest(http.MethodGet, "https://"+host+"/", nil)
+        req.RemoteAddr = "192.0.2.5:1234"
+        if !tls {
+            req.TLS = nil
+        } else {
+            req.TLS.ServerName = sni
+        }
+        rw := httptest.NewRecorder()
+        out := captureOutput(t, func() { handler.ServeHTTP(rw, req) })
+        return rw.Code, out
+    }
+    tests := []struct {
+        name, host, sni string
+        tls             bool
+        want            int
+    }{
+        {"fronted through a public certificate", "admin.example.com", "public.example.com", true, http.StatusMisdirectedRequest},
+        {"matching SNI", "admin.example.com", "admin.example.com", true, http.StatusOK},
+        {"matching after normalization", "Admin.Example.com.:443", "ADMIN.example.com", true, http.StatusOK},
+        {"no TLS", "admin.example.com", "", false, http.StatusOK},
+        {"no SNI", "admin.example.com", "", true, http.StatusOK},
+        {"custom status", "strict.example.com", "public.example.com", true, http.StatusForbidden},
+        {"no SNI with requireSNI", "strict.example.com", "", true, http.StatusForbidden},
+        {"option off", "loose.example.com", "public.example.com", true, http.StatusOK},
+    }
+    for _, tt := range tests {
+        if got, _ := send(tt.host, tt.sni, tt.tls); got != tt.want {
+            t.Errorf("%s: status %d, want %d", tt.name, got, tt.want)
+        }
+    }
+    if _, out := send("admin.example.com", "public.example.com", true); !strings.Contains(out, `Host "admin.example.com"`) || !strings.Contains(out, `server name "public.example.com"`) {
+        t.Errorf("mismatch log does not name both hosts:\n%s", out)
+    }
+
+    config = domainConfig("example.com", DomainConfig{SourceIPs: ips("192.0.2.0/24"), EnforceHostSNIMatch: boolFlag(true), HostSNIMismatchStatus: 302})
+    if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+        t.Error("hostSNIMismatchStatus 302 accepted")
+    }
+}
@@ -0,0 +1,177 @@
+package DomainSentinel
+
+import (
+    "encoding/json"
+    "fmt"
+    "net"
+    "reflect"
+    "sort"
+    "strings"
+)
+
+// mergedFields are the DomainConfig fields, by JSON name, that
+// mergeDuplicateDomains combines. Every other field must be the same in
+// all spellings of a key.
+var mergedFields = map[string]bool{"sourceIPs": true, "pathRules": true}
+
+// mergeDuplicateDomains returns config with the domainPathRules and zones
+// keys that name the same host after normalization (case, trailing dot,
+// punycode) merged into one entry. Without MergeDuplicateDomains such keys
+// are an error, since which spelling wins would otherwise be arbitrary.
+// config is not modified.
+func mergeDuplicateDomains(config *Config) (*Config, error) {
+    merged := *config
+    var problems, zoneProblems ConfigErrors
+    merged.DomainPathRules, problems = mergeDuplicateKeys("domainPathRules", config.DomainPathRules, config.MergeDuplicateDomains)
+    merged.Zones, zoneProblems = mergeDuplicateKeys("zones", config.Zones, config.MergeDuplicateDomains)
+    if problems = append(problems, zoneProblems...); len(problems) > 0 {
+        return nil, problems
+    }
+    return &merged, nil
+}
+
+// mergeDuplicateKeys merges the keys of section that normalize to the
+// same host. The merged entry has the spelling already in normalized form,
+// or else the first in lexical order; its sourceIPs and pathRules are
+// those of all spellings in that order, without duplicates.
+func mergeDuplicateKeys(section string, rules map[string]DomainConfig, merge bool) (map[string]DomainConfig, ConfigErrors) {
+    spellings := make(map[string][]string)
+    for key, rule := range rules {
+        if normalized, ok := normalizedDomainKey(key, rule); ok {
+            spellings[normalized] = append(spellings[normalized], key)
+        }
+    }
+    var hosts []string
+    for host, keys := range spellings {
+        if len(keys) > 1 {
+            hosts = append(hosts, host)
+        }
+    }
+    if len(hosts) == 0 {
+        return rules, nil
+    }
+    sort.Strings(hosts)
+
+    result := make(map[string]DomainConfig, len(rules))
+    for key, rule := range rules {
+        result[key] = rule
+    }
+    var problems ConfigErrors
+    for _, host := range hosts {
+        keys := spellings[host]
+        sort.SliceStable(keys, func(i, j int) bool { return keys[i] == host || (keys[j] != host && keys[i] < keys[j]) })
+        loc := at()
+        loc.Section, loc.Domain = section, host
+        if !merge {
+            loc.Reason = fmt.Sprintf("keys %s are the same host name; use one spelling, or set mergeDuplicateDomains to merge their sourceIPs and pathRules",
+                quotedList(keys))
+            problems = append(problems, &loc)
+            continue
+        }
+        combined, err := mergeSpellings(rules, keys)
+        if err != nil {
+            loc.Reason, loc.Err = err.Error(), err
+            problems = append(problems, &loc)
+            continue
+        }
+        for _, key := range keys[1:] {
+            delete(result, key)
+        }
+        result[keys[0]] = combined
+        fmt.Printf("Warning: %s keys %s are the same host name %q, merged into %q (mergeDuplicateDomains)\n", section, quotedList(keys), host, keys[0])
+    }
+    return result, problems
+}
+
+// normalizedDomainKey returns the host a domain key is looked up under, as
+// newDomainTable normalizes it. Regex keys, the catch-all and keys with a
+// hosts list are not looked up by their key, and malformed keys are left to
+// the compiler.
+func normalizedDomainKey(key string, rule DomainConfig) (string, bool) {
+    if key == catchAllKey || strings.HasPrefix(key, regexPrefix) || len(rule.Hosts) > 0 {
+        return "", false
+    }
+    name, port := splitKeyPort(key)
+    normalized, err := toASCII(strings.TrimSuffix(name, "."))
+    if err != nil {
+        return "", false
+    }
+    if port != "" {
+        normalized = net.JoinHostPort(normalized, port)
+    }
+    return normalized, true
+}
+
+// mergeSpellings merges the entries of keys, the first of which is kept.
+func mergeSpellings(rules map[string]DomainConfig, keys []string) (DomainConfig, error) {
+    merged := rules[keys[0]]
+    var base map[string]interface{}
+    if err := roundTrip(merged, &base); err != nil {
+        return merged, err
+    }
+    seenSources, seenPaths := make(map[string]bool), make(map[string]bool)
+    merged.SourceIPs, merged.PathRules = nil, nil
+    for _, key := range keys {
+        rule := rules[key]
+        var fields map[string]interface{}
+        if err := roundTrip(rule, &fields); err != nil {
+            return merged, err
+        }
+        if differing := differingFields(base, fields); len(differing) > 0 {
+            return merged, fmt.Errorf("keys %q and %q also differ in %s, which mergeDuplicateDomains does not merge; make them the same or use one spelling",
+                keys[0], key, strings.Join(differing, ", "))
+        }
+        for _, source := range rule.SourceIPs {
+            if id := jsonKey(source); !seenSources[id] {
+                seenSources[id] = true
+                merged.SourceIPs = append(merged.SourceIPs, source)
+            }
+        }
+        for _, pathRule := range rule.PathRules {
+            if id := jsonKey(pathRule); !seenPaths[id] {
+                seenPaths[id] = true
+                merged.PathRules = append(merged.PathRules, pathRule)
+            }
+        }
+    }
+    return merged, nil
+}
+
+// differingFields lists the fields, other than mergedFields, that a and b
+// do not set to the same value.
+func differingFields(a, b map[string]interface{}) []string {
+    var differing []string
+    for field := range a {
+        if _, ok := b[field]; !ok && !mergedFields[field] {
+            differing = append(differing, field)
+        }
+    }
+    for field, value := range b {
+        if !mergedFields[field] && !reflect.DeepEqual(a[field], value) {
+            differing = append(differing, field)
+        }
+    }
+    sort.Strings(differing)
+    return differing
+}
+
+// jsonKey identifies a list entry by its JSON encoding.
+func jsonKey(v interface{}) string {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return fmt.Sprint(v)
+    }
+    return string(data)
+}
+
+// quotedList formats keys as "a", "b" and "c".
+func quotedList(keys []string) string {
+    quoted := make([]string, len(keys))
+    for i, key := range keys {
+        quoted[i] = fmt.Sprintf("%q", key)
+    }
+    if len(quoted) == 1 {
+        return quoted[0]
+    }
+    return strings.Join(quoted[:len(quoted)-1], ", ") + " and " + quoted[len(quoted)-1]
+}
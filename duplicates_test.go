@@ -0,0 +1,113 @@
+package DomainSentinel
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "reflect"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+func TestDuplicateDomainKeys(t *testing.T) {
+    tests := []struct {
+        name string
+        keys []string
+        want string // the spellings as listed in the error
+    }{
+        {"two-way", []string{"example.com.", "example.com"}, `keys "example.com" and "example.com." are the same host name`},
+        {"three-way", []string{"Example.COM", "example.com.", "example.com"},
+            `keys "example.com", "Example.COM" and "example.com." are the same host name`},
+        {"punycode", []string{"bücher.example", "xn--bcher-kva.example"},
+            `keys "xn--bcher-kva.example" and "bücher.example" are the same host name`},
+        {"with port", []string{"Example.com:8443", "example.com:8443"}, `keys "example.com:8443" and "Example.com:8443"`},
+    }
+    for _, tt := range tests {
+        config := CreateConfig()
+        for i, key := range tt.keys {
+            config.DomainPathRules[key] = DomainConfig{SourceIPs: ips("10.0.0." + strconv.Itoa(i+1))}
+        }
+        _, err := New(context.Background(), okHandler, config, "test")
+        var problems ConfigErrors
+        if !errors.As(err, &problems) || len(problems) != 1 || !strings.Contains(err.Error(), tt.want) {
+            t.Errorf("%s: got %v, want one error containing %s", tt.name, err, tt.want)
+        }
+    }
+
+    // Keys that only look alike are not collisions.
+    config := CreateConfig()
+    config.DomainPathRules["example.com"] = DomainConfig{SourceIPs: ips("10.0.0.1")}
+    config.DomainPathRules["example.com:8443"] = DomainConfig{SourceIPs: ips("10.0.0.2")}
+    config.DomainPathRules["*.example.com"] = DomainConfig{SourceIPs: ips("10.0.0.3")}
+    newTestSentinel(t, config)
+}
+
+func TestMergeDuplicateDomains(t *testing.T) {
+    admin := PathConfig{Path: "/admin", SourceIPs: ips("10.0.0.1")}
+    config := CreateConfig()
+    config.MergeDuplicateDomains = true
+    config.DomainPathRules["Example.COM"] = DomainConfig{SourceIPs: ips("10.0.0.2", "10.0.0.1"), PathRules: []PathConfig{admin}}
+    config.DomainPathRules["example.com."] = DomainConfig{SourceIPs: ips("10.0.0.3"), PathRules: []PathConfig{{Path: "/api", SourceIPs: ips("10.0.0.3")}}}
+    config.DomainPathRules["example.com"] = DomainConfig{SourceIPs: ips("10.0.0.1"), PathRules: []PathConfig{admin}}
+    config.DomainPathRules["shop.example.com"] = DomainConfig{SourceIPs: ips("10.0.0.9")}
+
+    var merged *Config
+    out := captureOutput(t, func() {
+        var err error
+        if merged, err = mergeDuplicateDomains(config); err != nil {
+            t.Fatal(err)
+        }
+    })
+    if len(config.DomainPathRules) != 4 {
+        t.Error("mergeDuplicateDomains modified its input")
+    }
+    if !strings.Contains(out, `Warning: domainPathRules keys "example.com", "Example.COM" and "example.com." are the same host name`) {
+        t.Errorf("no warning naming the merged keys:\n%s", out)
+    }
+    if len(merged.DomainPathRules) != 2 {
+        t.Fatalf("merged keys %v, want example.com and shop.example.com", sortedConfigKeys(merged.DomainPathRules))
+    }
+    rule, ok := merged.DomainPathRules["example.com"]
+    if !ok {
+        t.Fatalf("merged keys %v, want the normalized spelling kept", sortedConfigKeys(merged.DomainPathRules))
+    }
+    // Concatenated in the order of the spellings, then deduplicated.
+    if want := ips("10.0.0.1", "10.0.0.2", "10.0.0.3"); !reflect.DeepEqual(rule.SourceIPs, want) {
+        t.Errorf("sourceIPs = %v, want %v", rule.SourceIPs, want)
+    }
+    var paths []string
+    for _, p := range rule.PathRules {
+        paths = append(paths, p.Path)
+    }
+    if want := []string{"/admin", "/api"}; !reflect.DeepEqual(paths, want) {
+        t.Errorf("pathRules = %v, want %v", paths, want)
+    }
+
+    // The merge does not depend on the order the map is read in.
+    for i := 0; i < 10; i++ {
+        again, err := mergeDuplicateDomains(config)
+        if err != nil || !reflect.DeepEqual(again.DomainPathRules, merged.DomainPathRules) {
+            t.Fatalf("merge %d differs: %v", i, err)
+        }
+    }
+
+    checkStatuses(t, newTestSentinel(t, config), []statusCase{
+        {"http://example.com/", "10.0.0.3:1234", http.StatusOK},
+        {"http://EXAMPLE.com./", "10.0.0.2:1234", http.StatusOK},
+        {"http://example.com/admin", "10.0.0.2:1234", http.StatusForbidden},
+        {"http://example.com/api", "10.0.0.3:1234", http.StatusOK},
+    })
+}
+
+func TestMergeDuplicateDomainsConflict(t *testing.T) {
+    config := CreateConfig()
+    config.MergeDuplicateDomains = true
+    config.DomainPathRules["example.com"] = DomainConfig{SourceIPs: ips("10.0.0.1"), DenyStatus: 404}
+    config.DomainPathRules["Example.com"] = DomainConfig{SourceIPs: ips("10.0.0.2")}
+    config.DomainPathRules["EXAMPLE.com"] = DomainConfig{SourceIPs: ips("10.0.0.3"), DenyStatus: 404}
+    _, err := New(context.Background(), okHandler, config, "test")
+    if err == nil || !strings.Contains(err.Error(), `keys "example.com" and "Example.com" also differ in denyStatus`) {
+        t.Errorf("got %v, want an error naming the differing field", err)
+    }
+}
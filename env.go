@@ -0,0 +1,206 @@
+package DomainSentinel
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// expandEnv returns config with the environment variable references in its
+// sourceIPs entries, rulesFile, overrideRulesFile, rulesURL,
+// rulesURLBearerToken and defaultDenyMessage expanded. A reference is
+// ${VAR}, or ${VAR:-default} for a default used when VAR is unset or empty;
+// "$$" stands for a literal "$". A variable that is unset without a default
+// is an error, as is a malformed reference. config is not modified.
+func expandEnv(config *Config) (*Config, error) {
+    e := &envExpander{}
+    expanded := *config
+    for _, option := range []struct {
+        name  string
+        value *string
+    }{
+        {"rulesFile", &expanded.RulesFile},
+        {"overrideRulesFile", &expanded.OverrideRulesFile},
+        {"rulesURL", &expanded.RulesURL},
+        {"rulesURLBearerToken", &expanded.RulesURLBearerToken},
+        {"defaultDenyMessage", &expanded.DefaultDenyMessage},
+    } {
+        *option.value = e.expand(field(at(), option.name), *option.value)
+    }
+    expanded.DefaultSourceIPs = e.strings(field(at(), "defaultSourceIPs"), config.DefaultSourceIPs)
+    expanded.DomainPathRules = e.domains("domainPathRules", config.DomainPathRules)
+    expanded.OverrideRules = e.domains("overrideRules", config.OverrideRules)
+    expanded.Zones = e.domains("zones", config.Zones)
+    expanded.Templates = e.domains("templates", config.Templates)
+    if config.Rules != nil {
+        expanded.Rules = make([]DomainConfig, len(config.Rules))
+        for i, rule := range config.Rules {
+            loc := at()
+            loc.Section, loc.Domain = "rules", strconv.Itoa(i)
+            expanded.Rules[i] = e.domain(loc, rule)
+        }
+    }
+    if config.GlobalPathRules != nil {
+        loc := at()
+        loc.Section = "globalPathRules"
+        expanded.GlobalPathRules = e.pathRules(loc, config.GlobalPathRules)
+    }
+    if len(e.problems) > 0 {
+        return nil, e.problems
+    }
+    return &expanded, nil
+}
+
+// envExpander expands the references of a configuration and collects the
+// problems with their location.
+type envExpander struct {
+    problems ConfigErrors
+}
+
+func (e *envExpander) domains(section string, rules map[string]DomainConfig) map[string]DomainConfig {
+    if rules == nil {
+        return nil
+    }
+    expanded := make(map[string]DomainConfig, len(rules))
+    for _, key := range sortedConfigKeys(rules) {
+        loc := at()
+        loc.Section, loc.Domain = section, key
+        expanded[key] = e.domain(loc, rules[key])
+    }
+    return expanded
+}
+
+func (e *envExpander) domain(loc ConfigError, config DomainConfig) DomainConfig {
+    config.SourceIPs = e.sourceIPs(field(loc, "sourceIPs"), config.SourceIPs)
+    if config.PathRules != nil {
+        config.PathRules = e.pathRules(loc, config.PathRules)
+    }
+    return config
+}
+
+func (e *envExpander) pathRules(loc ConfigError, rules []PathConfig) []PathConfig {
+    expanded := make([]PathConfig, len(rules))
+    for i, rule := range rules {
+        loc.PathIndex = i
+        rule.SourceIPs = e.sourceIPs(field(loc, "sourceIPs"), rule.SourceIPs)
+        expanded[i] = rule
+    }
+    return expanded
+}
+
+// sourceIPs expands string entries and the ip of SourceIP objects. Entries
+// of other types are left to validateConfig.
+func (e *envExpander) sourceIPs(loc ConfigError, values []interface{}) []interface{} {
+    if values == nil {
+        return nil
+    }
+    expanded := make([]interface{}, len(values))
+    for i, value := range values {
+        loc.Entry = i
+        switch v := value.(type) {
+        case string:
+            value = e.expand(loc, v)
+        case SourceIP:
+            v.IP = e.expand(loc, v.IP)
+            value = v
+        case *SourceIP:
+            entry := *v
+            entry.IP = e.expand(loc, entry.IP)
+            value = &entry
+        case map[string]interface{}:
+            if ip, ok := v["ip"].(string); ok {
+                m := make(map[string]interface{}, len(v))
+                for key, val := range v {
+                    m[key] = val
+                }
+                m["ip"] = e.expand(loc, ip)
+                value = m
+            }
+        }
+        expanded[i] = value
+    }
+    return expanded
+}
+
+func (e *envExpander) strings(loc ConfigError, values []string) []string {
+    if values == nil {
+        return nil
+    }
+    expanded := make([]string, len(values))
+    for i, value := range values {
+        loc.Entry = i
+        expanded[i] = e.expand(loc, value)
+    }
+    return expanded
+}
+
+// expand returns value with its references expanded, and records a problem
+// at loc if one cannot be.
+func (e *envExpander) expand(loc ConfigError, value string) string {
+    expanded, err := expandEnvValue(value, os.LookupEnv)
+    if err != nil {
+        loc.Reason, loc.Err = err.Error(), err
+        e.problems = append(e.problems, &loc)
+        return value
+    }
+    return expanded
+}
+
+// expandEnvValue expands the ${VAR} and ${VAR:-default} references of s,
+// looking variables up with lookup, and replaces "$$" by "$". A "$" that
+// starts neither is kept as it is.
+func expandEnvValue(s string, lookup func(string) (string, bool)) (string, error) {
+    if !strings.Contains(s, "$") {
+        return s, nil
+    }
+    var b strings.Builder
+    for i := 0; i < len(s); i++ {
+        if s[i] != '$' || i+1 == len(s) {
+            b.WriteByte(s[i])
+            continue
+        }
+        switch s[i+1] {
+        case '$':
+            b.WriteByte('$')
+            i++
+        case '{':
+            end := strings.IndexByte(s[i+2:], '}')
+            if end < 0 {
+                return "", errors.New(`unterminated "${" reference; write "$$" for a literal "$"`)
+            }
+            name, fallback, hasDefault := strings.Cut(s[i+2:i+2+end], ":-")
+            if !validEnvName(name) {
+                return "", fmt.Errorf("invalid environment variable name %q in \"${%s}\"", name, s[i+2:i+2+end])
+            }
+            value, ok := lookup(name)
+            switch {
+            case hasDefault && value == "":
+                value = fallback
+            case !ok:
+                return "", fmt.Errorf("environment variable %q is not set, and the reference has no default", name)
+            }
+            b.WriteString(value)
+            i += 2 + end
+        default:
+            b.WriteByte('$')
+        }
+    }
+    return b.String(), nil
+}
+
+// validEnvName reports whether name is a shell variable name: letters,
+// digits and underscores, not starting with a digit.
+func validEnvName(name string) bool {
+    if name == "" || (name[0] >= '0' && name[0] <= '9') {
+        return false
+    }
+    for i := 0; i < len(name); i++ {
+        c := name[i]
+        if !(c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+            return false
+        }
+    }
+    return true
+}
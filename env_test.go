@@ -0,0 +1,110 @@
+package DomainSentinel
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestExpandEnvValue(t *testing.T) {
+    env := map[string]string{"OFFICE": "192.0.2.0/24", "EMPTY": "", "HOST": "192.0.2.7"}
+    lookup := func(name string) (string, bool) {
+        value, ok := env[name]
+        return value, ok
+    }
+    tests := []struct {
+        in, want string
+        err      string // part of the error, empty if there is none
+    }{
+        {"10.0.0.0/8", "10.0.0.0/8", ""},
+        {"${OFFICE}", "192.0.2.0/24", ""},
+        {"${HOST}/32", "192.0.2.7/32", ""},
+        {"${MISSING:-10.0.0.1}", "10.0.0.1", ""},
+        {"${EMPTY:-10.0.0.1}", "10.0.0.1", ""},
+        {"${OFFICE:-10.0.0.1}", "192.0.2.0/24", ""},
+        {"${EMPTY}", "", ""},
+        {"$${OFFICE}", "${OFFICE}", ""},
+        {"a$$b", "a$b", ""},
+        {"cost $5", "cost $5", ""},
+        {"trailing $", "trailing $", ""},
+        {"${MISSING}", "", `environment variable "MISSING" is not set`},
+        {"${OFFICE", "", "unterminated"},
+        {"${1ST}", "", "invalid environment variable name"},
+        {"${}", "", "invalid environment variable name"},
+    }
+    for _, tt := range tests {
+        got, err := expandEnvValue(tt.in, lookup)
+        switch {
+        case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+            t.Errorf("expandEnvValue(%q) = %q, %v; want an error containing %q", tt.in, got, err, tt.err)
+        case tt.err == "" && (err != nil || got != tt.want):
+            t.Errorf("expandEnvValue(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+        }
+    }
+}
+
+func TestEnvInSourceIPs(t *testing.T) {
+    t.Setenv("DS_TEST_OFFICE", "192.0.2.0/24")
+    t.Setenv("DS_TEST_VPN", "198.51.100.7")
+    t.Setenv("DS_TEST_ADMIN", "10.1.0.0/16")
+    config := decodeTestConfig(t, `{
+        "domainPathRules": {"example.com": {
+            "sourceIPs": ["10.0.0.1", "${DS_TEST_OFFICE}", {"ip": "${DS_TEST_VPN}", "label": "vpn"}, "${DS_TEST_UNSET:-203.0.113.0/24}"],
+            "pathRules": [
+                {"path": "/admin", "sourceIPs": ["${DS_TEST_ADMIN}"]},
+                {"path": "/ops", "sourceIPs": ["${DS_TEST_VPN}/32", "10.2.0.0/16"]}
+            ]
+        }},
+        "globalPathRules": [{"path": "/.git/*", "sourceIPs": ["${DS_TEST_ADMIN}"]}]
+    }`)
+    handler := newTestSentinel(t, config)
+    checkStatuses(t, handler, []statusCase{
+        {"http://example.com/", "192.0.2.9:1234", http.StatusOK},
+        {"http://example.com/", "198.51.100.7:1234", http.StatusOK},
+        {"http://example.com/", "203.0.113.9:1234", http.StatusOK},
+        {"http://example.com/", "198.51.100.8:1234", http.StatusForbidden},
+        {"http://example.com/admin", "10.1.2.3:1234", http.StatusOK},
+        {"http://example.com/admin", "192.0.2.9:1234", http.StatusForbidden},
+        {"http://example.com/ops", "198.51.100.7:1234", http.StatusOK},
+        {"http://example.com/ops", "192.0.2.9:1234", http.StatusForbidden},
+        {"http://example.com/.git/config", "192.0.2.9:1234", http.StatusForbidden},
+    })
+    if got := config.DomainPathRules["example.com"].PathRules[0].SourceIPs[0]; got != "${DS_TEST_ADMIN}" {
+        t.Errorf("New modified the configuration: %v", got)
+    }
+}
+
+func TestEnvErrors(t *testing.T) {
+    config := decodeTestConfig(t, `{
+        "domainPathRules": {"example.com": {
+            "sourceIPs": ["10.0.0.1", "${DS_TEST_UNSET_A}"],
+            "pathRules": [
+                {"path": "/ok", "sourceIPs": ["10.0.0.1"]},
+                {"path": "/admin", "sourceIPs": ["10.0.0.2", {"ip": "${DS_TEST_UNSET_B}"}]}
+            ]
+        }},
+        "rulesFile": "${DS_TEST_UNSET_C}"
+    }`)
+    _, err := New(context.Background(), okHandler, config, "test")
+    var problems ConfigErrors
+    if !errors.As(err, &problems) {
+        t.Fatalf("got %v, want ConfigErrors", err)
+    }
+    var got []string
+    for _, p := range problems {
+        got = append(got, p.Location())
+        if !strings.Contains(p.Reason, "DS_TEST_UNSET_") {
+            t.Errorf("%s: reason %q does not name the variable", p.Location(), p.Reason)
+        }
+    }
+    want := []string{
+        "rulesFile",
+        `domainPathRules["example.com"].sourceIPs[1]`,
+        `domainPathRules["example.com"].pathRules[1].sourceIPs[1]`,
+    }
+    if strings.Join(got, "\n") != strings.Join(want, "\n") {
+        t.Errorf("problems at\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+    }
+}
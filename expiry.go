@@ -0,0 +1,53 @@
+package DomainSentinel
+
+import (
+    "context"
+    "fmt"
+    "time"
+)
+
+// expiryReportInterval is how often expired source IP entries are listed in
+// the log.
+const expiryReportInterval = time.Hour
+
+// expiringEntry is a source IP entry with a validUntil timestamp.
+type expiringEntry struct {
+    entry      string
+    label      string
+    validUntil time.Time
+}
+
+// reportExpired periodically logs the entries whose validUntil has passed,
+// so they can be removed from the configuration. Expired entries already stop
+// matching on their own; the report is only a reminder.
+func reportExpired(ctx context.Context, entries []expiringEntry, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case now := <-ticker.C:
+            logExpired(entries, now)
+        }
+    }
+}
+
+// logExpired prints a summary of the entries expired at now.
+func logExpired(entries []expiringEntry, now time.Time) {
+    var expired []string
+    for _, e := range entries {
+        if now.Before(e.validUntil) {
+            continue
+        }
+        s := fmt.Sprintf("%s (expired %s)", e.entry, e.validUntil.Format(time.RFC3339))
+        if e.label != "" {
+            s = fmt.Sprintf("%s (%s, expired %s)", e.entry, e.label, e.validUntil.Format(time.RFC3339))
+        }
+        expired = append(expired, s)
+    }
+    if len(expired) > 0 {
+        fmt.Printf("Warning: %d expired source IP entries can be removed from the configuration: %v\n", len(expired), expired)
+    }
+}
@@ -0,0 +1,650 @@
+package DomainSentinel
+
+import (
+    "fmt"
+    "net/http"
+    "net/netip"
+    "strings"
+)
+
+// IPStrategy selects how the client address is derived from the headers a
+// trusted proxy sets. Headers are only honored when the socket peer is one
+// of the trustedProxies.
+type IPStrategy struct {
+    Mode string `json:"mode,omitempty"` // xForwardedFor (default), forwarded, xRealIP, cdnHeader, customHeader or remoteAddr
+
+    // Platform selects a preset for a CDN or hosting platform (cloudflare,
+    // fastly, flyio or cloudfront) that sets the mode, the headers and,
+    // where the vendor publishes them, the edge ranges as trusted proxies.
+    Platform string `json:"platform,omitempty"`
+
+    // TrustedProxies are the peers whose headers are honored. At plugin
+    // level, legacy configurations also list them in Config.TrustedProxies,
+    // which migrateConfig moves here.
+    TrustedProxies []string `json:"trustedProxies,omitempty"`
+
+    // Headers lists the single-address headers checked in order by the
+    // cdnHeader mode. Defaults to CF-Connecting-IP and True-Client-IP.
+    Headers []string `json:"headers,omitempty"`
+
+    // Header names the header read by the customHeader mode. Format is ip
+    // (default) or ipPort, and MultipleValues tells whether several hops
+    // are commaJoined (default) or sent as repeated headers.
+    Header         string `json:"header,omitempty"`
+    Format         string `json:"format,omitempty"`
+    MultipleValues string `json:"multipleValues,omitempty"`
+
+    // InvalidHeaderAction handles a trusted proxy sending a client address
+    // header that does not contain a valid address: fallback (default) uses
+    // the socket address, reject answers 400 Bad Request.
+    InvalidHeaderAction string `json:"invalidHeaderAction,omitempty"`
+
+    // AllTrustedFallback decides the client address when every hop of a
+    // forwarding chain is a trusted proxy: leftmost (default) uses the first
+    // hop, remoteAddr the socket address.
+    AllTrustedFallback string `json:"allTrustedFallback,omitempty"`
+
+    // Depth selects the X-Forwarded-For entry that many hops from the right
+    // instead of walking the chain, like Traefik's ipStrategy.depth; 0 uses
+    // the socket address. DepthExceededAction handles shorter chains:
+    // fallback (default) uses the socket address, deny answers 403.
+    Depth               *int   `json:"depth,omitempty"`
+    DepthExceededAction string `json:"depthExceededAction,omitempty"`
+
+    // ExcludedIPs are skipped like trusted proxies while walking a
+    // forwarding chain from the right, like Traefik's
+    // ipStrategy.excludedIPs. They are not trusted to send headers.
+    ExcludedIPs []string `json:"excludedIPs,omitempty"`
+
+    // RejectSpoofedHeaders answers 400 Bad Request when a peer that is not
+    // a trusted proxy sends the client address header(s) of the mode.
+    RejectSpoofedHeaders bool `json:"rejectSpoofedHeaders,omitempty"`
+}
+
+// ClientIPHeader passes the client address the rules were evaluated
+// against on to the backend.
+type ClientIPHeader struct {
+    Enabled bool   `json:"enabled,omitempty"`
+    Name    string `json:"name,omitempty"` // default X-DS-Client-IP
+}
+
+// defaultClientIPHeader is the header set when ClientIPHeader.Name is empty.
+const defaultClientIPHeader = "X-DS-Client-IP"
+
+// headerName validates the block and returns the header to set, or an
+// empty string if the feature is disabled.
+func (h ClientIPHeader) headerName() (string, error) {
+    if !h.Enabled {
+        return "", nil
+    }
+    name := strings.TrimSpace(h.Name)
+    if name == "" {
+        return defaultClientIPHeader, nil
+    }
+    if strings.ContainsAny(name, " \t:") {
+        return "", fmt.Errorf("invalid name %q", name)
+    }
+    return name, nil
+}
+
+// Client IP strategy modes.
+const (
+    ipStrategyXForwardedFor = "xForwardedFor"
+    ipStrategyForwarded     = "forwarded" // RFC 7239
+    ipStrategyXRealIP       = "xRealIP"
+    ipStrategyCDNHeader     = "cdnHeader"
+    ipStrategyCustomHeader  = "customHeader"
+    ipStrategyRemoteAddr    = "remoteAddr" // ignore all headers
+)
+
+// defaultCDNHeaders are the client address headers set by Cloudflare.
+var defaultCDNHeaders = []string{"CF-Connecting-IP", "True-Client-IP"}
+
+// Actions for X-Forwarded-For chains shorter than depth.
+const (
+    depthExceededFallback = "fallback"
+    depthExceededDeny     = "deny"
+)
+
+// Address formats and value layouts of the customHeader mode.
+const (
+    headerFormatIP       = "ip"
+    headerFormatIPPort   = "ipPort"
+    headerValuesComma    = "commaJoined"
+    headerValuesRepeated = "repeated"
+)
+
+// Actions for invalid client address headers from trusted proxies.
+const (
+    invalidHeaderFallback = "fallback"
+    invalidHeaderReject   = "reject"
+)
+
+// Fallbacks for forwarding chains consisting of trusted proxies only.
+const (
+    allTrustedLeftmost   = "leftmost"
+    allTrustedRemoteAddr = "remoteAddr"
+)
+
+// clientIPStrategy is the compiled form of an IPStrategy.
+type clientIPStrategy struct {
+    mode           string
+    headers        []string // checked by the cdnHeader mode
+    depth          int      // X-Forwarded-For entry from the right, 0 to walk the chain
+    denyShort      bool     // depthExceededAction is deny
+    header         string   // read by the customHeader mode
+    withPort       bool     // customHeader values are ip:port
+    repeated       bool     // customHeader hops are separate headers
+    trustedProxies *ipList
+    excludedIPs    *ipList // nil if not set
+    rejectInvalid  bool
+    allTrustedPeer bool // allTrustedFallback is remoteAddr
+    rejectSpoofed  bool
+}
+
+// newClientIPStrategy validates an IPStrategy. excludedIPs is nil if the
+// block has none.
+func newClientIPStrategy(config IPStrategy, trustedProxies, excludedIPs *ipList) (*clientIPStrategy, error) {
+    s := &clientIPStrategy{mode: config.Mode, trustedProxies: trustedProxies, excludedIPs: excludedIPs}
+    switch config.Mode {
+    case "":
+        s.mode = ipStrategyXForwardedFor
+    case ipStrategyXForwardedFor, ipStrategyForwarded, ipStrategyXRealIP, ipStrategyCDNHeader, ipStrategyCustomHeader, ipStrategyRemoteAddr:
+    default:
+        return nil, fmt.Errorf("invalid mode %q: must be %q, %q, %q, %q, %q or %q", config.Mode,
+            ipStrategyXForwardedFor, ipStrategyForwarded, ipStrategyXRealIP, ipStrategyCDNHeader, ipStrategyCustomHeader, ipStrategyRemoteAddr)
+    }
+
+    if len(config.Headers) > 0 && s.mode != ipStrategyCDNHeader {
+        return nil, fmt.Errorf("headers is only used by mode %q", ipStrategyCDNHeader)
+    }
+    if s.mode == ipStrategyCDNHeader {
+        headers := config.Headers
+        if len(headers) == 0 {
+            headers = defaultCDNHeaders
+        }
+        for _, header := range headers {
+            for _, h := range splitListEntry(header) {
+                h = strings.TrimSpace(h)
+                if h == "" || strings.ContainsAny(h, " \t:") {
+                    return nil, fmt.Errorf("invalid header name %q", h)
+                }
+                s.headers = append(s.headers, h)
+            }
+        }
+    }
+    if err := s.compileCustomHeader(config); err != nil {
+        return nil, err
+    }
+    switch config.InvalidHeaderAction {
+    case "", invalidHeaderFallback:
+    case invalidHeaderReject:
+        s.rejectInvalid = true
+    default:
+        return nil, fmt.Errorf("invalid invalidHeaderAction %q: must be %q or %q",
+            config.InvalidHeaderAction, invalidHeaderFallback, invalidHeaderReject)
+    }
+    switch config.AllTrustedFallback {
+    case "", allTrustedLeftmost:
+    case allTrustedRemoteAddr:
+        s.allTrustedPeer = true
+    default:
+        return nil, fmt.Errorf("invalid allTrustedFallback %q: must be %q or %q",
+            config.AllTrustedFallback, allTrustedLeftmost, allTrustedRemoteAddr)
+    }
+    if err := s.compileDepth(config); err != nil {
+        return nil, err
+    }
+    if s.excludedIPs != nil {
+        switch {
+        case s.mode != ipStrategyXForwardedFor && s.mode != ipStrategyForwarded && s.mode != ipStrategyCustomHeader:
+            return nil, fmt.Errorf("excludedIPs is only used by modes %q, %q and %q",
+                ipStrategyXForwardedFor, ipStrategyForwarded, ipStrategyCustomHeader)
+        case s.depth > 0:
+            return nil, fmt.Errorf("excludedIPs cannot be combined with depth")
+        }
+    }
+    if config.RejectSpoofedHeaders {
+        if s.mode == ipStrategyRemoteAddr {
+            return nil, fmt.Errorf("rejectSpoofedHeaders has no effect with mode %q or depth 0", ipStrategyRemoteAddr)
+        }
+        s.rejectSpoofed = true
+    }
+    return s, nil
+}
+
+// compileDepth validates depth and depthExceededAction. Depth 0 turns the
+// strategy into remoteAddr.
+func (s *clientIPStrategy) compileDepth(config IPStrategy) error {
+    if config.Depth == nil {
+        if config.DepthExceededAction != "" {
+            return fmt.Errorf("depthExceededAction requires depth")
+        }
+        return nil
+    }
+    if s.mode != ipStrategyXForwardedFor {
+        return fmt.Errorf("depth is only used by mode %q", ipStrategyXForwardedFor)
+    }
+    switch depth := *config.Depth; {
+    case depth < 0:
+        return fmt.Errorf("invalid depth %d: must not be negative", depth)
+    case depth == 0:
+        s.mode = ipStrategyRemoteAddr
+    default:
+        s.depth = depth
+    }
+    switch config.DepthExceededAction {
+    case "", depthExceededFallback:
+    case depthExceededDeny:
+        s.denyShort = true
+    default:
+        return fmt.Errorf("invalid depthExceededAction %q: must be %q or %q",
+            config.DepthExceededAction, depthExceededFallback, depthExceededDeny)
+    }
+    return nil
+}
+
+// compileCustomHeader validates the header, format and multipleValues
+// fields, which only the customHeader mode uses.
+func (s *clientIPStrategy) compileCustomHeader(config IPStrategy) error {
+    if s.mode != ipStrategyCustomHeader {
+        if config.Header != "" || config.Format != "" || config.MultipleValues != "" {
+            return fmt.Errorf("header, format and multipleValues are only used by mode %q", ipStrategyCustomHeader)
+        }
+        return nil
+    }
+
+    s.header = strings.TrimSpace(config.Header)
+    if s.header == "" {
+        return fmt.Errorf("mode %q requires a header", ipStrategyCustomHeader)
+    }
+    if strings.ContainsAny(s.header, " \t:") {
+        return fmt.Errorf("invalid header name %q", s.header)
+    }
+    switch config.Format {
+    case "", headerFormatIP:
+    case headerFormatIPPort:
+        s.withPort = true
+    default:
+        return fmt.Errorf("invalid format %q: must be %q or %q", config.Format, headerFormatIP, headerFormatIPPort)
+    }
+    switch config.MultipleValues {
+    case "", headerValuesComma:
+    case headerValuesRepeated:
+        s.repeated = true
+    default:
+        return fmt.Errorf("invalid multipleValues %q: must be %q or %q", config.MultipleValues, headerValuesComma, headerValuesRepeated)
+    }
+    return nil
+}
+
+// clientHeaders returns the headers the mode reads the client address from.
+func (s *clientIPStrategy) clientHeaders() []string {
+    switch s.mode {
+    case ipStrategyCDNHeader:
+        return s.headers
+    case ipStrategyCustomHeader:
+        return []string{s.header}
+    case ipStrategyXRealIP:
+        return []string{"X-Real-IP"}
+    case ipStrategyForwarded:
+        return []string{"Forwarded"}
+    case ipStrategyRemoteAddr:
+        return nil
+    default:
+        return []string{"X-Forwarded-For"}
+    }
+}
+
+// clientAddr returns the client address of req, whose socket peer is socket,
+// and a description of where it came from for the decision log. status is
+// the HTTP status to reject the request with, or 0 to accept it: 400 if a
+// trusted proxy sent an invalid header and invalidHeaderAction is reject or
+// an untrusted peer sent a client address header and rejectSpoofedHeaders
+// is set, 403 if the X-Forwarded-For chain is shorter than depth and
+// depthExceededAction is deny. source then holds the reason.
+func (s *clientIPStrategy) clientAddr(req *http.Request, socket netip.Addr) (ip netip.Addr, source string, status int) {
+    if s.mode == ipStrategyRemoteAddr {
+        return socket, "RemoteAddr", 0
+    }
+    if s.trustedProxies.empty() || !s.trustedProxies.contains(socket) {
+        // Headers from anyone but a trusted proxy are never believed.
+        if s.rejectSpoofed {
+            for _, header := range s.clientHeaders() {
+                if _, present := headerValue(req, header); present {
+                    return socket, fmt.Sprintf("spoofed %s from untrusted peer (rejectSpoofedHeaders)", header), http.StatusBadRequest
+                }
+            }
+        }
+        return socket, "RemoteAddr", 0
+    }
+
+    switch s.mode {
+    case ipStrategyCDNHeader:
+        for _, header := range s.headers {
+            value, present := headerValue(req, header)
+            if !present {
+                continue
+            }
+            if ip, valid := parseHostAddr(value); valid {
+                return ip, "from " + header, 0
+            }
+            return s.invalidHeader(socket, fmt.Sprintf("%s %q is not a valid address", header, value))
+        }
+        return socket, fmt.Sprintf("RemoteAddr, trusted proxy sent none of %v", s.headers), 0
+    case ipStrategyCustomHeader:
+        var hops []string
+        if s.repeated {
+            for _, value := range req.Header.Values(s.header) {
+                hops = append(hops, strings.TrimSpace(value))
+            }
+        } else {
+            hops = headerEntries(req, s.header)
+        }
+        if len(hops) == 0 {
+            return socket, "RemoteAddr, trusted proxy sent no " + s.header, 0
+        }
+        parse := parseBareAddr
+        if s.withPort {
+            parse = parseAddrWithPort
+        }
+        return s.selectHop(socket, s.header, hops, parse)
+    case ipStrategyXRealIP:
+        value, present := headerValue(req, "X-Real-IP")
+        if !present {
+            return socket, "RemoteAddr, trusted proxy sent no X-Real-IP", 0
+        }
+        if ip, valid := parseHostAddr(value); valid {
+            return ip, "from X-Real-IP", 0
+        }
+        return s.invalidHeader(socket, fmt.Sprintf("X-Real-IP %q is not a valid address", value))
+    case ipStrategyForwarded:
+        values := req.Header.Values("Forwarded")
+        if len(values) == 0 {
+            return socket, "RemoteAddr, trusted proxy sent no Forwarded", 0
+        }
+        nodes, err := parseForwarded(values)
+        if err != nil {
+            return s.invalidHeader(socket, fmt.Sprintf("malformed Forwarded header %q: %v", strings.Join(values, ", "), err))
+        }
+        return s.selectHop(socket, "Forwarded", nodes, parseForwardedNode)
+    default:
+        entries := xffEntries(req)
+        if s.depth > 0 {
+            return s.hopAtDepth(socket, entries)
+        }
+        if len(entries) == 0 {
+            return socket, "RemoteAddr, trusted proxy sent no X-Forwarded-For", 0
+        }
+        return s.selectHop(socket, "X-Forwarded-For", entries, parseHostAddr)
+    }
+}
+
+// selectHop picks the client address from the hops of a forwarding chain,
+// the original client first. Only the hops appended by trusted proxies can
+// be relied on, since a client can send any header it likes, so the chain
+// is walked from the right: trusted proxies are skipped and the first
+// address that is not trusted is the client. excludedIPs are skipped as
+// well. A hidden hop ("unknown", an obfuscated identifier) ends the walk,
+// since the hops to its left were not appended by a proxy we can vouch
+// for; like any other unusable hop before that point it makes the header
+// invalid.
+func (s *clientIPStrategy) selectHop(socket netip.Addr, header string, hops []string,
+    parse func(string) (netip.Addr, bool)) (netip.Addr, string, int) {
+    var leftmost netip.Addr
+    excluded := false
+    for i := len(hops) - 1; i >= 0; i-- {
+        if isHiddenNode(hops[i]) {
+            return s.invalidHeader(socket, fmt.Sprintf("%s hop %d %q hides the client address", header, i+1, hops[i]))
+        }
+        ip, valid := parse(hops[i])
+        if !valid {
+            return s.invalidHeader(socket, fmt.Sprintf("%s hop %d %q is not a valid address", header, i+1, hops[i]))
+        }
+        if s.excludedIPs != nil && s.excludedIPs.contains(ip) {
+            excluded = true
+            continue
+        }
+        if !s.trustedProxies.contains(ip) {
+            return ip, fmt.Sprintf("from %s hop %d, the rightmost untrusted address", header, i+1), 0
+        }
+        leftmost = ip
+    }
+
+    if excluded {
+        return socket, fmt.Sprintf("RemoteAddr, every %s hop is a trusted proxy or excluded", header), 0
+    }
+    if s.allTrustedPeer || !leftmost.IsValid() {
+        return socket, fmt.Sprintf("RemoteAddr, every %s hop is a trusted proxy", header), 0
+    }
+    return leftmost, fmt.Sprintf("from %s hop 1, every hop is a trusted proxy", header), 0
+}
+
+// hopAtDepth returns the X-Forwarded-For entry depth hops from the right,
+// for setups with a known number of proxies.
+func (s *clientIPStrategy) hopAtDepth(socket netip.Addr, entries []string) (netip.Addr, string, int) {
+    if len(entries) < s.depth {
+        reason := fmt.Sprintf("X-Forwarded-For has %d entries, fewer than depth %d", len(entries), s.depth)
+        if s.denyShort {
+            return socket, reason + " (depthExceededAction=deny)", http.StatusForbidden
+        }
+        return socket, "RemoteAddr, " + reason, 0
+    }
+    i := len(entries) - s.depth
+    ip, valid := parseHostAddr(entries[i])
+    if !valid {
+        return s.invalidHeader(socket, fmt.Sprintf("X-Forwarded-For hop %d %q is not a valid address", i+1, entries[i]))
+    }
+    return ip, fmt.Sprintf("from X-Forwarded-For hop %d, %d from the right", i+1, s.depth), 0
+}
+
+// invalidHeader applies invalidHeaderAction to a header that could not be parsed.
+func (s *clientIPStrategy) invalidHeader(socket netip.Addr, reason string) (netip.Addr, string, int) {
+    if s.rejectInvalid {
+        return socket, "invalid header from trusted proxy: " + reason + " (invalidHeaderAction=reject)", http.StatusBadRequest
+    }
+    return socket, "RemoteAddr, " + reason, 0
+}
+
+// headerValue returns the trimmed value of a single-valued header.
+func headerValue(req *http.Request, name string) (string, bool) {
+    values := req.Header.Values(name)
+    if len(values) == 0 {
+        return "", false
+    }
+    return strings.TrimSpace(values[0]), true
+}
+
+// xffEntries returns the comma-separated entries of all X-Forwarded-For
+// headers in order, the original client first.
+func xffEntries(req *http.Request) []string {
+    return headerEntries(req, "X-Forwarded-For")
+}
+
+// headerEntries returns the non-empty comma-separated entries of all
+// headers called name in order.
+func headerEntries(req *http.Request, name string) []string {
+    var entries []string
+    for _, header := range req.Header.Values(name) {
+        for _, entry := range strings.Split(header, ",") {
+            if entry = strings.TrimSpace(entry); entry != "" {
+                entries = append(entries, entry)
+            }
+        }
+    }
+    return entries
+}
+
+// parseBareAddr parses an address without port; IPv6 may be bracketed.
+func parseBareAddr(s string) (netip.Addr, bool) {
+    if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+        s = s[1 : len(s)-1]
+    }
+    return parseIP(s)
+}
+
+// parseAddrWithPort parses "ip:port", with IPv6 in brackets. The port must
+// be numeric and in the range 1-65535.
+func parseAddrWithPort(s string) (netip.Addr, bool) {
+    addrPort, err := netip.ParseAddrPort(s)
+    if err != nil || addrPort.Port() == 0 {
+        return netip.Addr{}, false
+    }
+    return normalizeAddr(addrPort.Addr()), true
+}
+
+// parseForwarded parses RFC 7239 Forwarded header values and returns the
+// "for" parameter of every element in order, or an empty string for an
+// element without one. Quoted values are unescaped.
+func parseForwarded(values []string) ([]string, error) {
+    var nodes []string
+    for _, value := range values {
+        p := forwardedParser{s: value}
+        for {
+            node, err := p.element()
+            if err != nil {
+                return nil, err
+            }
+            nodes = append(nodes, node)
+            if p.done() {
+                break
+            }
+        }
+    }
+    return nodes, nil
+}
+
+// forwardedParser reads the elements of a single Forwarded header value.
+type forwardedParser struct {
+    s   string
+    pos int
+}
+
+func (p *forwardedParser) done() bool {
+    return p.pos >= len(p.s)
+}
+
+func (p *forwardedParser) skipSpace() {
+    for !p.done() && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+        p.pos++
+    }
+}
+
+// element parses "pair *( ";" pair )" up to and including a trailing comma.
+func (p *forwardedParser) element() (string, error) {
+    var node string
+    for {
+        p.skipSpace()
+        if p.done() {
+            return node, nil
+        }
+        if p.s[p.pos] != ';' && p.s[p.pos] != ',' {
+            key, value, err := p.pair()
+            if err != nil {
+                return "", err
+            }
+            if strings.EqualFold(key, "for") {
+                node = value
+            }
+            p.skipSpace()
+        }
+        if p.done() {
+            return node, nil
+        }
+        switch p.s[p.pos] {
+        case ';':
+            p.pos++
+        case ',':
+            p.pos++
+            return node, nil
+        default:
+            return "", fmt.Errorf("unexpected %q at offset %d", p.s[p.pos], p.pos)
+        }
+    }
+}
+
+// pair parses "token=value", where value is a token or a quoted string.
+func (p *forwardedParser) pair() (string, string, error) {
+    key := p.token()
+    if key == "" || p.done() || p.s[p.pos] != '=' {
+        return "", "", fmt.Errorf("expected parameter at offset %d", p.pos)
+    }
+    p.pos++
+    if p.done() || p.s[p.pos] != '"' {
+        value := p.token()
+        if value == "" {
+            return "", "", fmt.Errorf("empty value for %q", key)
+        }
+        return key, value, nil
+    }
+
+    p.pos++
+    var b strings.Builder
+    for !p.done() {
+        c := p.s[p.pos]
+        p.pos++
+        switch c {
+        case '"':
+            return key, b.String(), nil
+        case '\\':
+            if p.done() {
+                return "", "", fmt.Errorf("unterminated escape in %q", key)
+            }
+            b.WriteByte(p.s[p.pos])
+            p.pos++
+        default:
+            b.WriteByte(c)
+        }
+    }
+    return "", "", fmt.Errorf("unterminated quoted value for %q", key)
+}
+
+// token reads RFC 7230 token characters.
+func (p *forwardedParser) token() string {
+    start := p.pos
+    for !p.done() && isTokenChar(p.s[p.pos]) {
+        p.pos++
+    }
+    return p.s[start:p.pos]
+}
+
+func isTokenChar(c byte) bool {
+    switch {
+    case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+        return true
+    }
+    return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// isHiddenNode reports whether a hop deliberately hides the address: an
+// empty Forwarded element, "unknown" or an obfuscated identifier ("_hidden").
+func isHiddenNode(node string) bool {
+    return node == "" || strings.EqualFold(node, "unknown") || strings.HasPrefix(node, "_")
+}
+
+// parseForwardedNode parses the node of a "for" parameter: an IPv4 address
+// or a bracketed IPv6 address, either with an optional port. "unknown" and
+// obfuscated identifiers ("_hidden") are not usable.
+func parseForwardedNode(node string) (netip.Addr, bool) {
+    if isHiddenNode(node) {
+        return netip.Addr{}, false
+    }
+    host := node
+    if strings.HasPrefix(node, "[") {
+        end := strings.IndexByte(node, ']')
+        if end < 0 {
+            return netip.Addr{}, false
+        }
+        if rest := node[end+1:]; rest != "" && rest[0] != ':' {
+            return netip.Addr{}, false
+        }
+        host = node[1:end]
+    } else if i := strings.IndexByte(node, ':'); i >= 0 {
+        if strings.Count(node, ":") > 1 {
+            // IPv6 must be bracketed.
+            return netip.Addr{}, false
+        }
+        host = node[:i]
+    }
+    return parseIP(host)
+}
@@ -0,0 +1,764 @@
+package DomainSentinel
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "net/netip"
+    "os"
+    "strings"
+    "testing"
+)
+
+// captureOutput returns what f prints to stdout, where the middleware logs
+// its decisions.
+func captureOutput(t *testing.T, f func()) string {
+    t.Helper()
+    r, w, err := os.Pipe()
+    if err != nil {
+        t.Fatal(err)
+    }
+    stdout := os.Stdout
+    os.Stdout = w
+    done := make(chan string)
+    go func() {
+        out, _ := io.ReadAll(r)
+        done <- string(out)
+    }()
+    defer func() { os.Stdout = stdout }()
+    f()
+    w.Close()
+    return <-done
+}
+
+// testStrategy compiles an ipStrategy block the way New does.
+func testStrategy(t *testing.T, config IPStrategy) *clientIPStrategy {
+    t.Helper()
+    c, err := newCompiler(CreateConfig())
+    if err != nil {
+        t.Fatal(err)
+    }
+    s, err := c.compileIPStrategy(config)
+    if err != nil {
+        t.Fatalf("ipStrategy: %v", err)
+    }
+    return s
+}
+
+// clientAddrCase is a request from socket with header name/value pairs,
+// and the client address and status the strategy must derive from it.
+type clientAddrCase struct {
+    name   string
+    socket string
+    header []string
+    want   string
+    status int
+}
+
+func checkClientAddrs(t *testing.T, s *clientIPStrategy, cases []clientAddrCase) {
+    t.Helper()
+    for _, tc := range cases {
+        req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+        for i := 0; i+1 < len(tc.header); i += 2 {
+            req.Header.Add(tc.header[i], tc.header[i+1])
+        }
+        ip, source, status := s.clientAddr(req, netip.MustParseAddr(tc.socket))
+        if status != tc.status || (status == 0 && ip != netip.MustParseAddr(tc.want)) {
+            t.Errorf("%s: clientAddr = %s, %d (%s); want %s, %d", tc.name, ip, status, source, tc.want, tc.status)
+        }
+    }
+}
+
+func TestRequireBothAddresses(t *testing.T) {
+    config := domainConfig("example.com", DomainConfig{
+        SourceIPs:            ips("10.0.0.1", "192.0.2.0/24", "7.7.7.7"),
+        RequireBothAddresses: boolFlag(true),
+    })
+    config.IPStrategy.TrustedProxies = []string{"10.0.0.0/24"}
+    handler := newTestSentinel(t, config)
+    tests := []struct {
+        name, remoteAddr, xff string
+        want                  int
+    }{
+        {"both allowed", "10.0.0.1:1234", "192.0.2.5", http.StatusOK},
+        {"forwarded denied", "10.0.0.1:1234", "198.51.100.1", http.StatusForbidden},
+        {"socket denied", "10.0.0.2:1234", "192.0.2.5", http.StatusForbidden},
+        {"trusted proxy, no header", "10.0.0.1:1234", "", http.StatusOK},
+        // The header of an untrusted peer is not checked at all.
+        {"untrusted peer, forged header", "7.7.7.7:1234", "198.51.100.1", http.StatusOK},
+        {"untrusted peer, allowed header", "198.51.100.1:1234", "192.0.2.5", http.StatusForbidden},
+    }
+    for _, tt := range tests {
+        var header []string
+        if tt.xff != "" {
+            header = []string{"X-Forwarded-For", tt.xff}
+        }
+        if got := serve(handler, "http://example.com/", tt.remoteAddr, header...).Code; got != tt.want {
+            t.Errorf("%s: status %d, want %d", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestRequireBothAddressesLogsRejectedAddress(t *testing.T) {
+    config := domainConfig("example.com", DomainConfig{
+        SourceIPs:            ips("10.0.0.1", "192.0.2.0/24"),
+        RequireBothAddresses: boolFlag(true),
+    })
+    config.IPStrategy.TrustedProxies = []string{"10.0.0.0/24"}
+    handler := newTestSentinel(t, config)
+    out := captureOutput(t, func() { serve(handler, "http://example.com/", "10.0.0.1:1234", "X-Forwarded-For", "198.51.100.1") })
+    if !strings.Contains(out, "forwarded address 198.51.100.1 is not allowed") {
+        t.Errorf("log does not name the rejected forwarded address:\n%s", out)
+    }
+    out = captureOutput(t, func() { serve(handler, "http://example.com/", "10.0.0.2:1234", "X-Forwarded-For", "192.0.2.5") })
+    if !strings.Contains(out, "socket address 10.0.0.2 is not allowed") {
+        t.Errorf("log does not name the rejected socket address:\n%s", out)
+    }
+}
+
+func TestRequireBothAddressesAutoBan(t *testing.T) {
+    newHandler := func() http.Handler {
+        config := domainConfig("example.com", DomainConfig{
+            SourceIPs:            ips("10.0.0.1", "192.0.2.0/24", "7.7.7.7"),
+            RequireBothAddresses: boolFlag(true),
+            AutoBan:              &AutoBanConfig{MaxDenials: 2},
+        })
+        config.IPStrategy.TrustedProxies = []string{"10.0.0.0/24"}
+        return newTestSentinel(t, config)
+    }
+
+    t.Run("untrusted peer", func(t *testing.T) {
+        // A forged header from an allowlisted peer neither bans the forged
+        // address nor gets the peer rejected.
+        handler := newHandler()
+        out := captureOutput(t, func() {
+            for i := 0; i < 5; i++ {
+                if got := serve(handler, "http://example.com/", "7.7.7.7:1234", "X-Forwarded-For", "8.8.4.4").Code; got != http.StatusOK {
+                    t.Errorf("request %d: status %d, want 200", i, got)
+                }
+            }
+        })
+        if strings.Contains(out, "Auto-banned") || strings.Contains(out, "Rejecting banned") {
+            t.Errorf("forged header led to a ban:\n%s", out)
+        }
+    })
+
+    t.Run("trusted proxy", func(t *testing.T) {
+        // The forwarded client is banned, the proxy and its other clients
+        // are not.
+        handler := newHandler()
+        for i := 0; i < 2; i++ {
+            serve(handler, "http://example.com/", "10.0.0.1:1234", "X-Forwarded-For", "198.51.100.1")
+        }
+        out := captureOutput(t, func() {
+            if got := serve(handler, "http://example.com/", "10.0.0.1:1234", "X-Forwarded-For", "198.51.100.1").Code; got != http.StatusForbidden {
+                t.Errorf("banned forwarded client: status %d, want 403", got)
+            }
+        })
+        if !strings.Contains(out, "Rejecting banned forwarded address 198.51.100.1") {
+            t.Errorf("ban log does not name the banned forwarded address:\n%s", out)
+        }
+        if got := serve(handler, "http://example.com/", "10.0.0.1:1234", "X-Forwarded-For", "192.0.2.5").Code; got != http.StatusOK {
+            t.Errorf("other client of the proxy: status %d, want 200", got)
+        }
+    })
+
+    t.Run("socket banned", func(t *testing.T) {
+        handler := newHandler()
+        for i := 0; i < 2; i++ {
+            serve(handler, "http://example.com/", "10.0.0.9:1234", "X-Forwarded-For", "192.0.2.5")
+        }
+        out := captureOutput(t, func() {
+            serve(handler, "http://example.com/", "10.0.0.9:1234", "X-Forwarded-For", "192.0.2.5")
+        })
+        if !strings.Contains(out, "Rejecting banned socket address 10.0.0.9") {
+            t.Errorf("ban log does not name the banned socket address:\n%s", out)
+        }
+    })
+}
+
+func TestXRealIPStrategy(t *testing.T) {
+    config := IPStrategy{Mode: ipStrategyXRealIP, TrustedProxies: []string{"10.0.0.0/24"}}
+    checkClientAddrs(t, testStrategy(t, config), []clientAddrCase{
+        {"trusted proxy", "10.0.0.1", []string{"X-Real-IP", "192.0.2.5"}, "192.0.2.5", 0},
+        {"trusted proxy, with port", "10.0.0.1", []string{"X-Real-IP", "[2001:db8::5]:443"}, "2001:db8::5", 0},
+        {"trusted proxy, no header", "10.0.0.1", nil, "10.0.0.1", 0},
+        {"trusted proxy, invalid header", "10.0.0.1", []string{"X-Real-IP", "unknown"}, "10.0.0.1", 0},
+        {"forged from untrusted peer", "198.51.100.1", []string{"X-Real-IP", "192.0.2.5"}, "198.51.100.1", 0},
+        // Only X-Real-IP is read in this mode.
+        {"X-Forwarded-For ignored", "10.0.0.1", []string{"X-Forwarded-For", "192.0.2.5"}, "10.0.0.1", 0},
+    })
+
+    config.InvalidHeaderAction = invalidHeaderReject
+    checkClientAddrs(t, testStrategy(t, config), []clientAddrCase{
+        {"invalid header rejected", "10.0.0.1", []string{"X-Real-IP", "unknown"}, "", http.StatusBadRequest},
+        {"invalid header from untrusted peer", "198.51.100.1", []string{"X-Real-IP", "unknown"}, "198.51.100.1", 0},
+    })
+
+    config.RejectSpoofedHeaders = true
+    checkClientAddrs(t, testStrategy(t, config), []clientAddrCase{
+        {"forged header rejected", "198.51.100.1", []string{"X-Real-IP", "192.0.2.5"}, "", http.StatusBadRequest},
+        {"trusted proxy", "10.0.0.1", []string{"X-Real-IP", "192.0.2.5"}, "192.0.2.5", 0},
+    })
+
+    // A forged header does not get an untrusted peer past the allow list.
+    rules := domainConfig("example.com", DomainConfig{SourceIPs: ips("192.0.2.0/24")})
+    rules.IPStrategy = IPStrategy{Mode: ipStrategyXRealIP, TrustedProxies: []string{"10.0.0.0/24"}}
+    handler := newTestSentinel(t, rules)
+    if got := serve(handler, "http://example.com/", "198.51.100.1:1234", "X-Real-IP", "192.0.2.5").Code; got != http.StatusForbidden {
+        t.Errorf("forged X-Real-IP: status %d, want 403", got)
+    }
+    if got := serve(handler, "http://example.com/", "10.0.0.1:1234", "X-Real-IP", "192.0.2.5").Code; got != http.StatusOK {
+        t.Errorf("X-Real-IP from trusted proxy: status %d, want 200", got)
+    }
+}
+
+func TestCDNHeaderStrategy(t *testing.T) {
+    config := IPStrategy{Mode: ipStrategyCDNHeader, TrustedProxies: []string{"173.245.48.0/20", "2400:cb00::/32"}}
+    checkClientAddrs(t, testStrategy(t, config), []clientAddrCase{
+        {"CF-Connecting-IP", "173.245.48.1", []string{"CF-Connecting-IP", "192.0.2.5"}, "192.0.2.5", 0},
+        {"True-Client-IP alias", "173.245.48.1", []string{"True-Client-IP", "192.0.2.6"}, "192.0.2.6", 0},
+        {"CF-Connecting-IP first", "2400:cb00::1", []string{"True-Client-IP", "192.0.2.6", "CF-Connecting-IP", "2001:db8::5"}, "2001:db8::5", 0},
+        {"edge without header", "173.245.48.1", nil, "173.245.48.1", 0},
+        // The spoofing case: the header arrives from outside the CDN ranges.
+        {"spoofed from outside the CDN", "198.51.100.1", []string{"CF-Connecting-IP", "192.0.2.5"}, "198.51.100.1", 0},
+        {"spoofed alias from outside the CDN", "198.51.100.1", []string{"True-Client-IP", "192.0.2.5"}, "198.51.100.1", 0},
+        {"X-Forwarded-For ignored", "173.245.48.1", []string{"X-Forwarded-For", "192.0.2.5"}, "173.245.48.1", 0},
+    })
+
+    config.Headers = []string{"Fastly-Client-IP", "X-Akamai-Client"}
+    checkClientAddrs(t, testStrategy(t, config), []clientAddrCase{
+        {"custom header", "173.245.48.1", []string{"X-Akamai-Client", "192.0.2.7"}, "192.0.2.7", 0},
+        {"CF-Connecting-IP no longer read", "173.245.48.1", []string{"CF-Connecting-IP", "192.0.2.5"}, "173.245.48.1", 0},
+        {"custom header spoofed", "198.51.100.1", []string{"Fastly-Client-IP", "192.0.2.5"}, "198.51.100.1", 0},
+    })
+
+    // The cloudflare platform brings the published edge ranges.
+    checkClientAddrs(t, testStrategy(t, IPStrategy{Platform: "cloudflare"}), []clientAddrCase{
+        {"cloudflare edge", "104.16.0.1", []string{"CF-Connecting-IP", "192.0.2.5"}, "192.0.2.5", 0},
+        {"cloudflare spoofed", "198.51.100.1", []string{"CF-Connecting-IP", "192.0.2.5"}, "198.51.100.1", 0},
+    })
+
+    // A spoofed header does not get a client past the allow list.
+    rules := domainConfig("example.com", DomainConfig{SourceIPs: ips("192.0.2.0/24")})
+    rules.IPStrategy = IPStrategy{Platform: "cloudflare"}
+    handler := newTestSentinel(t, rules)
+    if got := serve(handler, "http://example.com/", "198.51.100.1:1234", "CF-Connecting-IP", "192.0.2.5").Code; got != http.StatusForbidden {
+        t.Errorf("spoofed CF-Connecting-IP: status %d, want 403", got)
+    }
+    if got := serve(handler, "http://example.com/", "104.16.0.1:1234", "CF-Connecting-IP", "192.0.2.5").Code; got != http.StatusOK {
+        t.Errorf("CF-Connecting-IP from the edge: status %d, want 200", got)
+    }
+}
+
+func TestCDNHeaderErrors(t *testing.T) {
+    for _, config := range []IPStrategy{
+        {Mode: ipStrategyCDNHeader, Headers: []string{"Bad Header"}},
+        {Mode: ipStrategyCDNHeader, Headers: []string{"X-Client:1"}},
+        {Mode: ipStrategyXForwardedFor, Headers: []string{"CF-Connecting-IP"}},
+        {Platform: "akamai"},
+    } {
+        c, err := newCompiler(CreateConfig())
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err := c.compileIPStrategy(config); err == nil {
+            t.Errorf("%+v: compiled without an error", config)
+        }
+    }
+}
+
+func TestPlatformPresets(t *testing.T) {
+    checkClientAddrs(t, testStrategy(t, IPStrategy{Platform: "fastly"}), []clientAddrCase{
+        {"fastly edge", "151.101.1.1", []string{"Fastly-Client-IP", "192.0.2.5"}, "192.0.2.5", 0},
+        {"fastly spoofed", "198.51.100.1", []string{"Fastly-Client-IP", "192.0.2.5"}, "198.51.100.1", 0},
+        {"fastly ignores CF-Connecting-IP", "151.101.1.1", []string{"CF-Connecting-IP", "192.0.2.5"}, "151.101.1.1", 0},
+    })
+    checkClientAddrs(t, testStrategy(t, IPStrategy{Platform: "flyio", TrustedProxies: []string{"fdaa::/16"}}), []clientAddrCase{
+        {"fly proxy", "fdaa::3", []string{"Fly-Client-IP", "192.0.2.5"}, "192.0.2.5", 0},
+        {"fly spoofed", "198.51.100.1", []string{"Fly-Client-IP", "192.0.2.5"}, "198.51.100.1", 0},
+    })
+    xff := func(hops ...string) []string { return []string{"X-Forwarded-For", strings.Join(hops, ", ")} }
+    cloudfront := IPStrategy{Platform: "cloudfront", TrustedProxies: []string{"10.0.0.0/24"}}
+    checkClientAddrs(t, testStrategy(t, cloudfront), []clientAddrCase{
+        {"cloudfront viewer", "10.0.0.1", xff("203.0.113.7", "192.0.2.5"), "192.0.2.5", 0},
+        {"cloudfront spoofed", "198.51.100.1", xff("192.0.2.5"), "198.51.100.1", 0},
+        {"cloudfront without header", "10.0.0.1", nil, "10.0.0.1", 0},
+    })
+    // Explicit trustedProxies replace the baked-in ranges.
+    checkClientAddrs(t, testStrategy(t, IPStrategy{Platform: "cloudflare", TrustedProxies: []string{"10.0.0.0/24"}}), []clientAddrCase{
+        {"overridden ranges", "10.0.0.1", []string{"CF-Connecting-IP", "192.0.2.5"}, "192.0.2.5", 0},
+        {"baked-in range dropped", "104.16.0.1", []string{"CF-Connecting-IP", "192.0.2.5"}, "104.16.0.1", 0},
+    })
+
+    // A preset selected for one domain leaves the others alone.
+    config := domainConfig("example.com", DomainConfig{SourceIPs: ips("192.0.2.0/24")})
+    config.DomainPathRules["cdn.example.com"] = DomainConfig{SourceIPs: ips("192.0.2.0/24"), IPStrategy: &IPStrategy{Platform: "fastly"}}
+    handler := newTestSentinel(t, config)
+    checkStatuses(t, handler, []statusCase{
+        {"http://cdn.example.com/", "151.101.1.1:1234", http.StatusForbidden},
+        {"http://example.com/", "192.0.2.5:1234", http.StatusOK},
+    })
+    if got := serve(handler, "http://cdn.example.com/", "151.101.1.1:1234", "Fastly-Client-IP", "192.0.2.5").Code; got != http.StatusOK {
+        t.Errorf("Fastly-Client-IP from the edge: status %d, want 200", got)
+    }
+    if got := serve(handler, "http://example.com/", "151.101.1.1:1234", "Fastly-Client-IP", "192.0.2.5").Code; got != http.StatusForbidden {
+        t.Errorf("Fastly-Client-IP on a domain without the preset: status %d, want 403", got)
+    }
+
+    config = domainConfig("example.com", DomainConfig{SourceIPs: ips("192.0.2.0/24")})
+    config.IPStrategy = IPStrategy{Platform: "akamai"}
+    _, err := New(context.Background(), okHandler, config, "test")
+    if err == nil || !strings.Contains(err.Error(), "cloudflare, cloudfront, fastly, flyio") {
+        t.Errorf("unknown platform: got %v, want the valid values listed", err)
+    }
+    for _, config := range []IPStrategy{{Platform: "flyio"}, {Platform: "cloudflare", Mode: ipStrategyXRealIP}} {
+        c, err := newCompiler(CreateConfig())
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err := c.compileIPStrategy(config); err == nil {
+            t.Errorf("%+v: compiled without an error", config)
+        }
+    }
+}
+
+func TestCustomHeaderStrategy(t *testing.T) {
+    config := IPStrategy{Mode: ipStrategyCustomHeader, Header: "X-Client-Address", Format: headerFormatIPPort, TrustedProxies: []string{"10.0.0.0/24"}}
+    checkClientAddrs(t, testStrategy(t, config), []clientAddrCase{
+        {"ip:port", "10.0.0.1", []string{"X-Client-Address", "192.0.2.5:50123"}, "192.0.2.5", 0},
+        {"bracketed IPv6", "10.0.0.1", []string{"X-Client-Address", "[2001:db8::5]:443"}, "2001:db8::5", 0},
+        {"comma-joined hops", "10.0.0.1", []string{"X-Client-Address", "192.0.2.5:1, 10.0.0.2:2"}, "192.0.2.5", 0},
+        {"port out of range", "10.0.0.1", []string{"X-Client-Address", "192.0.2.5:65536"}, "10.0.0.1", 0},
+        {"port zero", "10.0.0.1", []string{"X-Client-Address", "192.0.2.5:0"}, "10.0.0.1", 0},
+        {"missing port", "10.0.0.1", []string{"X-Client-Address", "192.0.2.5"}, "10.0.0.1", 0},
+        {"no header", "10.0.0.1", nil, "10.0.0.1", 0},
+        {"forged from untrusted peer", "198.51.100.1", []string{"X-Client-Address", "192.0.2.5:1"}, "198.51.100.1", 0},
+    })
+
+    config = IPStrategy{Mode: ipStrategyCustomHeader, Header: "X-Client-Address", MultipleValues: headerValuesRepeated,
+        TrustedProxies: []string{"10.0.0.0/24"}, InvalidHeaderAction: invalidHeaderReject}
+    checkClientAddrs(t, testStrategy(t, config), []clientAddrCase{
+        {"repeated headers", "10.0.0.1", []string{"X-Client-Address", "192.0.2.5", "X-Client-Address", "10.0.0.2"}, "192.0.2.5", 0},
+        {"bracketed bare IPv6", "10.0.0.1", []string{"X-Client-Address", "[2001:db8::5]"}, "2001:db8::5", 0},
+        {"comma in a repeated value", "10.0.0.1", []string{"X-Client-Address", "192.0.2.5, 10.0.0.2"}, "", http.StatusBadRequest},
+        {"port in ip format", "10.0.0.1", []string{"X-Client-Address", "192.0.2.5:1"}, "", http.StatusBadRequest},
+        {"invalid header from untrusted peer", "198.51.100.1", []string{"X-Client-Address", "garbage"}, "198.51.100.1", 0},
+    })
+
+    // The header feeds the same decision as every other strategy.
+    rules := domainConfig("example.com", DomainConfig{SourceIPs: ips("192.0.2.0/24")})
+    rules.IPStrategy = IPStrategy{Mode: ipStrategyCustomHeader, Header: "X-Client-Address", Format: headerFormatIPPort, TrustedProxies: []string{"10.0.0.0/24"}}
+    handler := newTestSentinel(t, rules)
+    if got := serve(handler, "http://example.com/", "10.0.0.1:1234", "X-Client-Address", "192.0.2.5:50123").Code; got != http.StatusOK {
+        t.Errorf("X-Client-Address from trusted proxy: status %d, want 200", got)
+    }
+    if got := serve(handler, "http://example.com/", "198.51.100.1:1234", "X-Client-Address", "192.0.2.5:50123").Code; got != http.StatusForbidden {
+        t.Errorf("forged X-Client-Address: status %d, want 403", got)
+    }
+
+    for _, config := range []IPStrategy{
+        {Mode: ipStrategyCustomHeader},
+        {Mode: ipStrategyCustomHeader, Header: "X Client"},
+        {Mode: ipStrategyCustomHeader, Header: "X-Client", Format: "port"},
+        {Mode: ipStrategyCustomHeader, Header: "X-Client", MultipleValues: "joined"},
+        {Mode: ipStrategyXRealIP, Header: "X-Client"},
+    } {
+        c, err := newCompiler(CreateConfig())
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err := c.compileIPStrategy(config); err == nil {
+            t.Errorf("%+v: compiled without an error", config)
+        }
+    }
+}
+
+func TestDepthStrategy(t *testing.T) {
+    xff := func(hops ...string) []string { return []string{"X-Forwarded-For", strings.Join(hops, ", ")} }
+    depth := 2
+    config := IPStrategy{TrustedProxies: []string{"10.0.0.0/24"}, Depth: &depth}
+    checkClientAddrs(t, testStrategy(t, config), []clientAddrCase{
+        {"second from the right", "10.0.0.1", xff("203.0.113.1", "192.0.2.5", "10.0.0.2"), "192.0.2.5", 0},
+        {"exactly depth entries", "10.0.0.1", xff("192.0.2.5", "10.0.0.2"), "192.0.2.5", 0},
+        {"fewer entries than depth", "10.0.0.1", xff("192.0.2.5"), "10.0.0.1", 0},
+        {"no header", "10.0.0.1", nil, "10.0.0.1", 0},
+        {"invalid entry at depth", "10.0.0.1", xff("garbage", "10.0.0.2"), "10.0.0.1", 0},
+        {"forged from untrusted peer", "198.51.100.1", xff("192.0.2.5", "10.0.0.2"), "198.51.100.1", 0},
+    })
+
+    config.DepthExceededAction = depthExceededDeny
+    checkClientAddrs(t, testStrategy(t, config), []clientAddrCase{
+        {"fewer entries than depth denied", "10.0.0.1", xff("192.0.2.5"), "", http.StatusForbidden},
+        {"no header denied", "10.0.0.1", nil, "", http.StatusForbidden},
+        {"enough entries", "10.0.0.1", xff("192.0.2.5", "10.0.0.2"), "192.0.2.5", 0},
+        // The chain length is only checked for headers a trusted proxy sent.
+        {"short chain from untrusted peer", "198.51.100.1", xff("192.0.2.5"), "198.51.100.1", 0},
+    })
+
+    zero := 0
+    checkClientAddrs(t, testStrategy(t, IPStrategy{TrustedProxies: []string{"10.0.0.0/24"}, Depth: &zero}), []clientAddrCase{
+        {"depth 0 uses RemoteAddr", "10.0.0.1", xff("192.0.2.5", "10.0.0.2"), "10.0.0.1", 0},
+    })
+
+    rules := domainConfig("example.com", DomainConfig{SourceIPs: ips("192.0.2.0/24", "10.0.0.0/24")})
+    rules.IPStrategy = config
+    handler := newTestSentinel(t, rules)
+    checkStatuses(t, handler, []statusCase{
+        {"http://example.com/", "10.0.0.1:1234", http.StatusForbidden}, // no X-Forwarded-For, depthExceededAction=deny
+    })
+    if got := serve(handler, "http://example.com/", "10.0.0.1:1234", "X-Forwarded-For", "203.0.113.1, 10.0.0.2").Code; got != http.StatusForbidden {
+        t.Errorf("client at depth not allowed: status %d, want 403", got)
+    }
+    if got := serve(handler, "http://example.com/", "10.0.0.1:1234", "X-Forwarded-For", "192.0.2.5, 10.0.0.2").Code; got != http.StatusOK {
+        t.Errorf("client at depth allowed: status %d, want 200", got)
+    }
+
+    negative := -1
+    for _, config := range []IPStrategy{
+        {Depth: &negative},
+        {Depth: &depth, Mode: ipStrategyXRealIP},
+        {DepthExceededAction: depthExceededDeny},
+        {Depth: &depth, DepthExceededAction: "drop"},
+    } {
+        c, err := newCompiler(CreateConfig())
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err := c.compileIPStrategy(config); err == nil {
+            t.Errorf("%+v: compiled without an error", config)
+        }
+    }
+}
+
+func TestRightmostUntrusted(t *testing.T) {
+    config := IPStrategy{TrustedProxies: []string{"10.0.0.0/24", "2001:db8:ffff::/48"}}
+    s := testStrategy(t, config)
+    const client = "192.0.2.5"
+    xff := func(hops ...string) []string { return []string{"X-Forwarded-For", strings.Join(hops, ", ")} }
+    var cases []clientAddrCase
+    // Chains of 0 to 4 proxies between the client and the socket peer,
+    // each also with entries an attacker put in front.
+    proxies := []string{"10.0.0.2", "2001:db8:ffff::3", "10.0.0.4", "10.0.0.5"}
+    for n := 0; n <= len(proxies); n++ {
+        chain := append([]string{client}, proxies[:n]...)
+        cases = append(cases,
+            clientAddrCase{fmt.Sprintf("%d proxies", n), "10.0.0.1", xff(chain...), client, 0},
+            clientAddrCase{fmt.Sprintf("%d proxies, forged hops", n), "10.0.0.1", xff(append([]string{"10.0.0.9", "1.1.1.1"}, chain...)...), client, 0},
+            clientAddrCase{fmt.Sprintf("%d proxies, garbage prepended", n), "10.0.0.1", xff(append([]string{"garbage", "<script>"}, chain...)...), client, 0},
+        )
+    }
+    cases = append(cases,
+        clientAddrCase{"no header", "10.0.0.1", nil, "10.0.0.1", 0},
+        clientAddrCase{"empty header", "10.0.0.1", []string{"X-Forwarded-For", ""}, "10.0.0.1", 0},
+        clientAddrCase{"whitespace", "10.0.0.1", []string{"X-Forwarded-For", "  192.0.2.5 ,10.0.0.2  "}, client, 0},
+        clientAddrCase{"repeated headers", "10.0.0.1", []string{"X-Forwarded-For", "1.1.1.1, 192.0.2.5", "X-Forwarded-For", "10.0.0.2"}, client, 0},
+        clientAddrCase{"forged trusted address in front", "10.0.0.1", xff("10.0.0.7", client), client, 0},
+        clientAddrCase{"untrusted peer", "198.51.100.1", xff(client), "198.51.100.1", 0},
+        // Garbage after the client, where only trusted proxies write, makes
+        // the header invalid.
+        clientAddrCase{"garbage among the proxies", "10.0.0.1", xff(client, "garbage", "10.0.0.2"), "10.0.0.1", 0},
+        clientAddrCase{"every hop trusted", "10.0.0.1", xff("10.0.0.3", "10.0.0.2"), "10.0.0.3", 0},
+    )
+    checkClientAddrs(t, s, cases)
+
+    config.AllTrustedFallback = allTrustedRemoteAddr
+    config.InvalidHeaderAction = invalidHeaderReject
+    checkClientAddrs(t, testStrategy(t, config), []clientAddrCase{
+        {"every hop trusted, remoteAddr", "10.0.0.1", xff("10.0.0.3", "10.0.0.2"), "10.0.0.1", 0},
+        {"prepended garbage ignored", "10.0.0.1", xff("garbage", client, "10.0.0.2"), client, 0},
+        {"garbage among the proxies rejected", "10.0.0.1", xff(client, "garbage", "10.0.0.2"), "", http.StatusBadRequest},
+    })
+}
+
+func TestClientIPHeader(t *testing.T) {
+    // The backend echoes the headers it got.
+    echo := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+        rw.Header().Set("Got-Client-IP", req.Header.Get(defaultClientIPHeader))
+        rw.Header().Set("Got-Custom", req.Header.Get("X-Custom-Client"))
+    })
+    newHandler := func(config *Config) http.Handler {
+        handler, err := New(context.Background(), echo, config, "test")
+        if err != nil {
+            t.Fatal(err)
+        }
+        return handler
+    }
+    config := domainConfig("example.com", DomainConfig{SourceIPs: ips("192.0.2.0/24")})
+    config.DomainPathRules["custom.example.com"] = DomainConfig{
+        SourceIPs:      ips("192.0.2.0/24"),
+        ClientIPHeader: &ClientIPHeader{Enabled: true, Name: "X-Custom-Client"},
+    }
+    // Requests passed on without an evaluation lose the domain's header too.
+    config.DomainPathRules["disabled.example.com"] = DomainConfig{
+        SourceIPs:      ips("192.0.2.0/24"),
+        Enabled:        boolFlag(false),
+        ClientIPHeader: &ClientIPHeader{Enabled: true, Name: "X-Custom-Client"},
+    }
+    config.DomainPathRules["tls.example.com"] = DomainConfig{
+        SourceIPs:      ips("192.0.2.0/24"),
+        Schemes:        []string{"https"},
+        ClientIPHeader: &ClientIPHeader{Enabled: true, Name: "X-Custom-Client"},
+    }
+    config.IPStrategy.TrustedProxies = []string{"10.0.0.0/24"}
+    config.ClientIPHeader = ClientIPHeader{Enabled: true}
+    config.OnAddressError = addressErrorAllow
+    handler := newHandler(config)
+
+    tests := []struct {
+        name, target, remoteAddr string
+        header                   []string
+        wantHeader               string
+        want                     string
+    }{
+        {"evaluated address", "http://example.com/", "192.0.2.5:1234", nil, "Got-Client-IP", "192.0.2.5"},
+        {"forwarded address", "http://example.com/", "10.0.0.1:1234", []string{"X-Forwarded-For", "192.0.2.6"}, "Got-Client-IP", "192.0.2.6"},
+        {"pre-filled header overwritten", "http://example.com/", "192.0.2.5:1234", []string{defaultClientIPHeader, "1.1.1.1"}, "Got-Client-IP", "192.0.2.5"},
+        {"per-domain name", "http://custom.example.com/", "192.0.2.5:1234", []string{defaultClientIPHeader, "1.1.1.1"}, "Got-Custom", "192.0.2.5"},
+        {"unconfigured domain", "http://other.example.org/", "192.0.2.5:1234", []string{defaultClientIPHeader, "1.1.1.1"}, "Got-Client-IP", ""},
+        {"address error allowed", "http://example.com/", "garbage", []string{defaultClientIPHeader, "1.1.1.1"}, "Got-Client-IP", ""},
+        {"address error allowed, per-domain name", "http://custom.example.com/", "garbage", []string{"X-Custom-Client", "1.1.1.1"}, "Got-Custom", ""},
+        {"disabled rule, per-domain name", "http://disabled.example.com/", "192.0.2.5:1234", []string{"X-Custom-Client", "1.1.1.1"}, "Got-Custom", ""},
+        {"skipped scheme, per-domain name", "http://tls.example.com/", "192.0.2.5:1234", []string{"X-Custom-Client", "1.1.1.1"}, "Got-Custom", ""},
+    }
+    for _, tt := range tests {
+        if got := serve(handler, tt.target, tt.remoteAddr, tt.header...).Header().Get(tt.wantHeader); got != tt.want {
+            t.Errorf("%s: backend got %q, want %q", tt.name, got, tt.want)
+        }
+    }
+
+    // With the option off, an incoming header is passed on untouched.
+    config.ClientIPHeader = ClientIPHeader{}
+    delete(config.DomainPathRules, "custom.example.com")
+    rw := serve(newHandler(config), "http://example.com/", "192.0.2.5:1234", defaultClientIPHeader, "1.1.1.1")
+    if got := rw.Header().Get("Got-Client-IP"); got != "1.1.1.1" {
+        t.Errorf("option off: backend got %q, want the incoming header", got)
+    }
+}
+
+func TestExcludedIPs(t *testing.T) {
+    config := IPStrategy{
+        TrustedProxies: []string{"10.0.0.0/24"},
+        ExcludedIPs:    []string{"172.16.0.0/12", "2001:db8:cd::/48"},
+    }
+    xff := func(hops ...string) []string { return []string{"X-Forwarded-For", strings.Join(hops, ", ")} }
+    checkClientAddrs(t, testStrategy(t, config), []clientAddrCase{
+        {"no excluded hop", "10.0.0.1", xff("192.0.2.5"), "192.0.2.5", 0},
+        {"one excluded hop", "10.0.0.1", xff("192.0.2.5", "172.16.0.1"), "192.0.2.5", 0},
+        {"varying chain", "10.0.0.1", xff("192.0.2.5", "2001:db8:cd::1", "172.16.0.1", "10.0.0.2"), "192.0.2.5", 0},
+        // Every hop excluded: the socket address decides.
+        {"chain excluded", "10.0.0.1", xff("172.16.0.2", "172.16.0.1"), "10.0.0.1", 0},
+        {"chain excluded and trusted", "10.0.0.1", xff("172.16.0.2", "10.0.0.2"), "10.0.0.1", 0},
+        // Malformed entries left of the client are never looked at, those
+        // between it and the socket make the header invalid.
+        {"malformed entry in front", "10.0.0.1", xff("garbage", "192.0.2.5", "172.16.0.1"), "192.0.2.5", 0},
+        {"malformed entry among excluded", "10.0.0.1", xff("192.0.2.5", "172.16.0.999", "172.16.0.1"), "10.0.0.1", 0},
+        // Excluded addresses are skipped, not trusted to send headers.
+        {"excluded peer", "172.16.0.1", xff("192.0.2.5"), "172.16.0.1", 0},
+    })
+    config.InvalidHeaderAction = invalidHeaderReject
+    checkClientAddrs(t, testStrategy(t, config), []clientAddrCase{
+        {"malformed entry rejected", "10.0.0.1", xff("192.0.2.5", "not-an-ip", "172.16.0.1"), "", http.StatusBadRequest},
+    })
+
+    // A domain's own strategy brings its own exclusions.
+    rules := domainConfig("example.com", DomainConfig{SourceIPs: ips("192.0.2.0/24")})
+    rules.DomainPathRules["cdn.example.com"] = DomainConfig{
+        SourceIPs: ips("192.0.2.0/24"),
+        IPStrategy: &IPStrategy{
+            TrustedProxies: []string{"10.0.0.0/24"},
+            ExcludedIPs:    []string{"172.16.0.0/12"},
+        },
+    }
+    rules.IPStrategy.TrustedProxies = []string{"10.0.0.0/24"}
+    handler := newTestSentinel(t, rules)
+    for _, tc := range []struct {
+        target string
+        want   int
+    }{
+        {"http://example.com/", http.StatusForbidden}, // 172.16.0.1 is the client
+        {"http://cdn.example.com/", http.StatusOK},
+    } {
+        if got := serve(handler, tc.target, "10.0.0.1:1234", "X-Forwarded-For", "192.0.2.5, 172.16.0.1").Code; got != tc.want {
+            t.Errorf("GET %s: status %d, want %d", tc.target, got, tc.want)
+        }
+    }
+}
+
+func TestExcludedIPsErrors(t *testing.T) {
+    depth := 1
+    for _, config := range []IPStrategy{
+        {Mode: ipStrategyXRealIP, ExcludedIPs: []string{"172.16.0.0/12"}},
+        {Depth: &depth, ExcludedIPs: []string{"172.16.0.0/12"}},
+        {ExcludedIPs: []string{"172.16.0.0/33"}},
+    } {
+        c, err := newCompiler(CreateConfig())
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err := c.compileIPStrategy(config); err == nil {
+            t.Errorf("%+v: compiled without an error", config)
+        }
+    }
+}
+
+func TestParseForwarded(t *testing.T) {
+    tests := []struct {
+        values []string
+        want   []string // nil if the header is malformed
+    }{
+        {[]string{"for=192.0.2.60"}, []string{"192.0.2.60"}},
+        {[]string{"for=192.0.2.60;proto=http;by=203.0.113.43"}, []string{"192.0.2.60"}},
+        {[]string{"proto=https; FOR=192.0.2.60"}, []string{"192.0.2.60"}},
+        {[]string{"for=192.0.2.43, for=198.51.100.17"}, []string{"192.0.2.43", "198.51.100.17"}},
+        {[]string{"for=192.0.2.43", "for=198.51.100.17"}, []string{"192.0.2.43", "198.51.100.17"}},
+        {[]string{`for="192.0.2.60:4711"`}, []string{"192.0.2.60:4711"}},
+        {[]string{`for="[2001:db8:cafe::17]:4711"`}, []string{"[2001:db8:cafe::17]:4711"}},
+        {[]string{`for="[2001:db8:cafe::17]"`}, []string{"[2001:db8:cafe::17]"}},
+        {[]string{`for="_gazonk"`}, []string{"_gazonk"}},
+        {[]string{`for=unknown, for="\"quoted\\"`}, []string{"unknown", `"quoted\`}},
+        {[]string{"for=_hidden, for=192.0.2.1"}, []string{"_hidden", "192.0.2.1"}},
+        {[]string{"proto=https, for=192.0.2.1"}, []string{"", "192.0.2.1"}},
+        {[]string{"  for=192.0.2.1 ,\tfor=192.0.2.2  "}, []string{"192.0.2.1", "192.0.2.2"}},
+        {[]string{"for=192.0.2.1,"}, []string{"192.0.2.1"}},
+        {[]string{""}, []string{""}},
+        {[]string{"for"}, nil},
+        {[]string{"for="}, nil},
+        {[]string{"=192.0.2.1"}, nil},
+        {[]string{`for="192.0.2.1`}, nil},
+        {[]string{`for="192.0.2.1"x`}, nil},
+        {[]string{"for=192.0.2.1 for=192.0.2.2"}, nil},
+        {[]string{"for=[2001:db8::1]"}, nil},
+        {[]string{"for=192.0.2.1", "for=@"}, nil},
+    }
+    for _, tt := range tests {
+        got, err := parseForwarded(tt.values)
+        if tt.want == nil {
+            if err == nil {
+                t.Errorf("parseForwarded(%q) = %q, want an error", tt.values, got)
+            }
+            continue
+        }
+        if err != nil || strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+            t.Errorf("parseForwarded(%q) = %q, %v; want %q", tt.values, got, err, tt.want)
+        }
+    }
+}
+
+// TestParseForwardedMalformed feeds the parser every prefix of valid
+// headers, and bytes that end tokens and quoted strings, none of which may
+// make it panic.
+func TestParseForwardedMalformed(t *testing.T) {
+    valid := []string{
+        `for="[2001:db8:cafe::17]:4711";proto=https, for=192.0.2.60;by="\"x\""`,
+        `for=unknown;host=example.com,for="_gazonk"`,
+    }
+    for _, header := range valid {
+        for i := 0; i <= len(header); i++ {
+            for _, suffix := range []string{"", `"`, `\`, "=", ";", ",", " ", "\x00", "\xff"} {
+                value := header[:i] + suffix
+                func() {
+                    defer func() {
+                        if r := recover(); r != nil {
+                            t.Errorf("parseForwarded(%q) panicked: %v", value, r)
+                        }
+                    }()
+                    if nodes, err := parseForwarded([]string{value}); err == nil {
+                        for _, node := range nodes {
+                            parseForwardedNode(node)
+                        }
+                    }
+                }()
+            }
+        }
+    }
+}
+
+func TestParseForwardedNode(t *testing.T) {
+    tests := []struct {
+        node string
+        want string // empty if the node is not a usable address
+    }{
+        {"192.0.2.60", "192.0.2.60"},
+        {"192.0.2.60:4711", "192.0.2.60"},
+        {"[2001:db8:cafe::17]", "2001:db8:cafe::17"},
+        {"[2001:db8:cafe::17]:4711", "2001:db8:cafe::17"},
+        {"[2001:db8:cafe::17]:_port", "2001:db8:cafe::17"},
+        {"[::ffff:192.0.2.1]", "192.0.2.1"},
+        {"unknown", ""},
+        {"UNKNOWN", ""},
+        {"_gazonk", ""},
+        {"", ""},
+        {"2001:db8:cafe::17", ""},
+        {"[2001:db8:cafe::17", ""},
+        {"[2001:db8:cafe::17]x", ""},
+        {"example.com", ""},
+    }
+    for _, tt := range tests {
+        addr, ok := parseForwardedNode(tt.node)
+        if tt.want == "" {
+            if ok {
+                t.Errorf("parseForwardedNode(%q) = %s, want no address", tt.node, addr)
+            }
+            continue
+        }
+        if !ok || addr != netip.MustParseAddr(tt.want) {
+            t.Errorf("parseForwardedNode(%q) = %s, %v; want %s", tt.node, addr, ok, tt.want)
+        }
+    }
+}
+
+func TestForwardedHiddenHop(t *testing.T) {
+    config := IPStrategy{Mode: ipStrategyForwarded, TrustedProxies: []string{"10.0.0.0/24"}}
+    fwd := func(value string) []string { return []string{"Forwarded", value} }
+    checkClientAddrs(t, testStrategy(t, config), []clientAddrCase{
+        {"client", "10.0.0.1", fwd("for=192.0.2.5, for=10.0.0.2"), "192.0.2.5", 0},
+        {"quoted IPv6 client", "10.0.0.1", fwd(`for="[2001:db8::5]:4711";proto=https`), "2001:db8::5", 0},
+        // The hops left of a hidden one come from the client: a spoofed
+        // allowed address there must not be selected.
+        {"spoofed left of unknown", "10.0.0.1", fwd("for=192.0.2.5, for=unknown"), "10.0.0.1", 0},
+        {"spoofed left of an obfuscated node", "10.0.0.1", fwd(`for=192.0.2.5, for="_gazonk", for=10.0.0.2`), "10.0.0.1", 0},
+        {"spoofed left of an element without for", "10.0.0.1", fwd("for=192.0.2.5, proto=https"), "10.0.0.1", 0},
+        {"hidden hop left of the client", "10.0.0.1", fwd("for=unknown, for=198.51.100.7"), "198.51.100.7", 0},
+        {"malformed", "10.0.0.1", fwd(`for="192.0.2.5`), "10.0.0.1", 0},
+    })
+
+    config.InvalidHeaderAction = invalidHeaderReject
+    checkClientAddrs(t, testStrategy(t, config), []clientAddrCase{
+        {"spoofed left of unknown, rejected", "10.0.0.1", fwd("for=192.0.2.5, for=unknown"), "", http.StatusBadRequest},
+        {"hidden hop left of the client", "10.0.0.1", fwd("for=_gazonk, for=198.51.100.7"), "198.51.100.7", 0},
+    })
+
+    rules := domainConfig("example.com", DomainConfig{SourceIPs: ips("192.0.2.0/24")})
+    rules.IPStrategy = IPStrategy{Mode: ipStrategyForwarded, TrustedProxies: []string{"10.0.0.0/24"}}
+    handler := newTestSentinel(t, rules)
+    if got := serve(handler, "http://example.com/", "10.0.0.1:1234", "Forwarded", "for=192.0.2.5, for=unknown").Code; got != http.StatusForbidden {
+        t.Errorf("allowed address left of for=unknown: status %d, want 403", got)
+    }
+    if got := serve(handler, "http://example.com/", "10.0.0.1:1234", "Forwarded", "for=192.0.2.5").Code; got != http.StatusOK {
+        t.Errorf("allowed address: status %d, want 200", got)
+    }
+}
+
+func TestXFFHiddenHop(t *testing.T) {
+    config := IPStrategy{TrustedProxies: []string{"10.0.0.0/24"}}
+    xff := func(hops ...string) []string { return []string{"X-Forwarded-For", strings.Join(hops, ", ")} }
+    checkClientAddrs(t, testStrategy(t, config), []clientAddrCase{
+        {"spoofed left of unknown", "10.0.0.1", xff("192.0.2.5", "unknown"), "10.0.0.1", 0},
+        {"spoofed left of unknown and a proxy", "10.0.0.1", xff("192.0.2.5", "unknown", "10.0.0.2"), "10.0.0.1", 0},
+        {"spoofed left of an obfuscated hop", "10.0.0.1", xff("192.0.2.5", "_hidden"), "10.0.0.1", 0},
+        {"unknown left of the client", "10.0.0.1", xff("unknown", "198.51.100.7", "10.0.0.2"), "198.51.100.7", 0},
+    })
+
+    rules := domainConfig("example.com", DomainConfig{SourceIPs: ips("192.0.2.0/24")})
+    rules.IPStrategy = config
+    handler := newTestSentinel(t, rules)
+    if got := serve(handler, "http://example.com/", "10.0.0.1:1234", "X-Forwarded-For", "192.0.2.5, unknown").Code; got != http.StatusForbidden {
+        t.Errorf("allowed address left of unknown: status %d, want 403", got)
+    }
+    if got := serve(handler, "http://example.com/", "10.0.0.1:1234", "X-Forwarded-For", "192.0.2.5, 10.0.0.2").Code; got != http.StatusOK {
+        t.Errorf("allowed address behind a trusted proxy: status %d, want 200", got)
+    }
+}
@@ -0,0 +1,56 @@
+package DomainSentinel
+
+import (
+    "fmt"
+    "net/netip"
+    "strings"
+)
+
+// How a rule's allowedCountries combine with its sourceIPs.
+const (
+    countryMatchAnd = "and" // both the IP and the country must be allowed
+    countryMatchOr  = "or"  // either the IP or the country must be allowed
+)
+
+// Actions for clients whose country cannot be determined.
+const (
+    unknownCountryDeny  = "deny"
+    unknownCountryAllow = "allow"
+)
+
+// geoDB resolves client addresses to ISO 3166-1 country codes using a
+// MaxMind-format country (or city) database.
+type geoDB struct {
+    reader *mmdbReader
+}
+
+// country returns the country code of ip, or false if it is unknown.
+func (g *geoDB) country(ip netip.Addr) (string, bool) {
+    record, err := g.reader.lookup(ip)
+    if err != nil {
+        fmt.Println("Error looking up country:", ip, err)
+        return "", false
+    }
+    for _, key := range []string{"country", "registered_country"} {
+        if code, ok := mmdbPath(record, key, "iso_code").(string); ok && code != "" {
+            return code, true
+        }
+    }
+    return "", false
+}
+
+// parseCountries validates a list of two-letter country codes.
+func parseCountries(codes []string) (map[string]struct{}, error) {
+    if len(codes) == 0 {
+        return nil, nil
+    }
+    countries := make(map[string]struct{}, len(codes))
+    for _, code := range codes {
+        code = strings.ToUpper(strings.TrimSpace(code))
+        if len(code) != 2 || !isLetters(code) {
+            return nil, fmt.Errorf("invalid country code %q", code)
+        }
+        countries[code] = struct{}{}
+    }
+    return countries, nil
+}
@@ -0,0 +1,184 @@
+package DomainSentinel
+
+import (
+    "errors"
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// globSegments is the wildcard used as a whole path segment to match any
+// number of segments.
+const globSegments = "**"
+
+// pathGlob is a compiled path pattern with wildcards or placeholders. It is
+// matched segment by segment, splitting on "/": "*" matches any characters
+// within one segment, so it never crosses a slash, a "**" segment matches
+// zero or more whole segments, and a "{name}" segment matches one non-empty
+// segment, whose value is captured.
+type pathGlob struct {
+    segments []globSegment
+}
+
+// globSegment is one segment of a pathGlob.
+type globSegment struct {
+    pattern string         // literal, possibly with "*"
+    any     bool           // "**"
+    param   string         // placeholder name, empty for other segments
+    re      *regexp.Regexp // constraint of "{name:regex}", nil if none
+}
+
+// isPathGlob reports whether a path pattern is a glob or template. A single
+// trailing "/*" is the older prefix wildcard and not a glob.
+func isPathGlob(pattern string) bool {
+    return strings.ContainsAny(strings.TrimSuffix(pattern, "/*"), "*{")
+}
+
+// compilePathGlob splits a glob pattern into segments and compiles the
+// constraints of its placeholders, case-insensitive if fold is set.
+func compilePathGlob(pattern string, fold bool) (*pathGlob, error) {
+    g := &pathGlob{}
+    params := make(map[string]bool)
+    for _, segment := range strings.Split(pattern, "/") {
+        switch {
+        case segment == globSegments:
+            g.segments = append(g.segments, globSegment{any: true})
+        case strings.Contains(segment, globSegments):
+            return nil, errors.New(`"**" must be a whole path segment, as in "/files/**/private.txt"`)
+        case strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"):
+            name, constraint := segment[1:len(segment)-1], ""
+            if i := strings.IndexByte(name, ':'); i >= 0 {
+                name, constraint = name[:i], name[i+1:]
+            }
+            if !validParamName(name) {
+                return nil, fmt.Errorf("invalid placeholder %q: the name must consist of letters, digits and underscores", segment)
+            }
+            if params[name] {
+                return nil, fmt.Errorf("duplicate placeholder %q", name)
+            }
+            params[name] = true
+            seg := globSegment{param: name}
+            if constraint != "" {
+                re, err := regexp.Compile(regexFlags(fold) + "^(?:" + constraint + ")$")
+                if err != nil {
+                    return nil, fmt.Errorf("placeholder %q: invalid constraint: %w", name, err)
+                }
+                seg.re = re
+            }
+            g.segments = append(g.segments, seg)
+        case strings.ContainsAny(segment, "{}"):
+            return nil, fmt.Errorf("placeholder in %q must be a whole path segment, as in \"/users/{id}/settings\"", segment)
+        default:
+            g.segments = append(g.segments, globSegment{pattern: segment})
+        }
+    }
+    return g, nil
+}
+
+func validParamName(name string) bool {
+    if name == "" {
+        return false
+    }
+    for _, r := range name {
+        if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+            return false
+        }
+    }
+    return true
+}
+
+// match reports whether path matches the pattern and returns the captured
+// placeholder values as "name=value", in pattern order. A "**" segment is
+// matched by backtracking to the most recent one only, which keeps the
+// cost linear in the number of segments times the pattern length.
+func (g *pathGlob) match(path string) (bool, []string) {
+    segments := strings.Split(path, "/")
+    values := make([]string, len(g.segments))
+    p, s := 0, 0
+    star, mark := -1, 0
+    for s < len(segments) {
+        switch {
+        case p < len(g.segments) && g.segments[p].any:
+            star, mark = p, s
+            p++
+        case p < len(g.segments) && g.segments[p].matches(segments[s]):
+            values[p] = segments[s]
+            p++
+            s++
+        case star >= 0:
+            mark++
+            p, s = star+1, mark
+        default:
+            return false, nil
+        }
+    }
+    for p < len(g.segments) && g.segments[p].any {
+        p++
+    }
+    if p != len(g.segments) {
+        return false, nil
+    }
+    var params []string
+    for i, seg := range g.segments {
+        if seg.param != "" {
+            params = append(params, seg.param+"="+values[i])
+        }
+    }
+    return true, params
+}
+
+// matches reports whether a single path segment matches.
+func (seg globSegment) matches(segment string) bool {
+    if seg.param != "" {
+        return segment != "" && (seg.re == nil || seg.re.MatchString(segment))
+    }
+    return matchSegment(seg.pattern, segment)
+}
+
+// matchSegment matches one path segment against a pattern segment in which
+// "*" stands for any run of characters. Suffix patterns use it to match
+// whole paths.
+func matchSegment(pattern, segment string) bool {
+    p, s := 0, 0
+    star, mark := -1, 0
+    for s < len(segment) {
+        switch {
+        case p < len(pattern) && pattern[p] == '*':
+            star, mark = p, s
+            p++
+        case p < len(pattern) && pattern[p] == segment[s]:
+            p++
+            s++
+        case star >= 0:
+            mark++
+            p, s = star+1, mark
+        default:
+            return false
+        }
+    }
+    for p < len(pattern) && pattern[p] == '*' {
+        p++
+    }
+    return p == len(pattern)
+}
+
+// firstSegment returns the first segment of a path.
+func firstSegment(path string) string {
+    path = strings.TrimPrefix(path, "/")
+    if i := strings.IndexByte(path, '/'); i >= 0 {
+        return path[:i]
+    }
+    return path
+}
+
+// globLiterals returns the number of literal characters of a glob pattern,
+// which orders globs from the most to the least specific.
+func globLiterals(pattern string) int {
+    n := 0
+    for _, segment := range strings.Split(pattern, "/") {
+        if !strings.HasPrefix(segment, "{") {
+            n += len(segment) - strings.Count(segment, "*")
+        }
+    }
+    return n
+}
@@ -0,0 +1,83 @@
+package DomainSentinel
+
+import "testing"
+
+func TestPathGlobConformance(t *testing.T) {
+    tests := []struct {
+        pattern, path string
+        want          bool
+    }{
+        // "*" matches within one segment and never crosses a slash.
+        {"/api/*/admin", "/api/v1/admin", true},
+        {"/api/*/admin", "/api/v1/v2/admin", false},
+        {"/api/*/admin", "/api/admin", false},
+        {"/api/*/admin", "/api/v1/admin/", false},
+        {"/api/*/admin", "/api/v1/adminx", false},
+        {"/api/v*/admin", "/api/v1/admin", true},
+        {"/api/v*/admin", "/api/x1/admin", false},
+        {"/files/*.txt", "/files/a.txt", true},
+        {"/files/*.txt", "/files/a/b.txt", false},
+        {"/files/*.txt", "/files/.txt", true},
+        {"/a*b*c/x", "/aXbYc/x", true},
+        {"/a*b*c/x", "/aXcYb/x", false},
+        // "**" matches any number of whole segments, including none.
+        {"/files/**/private.txt", "/files/private.txt", true},
+        {"/files/**/private.txt", "/files/a/private.txt", true},
+        {"/files/**/private.txt", "/files/a/b/c/private.txt", true},
+        {"/files/**/private.txt", "/files/a/b/public.txt", false},
+        {"/files/**/private.txt", "/files/a/private.txt/x", false},
+        {"/files/**/private.txt", "/other/a/private.txt", false},
+        {"/files/**", "/files", true},
+        {"/files/**", "/files/a/b", true},
+        {"/files/**", "/filesx/a", false},
+        {"/**/admin/*", "/x/y/admin/z", true},
+        {"/**/admin/*", "/admin/z", true},
+        {"/**/admin/*", "/x/admin/z/w", false},
+        {"/a/**/b/**/c", "/a/b/c", true},
+        {"/a/**/b/**/c", "/a/x/b/y/z/c", true},
+        {"/a/**/b/**/c", "/a/x/c/b", false},
+        // Empty segments and trailing slashes are segments of their own.
+        {"/api/*/admin", "/api//admin", true},
+        {"/api//*", "/api//x", true},
+        {"/api/*/", "/api/v1/", true},
+        {"/api/*/", "/api/v1", false},
+        {"/api/*", "/api/", true},
+        {"/files/**/x", "/files//x", true},
+        // Literal segments match exactly, case-sensitively.
+        {"/API/*/admin", "/api/v1/admin", false},
+        {"/api/*/admin", "/api/v1/Admin", false},
+        // Placeholders match one non-empty segment.
+        {"/users/{id}/settings", "/users/42/settings", true},
+        {"/users/{id}/settings", "/users//settings", false},
+        {"/users/{id:[0-9]+}", "/users/abc", false},
+    }
+    for _, tt := range tests {
+        g, err := compilePathGlob(tt.pattern, false)
+        if err != nil {
+            t.Errorf("compilePathGlob(%q): %v", tt.pattern, err)
+            continue
+        }
+        if got, _ := g.match(tt.path); got != tt.want {
+            t.Errorf("%q matching %q = %v, want %v", tt.pattern, tt.path, got, tt.want)
+        }
+    }
+}
+
+func TestPathGlobCaptures(t *testing.T) {
+    g, err := compilePathGlob("/orgs/{org}/**/repos/{repo:[a-z]+}", false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    ok, params := g.match("/orgs/acme/x/y/repos/web")
+    if !ok || len(params) != 2 || params[0] != "org=acme" || params[1] != "repo=web" {
+        t.Errorf("match = %v, %v; want org=acme, repo=web", ok, params)
+    }
+}
+
+func TestPathGlobErrors(t *testing.T) {
+    for _, pattern := range []string{"/a**/b", "/a/**b", "/files/x**", "/users/{id", "/users/x{id}", "/users/{}", "/users/{id}/{id}", "/users/{id:[}"} {
+        if _, err := compilePathGlob(pattern, false); err == nil {
+            t.Errorf("compilePathGlob(%q) succeeded, want an error", pattern)
+        }
+    }
+}
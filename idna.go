@@ -0,0 +1,208 @@
+package DomainSentinel
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// acePrefix starts the ASCII form of an internationalized label.
+const acePrefix = "xn--"
+
+// Punycode parameters (RFC 3492, section 5).
+const (
+    punyBase        = 36
+    punyTMin        = 1
+    punyTMax        = 26
+    punySkew        = 38
+    punyDamp        = 700
+    punyInitialBias = 72
+    punyInitialN    = 128
+)
+
+// toASCII converts a host name to the lower-case ASCII form used as the
+// domain table key: Unicode labels are punycode-encoded ("bücher.de"
+// becomes "xn--bcher-kva.de") and "xn--" labels are checked to decode.
+// It is a subset of IDNA: labels are lower-cased, not fully mapped or
+// normalized, so keys should be written in their usual form.
+func toASCII(name string) (string, error) {
+    if !utf8.ValidString(name) {
+        return "", errors.New("not valid UTF-8")
+    }
+    labels := strings.Split(strings.ToLower(name), ".")
+    for i, label := range labels {
+        if isASCII(label) {
+            if strings.HasPrefix(label, acePrefix) {
+                if _, err := punyDecode(label[len(acePrefix):]); err != nil {
+                    return "", fmt.Errorf("label %q: %w", label, err)
+                }
+            }
+            continue
+        }
+        for _, r := range label {
+            if r < utf8.RuneSelf {
+                continue
+            }
+            if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r) {
+                return "", fmt.Errorf("label %q: character %q is not allowed", label, r)
+            }
+        }
+        labels[i] = acePrefix + punyEncode([]rune(label))
+        if len(labels[i]) > 63 {
+            return "", fmt.Errorf("label %q is longer than 63 characters when encoded", label)
+        }
+    }
+    return strings.Join(labels, "."), nil
+}
+
+func isASCII(s string) bool {
+    for i := 0; i < len(s); i++ {
+        if s[i] >= utf8.RuneSelf {
+            return false
+        }
+    }
+    return true
+}
+
+// punyEncode encodes a label with the Punycode algorithm of RFC 3492.
+func punyEncode(input []rune) string {
+    var out strings.Builder
+    for _, r := range input {
+        if r < punyInitialN {
+            out.WriteRune(r)
+        }
+    }
+    basic := out.Len()
+    if basic > 0 {
+        out.WriteByte('-')
+    }
+
+    n, delta, bias := rune(punyInitialN), 0, punyInitialBias
+    for h := basic; h < len(input); {
+        m := rune(unicode.MaxRune + 1)
+        for _, r := range input {
+            if r >= n && r < m {
+                m = r
+            }
+        }
+        delta += int(m-n) * (h + 1)
+        n = m
+        for _, r := range input {
+            if r < n {
+                delta++
+            }
+            if r != n {
+                continue
+            }
+            q := delta
+            for k := punyBase; ; k += punyBase {
+                t := punyThreshold(k, bias)
+                if q < t {
+                    break
+                }
+                out.WriteByte(punyDigit(t + (q-t)%(punyBase-t)))
+                q = (q - t) / (punyBase - t)
+            }
+            out.WriteByte(punyDigit(q))
+            bias = punyAdapt(delta, h+1, h == basic)
+            delta = 0
+            h++
+        }
+        delta++
+        n++
+    }
+    return out.String()
+}
+
+// punyDecode decodes a Punycode label. It fails on anything that is not
+// the canonical encoding of a label.
+func punyDecode(input string) ([]rune, error) {
+    var output []rune
+    pos := 0
+    if b := strings.LastIndexByte(input, '-'); b >= 0 {
+        output = []rune(input[:b])
+        pos = b + 1
+    }
+
+    n, i, bias := rune(punyInitialN), 0, punyInitialBias
+    for pos < len(input) {
+        oldi, w := i, 1
+        for k := punyBase; ; k += punyBase {
+            if pos >= len(input) {
+                return nil, errors.New("truncated punycode")
+            }
+            d := punyValue(input[pos])
+            pos++
+            if d < 0 {
+                return nil, errors.New("invalid punycode digit")
+            }
+            i += d * w
+            t := punyThreshold(k, bias)
+            if d < t {
+                break
+            }
+            w *= punyBase - t
+            if i > 1<<24 || w > 1<<24 {
+                return nil, errors.New("punycode overflow")
+            }
+        }
+        bias = punyAdapt(i-oldi, len(output)+1, oldi == 0)
+        n += rune(i / (len(output) + 1))
+        i %= len(output) + 1
+        if n > unicode.MaxRune || n < punyInitialN {
+            return nil, errors.New("punycode decodes to an invalid character")
+        }
+        output = append(output[:i], append([]rune{n}, output[i:]...)...)
+        i++
+    }
+    if isASCII(string(output)) || punyEncode(output) != input {
+        return nil, errors.New("not a canonical punycode label")
+    }
+    return output, nil
+}
+
+func punyThreshold(k, bias int) int {
+    switch {
+    case k <= bias:
+        return punyTMin
+    case k >= bias+punyTMax:
+        return punyTMax
+    }
+    return k - bias
+}
+
+func punyAdapt(delta, points int, first bool) int {
+    if first {
+        delta /= punyDamp
+    } else {
+        delta /= 2
+    }
+    delta += delta / points
+    k := 0
+    for delta > (punyBase-punyTMin)*punyTMax/2 {
+        delta /= punyBase - punyTMin
+        k += punyBase
+    }
+    return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+func punyDigit(d int) byte {
+    if d < 26 {
+        return byte('a' + d)
+    }
+    return byte('0' + d - 26)
+}
+
+func punyValue(c byte) int {
+    switch {
+    case c >= 'a' && c <= 'z':
+        return int(c - 'a')
+    case c >= 'A' && c <= 'Z':
+        return int(c - 'A')
+    case c >= '0' && c <= '9':
+        return int(c-'0') + 26
+    }
+    return -1
+}
@@ -0,0 +1,57 @@
+package DomainSentinel
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestToASCII(t *testing.T) {
+    tests := []struct {
+        name, want string
+    }{
+        {"bücher.de", "xn--bcher-kva.de"},
+        {"münchen.de", "xn--mnchen-3ya.de"},
+        {"例え.jp", "xn--r8jz45g.jp"},
+        {"правительство.рф", "xn--80aealotwbjpid2k.xn--p1ai"},
+        {"BÜCHER.example", "xn--bcher-kva.example"},
+        {"xn--bcher-kva.de", "xn--bcher-kva.de"},
+        {"XN--BCHER-KVA.de", "xn--bcher-kva.de"},
+        {"www.example.com", "www.example.com"},
+        {"*.bücher.de", "*.xn--bcher-kva.de"},
+    }
+    for _, tt := range tests {
+        got, err := toASCII(tt.name)
+        if err != nil || got != tt.want {
+            t.Errorf("toASCII(%q) = %q, %v; want %q", tt.name, got, err, tt.want)
+        }
+    }
+}
+
+func TestToASCIIRejects(t *testing.T) {
+    tests := []struct {
+        name, reason string
+    }{
+        {"xn--bcher-kv!.de", "invalid punycode digit"},
+        {"xn--bcher-k.de", "truncated"},
+        {"xn--abc-.de", "canonical"}, // decodes to plain ASCII
+        {"xn--99999999999.de", "overflow"},
+        {"bü★cher.de", "not allowed"},
+        {"b\xffcher.de", "UTF-8"},
+        {strings.Repeat("ü", 60) + ".de", "longer than 63"},
+    }
+    for _, tt := range tests {
+        if _, err := toASCII(tt.name); err == nil || !strings.Contains(err.Error(), tt.reason) {
+            t.Errorf("toASCII(%q): got %v, want an error about %q", tt.name, err, tt.reason)
+        }
+    }
+}
+
+func TestPunycodeRoundTrip(t *testing.T) {
+    for _, label := range []string{"bücher", "例え", "правительство", "ü", "aü", "üa", "παράδειγμα"} {
+        encoded := punyEncode([]rune(label))
+        decoded, err := punyDecode(encoded)
+        if err != nil || string(decoded) != label {
+            t.Errorf("punyDecode(punyEncode(%q) = %q) = %q, %v", label, encoded, string(decoded), err)
+        }
+    }
+}
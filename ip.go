@@ -0,0 +1,650 @@
+package DomainSentinel
+
+import (
+    "fmt"
+    "net"
+    "net/netip"
+    "strings"
+    "time"
+)
+
+// SourceIP is the object form of a sourceIPs entry. Lists may mix plain
+// strings and objects:
+//
+//	sourceIPs:
+//	  - "10.0.0.0/8"
+//	  - ip: "203.0.113.88/32"
+//	    label: "monitoring-vm"
+//	  - ip: "198.51.100.23"
+//	    label: "contractor"
+//	    validUntil: "2024-07-01T00:00:00+02:00"
+//
+// ValidUntil is an RFC 3339 timestamp after which the entry stops matching.
+type SourceIP struct {
+    IP         string `json:"ip,omitempty"`
+    Label      string `json:"label,omitempty"`
+    ValidUntil string `json:"validUntil,omitempty"`
+}
+
+// ipEntry is a single list entry as written in the configuration.
+type ipEntry struct {
+    value      string
+    label      string
+    validUntil time.Time // zero if the entry does not expire
+}
+
+// ipList holds the parsed networks, ranges and exact addresses of a source IP list.
+type ipList struct {
+    raw    []string // entries in canonical form, for logging
+    labels []string // label of each raw entry, empty if none
+    nets   []ipPrefix
+    trie   *prefixTrie // built from nets
+    ranges []ipRange
+    ips    map[netip.Addr]int // exact addresses, unmapped and without zone
+    hosts  []listHost
+
+    // validUntil holds the expiry of each raw entry, zero if it has none.
+    // It is nil when no entry expires.
+    validUntil []time.Time
+
+    ipv6Bits int // set by truncateIPv6
+}
+
+// listHost is a hostname entry with the index of the entry it came from.
+type listHost struct {
+    *resolvedHost
+    index int
+}
+
+// ipPrefix is a parsed network with the index of the entry it came from.
+type ipPrefix struct {
+    netip.Prefix
+    index int
+}
+
+// ipRange is an inclusive start-end address range of a single address family.
+type ipRange struct {
+    start netip.Addr
+    end   netip.Addr
+    index int
+}
+
+// ipKeywords maps the shorthand keywords accepted in source IP lists to the
+// networks they stand for.
+var ipKeywords = map[string][]string{
+    "private":   {"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"},
+    "loopback":  {"127.0.0.0/8", "::1/128"},
+    "linklocal": {"169.254.0.0/16", "fe80::/10"},
+    "cgnat":     {"100.64.0.0/10"},
+}
+
+// buildIPList parses a list of IP addresses, CIDR blocks, ranges, keywords and
+// hostnames. Hostnames are registered with the compiler's host set, which
+// resolves them.
+func (c *compiler) buildIPList(entries []ipEntry) (*ipList, error) {
+    list := &ipList{ips: make(map[netip.Addr]int)}
+    var validUntil []time.Time
+    expires := false
+    for _, e := range entries {
+        entry := strings.TrimSpace(e.value)
+        if entry == "" {
+            continue
+        }
+        if strings.ContainsAny(entry, " \t") && !strings.Contains(entry, "-") {
+            return nil, fmt.Errorf("invalid source IP entry %q: contains whitespace, list each address separately", entry)
+        }
+        index := len(list.raw)
+        list.raw = append(list.raw, entry)
+        list.labels = append(list.labels, e.label)
+        validUntil = append(validUntil, e.validUntil)
+        if !e.validUntil.IsZero() {
+            expires = true
+            c.expiring = append(c.expiring, expiringEntry{entry: entry, label: e.label, validUntil: e.validUntil})
+            if !time.Now().Before(e.validUntil) {
+                fmt.Printf("Warning: source IP entry %q expired at %s and no longer matches\n", entry, e.validUntil.Format(time.RFC3339))
+            }
+        }
+
+        if isKeyword(entry) {
+            nets, err := c.keywordNets(entry)
+            if err != nil {
+                return nil, err
+            }
+            for _, p := range nets {
+                list.nets = append(list.nets, ipPrefix{Prefix: p, index: index})
+            }
+            continue
+        }
+
+        if isHostname(entry) || isHostLabel(entry) {
+            h := c.hosts.get(entry)
+            list.hosts = append(list.hosts, listHost{resolvedHost: h, index: index})
+            list.raw[index] = h.name
+            continue
+        }
+
+        if strings.Contains(entry, "-") {
+            r, err := parseIPRange(entry)
+            if err != nil {
+                return nil, err
+            }
+            r.index = index
+            list.ranges = append(list.ranges, r)
+            list.raw[index] = r.start.String() + "-" + r.end.String()
+            continue
+        }
+
+        var ip netip.Addr
+        if strings.Contains(entry, "*") || strings.Contains(entry, "/") {
+            var p netip.Prefix
+            var err error
+            if strings.Contains(entry, "*") {
+                p, err = parseWildcard(entry)
+                if err != nil {
+                    return nil, err
+                }
+                fmt.Printf("Source IP pattern %q is treated as %s\n", entry, p)
+            } else if p, err = parsePrefix(entry); err != nil {
+                return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+            }
+            list.raw[index] = p.String()
+            if !p.IsSingleIP() {
+                list.nets = append(list.nets, ipPrefix{Prefix: p, index: index})
+                continue
+            }
+            // A /32 or /128 is an exact address.
+            ip = p.Addr()
+        } else {
+            var ok bool
+            if ip, ok = parseIP(entry); !ok {
+                return nil, fmt.Errorf("invalid IP address %q", entry)
+            }
+            list.raw[index] = ip.String()
+        }
+        if prev, dup := list.ips[ip]; !dup || outlives(e.validUntil, validUntil[prev]) {
+            list.ips[ip] = index
+        }
+    }
+    list.trie = newPrefixTrie(list.nets)
+    if expires {
+        list.validUntil = validUntil
+    }
+    return list, nil
+}
+
+// checkIPEntry reports whether an entry of an IP list parses the way
+// buildIPList parses it, without resolving hostnames, keywords or groups.
+// Group references must name one of groups.
+func checkIPEntry(entry string, groups map[string][]string) error {
+    entry = strings.TrimSpace(entry)
+    switch {
+    case entry == "":
+        return nil
+    case strings.HasPrefix(entry, "@"):
+        if _, ok := groups[entry[1:]]; !ok {
+            return fmt.Errorf("unknown ipGroups reference %q", entry)
+        }
+        return nil
+    case strings.ContainsAny(entry, " \t") && !strings.Contains(entry, "-"):
+        return fmt.Errorf("invalid source IP entry %q: contains whitespace, list each address separately", entry)
+    case isKeyword(entry):
+        return nil
+    case isHostname(entry) || isHostLabel(entry):
+        return nil
+    case strings.Contains(entry, "-"):
+        _, err := parseIPRange(entry)
+        return err
+    case strings.Contains(entry, "*"):
+        _, err := parseWildcard(entry)
+        return err
+    case strings.Contains(entry, "/"):
+        if _, err := parsePrefix(entry); err != nil {
+            return fmt.Errorf("invalid CIDR %q: %w", entry, err)
+        }
+        return nil
+    }
+    if _, ok := parseIP(entry); !ok {
+        return fmt.Errorf("invalid IP address %q", entry)
+    }
+    return nil
+}
+
+// outlives reports whether an entry valid until a stays valid longer than
+// one valid until b, where the zero time means forever.
+func outlives(a, b time.Time) bool {
+    if b.IsZero() {
+        return false
+    }
+    return a.IsZero() || a.After(b)
+}
+
+// keywordNets returns the networks a keyword stands for. The "self" keyword
+// is resolved from the host's interfaces the first time it is used.
+func (c *compiler) keywordNets(keyword string) ([]netip.Prefix, error) {
+    keyword = strings.ToLower(keyword)
+    if keyword == "self" {
+        if c.selfNets == nil {
+            nets, err := localNetworks()
+            if err != nil {
+                return nil, fmt.Errorf("resolving \"self\" keyword: %w", err)
+            }
+            c.selfNets = nets
+        }
+        return c.selfNets, nil
+    }
+
+    cidrs, ok := ipKeywords[keyword]
+    if !ok {
+        return nil, fmt.Errorf("unknown source IP keyword %q", keyword)
+    }
+    nets := make([]netip.Prefix, 0, len(cidrs))
+    for _, cidr := range cidrs {
+        nets = append(nets, netip.MustParsePrefix(cidr))
+    }
+    return nets, nil
+}
+
+// localNetworks returns the networks of all interfaces that are up, and
+// logs them so operators can verify what the "self" keyword allows.
+func localNetworks() ([]netip.Prefix, error) {
+    interfaces, err := net.Interfaces()
+    if err != nil {
+        return nil, err
+    }
+
+    nets := []netip.Prefix{}
+    var names []string
+    for _, iface := range interfaces {
+        if iface.Flags&net.FlagUp == 0 {
+            continue
+        }
+        addrs, err := iface.Addrs()
+        if err != nil {
+            return nil, fmt.Errorf("interface %s: %w", iface.Name, err)
+        }
+        for _, addr := range addrs {
+            ipNet, ok := addr.(*net.IPNet)
+            if !ok {
+                continue
+            }
+            addr, ok := netip.AddrFromSlice(ipNet.IP)
+            if !ok {
+                continue
+            }
+            ones, bits := ipNet.Mask.Size()
+            if addr.Is4In6() && bits == 8*net.IPv4len {
+                ones += 96
+            }
+            p := unmapPrefix(netip.PrefixFrom(addr, ones).Masked())
+            nets = append(nets, p)
+            names = append(names, fmt.Sprintf("%s (%s)", p, iface.Name))
+        }
+    }
+
+    fmt.Println("Networks allowed by the \"self\" keyword:", names)
+    return nets, nil
+}
+
+// isKeyword reports whether entry is one of the ipKeywords or "self", in
+// any case. Other words are hostnames.
+func isKeyword(entry string) bool {
+    keyword := strings.ToLower(entry)
+    return keyword == "self" || ipKeywords[keyword] != nil
+}
+
+// isLetters reports whether s consists of ASCII letters only.
+func isLetters(s string) bool {
+    for _, c := range s {
+        if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+            return false
+        }
+    }
+    return true
+}
+
+// parseIPRange parses a "start-end" range entry. Both endpoints are inclusive
+// and must belong to the same address family.
+func parseIPRange(entry string) (ipRange, error) {
+    parts := strings.SplitN(entry, "-", 2)
+    start, okStart := parseIP(strings.TrimSpace(parts[0]))
+    end, okEnd := parseIP(strings.TrimSpace(parts[1]))
+    if !okStart || !okEnd {
+        return ipRange{}, fmt.Errorf("invalid IP range %q", entry)
+    }
+    if start.Is4() != end.Is4() {
+        return ipRange{}, fmt.Errorf("invalid IP range %q: start and end are different address families", entry)
+    }
+    if start.Compare(end) > 0 {
+        return ipRange{}, fmt.Errorf("invalid IP range %q: start is greater than end", entry)
+    }
+    return ipRange{start: start, end: end}, nil
+}
+
+// Every IP, CIDR and range entry is reduced to a single canonical form
+// before it is stored: lower-case hex, no leading zeros, "::" compression,
+// masked host bits and IPv4-mapped addresses unmapped. Client addresses go
+// through the same normalization, so "2001:DB8:0:0::/64", "2001:db8::/64"
+// and "2001:0db8:0000:0000::/64" are indistinguishable, and logs show the
+// canonical spelling.
+
+// parseWildcard translates an IPv4 octet pattern such as "192.168.1.*" or
+// "10.*.*.*" into the equivalent prefix. Wildcards must form a trailing run,
+// so "10.*.3.4" is rejected as ambiguous.
+func parseWildcard(entry string) (netip.Prefix, error) {
+    octets := strings.Split(entry, ".")
+    if len(octets) != 4 {
+        return netip.Prefix{}, fmt.Errorf("invalid wildcard pattern %q: expected four octets", entry)
+    }
+
+    fixed := 0
+    for fixed < 4 && octets[fixed] != "*" {
+        fixed++
+    }
+    for i := fixed; i < 4; i++ {
+        if octets[i] != "*" {
+            return netip.Prefix{}, fmt.Errorf("invalid wildcard pattern %q: wildcards must only appear in trailing octets", entry)
+        }
+        octets[i] = "0"
+    }
+
+    addr, err := netip.ParseAddr(strings.Join(octets, "."))
+    if err != nil || !addr.Is4() {
+        return netip.Prefix{}, fmt.Errorf("invalid wildcard pattern %q", entry)
+    }
+    return netip.PrefixFrom(addr, 8*fixed), nil
+}
+
+// parseIP parses an IPv4 or IPv6 address into the form used for matching:
+// any IPv6 zone identifier (fe80::1%eth0) is dropped, and IPv4-mapped IPv6
+// addresses (::ffff:203.0.113.7) become plain IPv4, which is what dual-stack
+// listeners report for IPv4 clients. netip.Addr compares by value, so
+// differently written spellings of the same IPv6 address are equal.
+func parseIP(s string) (netip.Addr, bool) {
+    addr, err := netip.ParseAddr(s)
+    if err != nil {
+        return netip.Addr{}, false
+    }
+    return normalizeAddr(addr), true
+}
+
+// normalizeAddr drops the zone of addr and unmaps IPv4-mapped IPv6 addresses.
+func normalizeAddr(addr netip.Addr) netip.Addr {
+    return addr.WithZone("").Unmap()
+}
+
+// parsePrefix parses a CIDR entry. The result is masked, and an IPv4-mapped
+// IPv6 network is returned as the equivalent IPv4 network.
+func parsePrefix(s string) (netip.Prefix, error) {
+    p, err := netip.ParsePrefix(stripZone(s))
+    if err != nil {
+        return netip.Prefix{}, err
+    }
+    return unmapPrefix(p.Masked()), nil
+}
+
+// unmapPrefix turns an IPv4-mapped IPv6 network (::ffff:10.0.0.0/104) into the
+// equivalent IPv4 network, so it matches clients regardless of the stack they
+// connected over.
+func unmapPrefix(p netip.Prefix) netip.Prefix {
+    if !p.Addr().Is4In6() || p.Bits() < 96 {
+        return p
+    }
+    return netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+}
+
+// stripZone removes an IPv6 zone identifier from an address or CIDR.
+func stripZone(s string) string {
+    i := strings.IndexByte(s, '%')
+    if i < 0 {
+        return s
+    }
+    if j := strings.IndexByte(s[i:], '/'); j >= 0 {
+        return s[:i] + s[i+j:]
+    }
+    return s[:i]
+}
+
+// stringEntries converts a plain string list into list entries.
+func stringEntries(values []string) []ipEntry {
+    var entries []ipEntry
+    for _, value := range values {
+        for _, v := range splitListEntry(value) {
+            entries = append(entries, ipEntry{value: v})
+        }
+    }
+    return entries
+}
+
+// sourceIPEntries converts a sourceIPs list, whose elements may be strings or
+// SourceIP objects, into list entries. Objects arrive as maps when decoded
+// from Traefik's dynamic configuration or JSON.
+func sourceIPEntries(values []interface{}) ([]ipEntry, error) {
+    var entries []ipEntry
+    for i, value := range values {
+        converted, err := sourceIPValue(value)
+        if err != nil {
+            return nil, fmt.Errorf("entry %d: %w", i, err)
+        }
+        entries = append(entries, converted...)
+    }
+    return entries, nil
+}
+
+// sourceIPValue converts one element of a sourceIPs list. A flattened
+// string list yields several entries.
+func sourceIPValue(value interface{}) ([]ipEntry, error) {
+    switch v := value.(type) {
+    case string:
+        var entries []ipEntry
+        for _, s := range splitListEntry(v) {
+            entries = append(entries, ipEntry{value: s})
+        }
+        return entries, nil
+    case SourceIP:
+        entry, err := sourceIPEntry(v)
+        return []ipEntry{entry}, err
+    case *SourceIP:
+        entry, err := sourceIPEntry(*v)
+        return []ipEntry{entry}, err
+    case map[string]interface{}:
+        entry, err := sourceIPFromMap(v)
+        return []ipEntry{entry}, err
+    case map[interface{}]interface{}:
+        m := make(map[string]interface{}, len(v))
+        for key, val := range v {
+            m[fmt.Sprint(key)] = val
+        }
+        entry, err := sourceIPFromMap(m)
+        return []ipEntry{entry}, err
+    default:
+        return nil, fmt.Errorf("unsupported source IP entry of type %T", value)
+    }
+}
+
+// sourceIPEntry converts a SourceIP object into a list entry.
+func sourceIPEntry(v SourceIP) (ipEntry, error) {
+    entry := ipEntry{value: v.IP, label: v.Label}
+    if v.ValidUntil != "" {
+        t, err := parseValidUntil(v.ValidUntil)
+        if err != nil {
+            return ipEntry{}, err
+        }
+        entry.validUntil = t
+    }
+    return entry, nil
+}
+
+// parseValidUntil parses a validUntil timestamp. RFC 3339 requires an
+// explicit offset ("Z" or "+02:00"), so the instant never depends on the
+// time zone Traefik runs in.
+func parseValidUntil(value string) (time.Time, error) {
+    t, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+    if err != nil {
+        return time.Time{}, fmt.Errorf("invalid validUntil %q: must be an RFC 3339 timestamp such as \"2024-07-01T00:00:00Z\"", value)
+    }
+    return t, nil
+}
+
+// sourceIPFromMap converts the map form of a SourceIP object.
+func sourceIPFromMap(m map[string]interface{}) (ipEntry, error) {
+    var v SourceIP
+    for key, val := range m {
+        s, ok := val.(string)
+        if !ok {
+            return ipEntry{}, fmt.Errorf("field %q must be a string", key)
+        }
+        switch key {
+        case "ip":
+            v.IP = s
+        case "label":
+            v.Label = s
+        case "validUntil":
+            v.ValidUntil = s
+        default:
+            return ipEntry{}, fmt.Errorf("unknown source IP field %q", key)
+        }
+    }
+    if v.IP == "" {
+        return ipEntry{}, fmt.Errorf("source IP object without \"ip\"")
+    }
+    return sourceIPEntry(v)
+}
+
+// splitListEntry expands a list value that Traefik hands over in its
+// flattened "║24║a║b" form into individual entries. Every other value is
+// passed through untouched, so each entry is parsed on its own.
+func splitListEntry(value string) []string {
+    if !strings.HasPrefix(value, "║24║") {
+        return []string{value}
+    }
+    return strings.Split(strings.TrimPrefix(value, "║24║"), "║")
+}
+
+// empty reports whether the list has no entries at all.
+func (l *ipList) empty() bool {
+    return len(l.raw) == 0
+}
+
+// contains reports whether ip is one of the listed addresses or inside one of the listed networks.
+func (l *ipList) contains(ip netip.Addr) bool {
+    _, ok := l.lookup(ip)
+    return ok
+}
+
+// lookup returns the index of the entry matching ip, which must be
+// normalized with normalizeAddr. Expired entries do not match.
+func (l *ipList) lookup(ip netip.Addr) (int, bool) {
+    var now time.Time
+    if l.validUntil != nil {
+        now = time.Now()
+    }
+
+    if index, ok := l.ips[ip]; ok && l.valid(index, now) {
+        return index, true
+    }
+    if index, ok := l.trie.lookup(ip); ok {
+        if l.valid(index, now) {
+            return index, true
+        }
+        // The most specific network has expired, a broader one may not have.
+        for _, n := range l.nets {
+            if n.Contains(ip) && l.valid(n.index, now) {
+                return n.index, true
+            }
+        }
+    }
+    for _, r := range l.ranges {
+        if ip.BitLen() == r.start.BitLen() && ip.Compare(r.start) >= 0 && ip.Compare(r.end) <= 0 && l.valid(r.index, now) {
+            return r.index, true
+        }
+    }
+    for _, h := range l.hosts {
+        if l.hostContains(h.resolvedHost, ip) && l.valid(h.index, now) {
+            return h.index, true
+        }
+    }
+    return 0, false
+}
+
+// hostContains reports whether ip is one of the addresses of h, comparing
+// IPv6 addresses by their truncated network if the list is truncated.
+func (l *ipList) hostContains(h *resolvedHost, ip netip.Addr) bool {
+    if l.ipv6Bits == 0 || !ip.Is6() {
+        return h.contains(ip)
+    }
+    for addr := range h.addrs.Load().(map[netip.Addr]struct{}) {
+        if addr.Is6() && netip.PrefixFrom(addr, l.ipv6Bits).Masked().Addr() == ip {
+            return true
+        }
+    }
+    return false
+}
+
+// truncateIPv6 rewrites the IPv6 entries of the list for clients whose
+// address is truncated to a /bits network: exact addresses and longer
+// prefixes are widened to the /bits network they belong to, and ranges to
+// the networks of their endpoints. IPv4 entries are left untouched.
+func (l *ipList) truncateIPv6(bits int) {
+    l.ipv6Bits = bits
+    truncate := func(ip netip.Addr) netip.Addr {
+        return netip.PrefixFrom(ip, bits).Masked().Addr()
+    }
+
+    ips := make(map[netip.Addr]int, len(l.ips))
+    for ip, index := range l.ips {
+        if ip.Is6() {
+            fmt.Printf("Source IP entry %s is matched as %s/%d (ipv6SubnetLength)\n", ip, truncate(ip), bits)
+            ip = truncate(ip)
+            if prev, dup := ips[ip]; dup && prev < index {
+                continue
+            }
+        }
+        ips[ip] = index
+    }
+    l.ips = ips
+
+    for i, n := range l.nets {
+        if n.Addr().Is6() && n.Bits() > bits {
+            p := netip.PrefixFrom(n.Addr(), bits).Masked()
+            fmt.Printf("Source IP entry %s is matched as %s (ipv6SubnetLength)\n", n.Prefix, p)
+            l.nets[i].Prefix = p
+        }
+    }
+    l.trie = newPrefixTrie(l.nets)
+
+    for i, r := range l.ranges {
+        if r.start.Is6() {
+            l.ranges[i].start = truncate(r.start)
+            l.ranges[i].end = truncate(r.end)
+        }
+    }
+}
+
+// valid reports whether the entry at index has not expired at now.
+func (l *ipList) valid(index int, now time.Time) bool {
+    return l.validUntil == nil || l.validUntil[index].IsZero() || now.Before(l.validUntil[index])
+}
+
+// allowsAll reports whether the list contains an entry covering every IPv4
+// or every IPv6 address, such as 0.0.0.0/0 or ::/0.
+func (l *ipList) allowsAll() bool {
+    for _, n := range l.nets {
+        if n.Bits() == 0 {
+            return true
+        }
+    }
+    for _, r := range l.ranges {
+        if r.start.IsUnspecified() && !r.end.Next().IsValid() {
+            return true
+        }
+    }
+    return false
+}
+
+// describe formats the entry at index for log output, including its label.
+func (l *ipList) describe(index int) string {
+    if l.labels[index] == "" {
+        return l.raw[index]
+    }
+    return fmt.Sprintf("%s (%s)", l.raw[index], l.labels[index])
+}
@@ -0,0 +1,519 @@
+package DomainSentinel
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net"
+    "net/http"
+    "net/netip"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestIPv6SourceIPs(t *testing.T) {
+    tests := []sourceIPCase{
+        {"loopback", []string{"::1"}, "[::1]:1234", http.StatusOK},
+        {"loopback prefix", []string{"::1/128"}, "[::1]:1234", http.StatusOK},
+        {"loopback other address", []string{"::1/128"}, "[::2]:1234", http.StatusForbidden},
+        {"prefix", []string{"2001:db8::/32"}, "[2001:db8:1234::7]:1234", http.StatusOK},
+        {"outside prefix", []string{"2001:db8::/32"}, "[2001:db9::7]:1234", http.StatusForbidden},
+        {"uncompressed entry", []string{"2001:0db8:0000:0000:0000:0000:0000:0001"}, "[2001:db8::1]:1234", http.StatusOK},
+        {"uncompressed client", []string{"2001:db8::1"}, "[2001:0db8:0:0:0:0:0:1]:1234", http.StatusOK},
+        {"upper-case entry", []string{"2001:DB8::/32"}, "[2001:db8::1]:1234", http.StatusOK},
+        {"upper-case client", []string{"2001:db8::/32"}, "[2001:DB8::1]:1234", http.StatusOK},
+        {"zone in entry", []string{"fe80::1%eth0"}, "[fe80::1]:1234", http.StatusOK},
+        {"zone in client", []string{"fe80::1"}, "[fe80::1%eth0]:1234", http.StatusOK},
+        {"mixed list, IPv4 client", []string{"2001:db8::/32", "192.0.2.0/24"}, "192.0.2.9:1234", http.StatusOK},
+        {"mixed list, IPv6 client", []string{"192.0.2.0/24", "2001:db8::/32"}, "[2001:db8::9]:1234", http.StatusOK},
+        {"mixed list, neither", []string{"192.0.2.0/24", "2001:db8::/32"}, "[2001:db9::9]:1234", http.StatusForbidden},
+        {"IPv4 list, IPv6 client", []string{"0.0.0.0/1"}, "[2001:db8::9]:1234", http.StatusForbidden},
+    }
+    runSourceIPCases(t, tests)
+}
+
+type sourceIPCase struct {
+    name       string
+    sourceIPs  []string
+    remoteAddr string
+    want       int
+}
+
+func runSourceIPCases(t *testing.T, tests []sourceIPCase) {
+    t.Helper()
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            handler := newTestSentinel(t, domainConfig("example.com", DomainConfig{SourceIPs: ips(tt.sourceIPs...)}))
+            if got := serve(handler, "http://example.com/", tt.remoteAddr).Code; got != tt.want {
+                t.Errorf("status %d, want %d", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestIPv4MappedAddresses(t *testing.T) {
+    runSourceIPCases(t, []sourceIPCase{
+        {"mapped client, IPv4 address", []string{"203.0.113.7"}, "[::ffff:203.0.113.7]:1234", http.StatusOK},
+        {"mapped client, IPv4 network", []string{"203.0.113.0/24"}, "[::ffff:203.0.113.7]:1234", http.StatusOK},
+        {"mapped client, hex notation", []string{"203.0.113.0/24"}, "[::ffff:cb00:7107]:1234", http.StatusOK},
+        {"mapped client outside network", []string{"203.0.113.0/24"}, "[::ffff:198.51.100.7]:1234", http.StatusForbidden},
+        {"mapped address entry, IPv4 client", []string{"::ffff:203.0.113.7"}, "203.0.113.7:1234", http.StatusOK},
+        {"mapped network entry, IPv4 client", []string{"::ffff:203.0.113.0/120"}, "203.0.113.7:1234", http.StatusOK},
+        {"mapped network entry, mapped client", []string{"::ffff:203.0.113.0/120"}, "[::ffff:203.0.113.7]:1234", http.StatusOK},
+        {"mapped network entry, other client", []string{"::ffff:203.0.113.0/120"}, "198.51.100.7:1234", http.StatusForbidden},
+        {"IPv6 list, mapped client", []string{"2001:db8::/32"}, "[::ffff:203.0.113.7]:1234", http.StatusForbidden},
+        {"mixed list, mapped client", []string{"2001:db8::/32", "203.0.113.0/24"}, "[::ffff:203.0.113.7]:1234", http.StatusOK},
+    })
+}
+
+// Entries are parsed one by one, so whitespace around an entry, or
+// characters a string-level cleanup would have stripped, no longer change
+// what the list matches.
+func TestSourceIPEntriesParsedSeparately(t *testing.T) {
+    runSourceIPCases(t, []sourceIPCase{
+        {"trailing space", []string{"10.0.0.1 ", "10.0.0.2"}, "10.0.0.1:1234", http.StatusOK},
+        {"leading space", []string{" 10.0.0.0/24"}, "10.0.0.9:1234", http.StatusOK},
+        {"tab and newline", []string{"\t10.0.0.1\n"}, "10.0.0.1:1234", http.StatusOK},
+        {"entry after a padded one", []string{"10.0.0.1  ", "192.0.2.0/24"}, "192.0.2.3:1234", http.StatusOK},
+        {"empty entry skipped", []string{"", "10.0.0.1"}, "10.0.0.1:1234", http.StatusOK},
+        {"no merging of neighbours", []string{"10.0.0.1", "10.0.0.2"}, "10.0.0.12:1234", http.StatusForbidden},
+        {"range with spaces", []string{"10.0.0.1 - 10.0.0.5"}, "10.0.0.4:1234", http.StatusOK},
+        {"flattened list", []string{"║24║10.0.0.1║192.0.2.0/24"}, "192.0.2.3:1234", http.StatusOK},
+        {"flattened list, first entry", []string{"║24║10.0.0.1║192.0.2.0/24"}, "10.0.0.1:1234", http.StatusOK},
+    })
+}
+
+func TestSourceIPEntryWithInnerSpaceRejected(t *testing.T) {
+    config := domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.1 10.0.0.2")})
+    if err := ValidateConfig(config); err == nil {
+        t.Error("an entry holding two addresses was accepted")
+    }
+}
+
+func TestSingleLabelHostnames(t *testing.T) {
+    for _, entry := range []string{"gateway", "localhost-proxy", "nas2"} {
+        config := domainConfig("example.com", DomainConfig{SourceIPs: ips(entry)})
+        if err := ValidateConfig(config); err != nil {
+            t.Errorf("%q: %v", entry, err)
+        }
+    }
+    for _, entry := range []string{"-gateway", "gateway-", "42", "gate_way"} {
+        config := domainConfig("example.com", DomainConfig{SourceIPs: ips(entry)})
+        if err := ValidateConfig(config); err == nil {
+            t.Errorf("%q accepted", entry)
+        }
+    }
+    runSourceIPCases(t, []sourceIPCase{
+        {"keyword", []string{"private"}, "10.1.2.3:1234", http.StatusOK},
+        {"keyword in upper case", []string{"PRIVATE"}, "10.1.2.3:1234", http.StatusOK},
+        {"keyword denies others", []string{"private"}, "203.0.113.9:1234", http.StatusForbidden},
+    })
+}
+
+// decodeTestConfig decodes a configuration from JSON, the way Traefik hands
+// over the dynamic configuration.
+func decodeTestConfig(t *testing.T, data string) *Config {
+    t.Helper()
+    config := CreateConfig()
+    if err := json.Unmarshal([]byte(data), config); err != nil {
+        t.Fatalf("decoding %s: %v", data, err)
+    }
+    return config
+}
+
+func TestLabeledSourceIPs(t *testing.T) {
+    for name, data := range map[string]string{
+        "strings only": `{"domainPathRules": {"example.com": {
+            "sourceIPs": ["203.0.113.88/32", "10.0.0.0/8"],
+            "pathRules": [{"path": "/admin", "sourceIPs": ["10.1.0.0/16"]}]}}}`,
+        "objects only": `{"domainPathRules": {"example.com": {
+            "sourceIPs": [{"ip": "203.0.113.88/32", "label": "monitoring-vm"}, {"ip": "10.0.0.0/8"}],
+            "pathRules": [{"path": "/admin", "sourceIPs": [{"ip": "10.1.0.0/16", "label": "admins"}]}]}}}`,
+        "mixed": `{"domainPathRules": {"example.com": {
+            "sourceIPs": [{"ip": "203.0.113.88/32", "label": "monitoring-vm"}, "10.0.0.0/8"],
+            "pathRules": [{"path": "/admin", "sourceIPs": ["10.1.0.0/16"]}]}}}`,
+    } {
+        t.Run(name, func(t *testing.T) {
+            handler := newTestSentinel(t, decodeTestConfig(t, data))
+            checkStatuses(t, handler, []statusCase{
+                {"http://example.com/", "203.0.113.88:1234", http.StatusOK},
+                {"http://example.com/", "203.0.113.89:1234", http.StatusForbidden},
+                {"http://example.com/", "10.9.9.9:1234", http.StatusOK},
+                {"http://example.com/admin", "10.1.2.3:1234", http.StatusOK},
+                {"http://example.com/admin", "10.2.2.3:1234", http.StatusForbidden},
+            })
+        })
+    }
+}
+
+func TestSourceIPObjectErrors(t *testing.T) {
+    for _, data := range []string{
+        `{"domainPathRules": {"example.com": {"sourceIPs": [{"label": "no address"}]}}}`,
+        `{"domainPathRules": {"example.com": {"sourceIPs": [{"ip": "10.0.0.1", "lable": "typo"}]}}}`,
+        `{"domainPathRules": {"example.com": {"sourceIPs": [{"ip": 10}]}}}`,
+        `{"domainPathRules": {"example.com": {"sourceIPs": [42]}}}`,
+    } {
+        if err := ValidateConfig(decodeTestConfig(t, data)); err == nil {
+            t.Errorf("%s: accepted, want an error", data)
+        }
+    }
+}
+
+func TestMatchedEntryLabel(t *testing.T) {
+    c, err := newCompiler(CreateConfig())
+    if err != nil {
+        t.Fatal(err)
+    }
+    entries, err := sourceIPEntries(ips("10.0.0.0/8"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    labeled, err := sourceIPEntries([]interface{}{map[string]interface{}{"ip": "203.0.113.88/32", "label": "monitoring-vm"}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    list, err := c.buildIPList(append(entries, labeled...))
+    if err != nil {
+        t.Fatal(err)
+    }
+    for addr, want := range map[string]string{
+        "203.0.113.88": "203.0.113.88/32 (monitoring-vm)",
+        "10.1.2.3":     "10.0.0.0/8",
+    } {
+        index, ok := list.lookup(netip.MustParseAddr(addr))
+        if !ok {
+            t.Errorf("%s does not match", addr)
+            continue
+        }
+        if got := list.describe(index); got != want {
+            t.Errorf("%s matched %q, want %q", addr, got, want)
+        }
+    }
+}
+
+// The string form of sourceIPs encodes as before, so configurations written
+// by tools keep their shape.
+func TestSourceIPsJSONShape(t *testing.T) {
+    data := `{"sourceIPs":["10.0.0.0/8"],"pathRules":[{"path":"/admin","sourceIPs":["10.1.0.0/16"]}]}`
+    var rule DomainConfig
+    if err := json.Unmarshal([]byte(data), &rule); err != nil {
+        t.Fatal(err)
+    }
+    out, err := json.Marshal(rule)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(out) != data {
+        t.Errorf("re-encoded as %s, want %s", out, data)
+    }
+}
+
+// BenchmarkClientMatch compares the per-request work of net/netip, parsing
+// RemoteAddr and matching the compiled list, with the net package types and
+// string comparison of exact addresses used before, against the same list
+// of 300 networks and 300 addresses.
+func BenchmarkClientMatch(b *testing.B) {
+    cidrs := benchmarkCIDRs(300)
+    addrs := make([]string, 300)
+    for i := range addrs {
+        addrs[i] = fmt.Sprintf("2001:db8::%x", i+1)
+    }
+    const remoteAddr = "[2001:db8::12c]:1234"
+
+    b.Run("netip", func(b *testing.B) {
+        c, err := newCompiler(CreateConfig())
+        if err != nil {
+            b.Fatal(err)
+        }
+        list, err := c.buildIPList(stringEntries(append(append([]string(nil), cidrs...), addrs...)))
+        if err != nil {
+            b.Fatal(err)
+        }
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            ip, ok := parseHostAddr(remoteAddr)
+            if !ok || !list.contains(ip) {
+                b.Fatal("no match")
+            }
+        }
+    })
+    b.Run("net", func(b *testing.B) {
+        nets := make([]*net.IPNet, len(cidrs))
+        for i, cidr := range cidrs {
+            _, n, err := net.ParseCIDR(cidr)
+            if err != nil {
+                b.Fatal(err)
+            }
+            nets[i] = n
+        }
+        exact := make(map[string]bool, len(addrs))
+        for _, addr := range addrs {
+            exact[addr] = true
+        }
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            host, _, err := net.SplitHostPort(remoteAddr)
+            if err != nil {
+                b.Fatal(err)
+            }
+            ip := net.ParseIP(host)
+            found := exact[ip.String()]
+            for j := 0; !found && j < len(nets); j++ {
+                found = nets[j].Contains(ip)
+            }
+            if !found {
+                b.Fatal("no match")
+            }
+        }
+    })
+}
+
+// Every spelling of an entry must give the same decision for every spelling
+// of a client address.
+func TestEquivalentSpellings(t *testing.T) {
+    groups := []struct {
+        entries, inside, outside []string
+    }{
+        {
+            entries: []string{"2001:DB8:0:0::/64", "2001:db8::/64", "2001:0db8:0000:0000::/64", "2001:db8:0:0:0:0:0:0/64", "2001:db8::1/64"},
+            inside:  []string{"2001:db8::1", "2001:DB8::1", "2001:0db8:0000:0000:0000:0000:0000:0001", "2001:db8:0:0:ffff::"},
+            outside: []string{"2001:db8:0:1::1", "2001:db9::1"},
+        },
+        {
+            entries: []string{"2001:db8::1", "2001:DB8::1", "2001:0db8::0001", "2001:db8:0:0:0:0:0:1", "2001:db8::1/128"},
+            inside:  []string{"2001:db8::1", "2001:0DB8:0:0::1"},
+            outside: []string{"2001:db8::2"},
+        },
+        {
+            entries: []string{"1.2.3.4", "::ffff:1.2.3.4", "::FFFF:1.2.3.4", "::ffff:102:304", "0:0:0:0:0:ffff:1.2.3.4", "1.2.3.4/32"},
+            inside:  []string{"1.2.3.4", "::ffff:1.2.3.4", "::ffff:0102:0304"},
+            outside: []string{"1.2.3.5", "::ffff:1.2.3.5", "::1.2.3.4"},
+        },
+        {
+            entries: []string{"10.0.0.0/8", "::ffff:10.0.0.0/104", "10.1.2.3/8"},
+            inside:  []string{"10.200.0.1", "::ffff:10.200.0.1"},
+            outside: []string{"11.0.0.1", "::ffff:11.0.0.1"},
+        },
+    }
+    for _, g := range groups {
+        for _, entry := range g.entries {
+            handler := newTestSentinel(t, domainConfig("example.com", DomainConfig{SourceIPs: ips(entry)}))
+            for _, addr := range g.inside {
+                if got := serve(handler, "http://example.com/", "["+addr+"]:1234").Code; got != http.StatusOK {
+                    t.Errorf("entry %s, client %s: status %d, want 200", entry, addr, got)
+                }
+            }
+            for _, addr := range g.outside {
+                if got := serve(handler, "http://example.com/", "["+addr+"]:1234").Code; got != http.StatusForbidden {
+                    t.Errorf("entry %s, client %s: status %d, want 403", entry, addr, got)
+                }
+            }
+        }
+    }
+}
+
+func TestIPv6SubnetLength(t *testing.T) {
+    handler := newTestSentinel(t, domainConfig("example.com", DomainConfig{
+        IPv6SubnetLength: 64,
+        SourceIPs:        ips("2001:db8:1:2::/64", "2001:db8:9:9::abcd", "192.0.2.1"),
+        DeniedIPs:        []string{"2001:db8:1:3::1"},
+        PathRules:        []PathConfig{{Path: "/admin", SourceIPs: ips("2001:db8:5:5::1")}},
+    }))
+    checkStatuses(t, handler, []statusCase{
+        // Two addresses of the same /64 match the single entry.
+        {"http://example.com/", "[2001:db8:1:2::1]:1234", http.StatusOK},
+        {"http://example.com/", "[2001:db8:1:2:aaaa:bbbb:cccc:dddd]:1234", http.StatusOK},
+        {"http://example.com/", "[2001:db8:1:4::1]:1234", http.StatusForbidden},
+        // An exact-address entry stands for its /64.
+        {"http://example.com/", "[2001:db8:9:9::1]:1234", http.StatusOK},
+        // Denials are widened the same way.
+        {"http://example.com/", "[2001:db8:1:3::2]:1234", http.StatusForbidden},
+        // Path rules of the domain too.
+        {"http://example.com/admin", "[2001:db8:5:5:1:2:3:4]:1234", http.StatusOK},
+        {"http://example.com/admin", "[2001:db8:5:6::1]:1234", http.StatusForbidden},
+        // IPv4 is not affected.
+        {"http://example.com/", "192.0.2.1:1234", http.StatusOK},
+        {"http://example.com/", "192.0.2.2:1234", http.StatusForbidden},
+    })
+
+    exact := newTestSentinel(t, domainConfig("example.com", DomainConfig{SourceIPs: ips("2001:db8:9:9::abcd")}))
+    if got := serve(exact, "http://example.com/", "[2001:db8:9:9::1]:1234").Code; got != http.StatusForbidden {
+        t.Errorf("without ipv6SubnetLength: status %d, want 403", got)
+    }
+}
+
+func TestIPv6SubnetLengthRange(t *testing.T) {
+    for _, length := range []int{-1, 129} {
+        config := domainConfig("example.com", DomainConfig{SourceIPs: ips("2001:db8::/32"), IPv6SubnetLength: length})
+        if err := ValidateConfig(config); err == nil {
+            t.Errorf("ipv6SubnetLength %d accepted", length)
+        }
+    }
+    for _, length := range []int{0, 1, 56, 128} {
+        config := domainConfig("example.com", DomainConfig{SourceIPs: ips("2001:db8::/32"), IPv6SubnetLength: length})
+        if err := ValidateConfig(config); err != nil {
+            t.Errorf("ipv6SubnetLength %d: %v", length, err)
+        }
+    }
+}
+
+func TestDefaultSourceIPs(t *testing.T) {
+    config := CreateConfig()
+    config.IPGroups = map[string][]string{"ops": {"192.0.2.0/24", "2001:db8:0:1::/64"}}
+    config.DefaultSourceIPs = []string{"@ops"}
+    config.DomainPathRules["example.com"] = DomainConfig{
+        SourceIPs: ips("10.0.0.0/8"),
+        DeniedIPs: []string{"192.0.2.66"},
+        PathRules: []PathConfig{{Path: "/admin", SourceIPs: ips("10.1.0.0/16")}},
+    }
+    config.DomainPathRules["acl.example.com"] = DomainConfig{ACL: []string{"deny 192.0.2.0/24", "allow 10.0.0.0/8"}}
+    config.DomainPathRules["own.example.com"] = DomainConfig{SourceIPs: ips("10.0.0.0/8"), InheritDefaults: boolFlag(false)}
+    handler := newTestSentinel(t, config)
+    checkStatuses(t, handler, []statusCase{
+        {"http://example.com/", "192.0.2.1:1234", http.StatusOK},
+        {"http://example.com/", "[2001:db8:0:1::5]:1234", http.StatusOK},
+        {"http://example.com/", "10.2.0.1:1234", http.StatusOK},
+        {"http://example.com/", "198.51.100.1:1234", http.StatusForbidden},
+        // DeniedIPs still take precedence.
+        {"http://example.com/", "192.0.2.66:1234", http.StatusForbidden},
+        // Path rules only get them with defaultSourceIPsForPathRules.
+        {"http://example.com/admin", "192.0.2.1:1234", http.StatusForbidden},
+        // The defaults lead the ACL, ahead of its deny entries.
+        {"http://acl.example.com/", "192.0.2.1:1234", http.StatusOK},
+        {"http://acl.example.com/", "10.2.0.1:1234", http.StatusOK},
+        {"http://own.example.com/", "192.0.2.1:1234", http.StatusForbidden},
+        {"http://own.example.com/", "10.2.0.1:1234", http.StatusOK},
+    })
+
+    config.DefaultSourceIPsForPathRules = true
+    handler = newTestSentinel(t, config)
+    checkStatuses(t, handler, []statusCase{
+        {"http://example.com/admin", "192.0.2.1:1234", http.StatusOK},
+        {"http://example.com/admin", "10.1.0.1:1234", http.StatusOK},
+        {"http://example.com/admin", "10.2.0.1:1234", http.StatusForbidden},
+    })
+}
+
+func TestDefaultSourceIPsErrors(t *testing.T) {
+    for _, entry := range []string{"10.0.0.0/33", "@missing"} {
+        config := domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.1")})
+        config.DefaultSourceIPs = []string{entry}
+        if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+            t.Errorf("defaultSourceIPs entry %q: New succeeded, want an error", entry)
+        }
+    }
+}
+
+func TestIPRangeEntries(t *testing.T) {
+    handler := newTestSentinel(t, domainConfig("example.com", DomainConfig{
+        SourceIPs: ips("192.168.1.10-192.168.1.50", "2001:db8::10 - 2001:db8::1:0"),
+        PathRules: []PathConfig{{Path: "/admin", SourceIPs: ips("10.0.0.255-10.0.1.1")}},
+    }))
+    checkStatuses(t, handler, []statusCase{
+        // Both endpoints are inclusive.
+        {"http://example.com/", "192.168.1.10:1234", http.StatusOK},
+        {"http://example.com/", "192.168.1.50:1234", http.StatusOK},
+        {"http://example.com/", "192.168.1.9:1234", http.StatusForbidden},
+        {"http://example.com/", "192.168.1.51:1234", http.StatusForbidden},
+        {"http://example.com/", "[2001:db8::ffff]:1234", http.StatusOK},
+        {"http://example.com/", "[2001:db8::1:1]:1234", http.StatusForbidden},
+        {"http://example.com/admin", "10.0.1.0:1234", http.StatusOK},
+        {"http://example.com/admin", "10.0.1.2:1234", http.StatusForbidden},
+    })
+
+    for _, entry := range []string{"192.168.1.50-192.168.1.10", "192.168.1.1-2001:db8::1", "192.168.1.1-", "192.168.1.1-192.168.1.300"} {
+        config := domainConfig("example.com", DomainConfig{PathRules: []PathConfig{{Path: "/admin", SourceIPs: ips("10.0.0.1", entry)}}})
+        err := ValidateConfig(config)
+        if err == nil || !strings.Contains(err.Error(), `domainPathRules["example.com"].pathRules[0].sourceIPs[1]`) {
+            t.Errorf("range %q: got %v, want an error naming the domain and entry", entry, err)
+        }
+    }
+}
+
+func TestIPGroups(t *testing.T) {
+    config := CreateConfig()
+    config.IPGroups = map[string][]string{
+        "office": {"192.0.2.0/24", "198.51.100.7"},
+        "vpn":    {"10.8.0.0/16"},
+        "staff":  {"@office", "@vpn"},
+    }
+    config.DomainPathRules["example.com"] = DomainConfig{
+        SourceIPs: ips("@staff"),
+        DeniedIPs: []string{"@vpn"},
+        PathRules: []PathConfig{{Path: "/admin", SourceIPs: ips("@office", "203.0.113.1")}},
+    }
+    config.DomainPathRules["vpn.example.com"] = DomainConfig{SourceIPs: ips("@vpn")}
+    checkStatuses(t, newTestSentinel(t, config), []statusCase{
+        {"http://example.com/", "192.0.2.9:1234", http.StatusOK},
+        {"http://example.com/", "198.51.100.8:1234", http.StatusForbidden},
+        {"http://example.com/", "10.8.0.1:1234", http.StatusForbidden}, // deniedIPs take precedence
+        {"http://example.com/admin", "198.51.100.7:1234", http.StatusOK},
+        {"http://example.com/admin", "203.0.113.1:1234", http.StatusOK},
+        {"http://example.com/admin", "203.0.113.2:1234", http.StatusForbidden},
+        {"http://vpn.example.com/", "10.8.5.5:1234", http.StatusOK},
+        {"http://vpn.example.com/", "192.0.2.9:1234", http.StatusForbidden},
+    })
+
+    tests := []struct {
+        name   string
+        groups map[string][]string
+        want   string
+    }{
+        {"unknown group", map[string][]string{"office": {"192.0.2.0/24"}}, "missing"},
+        {"cycle", map[string][]string{"missing": {"@a"}, "a": {"@b"}, "b": {"@a"}}, "cycle"},
+        {"invalid entry", map[string][]string{"missing": {"192.0.2.0/33"}}, "192.0.2.0/33"},
+    }
+    for _, tt := range tests {
+        config := domainConfig("example.com", DomainConfig{SourceIPs: ips("@missing")})
+        config.IPGroups = tt.groups
+        if _, err := New(context.Background(), okHandler, config, "test"); err == nil || !strings.Contains(err.Error(), tt.want) {
+            t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+        }
+    }
+}
+
+func TestValidUntil(t *testing.T) {
+    // The past entry is written in a zone far ahead of UTC, so its wall clock
+    // reads later than the future one; only the instant must count.
+    future := time.Now().Add(time.Hour).In(time.FixedZone("", -10*3600)).Format(time.RFC3339)
+    past := time.Now().Add(-time.Hour).In(time.FixedZone("", 14*3600)).Format(time.RFC3339)
+    data := fmt.Sprintf(`{"domainPathRules": {"example.com": {"sourceIPs": [
+        {"ip": "192.0.2.1", "label": "contractor", "validUntil": %q},
+        {"ip": "192.0.2.2", "label": "former contractor", "validUntil": %q},
+        "192.0.2.3"]}}}`, future, past)
+
+    var handler http.Handler
+    out := captureOutput(t, func() { handler = newTestSentinel(t, decodeTestConfig(t, data)) })
+    if !strings.Contains(out, `"192.0.2.2" expired`) {
+        t.Errorf("no startup warning for the expired entry in %q", out)
+    }
+    checkStatuses(t, handler, []statusCase{
+        {"http://example.com/", "192.0.2.1:1234", http.StatusOK},
+        {"http://example.com/", "192.0.2.2:1234", http.StatusForbidden},
+        {"http://example.com/", "192.0.2.3:1234", http.StatusOK},
+    })
+
+    // An entry stops matching once its time passes, without a reload.
+    soon := time.Now().Truncate(time.Second).Add(2 * time.Second)
+    handler = newTestSentinel(t, decodeTestConfig(t, fmt.Sprintf(`{"domainPathRules": {"example.com": {"sourceIPs": [
+        {"ip": "192.0.2.1", "validUntil": %q}]}}}`, soon.Format(time.RFC3339))))
+    checkStatuses(t, handler, []statusCase{{"http://example.com/", "192.0.2.1:1234", http.StatusOK}})
+    time.Sleep(time.Until(soon))
+    checkStatuses(t, handler, []statusCase{{"http://example.com/", "192.0.2.1:1234", http.StatusForbidden}})
+
+    entries := []expiringEntry{{entry: "192.0.2.1", label: "contractor", validUntil: time.Now().Add(time.Minute)}}
+    if out := captureOutput(t, func() { logExpired(entries, time.Now()) }); out != "" {
+        t.Errorf("summary before expiry: %q", out)
+    }
+    if out := captureOutput(t, func() { logExpired(entries, time.Now().Add(time.Hour)) }); !strings.Contains(out, "192.0.2.1 (contractor, expired") {
+        t.Errorf("summary after expiry: %q", out)
+    }
+
+    for _, value := range []string{"2024-07-01", "2024-07-01T00:00:00", "next week"} {
+        config := decodeTestConfig(t, fmt.Sprintf(`{"domainPathRules": {"example.com": {"sourceIPs": [{"ip": "192.0.2.1", "validUntil": %q}]}}}`, value))
+        if _, err := New(context.Background(), okHandler, config, "test"); err == nil || !strings.Contains(err.Error(), "validUntil") {
+            t.Errorf("validUntil %q: got %v, want an error", value, err)
+        }
+    }
+}
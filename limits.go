@@ -0,0 +1,142 @@
+package DomainSentinel
+
+import (
+    "fmt"
+    "strconv"
+)
+
+const (
+    limitActionWarn = "warn"
+    limitActionFail = "fail"
+
+    // The default limits are far above any configuration written by hand;
+    // they catch generated ones that ran away.
+    defaultMaxDomains            = 10000
+    defaultMaxPathRulesPerDomain = 1000
+    defaultMaxIPEntriesPerRule   = 10000
+)
+
+// ruleTotals counts the rules of a configuration, for the startup summary.
+type ruleTotals struct {
+    domains, pathRules, ipEntries int
+}
+
+func (t ruleTotals) String() string {
+    return fmt.Sprintf("%d domains, %d path rules, %d IP list entries", t.domains, t.pathRules, t.ipEntries)
+}
+
+// ruleLimits are the soft limits of maxDomains, maxPathRulesPerDomain and
+// maxIPEntriesPerRule.
+type ruleLimits struct {
+    domains, pathRules, ipEntries int
+    fail                          bool // limitAction is fail
+    exceeded                      ConfigErrors
+}
+
+// checkLimits counts the domains, path rules and IP list entries of config
+// and compares them with its limits. Each limit exceeded is logged as a
+// warning, or with limitAction fail returned as an error.
+func checkLimits(config *Config) (ruleTotals, error) {
+    l := &ruleLimits{fail: config.LimitAction == limitActionFail}
+    var err error
+    if l.domains, err = limitOrDefault("maxDomains", config.MaxDomains, defaultMaxDomains); err != nil {
+        return ruleTotals{}, err
+    }
+    if l.pathRules, err = limitOrDefault("maxPathRulesPerDomain", config.MaxPathRulesPerDomain, defaultMaxPathRulesPerDomain); err != nil {
+        return ruleTotals{}, err
+    }
+    if l.ipEntries, err = limitOrDefault("maxIPEntriesPerRule", config.MaxIPEntriesPerRule, defaultMaxIPEntriesPerRule); err != nil {
+        return ruleTotals{}, err
+    }
+    switch config.LimitAction {
+    case "", limitActionWarn, limitActionFail:
+    default:
+        return ruleTotals{}, fmt.Errorf("invalid limitAction %q: must be %q or %q", config.LimitAction, limitActionWarn, limitActionFail)
+    }
+
+    var totals ruleTotals
+    for _, section := range []struct {
+        name  string
+        rules map[string]DomainConfig
+    }{{"domainPathRules", config.DomainPathRules}, {"zones", config.Zones}} {
+        for _, key := range sortedConfigKeys(section.rules) {
+            loc := at()
+            loc.Section, loc.Domain = section.name, key
+            l.domain(loc, section.rules[key], &totals)
+        }
+    }
+    for i, rule := range config.Rules {
+        loc := at()
+        loc.Section, loc.Domain = "rules", strconv.Itoa(i)
+        l.domain(loc, rule, &totals)
+    }
+    if totals.domains > l.domains {
+        l.exceed(at(), fmt.Sprintf("%d domains are configured, more than maxDomains %d", totals.domains, l.domains))
+    }
+    loc := at()
+    loc.Section = "globalPathRules"
+    l.pathRuleCount(loc, len(config.GlobalPathRules))
+    for i, rule := range config.GlobalPathRules {
+        loc.PathIndex = i
+        totals.pathRules++
+        totals.ipEntries += l.pathRule(loc, rule)
+    }
+    if len(l.exceeded) > 0 && l.fail {
+        return totals, l.exceeded
+    }
+    return totals, nil
+}
+
+// limitOrDefault returns the limit of an option, the default if it is 0.
+func limitOrDefault(option string, value, fallback int) (int, error) {
+    switch {
+    case value < 0:
+        return 0, fmt.Errorf("invalid %s %d: must not be negative", option, value)
+    case value == 0:
+        return fallback, nil
+    }
+    return value, nil
+}
+
+// domain counts the domain entry at loc and its path rules.
+func (l *ruleLimits) domain(loc ConfigError, config DomainConfig, totals *ruleTotals) {
+    totals.domains++
+    totals.ipEntries += l.ipEntryCount(loc, len(config.SourceIPs)+len(config.DeniedIPs)+len(config.ExceptIPs))
+    l.pathRuleCount(loc, len(config.PathRules))
+    for i, rule := range config.PathRules {
+        loc.PathIndex = i
+        totals.pathRules++
+        totals.ipEntries += l.pathRule(loc, rule)
+    }
+}
+
+// pathRule checks the IP list entries of the path rule at loc, and returns
+// their number.
+func (l *ruleLimits) pathRule(loc ConfigError, config PathConfig) int {
+    entries := len(config.SourceIPs) + len(config.DeniedIPs) + len(config.ExceptIPs)
+    for _, list := range config.TenantIPs {
+        entries += len(list)
+    }
+    return l.ipEntryCount(loc, entries)
+}
+
+func (l *ruleLimits) pathRuleCount(loc ConfigError, rules int) {
+    if rules > l.pathRules {
+        l.exceed(loc, fmt.Sprintf("%d path rules, more than maxPathRulesPerDomain %d", rules, l.pathRules))
+    }
+}
+
+func (l *ruleLimits) ipEntryCount(loc ConfigError, entries int) int {
+    if entries > l.ipEntries {
+        l.exceed(loc, fmt.Sprintf("%d IP list entries, more than maxIPEntriesPerRule %d", entries, l.ipEntries))
+    }
+    return entries
+}
+
+func (l *ruleLimits) exceed(loc ConfigError, reason string) {
+    loc.Reason = reason
+    if !l.fail {
+        fmt.Printf("Warning: LIMIT EXCEEDED: %s (limitAction=warn, set limitAction: fail to refuse such configurations)\n", loc.Error())
+    }
+    l.exceeded = append(l.exceeded, &loc)
+}
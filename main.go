@@ -5,86 +5,843 @@ import (
     "fmt"
     "net"
     "net/http"
+    "net/netip"
+    "net/url"
     "strings"
+    "sync/atomic"
+    "time"
 )
 
 // Config holds the plugin configuration.
 type Config struct {
+    Version         int                     `json:"version,omitempty"` // Configuration shape, 0 for the legacy shape
     DomainPathRules map[string]DomainConfig `json:"domainPathRules,omitempty"`
+    Rules           []DomainConfig          `json:"rules,omitempty"`           // Ordered alternative to domainPathRules, first match wins
+    Zones           map[string]DomainConfig `json:"zones,omitempty"`           // Rules for a registrable domain and all its subdomains
+    Templates       map[string]DomainConfig `json:"templates,omitempty"`       // Partial rules that entries merge in with extends
+    IPGroups        map[string][]string     `json:"ipGroups,omitempty"`        // Named IP lists, referenced as "@name"
+    Tiers           map[string]TierConfig   `json:"tiers,omitempty"`           // Trust levels, required by rules via minTier
+    OnAddressError  string                  `json:"onAddressError,omitempty"`  // deny, allow or error500
+    TrustedProxies  []string                `json:"trustedProxies,omitempty"`  // Peers whose forwarding headers are used; legacy shape only
+    IPStrategy      IPStrategy              `json:"ipStrategy,omitempty"`      // Which header trusted proxies carry the client in
+    ClientIPHeader  ClientIPHeader          `json:"clientIPHeader,omitempty"`  // Pass the evaluated client address to the backend
+    EmptyListAction string                  `json:"emptyListAction,omitempty"` // denyAll or allowAll
+    HostSource      string                  `json:"hostSource,omitempty"`      // host, xForwardedHost, preferForwarded or sni
+    EmptySNIAction  string                  `json:"emptySNIAction,omitempty"`  // host or deny, for TLS requests without SNI
+    StrictHost      bool                    `json:"strictHost,omitempty"`      // Reject host values that are not valid host names
+    LoosePathPrefix bool                    `json:"loosePathPrefix,omitempty"` // "/api/*" also matches "/apiv2", as before segment-aware matching
+    AuditMode       bool                    `json:"auditMode,omitempty"`       // Log denials instead of enforcing them
+
+    // DebugPath serves the effective configuration as JSON to requests from
+    // DebugSourceIPs, on DebugHost or on every host if that is empty.
+    DebugPath      string   `json:"debugPath,omitempty"`
+    DebugHost      string   `json:"debugHost,omitempty"`
+    DebugSourceIPs []string `json:"debugSourceIPs,omitempty"`
+
+    // DefaultSourceIPs are prepended to the allowlist of every domain that
+    // does not set inheritDefaults: false, and with
+    // DefaultSourceIPsForPathRules to those of its path rules as well.
+    DefaultSourceIPs             []string `json:"defaultSourceIPs,omitempty"`
+    DefaultSourceIPsForPathRules bool     `json:"defaultSourceIPsForPathRules,omitempty"`
+
+    // RulesFile is a JSON file with further domainPathRules, read when the
+    // middleware is created. A relative path is resolved against
+    // RulesBaseDir, or else the working directory of Traefik.
+    RulesFile    string `json:"rulesFile,omitempty"`
+    RulesBaseDir string `json:"rulesBaseDir,omitempty"`
+
+    // RulesFileReloadInterval polls the rulesFile for changes, as a Go
+    // duration ("30s"), and replaces the rules with each valid new version.
+    RulesFileReloadInterval string `json:"rulesFileReloadInterval,omitempty"`
+
+    // RulesURL fetches further domainPathRules over HTTP(S) instead of a
+    // rulesFile, and refreshes them every RulesURLRefreshInterval (default
+    // "5m"). Each request times out after RulesURLTimeout (default "10s").
+    // RulesURLUnavailableAction decides whether an unreachable URL fails
+    // the startup ("fail", default) or starts with the inline rules
+    // ("inline").
+    RulesURL                  string `json:"rulesURL,omitempty"`
+    RulesURLRefreshInterval   string `json:"rulesURLRefreshInterval,omitempty"`
+    RulesURLTimeout           string `json:"rulesURLTimeout,omitempty"`
+    RulesURLBearerToken       string `json:"rulesURLBearerToken,omitempty"`
+    RulesURLUnavailableAction string `json:"rulesURLUnavailableAction,omitempty"`
+
+    // CompressedRules holds the domainPathRules as base64(gzip(JSON)), see
+    // CompressRules, for providers that limit the size of the
+    // configuration. It replaces inline domainPathRules and other sources.
+    CompressedRules string `json:"compressedRules,omitempty"`
+
+    // OverrideRules are layered over the domainPathRules, including those
+    // of a rules source, such as the deltas of one environment to a shared
+    // base; OverrideRulesFile reads them from a JSON file once at startup.
+    // OverrideMode merge (default) extends the base entry of a domain,
+    // replace replaces it.
+    OverrideRules     map[string]DomainConfig `json:"overrideRules,omitempty"`
+    OverrideRulesFile string                  `json:"overrideRulesFile,omitempty"`
+    OverrideMode      string                  `json:"overrideMode,omitempty"`
+
+    // AllowEmptyConfig lets the middleware load without domainPathRules,
+    // zones or globalPathRules, which is otherwise an error.
+    AllowEmptyConfig bool `json:"allowEmptyConfig,omitempty"`
+
+    // NormalizeTrailingSlash lets exact path rules match with or without a
+    // trailing slash ("/admin" and "/admin/"). Domains can override it.
+    NormalizeTrailingSlash bool `json:"normalizeTrailingSlash,omitempty"`
+
+    // DecodeEncodedSlashes treats "%2F" in request paths as a separator when
+    // paths are canonicalized, for backends that decode it.
+    DecodeEncodedSlashes bool `json:"decodeEncodedSlashes,omitempty"`
+
+    // GlobalPathRules are checked for every request to a configured domain
+    // before its own rules, and with GlobalPathRulesForUnconfigured also
+    // for other hosts. Both must allow a request. Domains can skip global
+    // rules by name with skipGlobalRules.
+    GlobalPathRules                []PathConfig `json:"globalPathRules,omitempty"`
+    GlobalPathRulesForUnconfigured bool         `json:"globalPathRulesForUnconfigured,omitempty"`
+
+    // StrictPathPriority rejects path rules of a domain that share a
+    // non-zero priority, whose relative order would depend on specificity.
+    StrictPathPriority bool `json:"strictPathPriority,omitempty"`
+
+    // MergeDuplicateDomains merges the sourceIPs and pathRules of domain
+    // keys that only differ in case, trailing dot or encoding, which are
+    // otherwise an error.
+    MergeDuplicateDomains bool `json:"mergeDuplicateDomains,omitempty"`
+
+    // MaxDomains, MaxPathRulesPerDomain and MaxIPEntriesPerRule are soft
+    // limits against runaway generated configurations, 0 for the defaults.
+    // LimitAction is warn (default) to log a configuration exceeding them,
+    // or fail to refuse it.
+    MaxDomains            int    `json:"maxDomains,omitempty"`
+    MaxPathRulesPerDomain int    `json:"maxPathRulesPerDomain,omitempty"`
+    MaxIPEntriesPerRule   int    `json:"maxIPEntriesPerRule,omitempty"`
+    LimitAction           string `json:"limitAction,omitempty"`
+
+    // Strict rejects domain entries that set no fields, which is what an
+    // entry whose field names are all misspelled decodes to, instead of
+    // warning about them.
+    Strict bool `json:"strict,omitempty"`
+
+    // DenyStatus is the status of denied requests, default 403. Domains and
+    // path rules can override it.
+    DenyStatus int `json:"denyStatus,omitempty"`
+
+    // DefaultAction applies to hosts no domainPathRules key matches: allow
+    // (default) passes them on, deny rejects them with DefaultDenyStatus
+    // (default DenyStatus) and DefaultDenyMessage.
+    DefaultAction      string `json:"defaultAction,omitempty"`
+    DefaultDenyStatus  int    `json:"defaultDenyStatus,omitempty"`
+    DefaultDenyMessage string `json:"defaultDenyMessage,omitempty"`
+
+    // RequireAllowAllConfirmation rejects 0.0.0.0/0 and ::/0 in sourceIPs
+    // unless the domain sets allowAllConfirmed. Off by default so existing
+    // configurations keep loading.
+    RequireAllowAllConfirmation bool `json:"requireAllowAllConfirmation,omitempty"`
+
+    // DNSRefreshInterval and DNSTimeout control how hostnames listed in
+    // source IP lists are re-resolved, as Go durations ("5m", "2s").
+    DNSRefreshInterval string `json:"dnsRefreshInterval,omitempty"`
+    DNSTimeout         string `json:"dnsTimeout,omitempty"`
+
+    // GeoIPDatabase is the path of a MaxMind-format country database used
+    // by allowedCountries and deniedCountries.
+    GeoIPDatabase        string `json:"geoIPDatabase,omitempty"`
+    CountryMatch         string `json:"countryMatch,omitempty"`         // and (default) or or
+    UnknownCountryAction string `json:"unknownCountryAction,omitempty"` // deny (default) or allow
+
+    // PublicSuffixList is the path of a public_suffix_list.dat file that
+    // replaces the built-in subset used to find the registrable domain of
+    // a host for zones.
+    PublicSuffixList string `json:"publicSuffixList,omitempty"`
+
+    // ASNDatabase is the path of a MaxMind-format ASN database used by
+    // allowedASNs and deniedASNs.
+    ASNDatabase      string `json:"asnDatabase,omitempty"`
+    UnknownASNAction string `json:"unknownASNAction,omitempty"` // deny (default) or allow
+
+    // ReverseDNSCacheTTL is how long sourceHostSuffixes verification results
+    // are cached, as a Go duration (default "1h").
+    ReverseDNSCacheTTL      string `json:"reverseDNSCacheTTL,omitempty"`
+    ReverseDNSFailureAction string `json:"reverseDNSFailureAction,omitempty"` // deny (default) or allow
+
+    // Aggregate collapses every IP list to the minimal set of networks
+    // covering the same addresses. Redundant entries are logged either way.
+    Aggregate bool `json:"aggregate,omitempty"`
 }
 
+// Actions for requests to unconfigured domains.
+const (
+    defaultActionAllow = "allow"
+    defaultActionDeny  = "deny"
+)
+
+// Actions for requests whose client address cannot be determined.
+const (
+    addressErrorDeny     = "deny"
+    addressErrorAllow    = "allow"
+    addressErrorError500 = "error500"
+)
+
+// Actions for decisions whose effective allow list is empty.
+const (
+    emptyListDenyAll  = "denyAll"
+    emptyListAllowAll = "allowAll"
+)
+
+// Actions for path rules with tenantIPs and a tenant that is not listed.
+const (
+    unknownTenantDeny      = "deny"
+    unknownTenantSourceIPs = "sourceIPs"
+)
+
 // DomainConfig holds domain-wide source IPs and path-specific configurations.
+// SourceIPs entries are either strings or SourceIP objects.
 type DomainConfig struct {
-    SourceIPs []string     `json:"sourceIPs,omitempty"` // Domain-wide source IPs
-    PathRules []PathConfig `json:"pathRules,omitempty"` // Path-specific rules
+    // Enabled set to false lets all requests for the domain pass unchecked,
+    // keeping the rule in the configuration. Defaults to true.
+    Enabled *bool `json:"enabled,omitempty"`
+
+    SourceIPs       []interface{} `json:"sourceIPs,omitempty"`       // Domain-wide source IPs
+    ACL             []string      `json:"acl,omitempty"`             // Ordered "allow X"/"deny X" entries, instead of SourceIPs
+    ACLDefault      string        `json:"aclDefault,omitempty"`      // deny (default) or allow when no ACL entry matches
+    MinTier         string        `json:"minTier,omitempty"`         // Name of the lowest tier allowed in addition to SourceIPs
+    DeniedIPs       []string      `json:"deniedIPs,omitempty"`       // Domain-wide blocked IPs, checked before any allow list
+    ExceptIPs       []string      `json:"exceptIPs,omitempty"`       // Carved out of the domain-wide SourceIPs only
+    PathRules       []PathConfig  `json:"pathRules,omitempty"`       // Path-specific rules
+    Name            string        `json:"name,omitempty"`            // Shown in decision logs, defaults to the key
+    EmptyListAction string        `json:"emptyListAction,omitempty"` // Overrides the plugin-level emptyListAction
+
+    // AllowAllConfirmed acknowledges that this domain's or its path rules'
+    // sourceIPs intentionally contain 0.0.0.0/0 or ::/0.
+    AllowAllConfirmed *bool `json:"allowAllConfirmed,omitempty"`
+
+    AllowedCountries []string `json:"allowedCountries,omitempty"` // ISO 3166-1 alpha-2 codes
+    DeniedCountries  []string `json:"deniedCountries,omitempty"`
+
+    // SourceHostSuffixes allows clients whose forward-confirmed reverse DNS
+    // name ends in one of the suffixes, in addition to SourceIPs.
+    SourceHostSuffixes []string `json:"sourceHostSuffixes,omitempty"`
+
+    // AllowedASNs and DeniedASNs restrict the whole domain by the client's
+    // autonomous system ("13335" or "AS13335").
+    AllowedASNs []string `json:"allowedASNs,omitempty"`
+    DeniedASNs  []string `json:"deniedASNs,omitempty"`
+
+    AutoBan *AutoBanConfig `json:"autoBan,omitempty"` // Temporarily ban repeatedly denied IPs
+
+    // IPv6SubnetLength matches IPv6 clients by their /n network instead of
+    // their full address. 0 (default) disables truncation.
+    IPv6SubnetLength int `json:"ipv6SubnetLength,omitempty"`
+
+    // IPStrategy replaces the plugin-level ipStrategy and trustedProxies
+    // for this domain and its path rules.
+    IPStrategy *IPStrategy `json:"ipStrategy,omitempty"`
+
+    // ClientIPHeader replaces the plugin-level clientIPHeader for this domain.
+    ClientIPHeader *ClientIPHeader `json:"clientIPHeader,omitempty"`
+
+    // Hosts applies the rule to each of the listed host names, wildcards or
+    // regex patterns instead of the map key, which then only names the rule.
+    Hosts []string `json:"hosts,omitempty"`
+
+    // IncludeSubdomains applies the rule to every subdomain without a more
+    // specific entry. Inherit makes an entry also apply the rules of the
+    // closest such parent, which are checked first.
+    IncludeSubdomains *bool `json:"includeSubdomains,omitempty"`
+    Inherit           *bool `json:"inherit,omitempty"`
+
+    // Priority orders rules of the same precedence, such as regex keys;
+    // higher values win. Defaults to 0.
+    Priority int `json:"priority,omitempty"`
+
+    // MatchApex makes a "*.example.com" key match example.com as well.
+    MatchApex *bool `json:"matchApex,omitempty"`
+
+    // RequireBothAddresses requires the socket address and the client
+    // address from X-Forwarded-For to both pass the rules.
+    RequireBothAddresses *bool `json:"requireBothAddresses,omitempty"`
+
+    // CaseInsensitivePaths matches the path rules of the domain ignoring
+    // case, for backends that treat "/Admin" and "/admin" alike.
+    CaseInsensitivePaths *bool `json:"caseInsensitivePaths,omitempty"`
+
+    // InheritDomainIPs makes path rules without sourceIPs, acl,
+    // sourceHostSuffixes or minTier use those of the domain instead of
+    // emptyListAction. Path rules can override it.
+    InheritDomainIPs *bool `json:"inheritDomainIPs,omitempty"`
+
+    // InheritDefaults: false leaves the plugin-level defaultSourceIPs out
+    // of the domain's lists. Defaults to true.
+    InheritDefaults *bool `json:"inheritDefaults,omitempty"`
+
+    // PublicPaths are paths, in the syntax of PathConfig.Path, that skip
+    // the IP checks of the domain. Matching path rules still apply unless
+    // PublicPathsFirst is set, which also skips inherited rules.
+    PublicPaths      []string `json:"publicPaths,omitempty"`
+    PublicPathsFirst *bool    `json:"publicPathsFirst,omitempty"`
+
+    // MatchRawPath matches the path rules and public paths of the domain
+    // against the path as sent, without decoding or cleaning it, for
+    // backends that route on the escaped form, where "/admin%2Fpanel" is
+    // not "/admin/panel". The rules must then list every encoding the
+    // backend accepts: "/%61dmin" and "/admin/./panel" no longer match a
+    // rule for "/admin/*". Global path rules still see the canonical path.
+    MatchRawPath *bool `json:"matchRawPath,omitempty"`
+
+    // SkipGlobalRules names the globalPathRules that do not apply to the
+    // domain, so that its own rules decide alone.
+    SkipGlobalRules []string `json:"skipGlobalRules,omitempty"`
+
+    // Extends names a template whose fields apply where the entry sets
+    // none; its lists are appended to those of the entry, or replaced by
+    // them with Override.
+    Extends  string `json:"extends,omitempty"`
+    Override bool   `json:"override,omitempty"`
+
+    // AuditOnly logs the denials of the domain instead of enforcing them,
+    // like auditMode does for all domains. Path rules can override it.
+    AuditOnly *bool `json:"auditOnly,omitempty"`
+
+    // DenyStatus overrides the plugin-level denyStatus. Path rules can
+    // override it.
+    DenyStatus int `json:"denyStatus,omitempty"`
+
+    // NormalizeTrailingSlash overrides the plugin-level setting.
+    NormalizeTrailingSlash *bool `json:"normalizeTrailingSlash,omitempty"`
+
+    // Schemes restricts the rule to requests made over the listed schemes
+    // ("http", "https"). Requests over other schemes skip the rule, or are
+    // denied with schemeMismatchAction deny.
+    Schemes              []string `json:"schemes,omitempty"`
+    SchemeMismatchAction string   `json:"schemeMismatchAction,omitempty"` // skip (default) or deny
+
+    // EnforceHostSNIMatch rejects TLS requests whose Host header names a
+    // different host than the TLS server name, with HostSNIMismatchStatus
+    // (default 421). Requests without SNI are only rejected with RequireSNI.
+    EnforceHostSNIMatch   *bool `json:"enforceHostSNIMatch,omitempty"`
+    HostSNIMismatchStatus int   `json:"hostSNIMismatchStatus,omitempty"`
+    RequireSNI            *bool `json:"requireSNI,omitempty"`
 }
 
 // PathConfig holds the path and source IPs for a specific path under a domain.
 type PathConfig struct {
-    Path      string   `json:"path,omitempty"`
-    SourceIPs []string `json:"sourceIPs,omitempty"`
+    Name      string        `json:"name,omitempty"` // Shown in decision logs, defaults to "<domain>/pathRules[<index>]"
+    Path      string        `json:"path,omitempty"`
+    SourceIPs []interface{} `json:"sourceIPs,omitempty"`
+    DeniedIPs []string      `json:"deniedIPs,omitempty"`
+    ExceptIPs []string      `json:"exceptIPs,omitempty"`
+
+    ACL        []string `json:"acl,omitempty"`
+    ACLDefault string   `json:"aclDefault,omitempty"`
+
+    // Schemes and SchemeMismatchAction work as for DomainConfig; a skipped
+    // path rule lets the next matching rule apply.
+    Schemes              []string `json:"schemes,omitempty"`
+    SchemeMismatchAction string   `json:"schemeMismatchAction,omitempty"`
+    // Methods restricts the rule to the listed request methods, ignoring
+    // case. Requests with other methods skip the rule, so the next matching
+    // rule or the domain-wide lists apply.
+    Methods []string `json:"methods,omitempty"`
+    // Query maps query parameters to the value they must have ("*" for
+    // any value) for the rule to apply. A repeated parameter matches if
+    // any of its values does; requests that fail a condition skip the rule.
+    Query map[string]string `json:"query,omitempty"`
+    // ExceptPaths are patterns, in the syntax of Path, of requests that the
+    // rule does not apply to although they match Path. They skip the rule.
+    ExceptPaths []string `json:"exceptPaths,omitempty"`
+    // Priority forces the evaluation order: rules with a higher priority
+    // are tried first, while rules of the same priority (default 0) are
+    // ordered by specificity.
+    Priority int    `json:"priority,omitempty"`
+    MinTier  string `json:"minTier,omitempty"`
+
+    // InheritDomainIPs overrides DomainConfig.InheritDomainIPs.
+    InheritDomainIPs *bool `json:"inheritDomainIPs,omitempty"`
+
+    // AuditOnly overrides DomainConfig.AuditOnly for requests the rule
+    // denies. It cannot enforce a rule while auditMode is on.
+    AuditOnly *bool `json:"auditOnly,omitempty"`
+
+    // DenyStatus overrides DomainConfig.DenyStatus for requests the rule
+    // denies.
+    DenyStatus int `json:"denyStatus,omitempty"`
+
+    // TenantIPs replaces SourceIPs with the list of the tenant named by the
+    // first path segment, which must be a placeholder ("/{tenant}/admin").
+    // Unknown tenants are denied, or checked against SourceIPs with
+    // UnknownTenantAction sourceIPs.
+    TenantIPs           map[string][]string `json:"tenantIPs,omitempty"`
+    UnknownTenantAction string              `json:"unknownTenantAction,omitempty"`
+
+    AllowedCountries []string `json:"allowedCountries,omitempty"`
+    DeniedCountries  []string `json:"deniedCountries,omitempty"`
+
+    SourceHostSuffixes []string `json:"sourceHostSuffixes,omitempty"`
 }
 
 // CreateConfig creates the default plugin configuration.
 func CreateConfig() *Config {
     return &Config{
-        DomainPathRules: make(map[string]DomainConfig),
+        DomainPathRules:         make(map[string]DomainConfig),
+        OnAddressError:          addressErrorDeny,
+        DefaultAction:           defaultActionAllow,
+        EmptyListAction:         emptyListDenyAll,
+        CountryMatch:            countryMatchAnd,
+        UnknownCountryAction:    unknownCountryDeny,
+        UnknownASNAction:        unknownASNDeny,
+        ReverseDNSFailureAction: reverseDNSFailureDeny,
     }
 }
 
 // DomainSentinel middleware struct
 type DomainSentinel struct {
-    next   http.Handler
-    config *Config
-    name   string
+    next    http.Handler
+    config  *Config
+    name    string
+    domains atomic.Value // *domainTable, replaced when the rulesFile or rulesURL is reloaded
+
+    global             *compiledDomain // globalPathRules, nil if none
+    globalUnconfigured bool            // globalPathRulesForUnconfigured
+
+    onAddressError string
+    auditMode      bool // denials are logged, and the request is passed on
+
+    debug *debugEndpoint // nil unless debugPath is set
+
+    geo                 *geoDB
+    countryMatchOr      bool // countryMatch is "or"
+    allowUnknownCountry bool // unknownCountryAction is "allow"
+
+    asn             *asnDB
+    allowUnknownASN bool // unknownASNAction is "allow"
+
+    rdns                  *rdnsVerifier
+    allowOnReverseDNSFail bool // reverseDNSFailureAction is "allow"
+
+    tiers []*tier // highest level first
+
+    ipStrategy     *clientIPStrategy
+    clientIPHeader string // set on requests to unconfigured domains, if not empty
+    hostSource     string
+    denyEmptySNI   bool // emptySNIAction is "deny"
+    strictHost     bool
+    decodeSlashes  bool // decodeEncodedSlashes
+
+    denyStatus       int  // denyStatus, or 403
+    denyUnconfigured bool // defaultAction is deny
+    defaultStatus    int
+    defaultMessage   string
 }
 
-// New creates a new DomainSentinel middleware.
+// New creates a new DomainSentinel middleware. Its errors are a ConfigError
+// or ConfigErrors, see there.
 func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-    return &DomainSentinel{
-        next:   next,
-        config: config,
-        name:   name,
-    }, nil
+    ds, err := newDomainSentinel(ctx, next, config, name, false)
+    if err != nil {
+        return nil, asConfigError(err)
+    }
+    return ds, nil
 }
 
-func (ds *DomainSentinel) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-    fmt.Println("Plugin: DomainSentinel")
-    host := req.Host
-    var requestedDomain string
+// ValidateConfig checks config as New does, for checks before deployment:
+// it runs the same validation and compilation and returns the same errors,
+// in the same order on every run. It serves no requests, starts no
+// background tasks and resolves no hostnames, and it does not fetch a
+// rulesURL, whose options are checked alone. A rulesFile is read.
+func ValidateConfig(config *Config) error {
+    if _, err := newDomainSentinel(context.Background(), nil, config, "ValidateConfig", true); err != nil {
+        return asConfigError(err)
+    }
+    return nil
+}
 
-    // Handle host with or without port
-    if strings.Contains(host, ":") {
-        var err error
-        requestedDomain, _, err = net.SplitHostPort(host)
-        if err != nil {
-            requestedDomain = host
+// newDomainSentinel implements New and, with validateOnly, ValidateConfig.
+func newDomainSentinel(ctx context.Context, next http.Handler, config *Config, name string, validateOnly bool) (*DomainSentinel, error) {
+    config, err := migrateConfig(config, name)
+    if err != nil {
+        return nil, err
+    }
+    if config, err = expandEnv(config); err != nil {
+        return nil, err
+    }
+    if config, err = loadOverrides(config); err != nil {
+        return nil, err
+    }
+    source, err := newRulesSource(config)
+    if err != nil {
+        return nil, err
+    }
+    inline := config
+    var sourceRules map[string]DomainConfig
+    if source != nil && validateOnly && config.RulesURL != "" {
+        // Only the inline rules are checked, as when the URL is unavailable.
+        fallback := *config
+        fallback.AllowEmptyConfig = true
+        config = &fallback
+    } else if source != nil {
+        rules, _, err := source.fetch(ctx)
+        switch {
+        case err == nil:
+            if config, err = mergeRules(inline, rules, source.name); err != nil {
+                return nil, err
+            }
+            sourceRules = rules
+        case config.RulesURLUnavailableAction == rulesURLUnavailableInline:
+            fmt.Printf("Warning: %s cannot be loaded, starting with the inline rules: %v\n", source.name, err)
+            // The rules of the source arrive with the first successful refresh.
+            fallback := *config
+            fallback.AllowEmptyConfig = true
+            config = &fallback
+        default:
+            return nil, err
         }
-    } else {
-        requestedDomain = host
     }
+    if config, err = applyOverrides(config); err != nil {
+        return nil, err
+    }
+    if config, err = applyTemplates(config); err != nil {
+        return nil, err
+    }
+    if config, err = mergeDuplicateDomains(config); err != nil {
+        return nil, err
+    }
+    if err := validateConfig(config); err != nil {
+        return nil, err
+    }
+    totals, err := checkLimits(config)
+    if err != nil {
+        return nil, err
+    }
+    c, err := newCompiler(config)
+    if err != nil {
+        return nil, err
+    }
+    suffixes, err := newSuffixList(builtinPublicSuffixes)
+    if err != nil {
+        return nil, err
+    }
+    if config.PublicSuffixList != "" {
+        if suffixes, err = loadSuffixList(config.PublicSuffixList); err != nil {
+            return nil, fmt.Errorf("publicSuffixList: %w", err)
+        }
+        if len(config.Zones) == 0 {
+            fmt.Println("Warning: publicSuffixList is only used by zones, and none are configured")
+        }
+    }
+    global, err := c.compileGlobalPathRules(config.GlobalPathRules)
+    if err != nil {
+        return nil, err
+    }
+    debug, err := c.compileDebugEndpoint(config)
+    if err != nil {
+        return nil, err
+    }
+    rules, err := c.fork().compileRuleSet(config, suffixes, global)
+    if err != nil {
+        return nil, err
+    }
+    if config.GlobalPathRulesForUnconfigured && global == nil {
+        fmt.Println("Warning: globalPathRulesForUnconfigured is set, but there are no globalPathRules")
+    }
+
+    dnsRefreshInterval, err := parseDuration("dnsRefreshInterval", config.DNSRefreshInterval, defaultDNSRefreshInterval)
+    if err != nil {
+        return nil, err
+    }
+    onAddressError := config.OnAddressError
+    switch onAddressError {
+    case "":
+        onAddressError = addressErrorDeny
+    case addressErrorDeny, addressErrorAllow, addressErrorError500:
+    default:
+        return nil, fmt.Errorf("invalid onAddressError %q: must be %q, %q or %q",
+            onAddressError, addressErrorDeny, addressErrorAllow, addressErrorError500)
+    }
+
+    switch config.CountryMatch {
+    case "", countryMatchAnd, countryMatchOr:
+    default:
+        return nil, fmt.Errorf("invalid countryMatch %q: must be %q or %q", config.CountryMatch, countryMatchAnd, countryMatchOr)
+    }
+    switch config.UnknownCountryAction {
+    case "", unknownCountryDeny, unknownCountryAllow:
+    default:
+        return nil, fmt.Errorf("invalid unknownCountryAction %q: must be %q or %q",
+            config.UnknownCountryAction, unknownCountryDeny, unknownCountryAllow)
+    }
+
+    switch config.UnknownASNAction {
+    case "", unknownASNDeny, unknownASNAllow:
+    default:
+        return nil, fmt.Errorf("invalid unknownASNAction %q: must be %q or %q",
+            config.UnknownASNAction, unknownASNDeny, unknownASNAllow)
+    }
+
+    switch config.ReverseDNSFailureAction {
+    case "", reverseDNSFailureDeny, reverseDNSFailureAllow:
+    default:
+        return nil, fmt.Errorf("invalid reverseDNSFailureAction %q: must be %q or %q",
+            config.ReverseDNSFailureAction, reverseDNSFailureDeny, reverseDNSFailureAllow)
+    }
+
+    hostSource := config.HostSource
+    switch hostSource {
+    case "":
+        hostSource = hostSourceHost
+    case hostSourceHost, hostSourceXForwardedHost, hostSourcePreferForwarded, hostSourceSNI:
+    default:
+        return nil, fmt.Errorf("invalid hostSource %q: must be %q, %q, %q or %q",
+            hostSource, hostSourceHost, hostSourceXForwardedHost, hostSourcePreferForwarded, hostSourceSNI)
+    }
+    switch config.EmptySNIAction {
+    case "", emptySNIHost, emptySNIDeny:
+    default:
+        return nil, fmt.Errorf("invalid emptySNIAction %q: must be %q or %q", config.EmptySNIAction, emptySNIHost, emptySNIDeny)
+    }
+    if config.EmptySNIAction != "" && hostSource != hostSourceSNI {
+        fmt.Printf("Warning: emptySNIAction %q has no effect without hostSource %q\n", config.EmptySNIAction, hostSourceSNI)
+    }
+
+    switch config.DefaultAction {
+    case "", defaultActionAllow, defaultActionDeny:
+    default:
+        return nil, fmt.Errorf("invalid defaultAction %q: must be %q or %q", config.DefaultAction, defaultActionAllow, defaultActionDeny)
+    }
+    if err := checkDenyStatus("denyStatus", config.DenyStatus); err != nil {
+        return nil, err
+    }
+    denyStatus := config.DenyStatus
+    if denyStatus == 0 {
+        denyStatus = http.StatusForbidden
+    }
+    if err := checkDenyStatus("defaultDenyStatus", config.DefaultDenyStatus); err != nil {
+        return nil, err
+    }
+    defaultStatus := config.DefaultDenyStatus
+    if defaultStatus == 0 {
+        defaultStatus = denyStatus
+    }
+    defaultMessage := config.DefaultDenyMessage
+    if defaultMessage == "" {
+        defaultMessage = "DS: " + http.StatusText(defaultStatus)
+    }
+    denyUnconfigured := config.DefaultAction == defaultActionDeny
+    switch {
+    case rules.table.fallback != nil:
+        fmt.Printf("DomainSentinel %s: unconfigured domains use the catch-all rule %q", name, catchAllKey)
+        if denyUnconfigured {
+            fmt.Print(", defaultAction=deny has no effect")
+        }
+        fmt.Println()
+    case denyUnconfigured:
+        fmt.Printf("DomainSentinel %s: requests for unconfigured domains are DENIED with %d (defaultAction=deny)\n", name, defaultStatus)
+    default:
+        fmt.Printf("DomainSentinel %s: requests for unconfigured domains are ALLOWED without checks (defaultAction=allow)\n", name)
+    }
+    fmt.Printf("DomainSentinel %s: loaded %s\n", name, totals)
+    if config.AuditMode {
+        fmt.Printf("DomainSentinel %s: AUDIT MODE is on, denials are logged but NOT enforced (auditMode=true)\n", name)
+    }
+
+    ds := &DomainSentinel{
+        next:                  next,
+        config:                config,
+        name:                  name,
+        global:                global,
+        globalUnconfigured:    config.GlobalPathRulesForUnconfigured,
+        onAddressError:        onAddressError,
+        auditMode:             config.AuditMode,
+        debug:                 debug,
+        geo:                   c.geo,
+        countryMatchOr:        config.CountryMatch == countryMatchOr,
+        allowUnknownCountry:   config.UnknownCountryAction == unknownCountryAllow,
+        asn:                   c.asn,
+        allowUnknownASN:       config.UnknownASNAction == unknownASNAllow,
+        rdns:                  c.rdns,
+        allowOnReverseDNSFail: config.ReverseDNSFailureAction == reverseDNSFailureAllow,
+        tiers:                 c.tiers,
+        ipStrategy:            c.ipStrategy,
+        clientIPHeader:        c.clientIPHeader,
+        hostSource:            hostSource,
+        denyEmptySNI:          config.EmptySNIAction == emptySNIDeny,
+        strictHost:            config.StrictHost,
+        decodeSlashes:         config.DecodeEncodedSlashes,
+        denyStatus:            denyStatus,
+        denyUnconfigured:      denyUnconfigured,
+        defaultStatus:         defaultStatus,
+        defaultMessage:        defaultMessage,
+    }
+    ds.domains.Store(rules.table)
+    if validateOnly {
+        return ds, nil
+    }
+    if len(c.hosts.hosts) > 0 {
+        c.hosts.resolveAll(ctx)
+        go c.hosts.refreshLoop(ctx, dnsRefreshInterval)
+    }
+    if len(c.expiring) > 0 {
+        go reportExpired(ctx, c.expiring, expiryReportInterval)
+    }
+    rules.start(ctx, dnsRefreshInterval)
+    if source != nil && source.interval > 0 {
+        reloader := &rulesReloader{
+            config:             inline,
+            source:             source,
+            rules:              sourceRules,
+            compiler:           c,
+            suffixes:           suffixes,
+            global:             global,
+            dnsRefreshInterval: dnsRefreshInterval,
+        }
+        go reloader.watch(ctx, ds, rules)
+        fmt.Printf("Watching %s for changes every %s\n", source.name, source.interval)
+    }
+    return ds, nil
+}
 
-    fmt.Println("Requested Domain:", requestedDomain)
+// table returns the current domain table.
+func (ds *DomainSentinel) table() *domainTable {
+    return ds.domains.Load().(*domainTable)
+}
+
+func (ds *DomainSentinel) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+    fmt.Println("Plugin: DomainSentinel")
+    requestedDomain, requestedPort, hostSource, err := ds.requestHost(req)
+    if err != nil {
+        fmt.Println("Rejecting request:", err)
+        http.Error(rw, "DS: Bad Request", http.StatusBadRequest)
+        return
+    }
+    fmt.Printf("Requested Domain: %s (from %s)\n", requestedDomain, hostSource)
+    if ds.debug != nil && ds.serveDebug(rw, req, requestedDomain) {
+        return
+    }
 
-    // Allow request if domain is not found in the configuration. 
-    domainConfig, domainExists := ds.config.DomainPathRules[requestedDomain]
+    // Apply defaultAction if the domain is not found in the configuration.
+    domainConfig, domainKey, domainExists := ds.table().lookup(requestedDomain, requestedPort)
     if !domainExists {
-        fmt.Println("No config found for domain:", requestedDomain)
+        if ds.denyUnconfigured {
+            fmt.Println("No config found for domain, denying request (defaultAction=deny):", requestedDomain)
+            if !ds.audited(false, ds.defaultStatus, requestedDomain, req.URL.Path, req.RemoteAddr, "defaultAction=deny") {
+                http.Error(rw, ds.defaultMessage, ds.defaultStatus)
+                return
+            }
+        } else {
+            fmt.Println("No config found for domain:", requestedDomain)
+            if ds.global != nil && ds.globalUnconfigured && !ds.checkUnconfigured(rw, req, requestedDomain) {
+                return
+            }
+        }
+        if ds.clientIPHeader != "" {
+            // Nothing was evaluated, but a client must not be able to pre-fill the header.
+            req.Header.Del(ds.clientIPHeader)
+        }
         ds.next.ServeHTTP(rw, req)
         return
     }
+    if domainKey == catchAllKey {
+        fmt.Printf("No rule for domain %s, applying the fallback rule %q\n", requestedDomain, catchAllKey)
+    } else if domainKey != requestedDomain {
+        fmt.Println("Matched domain rule:", domainKey)
+    }
+    if domainConfig.disabled {
+        fmt.Printf("Skipping rule %q for domain %s: the rule is disabled (enabled=false)\n", domainConfig.name, requestedDomain)
+        if domainConfig.clientIPHeader != "" {
+            req.Header.Del(domainConfig.clientIPHeader)
+        }
+        ds.next.ServeHTTP(rw, req)
+        return
+    }
+    scheme := ds.requestScheme(req)
+    if !domainConfig.schemes.allows(scheme) {
+        if domainConfig.schemes.deny {
+            fmt.Printf("Rejecting %s request: rule %q only applies to %s (schemeMismatchAction=deny)\n", scheme, domainConfig.name, domainConfig.schemes)
+            status := ds.denyStatusOf(domainConfig, nil)
+            if !ds.audited(domainConfig.auditOnly, status, domainKey, req.URL.Path, req.RemoteAddr, domainConfig.name) {
+                http.Error(rw, "DS: "+http.StatusText(status), status)
+                return
+            }
+        } else {
+            fmt.Printf("Skipping rule %q for %s request: it only applies to %s\n", domainConfig.name, scheme, domainConfig.schemes)
+        }
+        if domainConfig.clientIPHeader != "" {
+            req.Header.Del(domainConfig.clientIPHeader)
+        }
+        ds.next.ServeHTTP(rw, req)
+        return
+    }
+    if domainConfig.enforceSNI {
+        if host, sni, mismatch := hostSNIMismatch(req, domainConfig.requireSNI); mismatch {
+            fmt.Printf("Rejecting request: Host %q does not match TLS server name %q\n", host, sni)
+            if !ds.audited(domainConfig.auditOnly, domainConfig.sniMismatchStatus, domainKey, req.URL.Path, req.RemoteAddr, domainConfig.name+" (enforceSNI)") {
+                http.Error(rw, "DS: "+http.StatusText(domainConfig.sniMismatchStatus), domainConfig.sniMismatchStatus)
+                return
+            }
+            if domainConfig.clientIPHeader != "" {
+                req.Header.Del(domainConfig.clientIPHeader)
+            }
+            ds.next.ServeHTTP(rw, req)
+            return
+        }
+    }
 
-    fmt.Println("SourceIPs: ", domainConfig.SourceIPs)
-    fmt.Println("Requested Path: ", req.URL.Path)
+    path, err := canonicalPath(req.URL.EscapedPath(), ds.decodeSlashes)
+    if err != nil {
+        fmt.Printf("Rejecting request for path %q: %v\n", req.URL.EscapedPath(), err)
+        http.Error(rw, "DS: Bad Request", http.StatusBadRequest)
+        return
+    }
 
-    // Check the path-specific rules first
-    for _, pathRule := range domainConfig.PathRules {
-        fmt.Println("Configured Path: ", pathRule.Path)
-        if isPathAllowed(req.URL.Path, pathRule.Path) {
-            fmt.Println("Path matches")
-            fmt.Println("SourceIPs: ", pathRule.SourceIPs)
-            if !ds.isIPAllowed(req, pathRule.SourceIPs) {
-                http.Error(rw, "DS: Forbidden", http.StatusForbidden)
+    socketIP, err := clientIP(req)
+    if err != nil {
+        ds.handleAddressError(rw, req, domainConfig.clientIPHeader, err)
+        return
+    }
+    strategy := ds.ipStrategy
+    if domainConfig.ipStrategy != nil {
+        strategy = domainConfig.ipStrategy
+    }
+    ip, source, status := strategy.clientAddr(req, socketIP)
+    if status != 0 {
+        fmt.Printf("Rejecting request from %s: %s\n", socketIP, source)
+        http.Error(rw, "DS: "+http.StatusText(status), status)
+        return
+    }
+    fmt.Printf("Client IP: %s (%s)\n", ip, source)
+    if domainConfig.clientIPHeader != "" {
+        req.Header.Set(domainConfig.clientIPHeader, ip.String())
+    }
+
+    client := &clientInfo{ip: ip, listIP: domainConfig.listAddr(ip), ctx: req.Context()}
+    var forwarded *clientInfo
+    if domainConfig.requireBoth && ip != socketIP {
+        // Check the socket address and the forwarded address separately.
+        // Only an address derived from a trusted proxy counts as forwarded;
+        // otherwise the socket address alone decides, and denials are
+        // never counted against a header the peer made up.
+        client = &clientInfo{ip: socketIP, listIP: domainConfig.listAddr(socketIP), ctx: req.Context()}
+        forwarded = &clientInfo{ip: ip, listIP: domainConfig.listAddr(ip), ctx: req.Context()}
+    }
+
+    // Banned clients are rejected before any rule is evaluated.
+    if domainConfig.bans != nil {
+        now := time.Now()
+        var banned *clientInfo
+        which := "client"
+        switch {
+        case domainConfig.bans.banned(client.listIP, now):
+            banned = client
+            if forwarded != nil {
+                which = "socket address"
+            }
+        case forwarded != nil && domainConfig.bans.banned(forwarded.listIP, now):
+            banned, which = forwarded, "forwarded address"
+        }
+        if banned != nil {
+            fmt.Printf("Rejecting banned %s %s (autoBan of rule %s)\n", which, banned.listIP, domainConfig.name)
+            status := ds.denyStatusOf(domainConfig, nil)
+            if !ds.audited(domainConfig.auditOnly, status, domainKey, path, banned.ip.String(), domainConfig.name+" (autoBan)") {
+                http.Error(rw, "DS: "+http.StatusText(status), status)
                 return
             }
             ds.next.ServeHTTP(rw, req)
@@ -92,64 +849,326 @@ func (ds *DomainSentinel) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
         }
     }
 
-    // If no path-specific rules matched, check the domain-wide rules
-    if !ds.isIPAllowed(req, domainConfig.SourceIPs) {
-        http.Error(rw, "DS: Forbidden", http.StatusForbidden)
+    if ds.global != nil {
+        if denied := ds.checkGlobal(domainConfig.skipGlobal, path, scheme, req, client, forwarded); denied != nil {
+            ds.deny(rw, req, domainKey, path, domainConfig, denied)
+            return
+        }
+    }
+    if domainConfig.rawPath {
+        path = rawRequestPath(req)
+        fmt.Println("Matching raw path (matchRawPath):", path)
+    }
+    if denied := ds.check(domainConfig, path, scheme, req.Method, req.URL.Query(), client, forwarded); denied != nil {
+        ds.deny(rw, req, domainKey, path, domainConfig, denied)
         return
     }
 
     ds.next.ServeHTTP(rw, req)
 }
 
-// isPathAllowed checks if the request path matches any allowed path patterns.
-func isPathAllowed(reqPath string, pathPattern string) bool {
-    if strings.HasSuffix(pathPattern, "/*") {
-        basePath := strings.TrimSuffix(pathPattern, "/*")
-        if strings.HasPrefix(reqPath, basePath) {
-            return true
+// check evaluates the rules of cd for a request path, scheme, method and
+// query and returns the client that was denied, or nil if the request is
+// allowed. The rules of the parent a domain inherits from are checked first.
+func (ds *DomainSentinel) check(cd *compiledDomain, path, scheme, method string, query url.Values, client, forwarded *clientInfo) *clientInfo {
+    matchPath := path
+    if cd.foldPaths {
+        matchPath = strings.ToLower(path)
+    }
+    if cd.publicPathsFirst {
+        if public := cd.publicPath(matchPath); public != "" {
+            fmt.Printf("Public path matches: %s, skipping IP checks (publicPathsFirst)\n", public)
+            return decided(cd.name, client, nil)
         }
-    } else if reqPath == pathPattern {
-        return true
     }
-    return false
+
+    switch parent := cd.parent; {
+    case parent == nil:
+    case parent.disabled:
+        fmt.Printf("Skipping inherited rules of %s: the rule is disabled (enabled=false)\n", cd.parentKey)
+    case !parent.schemes.allows(scheme) && parent.schemes.deny:
+        fmt.Printf("Rejecting %s request: inherited rule %q only applies to %s (schemeMismatchAction=deny)\n", scheme, cd.parentKey, parent.schemes)
+        return decided(parent.name, client, client)
+    case !parent.schemes.allows(scheme):
+        fmt.Printf("Skipping inherited rules of %s for %s request: they only apply to %s\n", cd.parentKey, scheme, parent.schemes)
+    default:
+        fmt.Println("Checking inherited rules of", cd.parentKey)
+        pc, pf := parent.clientFor(client), parent.clientFor(forwarded)
+        switch denied := ds.check(parent, path, scheme, method, query, pc, pf); denied {
+        case nil:
+        case pc:
+            return client.deniedAs(denied)
+        default:
+            return forwarded.deniedAs(denied)
+        }
+    }
+
+    fmt.Println("SourceIPs: ", cd.sourceIPs.raw)
+    fmt.Println("Requested Path: ", path)
+    path = matchPath
+
+    // Check the path-specific rules first
+    if denied, matched := ds.checkPathRules(cd, path, scheme, method, query, client, forwarded, nil); matched {
+        return denied
+    }
+
+    if public := cd.publicPath(path); public != "" {
+        fmt.Printf("Public path matches: %s, skipping IP checks\n", public)
+        return decided(cd.name, client, nil)
+    }
+
+    // If no path-specific rules matched, check the domain-wide rules
+    return decided(cd.name, client, ds.evaluate(cd, &cd.accessRule, client, forwarded))
+}
+
+// checkGlobal evaluates the global path rules, except those in skip, and
+// returns the client that was denied, or nil if the request may proceed.
+func (ds *DomainSentinel) checkGlobal(skip map[string]bool, path, scheme string, req *http.Request, client, forwarded *clientInfo) *clientInfo {
+    fmt.Println("Checking", globalRulesOwner)
+    gc, gf := ds.global.clientFor(client), ds.global.clientFor(forwarded)
+    switch denied, _ := ds.checkPathRules(ds.global, path, scheme, req.Method, req.URL.Query(), gc, gf, skip); denied {
+    case nil:
+        return nil
+    case gc:
+        return client.deniedAs(denied)
+    default:
+        return forwarded.deniedAs(denied)
+    }
 }
 
-func (ds *DomainSentinel) isIPAllowed(req *http.Request, allowedIPs []string) bool {
-    ip, _, err := net.SplitHostPort(req.RemoteAddr)
+// checkUnconfigured applies the global path rules to a request for a host
+// without configuration, using the plugin-level ipStrategy. It reports
+// whether the request may proceed; otherwise it has been answered.
+func (ds *DomainSentinel) checkUnconfigured(rw http.ResponseWriter, req *http.Request, domain string) bool {
+    path, err := canonicalPath(req.URL.EscapedPath(), ds.decodeSlashes)
     if err != nil {
-        fmt.Println("Error splitting host and port: ", err)
+        fmt.Printf("Rejecting request for path %q: %v\n", req.URL.EscapedPath(), err)
+        http.Error(rw, "DS: Bad Request", http.StatusBadRequest)
         return false
     }
+    socketIP, err := clientIP(req)
+    if err != nil {
+        ds.handleAddressError(rw, req, ds.clientIPHeader, err)
+        return false
+    }
+    ip, source, status := ds.ipStrategy.clientAddr(req, socketIP)
+    if status != 0 {
+        fmt.Printf("Rejecting request from %s: %s\n", socketIP, source)
+        http.Error(rw, "DS: "+http.StatusText(status), status)
+        return false
+    }
+    fmt.Printf("Client IP: %s (%s)\n", ip, source)
+    client := &clientInfo{ip: ip, listIP: ip, ctx: req.Context()}
+    if denied := ds.checkGlobal(nil, path, ds.requestScheme(req), req, client, nil); denied != nil {
+        status := ds.denyStatusOf(nil, denied)
+        if !ds.audited(auditOnly(nil, denied), status, domain, path, denied.ip.String(), denied.deniedBy) {
+            http.Error(rw, "DS: "+http.StatusText(status), status)
+            return false
+        }
+    }
+    return true
+}
+
+// checkPathRules evaluates the first path rule of cd that applies to the
+// request, skipping the rules named in skip. matched is false if none does.
+func (ds *DomainSentinel) checkPathRules(cd *compiledDomain, path, scheme, method string, query url.Values, client, forwarded *clientInfo,
+    skip map[string]bool) (denied *clientInfo, matched bool) {
+    for _, i := range cd.router.candidates(path) {
+        pathRule := &cd.pathRules[i]
+        fmt.Println("Configured Path: ", pathRule.path)
+        if ok, params := pathRule.matches(path); ok {
+            if skip[pathRule.name] {
+                fmt.Printf("Skipping path rule %s: the domain overrides it (skipGlobalRules)\n", pathRule.name)
+                continue
+            }
+            if len(params) > 0 {
+                fmt.Printf("Path matches: %s (rule %s, %s)\n", pathRule.path, pathRule.name, strings.Join(params, ", "))
+            } else {
+                fmt.Printf("Path matches: %s (rule %s)\n", pathRule.path, pathRule.name)
+            }
+            if except := pathRule.exception(path); except != "" {
+                fmt.Printf("Skipping path rule %s: the path matches its exceptPaths entry %s\n", pathRule.name, except)
+                continue
+            }
+            if !pathRule.methods.allows(method) {
+                fmt.Printf("Skipping path rule %s for %s request: it only applies to %s\n", pathRule.name, method, pathRule.methods)
+                continue
+            }
+            if len(pathRule.query) > 0 {
+                if !pathRule.query.holds(query) {
+                    fmt.Printf("Skipping path rule %s: query conditions %s do not hold\n", pathRule.name, pathRule.query)
+                    continue
+                }
+                fmt.Printf("Query conditions hold: %s\n", pathRule.query)
+            }
+            if !pathRule.schemes.allows(scheme) {
+                if pathRule.schemes.deny {
+                    fmt.Printf("Rejecting %s request: path rule %s only applies to %s (schemeMismatchAction=deny)\n", scheme, pathRule.name, pathRule.schemes)
+                    return decidedPath(pathRule, client, client), true
+                }
+                fmt.Printf("Skipping path rule %s for %s request: it only applies to %s\n", pathRule.name, scheme, pathRule.schemes)
+                continue
+            }
+            rule := &pathRule.accessRule
+            if pathRule.tenants != nil {
+                tenant := firstSegment(path)
+                if t, ok := pathRule.tenants[tenant]; ok {
+                    fmt.Printf("Tenant %q: checking its tenantIPs\n", tenant)
+                    rule = t
+                } else if pathRule.denyUnknownTenant {
+                    fmt.Printf("Unknown tenant %q, denying access (unknownTenantAction=deny)\n", tenant)
+                    return decidedPath(pathRule, client, client), true
+                } else {
+                    fmt.Printf("Unknown tenant %q, checking the rule's sourceIPs (unknownTenantAction=sourceIPs)\n", tenant)
+                }
+            }
+            fmt.Println("SourceIPs: ", rule.sourceIPs.raw)
+            return decidedPath(pathRule, client, ds.evaluate(cd, rule, client, forwarded)), true
+        }
+    }
+    return nil, false
+}
 
-    allowedIPsString := fmt.Sprint(allowedIPs)
-    cleanedAllowedIPs := cleanCIDR(allowedIPsString)
-    cleanedAllowedIPsArray := strings.Split(strings.Trim(cleanedAllowedIPs, "[]"), " ")
+// decided logs the outcome of a request checked by the named rule and
+// returns denied, the client that was denied or nil.
+func decided(rule string, client, denied *clientInfo) *clientInfo {
+    if denied != nil {
+        fmt.Printf("Decision: denied %s by rule %s\n", denied.ip, rule)
+        denied.deniedBy = rule
+    } else {
+        fmt.Printf("Decision: allowed %s by rule %s\n", client.ip, rule)
+    }
+    return denied
+}
 
-    fmt.Println("Cleaned source IP list: ", cleanedAllowedIPsArray)
+// decidedPath is decided for a path rule, and records the rule's auditOnly
+// and denyStatus on the denied client.
+func decidedPath(rule *compiledPathRule, client, denied *clientInfo) *clientInfo {
+    if decided(rule.name, client, denied) != nil {
+        denied.deniedAudit, denied.deniedStatus = rule.auditOnly, rule.denyStatus
+    }
+    return denied
+}
 
-    for _, cidr := range cleanedAllowedIPsArray {
-        _, ipNet, err := net.ParseCIDR(cidr)
-        if err != nil {
-            if cidr == ip {
-                fmt.Println("Direct IP match found:", ip)
-                return true
-            }
-            continue
+// isPathAllowed checks if the request path matches any allowed path patterns.
+// A "/prefix/*" pattern matches "/prefix" and everything below it, but not
+// "/prefixv2"; with loose it matches any path starting with "/prefix", as
+// older versions did.
+func isPathAllowed(reqPath string, pathPattern string, loose bool) bool {
+    if strings.HasSuffix(pathPattern, "/*") {
+        basePath := strings.TrimSuffix(pathPattern, "/*")
+        if loose {
+            return strings.HasPrefix(reqPath, basePath)
         }
+        return reqPath == basePath || strings.HasPrefix(reqPath, basePath+"/")
+    }
+    return reqPath == pathPattern
+}
 
-        if ipNet.Contains(net.ParseIP(ip)) {
-            fmt.Println("IP match found in CIDR:", cidr)
-            return true
+// deny rejects a request and counts the denial towards the domain's autoBan.
+// Clients on any of the domain's allow lists or in any tier are never
+// banned, so a misconfigured rule cannot lock out a health check for good.
+// When the denial is only audited, the request is passed on instead, and
+// bans are recorded only to be logged.
+func (ds *DomainSentinel) deny(rw http.ResponseWriter, req *http.Request, domain, path string, cd *compiledDomain, client *clientInfo) {
+    if cd.bans != nil && !cd.allowlisted(client.listIP) && (len(ds.tiers) == 0 || ds.tierOf(client) == nil) &&
+        cd.bans.recordDenial(client.listIP, time.Now()) {
+        fmt.Printf("Auto-banned %s on domain %q for %s after %d denials\n", client.listIP, domain, cd.bans.duration, cd.bans.maxDenials)
+    }
+    status := ds.denyStatusOf(cd, client)
+    if ds.audited(auditOnly(cd, client), status, domain, path, client.ip.String(), client.deniedBy) {
+        ds.next.ServeHTTP(rw, req)
+        return
+    }
+    http.Error(rw, "DS: "+http.StatusText(status), status)
+}
+
+// audited reports whether a request that rule denies with status is let
+// through, because auditMode is on or scope, the auditOnly of the rule's
+// domain or path rule, is set. Either way the denial is logged, as "DENY"
+// when it is enforced and as "AUDIT" when it is not.
+func (ds *DomainSentinel) audited(scope bool, status int, domain, path, client, rule string) bool {
+    var flag string
+    switch {
+    case ds.auditMode:
+        flag = "auditMode"
+    case scope:
+        flag = "auditOnly"
+    default:
+        fmt.Printf("DENY: denied with %d: domain %q, path %q, client %s, rule %s\n", status, domain, path, client, rule)
+        return false
+    }
+    fmt.Printf("AUDIT: would deny with %d: domain %q, path %q, client %s, rule %s (%s)\n", status, domain, path, client, rule, flag)
+    return true
+}
+
+// auditOnly returns the auditOnly setting for a denial of client: that of
+// the path rule that denied it, if set, or else that of the domain cd.
+func auditOnly(cd *compiledDomain, denied *clientInfo) bool {
+    if denied.deniedAudit != nil {
+        return *denied.deniedAudit
+    }
+    return cd != nil && cd.auditOnly
+}
+
+// denyStatusOf returns the status of a denial of client, nil if the denial
+// is not one of a rule: the denyStatus of the path rule that denied it, if
+// set, or else that of the domain cd, or else the plugin-level one.
+func (ds *DomainSentinel) denyStatusOf(cd *compiledDomain, denied *clientInfo) int {
+    switch {
+    case denied != nil && denied.deniedStatus != 0:
+        return denied.deniedStatus
+    case cd != nil && cd.denyStatus != 0:
+        return cd.denyStatus
+    }
+    return ds.denyStatus
+}
+
+// handleAddressError applies the configured onAddressError action to a
+// request whose client address could not be determined. clientIPHeader is
+// the header that would have carried the address, if enabled; a request
+// that is let through has it removed, since nothing was evaluated.
+func (ds *DomainSentinel) handleAddressError(rw http.ResponseWriter, req *http.Request, clientIPHeader string, err error) {
+    switch ds.onAddressError {
+    case addressErrorAllow:
+        fmt.Println("Client address error, allowing request (onAddressError=allow): ", err)
+        if clientIPHeader != "" {
+            req.Header.Del(clientIPHeader)
         }
+        ds.next.ServeHTTP(rw, req)
+    case addressErrorError500:
+        fmt.Println("Client address error, failing request (onAddressError=error500): ", err)
+        http.Error(rw, "DS: Internal Server Error", http.StatusInternalServerError)
+    default:
+        fmt.Println("Client address error, denying request (onAddressError=deny): ", err)
+        http.Error(rw, "DS: "+http.StatusText(ds.denyStatus), ds.denyStatus)
     }
+}
 
-    fmt.Println("No IP match found, denying access")
-    return false
+// clientIP returns the address of the peer that sent the request. A
+// RemoteAddr without a port is treated as a bare IP, with or without
+// brackets ("1.2.3.4", "::1", "[::1]"), as set by httptest and some
+// transports.
+func clientIP(req *http.Request) (netip.Addr, error) {
+    ip, ok := parseHostAddr(req.RemoteAddr)
+    if !ok {
+        return netip.Addr{}, fmt.Errorf("invalid remote address %q", req.RemoteAddr)
+    }
+    return ip, nil
 }
 
-// cleanCIDR replaces "║24║" with an empty string and remaining "║" with a space.
-func cleanCIDR(cidr string) string {
-    cleaned := strings.ReplaceAll(cidr, "║24║", "")
-    cleaned = strings.ReplaceAll(cleaned, "║", " ")
-    return cleaned
+// parseHostAddr parses an address that may carry a port and IPv6 brackets:
+// "1.2.3.4", "1.2.3.4:5678", "::1", "[::1]" or "[::1]:443".
+func parseHostAddr(s string) (netip.Addr, bool) {
+    s = strings.TrimSpace(s)
+    if addrPort, err := netip.ParseAddrPort(s); err == nil {
+        return normalizeAddr(addrPort.Addr()), true
+    }
+    if host, _, err := net.SplitHostPort(s); err == nil {
+        // Ports netip rejects, such as service names, are not needed.
+        s = host
+    } else if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+        s = s[1 : len(s)-1]
+    }
+    return parseIP(s)
 }
@@ -0,0 +1,288 @@
+package DomainSentinel
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "net/netip"
+    "strings"
+    "testing"
+)
+
+// okHandler stands for the backend and answers every request it gets with
+// 200.
+var okHandler = http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+    rw.WriteHeader(http.StatusOK)
+})
+
+// newTestSentinel creates the middleware for config in front of okHandler.
+func newTestSentinel(tb testing.TB, config *Config) http.Handler {
+    tb.Helper()
+    handler, err := New(context.Background(), okHandler, config, "test")
+    if err != nil {
+        tb.Fatalf("New: %v", err)
+    }
+    return handler
+}
+
+// serve sends a GET request for target from remoteAddr through handler and
+// returns the response. header holds name/value pairs to set.
+func serve(handler http.Handler, target, remoteAddr string, header ...string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(http.MethodGet, target, nil)
+    req.RemoteAddr = remoteAddr
+    for i := 0; i+1 < len(header); i += 2 {
+        req.Header.Set(header[i], header[i+1])
+    }
+    rw := httptest.NewRecorder()
+    handler.ServeHTTP(rw, req)
+    return rw
+}
+
+// domainConfig returns a configuration with one domain rule.
+func domainConfig(domain string, rule DomainConfig) *Config {
+    config := CreateConfig()
+    config.DomainPathRules[domain] = rule
+    return config
+}
+
+// ips converts addresses to a sourceIPs list.
+func ips(entries ...string) []interface{} {
+    list := make([]interface{}, len(entries))
+    for i, entry := range entries {
+        list[i] = entry
+    }
+    return list
+}
+
+// boolFlag returns an optional flag set to v.
+func boolFlag(v bool) *bool {
+    return &v
+}
+
+// statusCase is a request and the status the middleware must answer it with.
+type statusCase struct {
+    target, remoteAddr string
+    want               int
+}
+
+func checkStatuses(t *testing.T, handler http.Handler, cases []statusCase) {
+    t.Helper()
+    for _, tc := range cases {
+        if got := serve(handler, tc.target, tc.remoteAddr).Code; got != tc.want {
+            t.Errorf("GET %s from %s: status %d, want %d", tc.target, tc.remoteAddr, got, tc.want)
+        }
+    }
+}
+
+func TestNewRejectsInvalidSourceIPs(t *testing.T) {
+    for _, entry := range []string{"10.0.0.0/33", "300.1.2.3", "10.0.0.1/", "::1/129", "not an address"} {
+        config := domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.1", entry)})
+        if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+            t.Errorf("sourceIPs entry %q: New succeeded, want an error", entry)
+        }
+    }
+}
+
+func TestCompiledSourceIPs(t *testing.T) {
+    handler := newTestSentinel(t, domainConfig("example.com", DomainConfig{
+        SourceIPs: ips("10.0.0.0/8", "192.168.1.10", " 172.16.0.0/12 "),
+        PathRules: []PathConfig{{Path: "/admin", SourceIPs: ips("10.1.0.0/16")}},
+    }))
+    checkStatuses(t, handler, []statusCase{
+        {"http://example.com/", "10.2.3.4:1234", http.StatusOK},
+        {"http://example.com/", "192.168.1.10:1234", http.StatusOK},
+        {"http://example.com/", "172.20.0.1:1234", http.StatusOK},
+        {"http://example.com/", "192.168.1.11:1234", http.StatusForbidden},
+        {"http://example.com/admin", "10.1.2.3:1234", http.StatusOK},
+        {"http://example.com/admin", "10.2.3.4:1234", http.StatusForbidden},
+    })
+}
+
+// benchmarkCIDRs returns n distinct /24 networks.
+func benchmarkCIDRs(n int) []string {
+    cidrs := make([]string, n)
+    for i := range cidrs {
+        cidrs[i] = fmt.Sprintf("10.%d.%d.0/24", i/256, i%256)
+    }
+    return cidrs
+}
+
+// BenchmarkCompiledListLookup measures the per-request check against a list
+// of a few hundred CIDRs, which must not allocate.
+func BenchmarkCompiledListLookup(b *testing.B) {
+    c, err := newCompiler(CreateConfig())
+    if err != nil {
+        b.Fatal(err)
+    }
+    list, err := c.buildIPList(stringEntries(benchmarkCIDRs(300)))
+    if err != nil {
+        b.Fatal(err)
+    }
+    hit, miss := netip.MustParseAddr("10.1.20.7"), netip.MustParseAddr("192.0.2.1")
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        if !list.contains(hit) || list.contains(miss) {
+            b.Fatal("wrong result")
+        }
+    }
+}
+
+func TestOnAddressError(t *testing.T) {
+    tests := []struct {
+        action string
+        want   int
+    }{
+        {"", http.StatusForbidden},
+        {addressErrorDeny, http.StatusForbidden},
+        {addressErrorAllow, http.StatusOK},
+        {addressErrorError500, http.StatusInternalServerError},
+    }
+    for _, tt := range tests {
+        config := domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.1")})
+        config.OnAddressError = tt.action
+        handler := newTestSentinel(t, config)
+        if got := serve(handler, "http://example.com/", "not-an-address").Code; got != tt.want {
+            t.Errorf("onAddressError %q: status %d, want %d", tt.action, got, tt.want)
+        }
+        // An address without a port is not an error.
+        if got := serve(handler, "http://example.com/", "10.0.0.1").Code; got != http.StatusOK {
+            t.Errorf("onAddressError %q, RemoteAddr without port: status %d, want 200", tt.action, got)
+        }
+    }
+
+    config := domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.1")})
+    config.OnAddressError = "ignore"
+    if err := ValidateConfig(config); err == nil || !strings.Contains(err.Error(), "onAddressError") {
+        t.Errorf(`onAddressError "ignore": got %v, want an error naming the option`, err)
+    }
+}
+
+func TestParseHostAddr(t *testing.T) {
+    tests := []struct {
+        in   string
+        want string // empty if the address cannot be parsed
+    }{
+        {"1.2.3.4", "1.2.3.4"},
+        {"1.2.3.4:5678", "1.2.3.4"},
+        {" 1.2.3.4:5678 ", "1.2.3.4"},
+        {"::1", "::1"},
+        {"[::1]", "::1"},
+        {"[::1]:443", "::1"},
+        {"[fe80::1%eth0]:443", "fe80::1"},
+        {"[::ffff:1.2.3.4]:443", "1.2.3.4"},
+        {"1.2.3.4:http", "1.2.3.4"},
+        {"", ""},
+        {"garbage", ""},
+        {"1.2.3", ""},
+        {"1.2.3.4:5678:9", ""},
+        {"[::1", ""},
+        {"[1.2.3.4.5]:80", ""},
+    }
+    for _, tt := range tests {
+        addr, ok := parseHostAddr(tt.in)
+        if tt.want == "" {
+            if ok {
+                t.Errorf("parseHostAddr(%q) = %s, want an error", tt.in, addr)
+            }
+            continue
+        }
+        if !ok || addr != netip.MustParseAddr(tt.want) {
+            t.Errorf("parseHostAddr(%q) = %s, %v; want %s", tt.in, addr, ok, tt.want)
+        }
+    }
+}
+
+func TestRemoteAddrForms(t *testing.T) {
+    handler := newTestSentinel(t, domainConfig("example.com", DomainConfig{SourceIPs: ips("1.2.3.4", "::1")}))
+    checkStatuses(t, handler, []statusCase{
+        {"http://example.com/", "1.2.3.4", http.StatusOK},
+        {"http://example.com/", "1.2.3.4:5678", http.StatusOK},
+        {"http://example.com/", "::1", http.StatusOK},
+        {"http://example.com/", "[::1]:443", http.StatusOK},
+        {"http://example.com/", "garbage", http.StatusForbidden},
+    })
+}
+
+func TestRequireAllowAllConfirmation(t *testing.T) {
+    rule := DomainConfig{
+        SourceIPs: ips("10.0.0.0/8"),
+        PathRules: []PathConfig{{Path: "/public", SourceIPs: ips("0.0.0.0/0", "::/0")}},
+    }
+    config := domainConfig("example.com", rule)
+    config.RequireAllowAllConfirmation = true
+    _, err := New(context.Background(), okHandler, config, "test")
+    if err == nil {
+        t.Fatal("unconfirmed allow-all path rule accepted")
+    }
+    for _, want := range []string{"example.com", "/public"} {
+        if !strings.Contains(err.Error(), want) {
+            t.Errorf("error %q does not name %q", err, want)
+        }
+    }
+
+    rule.AllowAllConfirmed = boolFlag(true)
+    config = domainConfig("example.com", rule)
+    config.RequireAllowAllConfirmation = true
+    checkStatuses(t, newTestSentinel(t, config), []statusCase{
+        {"http://example.com/public", "203.0.113.9:1234", http.StatusOK},
+        {"http://example.com/private", "203.0.113.9:1234", http.StatusForbidden},
+    })
+
+    rule.AllowAllConfirmed = nil
+    checkStatuses(t, newTestSentinel(t, domainConfig("example.com", rule)), []statusCase{
+        {"http://example.com/public", "203.0.113.9:1234", http.StatusOK},
+    })
+}
+
+func TestDenyStatus(t *testing.T) {
+    // Without denyStatus, denials are 403 as they always were.
+    checkStatuses(t, newTestSentinel(t, domainConfig("example.com", DomainConfig{SourceIPs: ips("192.0.2.0/24")})), []statusCase{
+        {"http://example.com/", "192.0.2.5:1234", http.StatusOK},
+        {"http://example.com/", "198.51.100.1:1234", http.StatusForbidden},
+    })
+
+    config := CreateConfig()
+    config.DenyStatus = http.StatusServiceUnavailable
+    config.DomainPathRules = map[string]DomainConfig{
+        "example.com": {
+            SourceIPs:  ips("192.0.2.0/24"),
+            DenyStatus: http.StatusNotFound,
+            PathRules: []PathConfig{
+                {Path: "/admin", SourceIPs: ips("192.0.2.1"), DenyStatus: http.StatusForbidden},
+                {Path: "/internal", SourceIPs: ips("192.0.2.1")},
+            },
+        },
+        "other.example.com": {SourceIPs: ips("192.0.2.0/24")},
+    }
+    handler := newTestSentinel(t, config)
+    checkStatuses(t, handler, []statusCase{
+        {"http://example.com/", "192.0.2.5:1234", http.StatusOK},
+        {"http://example.com/", "198.51.100.1:1234", http.StatusNotFound},
+        {"http://example.com/admin", "192.0.2.1:1234", http.StatusOK},
+        {"http://example.com/admin", "192.0.2.5:1234", http.StatusForbidden},
+        {"http://example.com/internal", "192.0.2.5:1234", http.StatusNotFound},
+        {"http://other.example.com/", "192.0.2.5:1234", http.StatusOK},
+        {"http://other.example.com/", "198.51.100.1:1234", http.StatusServiceUnavailable},
+    })
+    if rw := serve(handler, "http://example.com/", "198.51.100.1:1234"); !strings.Contains(rw.Body.String(), "Not Found") {
+        t.Errorf("body %q, want the text of the deny status", rw.Body.String())
+    }
+
+    withOption := func(set func(*Config)) *Config {
+        config := domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.1")})
+        set(config)
+        return config
+    }
+    for name, config := range map[string]*Config{
+        "plugin":    withOption(func(c *Config) { c.DenyStatus = http.StatusFound }),
+        "default":   withOption(func(c *Config) { c.DefaultDenyStatus = http.StatusOK }),
+        "domain":    domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.1"), DenyStatus: 399}),
+        "path rule": domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.1"), PathRules: []PathConfig{{Path: "/x", SourceIPs: ips("10.0.0.1"), DenyStatus: 600}}}),
+    } {
+        if _, err := New(context.Background(), okHandler, config, "test"); err == nil || !strings.Contains(err.Error(), "4xx or 5xx") {
+            t.Errorf("invalid %s deny status: got %v, want a range error", name, err)
+        }
+    }
+}
@@ -0,0 +1,325 @@
+package DomainSentinel
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "math"
+    "net/netip"
+    "os"
+)
+
+// mmdbMetadataMarker precedes the metadata section of a MaxMind DB file.
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// mmdbReader is a minimal reader for the MaxMind DB format
+// (https://maxmind.github.io/MaxMind-DB/). The whole file is loaded into
+// memory once and shared by all requests; lookups do not modify it.
+type mmdbReader struct {
+    buf          []byte
+    databaseType string
+    nodeCount    uint
+    recordSize   uint
+    ipVersion    uint
+    treeSize     uint // size of the search tree in bytes
+    ipv4Start    uint // node where IPv4 lookups start in an IPv6 tree
+}
+
+// openMMDB reads and validates a MaxMind DB file.
+func openMMDB(path string) (*mmdbReader, error) {
+    buf, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    start := bytes.LastIndex(buf, mmdbMetadataMarker)
+    if start < 0 {
+        return nil, fmt.Errorf("%s: not a MaxMind DB file", path)
+    }
+    start += len(mmdbMetadataMarker)
+
+    d := mmdbDecoder{buf: buf[start:]}
+    raw, _, err := d.decode(0)
+    if err != nil {
+        return nil, fmt.Errorf("%s: invalid metadata: %w", path, err)
+    }
+    meta, ok := raw.(map[string]interface{})
+    if !ok {
+        return nil, fmt.Errorf("%s: invalid metadata", path)
+    }
+
+    r := &mmdbReader{buf: buf}
+    r.nodeCount, _ = mmdbUint(meta["node_count"])
+    r.recordSize, _ = mmdbUint(meta["record_size"])
+    r.ipVersion, _ = mmdbUint(meta["ip_version"])
+    r.databaseType, _ = meta["database_type"].(string)
+    switch r.recordSize {
+    case 24, 28, 32:
+    default:
+        return nil, fmt.Errorf("%s: unsupported record size %d", path, r.recordSize)
+    }
+    if r.ipVersion != 4 && r.ipVersion != 6 {
+        return nil, fmt.Errorf("%s: unsupported IP version %d", path, r.ipVersion)
+    }
+    r.treeSize = r.nodeCount * r.recordSize / 4
+    if r.treeSize+16 > uint(start) {
+        return nil, fmt.Errorf("%s: search tree exceeds file size", path)
+    }
+
+    if r.ipVersion == 6 {
+        node := uint(0)
+        for i := 0; i < 96 && node < r.nodeCount; i++ {
+            node = r.record(node, 0)
+        }
+        r.ipv4Start = node
+    }
+    return r, nil
+}
+
+// lookup returns the data record for ip, or nil if the database has none.
+func (r *mmdbReader) lookup(ip netip.Addr) (interface{}, error) {
+    node := uint(0)
+    bits, offset := addrBits(ip)
+    if ip.Is4() {
+        node = r.ipv4Start
+    } else if r.ipVersion == 4 {
+        return nil, nil
+    }
+
+    for i := offset; i < len(bits)*8 && node < r.nodeCount; i++ {
+        node = r.record(node, bitAt(bits[:], i))
+    }
+    if node == r.nodeCount {
+        return nil, nil
+    }
+    if node < r.nodeCount {
+        return nil, errors.New("invalid search tree")
+    }
+
+    d := mmdbDecoder{buf: r.buf[r.treeSize+16:]}
+    value, _, err := d.decode(node - r.nodeCount - 16)
+    return value, err
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a tree node.
+func (r *mmdbReader) record(node uint, bit int) uint {
+    b := r.buf[node*r.recordSize/4:]
+    switch r.recordSize {
+    case 24:
+        b = b[uint(bit)*3:]
+        return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+    case 28:
+        if bit == 0 {
+            return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+        }
+        return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+    default:
+        return uint(binary.BigEndian.Uint32(b[uint(bit)*4:]))
+    }
+}
+
+// mmdbDecoder decodes values of the MaxMind DB data section. Offsets and
+// pointers are relative to buf.
+type mmdbDecoder struct {
+    buf []byte
+}
+
+// MaxMind DB data types.
+const (
+    mmdbExtended = iota
+    mmdbPointer
+    mmdbString
+    mmdbDouble
+    mmdbBytes
+    mmdbUint16
+    mmdbUint32
+    mmdbMap
+    mmdbInt32
+    mmdbUint64
+    mmdbUint128
+    mmdbArray
+    mmdbContainer
+    mmdbEndMarker
+    mmdbBool
+    mmdbFloat
+)
+
+var errMMDBTruncated = errors.New("truncated data section")
+
+// decode decodes the value at offset and returns it with the offset of the
+// next value.
+func (d *mmdbDecoder) decode(offset uint) (interface{}, uint, error) {
+    return d.decodeDepth(offset, 0)
+}
+
+func (d *mmdbDecoder) decodeDepth(offset uint, depth int) (interface{}, uint, error) {
+    if depth > 32 {
+        return nil, 0, errors.New("data nested too deeply")
+    }
+    if offset >= uint(len(d.buf)) {
+        return nil, 0, errMMDBTruncated
+    }
+
+    ctrl := d.buf[offset]
+    offset++
+    typ := uint(ctrl >> 5)
+    if typ == mmdbPointer {
+        pointer, next, err := d.pointer(ctrl, offset)
+        if err != nil {
+            return nil, 0, err
+        }
+        value, _, err := d.decodeDepth(pointer, depth+1)
+        return value, next, err
+    }
+    if typ == mmdbExtended {
+        if offset >= uint(len(d.buf)) {
+            return nil, 0, errMMDBTruncated
+        }
+        typ = 7 + uint(d.buf[offset])
+        offset++
+    }
+
+    size := uint(ctrl & 0x1f)
+    if size >= 29 {
+        n := size - 28
+        if offset+n > uint(len(d.buf)) {
+            return nil, 0, errMMDBTruncated
+        }
+        extra := uint(0)
+        for _, b := range d.buf[offset : offset+n] {
+            extra = extra<<8 | uint(b)
+        }
+        offset += n
+        switch size {
+        case 29:
+            size = 29 + extra
+        case 30:
+            size = 285 + extra
+        default:
+            size = 65821 + extra
+        }
+    }
+
+    switch typ {
+    case mmdbMap:
+        m := make(map[string]interface{}, size)
+        for i := uint(0); i < size; i++ {
+            key, next, err := d.decodeDepth(offset, depth+1)
+            if err != nil {
+                return nil, 0, err
+            }
+            k, ok := key.(string)
+            if !ok {
+                return nil, 0, errors.New("map key is not a string")
+            }
+            value, next, err := d.decodeDepth(next, depth+1)
+            if err != nil {
+                return nil, 0, err
+            }
+            m[k] = value
+            offset = next
+        }
+        return m, offset, nil
+    case mmdbArray:
+        a := make([]interface{}, 0, size)
+        for i := uint(0); i < size; i++ {
+            value, next, err := d.decodeDepth(offset, depth+1)
+            if err != nil {
+                return nil, 0, err
+            }
+            a = append(a, value)
+            offset = next
+        }
+        return a, offset, nil
+    case mmdbBool:
+        return size != 0, offset, nil
+    case mmdbContainer, mmdbEndMarker:
+        return nil, offset, nil
+    }
+
+    if offset+size > uint(len(d.buf)) {
+        return nil, 0, errMMDBTruncated
+    }
+    b := d.buf[offset : offset+size]
+    offset += size
+    switch typ {
+    case mmdbString:
+        return string(b), offset, nil
+    case mmdbBytes:
+        return append([]byte(nil), b...), offset, nil
+    case mmdbDouble:
+        if size != 8 {
+            return nil, 0, errors.New("invalid double size")
+        }
+        return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+    case mmdbFloat:
+        if size != 4 {
+            return nil, 0, errors.New("invalid float size")
+        }
+        return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+    case mmdbUint16, mmdbUint32, mmdbUint64, mmdbInt32:
+        if size > 8 {
+            return nil, 0, errors.New("invalid integer size")
+        }
+        v := uint64(0)
+        for _, c := range b {
+            v = v<<8 | uint64(c)
+        }
+        if typ == mmdbInt32 {
+            return int64(int32(uint32(v))), offset, nil
+        }
+        return v, offset, nil
+    case mmdbUint128:
+        return append([]byte(nil), b...), offset, nil
+    }
+    return nil, 0, fmt.Errorf("unknown data type %d", typ)
+}
+
+// pointer decodes a pointer whose control byte is ctrl.
+func (d *mmdbDecoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+    n := uint(ctrl>>3&0x3) + 1
+    if offset+n > uint(len(d.buf)) {
+        return 0, 0, errMMDBTruncated
+    }
+    b := d.buf[offset : offset+n]
+    v := uint(0)
+    if n < 4 {
+        v = uint(ctrl & 0x7)
+    }
+    for _, c := range b {
+        v = v<<8 | uint(c)
+    }
+    switch n {
+    case 2:
+        v += 2048
+    case 3:
+        v += 526336
+    }
+    return v, offset + n, nil
+}
+
+// mmdbUint converts a decoded unsigned integer to uint.
+func mmdbUint(v interface{}) (uint, bool) {
+    switch n := v.(type) {
+    case uint64:
+        return uint(n), true
+    case int64:
+        if n >= 0 {
+            return uint(n), true
+        }
+    }
+    return 0, false
+}
+
+// mmdbPath follows a chain of map keys through a decoded record.
+func mmdbPath(record interface{}, keys ...string) interface{} {
+    for _, key := range keys {
+        m, ok := record.(map[string]interface{})
+        if !ok {
+            return nil
+        }
+        record = m[key]
+    }
+    return record
+}
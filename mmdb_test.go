@@ -0,0 +1,318 @@
+package DomainSentinel
+
+import (
+    "bytes"
+    "context"
+    "flag"
+    "net/http"
+    "net/netip"
+    "os"
+    "path/filepath"
+    "sort"
+    "testing"
+)
+
+var updateFixtures = flag.Bool("update", false, "rewrite the fixtures in testdata")
+
+// countryFixture is a MaxMind-format country database with the networks of
+// countryFixtureNetworks, built by writeTestMMDB. Run the tests with
+// -update to rewrite it.
+var countryFixture = filepath.Join("testdata", "countries.mmdb")
+
+// countryFixtureNetworks are the records of the fixture, keyed by network.
+var countryFixtureNetworks = map[string]map[string]interface{}{
+    "192.0.2.0/24":    {"country": map[string]interface{}{"iso_code": "DE"}},
+    "198.51.100.0/24": {"country": map[string]interface{}{"iso_code": "US"}},
+    "203.0.113.0/25":  {"registered_country": map[string]interface{}{"iso_code": "CH"}},
+    "203.0.113.128/25": {
+        "country":            map[string]interface{}{"iso_code": "AT"},
+        "registered_country": map[string]interface{}{"iso_code": "DE"},
+    },
+    "2001:db8::/32": {"country": map[string]interface{}{"iso_code": "AT"}},
+}
+
+// writeTestMMDB encodes networks as a MaxMind DB with an IPv6 search tree
+// and 24-bit records, IPv4 networks living under ::/96. The networks must
+// not overlap.
+func writeTestMMDB(networks map[string]map[string]interface{}) ([]byte, error) {
+    keys := make([]string, 0, len(networks))
+    for key := range networks {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    const empty = -1
+    var data bytes.Buffer
+    // Records are node indexes, empty, or the data offset plus dataBase;
+    // the base is only known when the tree is complete.
+    var tree [][2]int
+    var refs []map[int]int // per node, record side to data offset
+    newNode := func() int {
+        tree = append(tree, [2]int{empty, empty})
+        refs = append(refs, map[int]int{})
+        return len(tree) - 1
+    }
+    newNode()
+    for _, key := range keys {
+        prefix, err := netip.ParsePrefix(key)
+        if err != nil {
+            return nil, err
+        }
+        bits, addr := prefix.Bits(), prefix.Addr().As16()
+        if prefix.Addr().Is4() {
+            // As16 maps IPv4 into ::ffff:0:0/96; the tree expects ::/96.
+            addr[10], addr[11] = 0, 0
+            bits += 96
+        }
+        offset := data.Len()
+        writeMMDBValue(&data, networks[key])
+        node := 0
+        for i := 0; i < bits; i++ {
+            bit := int(addr[i/8]>>(7-uint(i%8))) & 1
+            if i == bits-1 {
+                refs[node][bit] = offset
+                break
+            }
+            if tree[node][bit] == empty {
+                next := newNode()
+                tree[node][bit] = next
+            }
+            node = tree[node][bit]
+        }
+    }
+
+    var out bytes.Buffer
+    nodeCount := len(tree)
+    for n, records := range tree {
+        for side, record := range records {
+            value := nodeCount // empty
+            if offset, ok := refs[n][side]; ok {
+                value = nodeCount + 16 + offset
+            } else if record != empty {
+                value = record
+            }
+            out.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+        }
+    }
+    out.Write(make([]byte, 16))
+    out.Write(data.Bytes())
+    out.Write(mmdbMetadataMarker)
+    writeMMDBValue(&out, map[string]interface{}{
+        "binary_format_major_version": uint16(2),
+        "binary_format_minor_version": uint16(0),
+        "build_epoch":                 uint64(1700000000),
+        "database_type":               "DomainSentinel-Test-Country",
+        "description":                 map[string]interface{}{"en": "DomainSentinel test fixture"},
+        "ip_version":                  uint16(6),
+        "languages":                   []interface{}{"en"},
+        "node_count":                  uint32(nodeCount),
+        "record_size":                 uint16(24),
+    })
+    return out.Bytes(), nil
+}
+
+// writeMMDBValue appends the data section encoding of v. Maps are written
+// in key order, so the output is reproducible.
+func writeMMDBValue(buf *bytes.Buffer, v interface{}) {
+    control := func(typ, size int) {
+        if typ > 7 {
+            buf.WriteByte(byte(size))
+            buf.WriteByte(byte(typ - 7))
+            return
+        }
+        buf.WriteByte(byte(typ<<5 | size))
+    }
+    switch v := v.(type) {
+    case string:
+        control(mmdbString, len(v))
+        buf.WriteString(v)
+    case uint16:
+        control(mmdbUint16, 2)
+        buf.Write([]byte{byte(v >> 8), byte(v)})
+    case uint32:
+        control(mmdbUint32, 4)
+        buf.Write([]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+    case uint64:
+        control(mmdbUint64, 8)
+        for i := 7; i >= 0; i-- {
+            buf.WriteByte(byte(v >> (8 * uint(i))))
+        }
+    case []interface{}:
+        control(mmdbArray, len(v))
+        for _, item := range v {
+            writeMMDBValue(buf, item)
+        }
+    case map[string]interface{}:
+        keys := make([]string, 0, len(v))
+        for key := range v {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        control(mmdbMap, len(keys))
+        for _, key := range keys {
+            writeMMDBValue(buf, key)
+            writeMMDBValue(buf, v[key])
+        }
+    default:
+        panic("unsupported fixture value")
+    }
+}
+
+func TestCountryFixtureUpToDate(t *testing.T) {
+    want, err := writeTestMMDB(countryFixtureNetworks)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if *updateFixtures {
+        if err := os.WriteFile(countryFixture, want, 0o644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    got, err := os.ReadFile(countryFixture)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(got, want) {
+        t.Errorf("%s is out of date, run go test -run TestCountryFixtureUpToDate -update", countryFixture)
+    }
+}
+
+func TestMMDBLookup(t *testing.T) {
+    reader, err := openMMDB(countryFixture)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if reader.databaseType != "DomainSentinel-Test-Country" {
+        t.Errorf("database type %q", reader.databaseType)
+    }
+    geo := &geoDB{reader: reader}
+    for addr, want := range map[string]string{
+        "192.0.2.1":       "DE",
+        "192.0.2.255":     "DE",
+        "198.51.100.7":    "US",
+        "203.0.113.7":     "CH", // registered_country only
+        "203.0.113.200":   "AT", // country wins over registered_country
+        "2001:db8::1":     "AT",
+        "2001:db8:ffff::": "AT",
+        "192.0.3.1":       "",
+        "10.0.0.1":        "",
+        "2001:db9::1":     "",
+    } {
+        code, ok := geo.country(netip.MustParseAddr(addr))
+        if code != want || ok != (want != "") {
+            t.Errorf("country(%s) = %q, %v; want %q", addr, code, ok, want)
+        }
+    }
+}
+
+func TestOpenMMDBErrors(t *testing.T) {
+    dir := t.TempDir()
+    fixture, err := os.ReadFile(countryFixture)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for name, data := range map[string][]byte{
+        "empty":     {},
+        "no marker": []byte("not a database"),
+        "truncated": fixture[len(fixture)-40:],
+    } {
+        path := filepath.Join(dir, name)
+        if err := os.WriteFile(path, data, 0o644); err != nil {
+            t.Fatal(err)
+        }
+        if _, err := openMMDB(path); err == nil {
+            t.Errorf("%s: opened without an error", name)
+        }
+    }
+    if _, err := openMMDB(filepath.Join(dir, "missing")); err == nil {
+        t.Error("missing file: opened without an error")
+    }
+}
+
+func TestCountryRules(t *testing.T) {
+    newHandler := func(match, unknown string, rule DomainConfig) http.Handler {
+        config := domainConfig("example.com", rule)
+        config.GeoIPDatabase = countryFixture
+        config.CountryMatch = match
+        config.UnknownCountryAction = unknown
+        return newTestSentinel(t, config)
+    }
+    const (
+        de      = "192.0.2.1:1234"
+        us      = "198.51.100.7:1234"
+        ch      = "203.0.113.7:1234"
+        at      = "[2001:db8::1]:1234"
+        unknown = "10.0.0.1:1234"
+    )
+
+    t.Run("and", func(t *testing.T) {
+        handler := newHandler(countryMatchAnd, unknownCountryDeny, DomainConfig{
+            SourceIPs:        ips("192.0.2.0/24", "198.51.100.0/24", "10.0.0.0/8"),
+            AllowedCountries: []string{"DE", "at", "CH"},
+        })
+        checkStatuses(t, handler, []statusCase{
+            {"http://example.com/", de, http.StatusOK},
+            {"http://example.com/", us, http.StatusForbidden},      // IP allowed, country not
+            {"http://example.com/", ch, http.StatusForbidden},      // country allowed, IP not
+            {"http://example.com/", unknown, http.StatusForbidden}, // IP allowed, country unknown
+        })
+    })
+    t.Run("or", func(t *testing.T) {
+        handler := newHandler(countryMatchOr, unknownCountryDeny, DomainConfig{
+            SourceIPs:        ips("198.51.100.0/24"),
+            AllowedCountries: []string{"DE", "AT", "CH"},
+        })
+        checkStatuses(t, handler, []statusCase{
+            {"http://example.com/", de, http.StatusOK},
+            {"http://example.com/", us, http.StatusOK},
+            {"http://example.com/", ch, http.StatusOK},
+            {"http://example.com/", at, http.StatusOK},
+            {"http://example.com/", unknown, http.StatusForbidden},
+        })
+    })
+    t.Run("unknown allowed", func(t *testing.T) {
+        handler := newHandler(countryMatchAnd, unknownCountryAllow, DomainConfig{
+            SourceIPs:        ips("10.0.0.0/8", "198.51.100.0/24"),
+            AllowedCountries: []string{"DE"},
+        })
+        checkStatuses(t, handler, []statusCase{
+            {"http://example.com/", unknown, http.StatusOK},
+            {"http://example.com/", us, http.StatusForbidden},
+        })
+    })
+    t.Run("denied countries", func(t *testing.T) {
+        handler := newHandler(countryMatchAnd, unknownCountryDeny, DomainConfig{
+            SourceIPs:       ips("0.0.0.0/0", "::/0"),
+            DeniedCountries: []string{"US"},
+            PathRules: []PathConfig{
+                {Path: "/eu", SourceIPs: ips("0.0.0.0/0", "::/0"), AllowedCountries: []string{"AT"}},
+            },
+        })
+        checkStatuses(t, handler, []statusCase{
+            {"http://example.com/", de, http.StatusOK},
+            {"http://example.com/", us, http.StatusForbidden},
+            {"http://example.com/eu", at, http.StatusOK},
+            {"http://example.com/eu", de, http.StatusForbidden},
+            {"http://example.com/eu", us, http.StatusForbidden},
+        })
+    })
+}
+
+func TestCountryOptionErrors(t *testing.T) {
+    tests := []struct {
+        name      string
+        database  string
+        countries []string
+    }{
+        {"no database", "", []string{"DE"}},
+        {"invalid code", countryFixture, []string{"Germany"}},
+        {"missing database", filepath.Join(t.TempDir(), "missing.mmdb"), []string{"DE"}},
+    }
+    for _, tt := range tests {
+        config := domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.1"), AllowedCountries: tt.countries})
+        config.GeoIPDatabase = tt.database
+        if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+            t.Errorf("%s: New succeeded, want an error", tt.name)
+        }
+    }
+}
@@ -0,0 +1,145 @@
+package DomainSentinel
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// orderedRule is an entry of the rules list. Its host patterns are tried
+// in the order written, and the first rule with a matching pattern wins.
+type orderedRule struct {
+    key   string // "rules[<index>]"
+    cd    *compiledDomain
+    hosts []hostMatcher
+}
+
+// hostMatcher is a compiled host pattern of an ordered rule, in the syntax
+// of domainPathRules keys.
+type hostMatcher struct {
+    pattern  string // as configured
+    name     string // exact name, or the suffix of a wildcard, in punycode
+    port     string // empty for any port
+    wildcard bool
+    all      bool           // "*"
+    re       *regexp.Regexp // regex patterns
+}
+
+// orderedRuleKey returns the key rules[i] is compiled under.
+func orderedRuleKey(i int) string {
+    return fmt.Sprintf("rules[%d]", i)
+}
+
+// orderedRuleConfigs keys the entries of the rules list by orderedRuleKey,
+// so that they compile like domainPathRules entries with a hosts list.
+func orderedRuleConfigs(rules []DomainConfig) map[string]DomainConfig {
+    configs := make(map[string]DomainConfig, len(rules))
+    for i, rule := range rules {
+        configs[orderedRuleKey(i)] = rule
+    }
+    return configs
+}
+
+// newOrderedTable builds a domain table that looks up the compiled rules
+// list in order, instead of by the precedence of key types. Priorities do
+// not apply. Host patterns that an earlier rule already matches entirely
+// are logged, since they are never used.
+func newOrderedTable(domains map[string]*compiledDomain, n int) (*domainTable, error) {
+    t := &domainTable{}
+    exact := make(map[string]string)
+    catchAll := ""
+    for i := 0; i < n; i++ {
+        key := orderedRuleKey(i)
+        cd := domains[key]
+        if cd.priority != 0 {
+            fmt.Printf("Warning: %s sets a priority, which has no effect in rules, which are evaluated in order\n", key)
+        }
+        if catchAll != "" {
+            fmt.Printf("Warning: %s is never used: %s matches every host\n", key, catchAll)
+        }
+        rule := orderedRule{key: key, cd: cd}
+        for _, host := range cd.hosts {
+            m, err := compileHostMatcher(key, host)
+            if err != nil {
+                return nil, err
+            }
+            switch {
+            case m.all:
+                if catchAll == "" {
+                    catchAll = key
+                    t.fallback = cd
+                }
+            case m.re == nil && !m.wildcard:
+                id := m.name + ":" + m.port
+                if other, ok := exact[id]; ok {
+                    fmt.Printf("Warning: host %q of %s is never used: %s matches it first\n", host, key, other)
+                } else {
+                    exact[id] = key
+                }
+            }
+            rule.hosts = append(rule.hosts, m)
+        }
+        t.ordered = append(t.ordered, rule)
+    }
+    return t, nil
+}
+
+// compileHostMatcher compiles a host pattern of the rule key.
+func compileHostMatcher(key, pattern string) (hostMatcher, error) {
+    m := hostMatcher{pattern: pattern}
+    if pattern == catchAllKey {
+        m.all = true
+        return m, nil
+    }
+    if regex, ok := regexPattern(pattern); ok {
+        re, err := compileDomainRegex(key, regex)
+        if err != nil {
+            return m, err
+        }
+        m.re = re
+        return m, nil
+    }
+    name, port := splitKeyPort(pattern)
+    name, err := toASCII(strings.TrimSuffix(name, "."))
+    if err != nil {
+        return m, fmt.Errorf("%s: invalid internationalized domain name %q: %w", key, pattern, err)
+    }
+    m.port = port
+    m.name, m.wildcard = wildcardSuffix(name)
+    if !m.wildcard {
+        m.name = name
+    }
+    return m, nil
+}
+
+// matches reports whether the pattern matches host, requested on port.
+// Plain names cover their subdomains with includeSubdomains, and wildcards
+// cover the apex with matchApex, as in domainPathRules.
+func (m hostMatcher) matches(cd *compiledDomain, host, port string) bool {
+    if m.port != "" && m.port != port {
+        return false
+    }
+    switch {
+    case m.all:
+        return true
+    case m.re != nil:
+        return m.re.MatchString(host)
+    case m.wildcard:
+        return strings.HasSuffix(host, "."+m.name) || cd.matchApex && host == m.name
+    default:
+        return host == m.name || cd.includeSubdomains && strings.HasSuffix(host, "."+m.name)
+    }
+}
+
+// lookupOrdered returns the first rule of the rules list with a host
+// pattern matching host.
+func (t *domainTable) lookupOrdered(host, port string) (*compiledDomain, string, bool) {
+    for _, rule := range t.ordered {
+        for _, m := range rule.hosts {
+            if m.matches(rule.cd, host, port) {
+                return rule.cd, rule.key, true
+            }
+        }
+    }
+    return nil, "", false
+}
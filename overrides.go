@@ -0,0 +1,167 @@
+package DomainSentinel
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "strings"
+)
+
+const (
+    overrideModeMerge   = "merge"
+    overrideModeReplace = "replace"
+)
+
+// loadOverrides checks the override options of config and returns it with
+// the rules of its overrideRulesFile in overrideRules. config is not
+// modified.
+func loadOverrides(config *Config) (*Config, error) {
+    switch config.OverrideMode {
+    case "", overrideModeMerge, overrideModeReplace:
+    default:
+        return nil, fmt.Errorf("invalid overrideMode %q: must be %q or %q", config.OverrideMode, overrideModeMerge, overrideModeReplace)
+    }
+    if config.OverrideRulesFile != "" && len(config.OverrideRules) > 0 {
+        return nil, fmt.Errorf("overrideRules and overrideRulesFile cannot be combined; use one")
+    }
+    if config.OverrideRulesFile == "" && len(config.OverrideRules) == 0 {
+        if config.OverrideMode != "" {
+            return nil, fmt.Errorf("overrideMode is set without overrideRules or overrideRulesFile")
+        }
+        return config, nil
+    }
+    if len(config.Rules) > 0 {
+        return nil, fmt.Errorf("overrideRules apply to domainPathRules and cannot be combined with rules")
+    }
+    if config.OverrideRulesFile == "" {
+        return config, nil
+    }
+    path := config.OverrideRulesFile
+    if !filepath.IsAbs(path) && config.RulesBaseDir != "" {
+        path = filepath.Join(config.RulesBaseDir, path)
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("overrideRulesFile: %w", err)
+    }
+    rules, err := decodeRules("overrideRulesFile "+path, data)
+    if err != nil {
+        return nil, err
+    }
+    loaded := *config
+    loaded.OverrideRules = rules
+    fmt.Printf("Loaded %d override rules from overrideRulesFile %s\n", len(rules), path)
+    return &loaded, nil
+}
+
+// applyOverrides returns config with its overrideRules applied to the
+// domainPathRules, which include those of a rules source by now. A domain
+// only in the overrides is added. With overrideMode replace, an override
+// replaces the base entry of its domain; with merge (default), see
+// mergeOverride. config is not modified.
+func applyOverrides(config *Config) (*Config, error) {
+    if len(config.OverrideRules) == 0 {
+        return config, nil
+    }
+    merged := *config
+    merged.DomainPathRules = make(map[string]DomainConfig, len(config.DomainPathRules)+len(config.OverrideRules))
+    for key, rule := range config.DomainPathRules {
+        merged.DomainPathRules[key] = rule
+    }
+    var problems ConfigErrors
+    for _, key := range sortedConfigKeys(config.OverrideRules) {
+        override := config.OverrideRules[key]
+        base, ok := config.DomainPathRules[key]
+        if !ok || config.OverrideMode == overrideModeReplace {
+            merged.DomainPathRules[key] = override
+            continue
+        }
+        rule, err := mergeOverride(base, override)
+        if err != nil {
+            loc := at()
+            loc.Section, loc.Domain = "overrideRules", key
+            loc.Reason, loc.Err = err.Error(), err
+            problems = append(problems, &loc)
+            continue
+        }
+        merged.DomainPathRules[key] = rule
+    }
+    if len(problems) > 0 {
+        return nil, problems
+    }
+    return &merged, nil
+}
+
+// mergeOverride extends base with override. Lists are concatenated, base
+// first. A path rule of override replaces the path rule of base with the
+// same name, or without names the same path, and is appended otherwise.
+// Every other field may be set by one of them or to the same value by
+// both; different values are an error rather than a guess at which one
+// was meant. A flag set to false is set, so an override that turns off a
+// flag of base conflicts with it.
+func mergeOverride(base, override DomainConfig) (DomainConfig, error) {
+    pathRules := mergeOverridePathRules(base.PathRules, override.PathRules)
+    base.PathRules, override.PathRules = nil, nil
+    var b, o map[string]interface{}
+    if err := roundTrip(base, &b); err != nil {
+        return base, err
+    }
+    if err := roundTrip(override, &o); err != nil {
+        return base, err
+    }
+    var conflicts []string
+    for field, value := range o {
+        own, ok := b[field]
+        if !ok {
+            b[field] = value
+            continue
+        }
+        baseList, isList := own.([]interface{})
+        overrideList, overrideIsList := value.([]interface{})
+        switch {
+        case isList && overrideIsList:
+            b[field] = append(baseList, overrideList...)
+        case !reflect.DeepEqual(own, value):
+            conflicts = append(conflicts, field)
+        }
+    }
+    if len(conflicts) > 0 {
+        sort.Strings(conflicts)
+        return base, fmt.Errorf("the base rule and the override set %s to different values; overrideMode %q only adds fields and extends lists, "+
+            "set the field in one of them or use overrideMode %q", strings.Join(conflicts, ", "), overrideModeMerge, overrideModeReplace)
+    }
+    var merged DomainConfig
+    if err := roundTrip(b, &merged); err != nil {
+        return base, err
+    }
+    merged.PathRules = pathRules
+    return merged, nil
+}
+
+func mergeOverridePathRules(base, override []PathConfig) []PathConfig {
+    if len(override) == 0 {
+        return base
+    }
+    key := func(rule PathConfig) string {
+        if rule.Name != "" {
+            return "name " + rule.Name
+        }
+        return "path " + rule.Path
+    }
+    merged := append([]PathConfig(nil), base...)
+    index := make(map[string]int, len(merged))
+    for i, rule := range merged {
+        index[key(rule)] = i
+    }
+    for _, rule := range override {
+        if i, ok := index[key(rule)]; ok {
+            merged[i] = rule
+            continue
+        }
+        index[key(rule)] = len(merged)
+        merged = append(merged, rule)
+    }
+    return merged
+}
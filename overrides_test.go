@@ -0,0 +1,160 @@
+package DomainSentinel
+
+import (
+    "context"
+    "net/http"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// overrideBase is the shared base rule set of the override tests.
+func overrideBase() *Config {
+    config := CreateConfig()
+    config.DomainPathRules["example.com"] = DomainConfig{
+        SourceIPs:            ips("10.0.0.0/8"),
+        RequireBothAddresses: boolFlag(true),
+        PathRules: []PathConfig{
+            {Name: "admin", Path: "/admin", SourceIPs: ips("10.1.0.0/16")},
+            {Path: "/api", SourceIPs: ips("10.2.0.0/16")},
+        },
+    }
+    config.DomainPathRules["shop.example.com"] = DomainConfig{SourceIPs: ips("10.0.0.1")}
+    return config
+}
+
+func TestOverrideReplace(t *testing.T) {
+    config := overrideBase()
+    config.OverrideMode = overrideModeReplace
+    config.OverrideRules = map[string]DomainConfig{
+        "example.com":     {SourceIPs: ips("192.0.2.0/24")},
+        "new.example.com": {SourceIPs: ips("192.0.2.1")},
+    }
+    merged, err := applyOverrides(config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := (DomainConfig{SourceIPs: ips("192.0.2.0/24")}); !reflect.DeepEqual(merged.DomainPathRules["example.com"], want) {
+        t.Errorf("example.com = %+v, want the override entry alone", merged.DomainPathRules["example.com"])
+    }
+    if _, ok := merged.DomainPathRules["new.example.com"]; !ok || len(merged.DomainPathRules) != 3 {
+        t.Errorf("domains %v, want new.example.com added", sortedConfigKeys(merged.DomainPathRules))
+    }
+    if len(config.DomainPathRules["example.com"].PathRules) != 2 {
+        t.Error("applyOverrides modified its input")
+    }
+}
+
+func TestOverrideMerge(t *testing.T) {
+    config := overrideBase()
+    config.OverrideRules = map[string]DomainConfig{
+        "example.com": {
+            SourceIPs:            ips("192.0.2.0/24"),
+            RequireBothAddresses: boolFlag(true), // the same value is no conflict
+            DenyStatus:           404,
+            PathRules: []PathConfig{
+                {Name: "admin", Path: "/admin/*", SourceIPs: ips("10.3.0.0/16")}, // replaces by name
+                {Path: "/api", SourceIPs: ips("10.4.0.0/16")},                    // replaces by path
+                {Path: "/metrics", SourceIPs: ips("10.5.0.0/16")},                // appended
+            },
+        },
+        "shop.example.com": {MatchApex: boolFlag(false), SourceIPs: ips("10.0.0.1")},
+    }
+    merged, err := applyOverrides(config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    rule := merged.DomainPathRules["example.com"]
+    if want := ips("10.0.0.0/8", "192.0.2.0/24"); !reflect.DeepEqual(rule.SourceIPs, want) {
+        t.Errorf("sourceIPs = %v, want the base entries first: %v", rule.SourceIPs, want)
+    }
+    if rule.DenyStatus != 404 || !isTrue(rule.RequireBothAddresses) {
+        t.Errorf("denyStatus %d, requireBothAddresses %v; want the field of the override added and the base flag kept",
+            rule.DenyStatus, isTrue(rule.RequireBothAddresses))
+    }
+    var paths []string
+    for _, p := range rule.PathRules {
+        paths = append(paths, p.Path+" "+p.SourceIPs[0].(string))
+    }
+    if want := []string{"/admin/* 10.3.0.0/16", "/api 10.4.0.0/16", "/metrics 10.5.0.0/16"}; !reflect.DeepEqual(paths, want) {
+        t.Errorf("pathRules = %v, want %v", paths, want)
+    }
+    // A flag the base leaves out is added, even as false.
+    shop := merged.DomainPathRules["shop.example.com"]
+    if shop.MatchApex == nil || *shop.MatchApex {
+        t.Errorf("shop matchApex = %v, want false from the override", shop.MatchApex)
+    }
+    if want := ips("10.0.0.1", "10.0.0.1"); !reflect.DeepEqual(shop.SourceIPs, want) {
+        t.Errorf("shop sourceIPs = %v, want both lists concatenated: %v", shop.SourceIPs, want)
+    }
+
+    checkStatuses(t, newTestSentinel(t, config), []statusCase{
+        {"http://example.com/", "192.0.2.1:1234", http.StatusOK},
+        {"http://example.com/admin/x", "10.3.0.1:1234", http.StatusOK},
+        {"http://example.com/admin/x", "10.1.0.1:1234", http.StatusNotFound},
+        {"http://example.com/metrics", "10.2.0.1:1234", http.StatusNotFound},
+    })
+}
+
+func TestOverrideConflicts(t *testing.T) {
+    tests := []struct {
+        name     string
+        override DomainConfig
+        fields   string
+    }{
+        {"field only in the override", DomainConfig{DenyStatus: 404}, ""},
+        {"different scalar", DomainConfig{ACLDefault: "allow", EmptyListAction: emptyListAllowAll}, "aclDefault, emptyListAction"},
+        {"flag turned off", DomainConfig{RequireBothAddresses: boolFlag(false)}, "requireBothAddresses"},
+    }
+    for _, tt := range tests {
+        base := overrideBase()
+        rule := base.DomainPathRules["example.com"]
+        rule.ACLDefault, rule.EmptyListAction = "deny", emptyListDenyAll
+        base.DomainPathRules["example.com"] = rule
+        base.OverrideRules = map[string]DomainConfig{"example.com": tt.override}
+        _, err := applyOverrides(base)
+        switch {
+        case tt.fields == "" && err != nil:
+            t.Errorf("%s: %v", tt.name, err)
+        case tt.fields != "" && (err == nil || !strings.Contains(err.Error(), "set "+tt.fields+" to different values")):
+            t.Errorf("%s: got %v, want a conflict naming %s", tt.name, err, tt.fields)
+        }
+        // With replace, nothing conflicts.
+        base.OverrideMode = overrideModeReplace
+        if _, err := applyOverrides(base); err != nil {
+            t.Errorf("%s, overrideMode replace: %v", tt.name, err)
+        }
+    }
+}
+
+func TestOverrideRulesFile(t *testing.T) {
+    dir := t.TempDir()
+    if err := os.WriteFile(filepath.Join(dir, "prod.json"), []byte(`{"example.com": {"pathRules": [{"path": "/debug", "sourceIPs": ["10.9.0.0/16"]}]}}`), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    config := overrideBase()
+    config.RulesBaseDir = dir
+    config.OverrideRulesFile = "prod.json"
+    checkStatuses(t, newTestSentinel(t, config), []statusCase{
+        {"http://example.com/debug", "10.9.0.1:1234", http.StatusOK},
+        {"http://example.com/debug", "10.8.0.1:1234", http.StatusForbidden},
+    })
+
+    for name, configure := range map[string]func(*Config){
+        "invalid mode":             func(c *Config) { c.OverrideMode = "extend" },
+        "file and inline":          func(c *Config) { c.OverrideRules = map[string]DomainConfig{"example.com": {}} },
+        "mode without overrides":   func(c *Config) { c.OverrideRulesFile, c.OverrideMode = "", overrideModeMerge },
+        "missing file":             func(c *Config) { c.OverrideRulesFile = "staging.json" },
+        "combined with rules list": func(c *Config) { c.DomainPathRules, c.Rules = nil, []DomainConfig{{Hosts: []string{"example.com"}}} },
+    } {
+        config := overrideBase()
+        config.RulesBaseDir = dir
+        config.OverrideRulesFile = "prod.json"
+        configure(config)
+        if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+            t.Errorf("%s: New succeeded, want an error", name)
+        }
+    }
+}
@@ -0,0 +1,128 @@
+package DomainSentinel
+
+import (
+    "errors"
+    "net/http"
+    "strings"
+)
+
+// errPathEscapesRoot is returned for paths whose ".." segments climb above "/".
+var errPathEscapesRoot = errors.New("path escapes the root")
+
+// canonicalPath returns the path that rules are matched against, from the
+// escaped form of a request path: percent-encodings are decoded, empty and
+// "." segments are dropped and ".." removes the previous segment, so
+// "//admin/./x/../panel" and "/%2e%2e/admin" cannot bypass a rule for
+// "/admin/*" when the backend normalizes paths the same way. An encoded
+// slash ("%2F") stays part of its segment unless decodeSlashes is set. A
+// trailing slash is kept.
+func canonicalPath(escaped string, decodeSlashes bool) (string, error) {
+    if escaped == "" {
+        return "/", nil
+    }
+    var segments []string
+    raw := strings.Split(escaped, "/")
+    for i, segment := range raw {
+        decoded, err := unescapePathSegment(segment, !decodeSlashes)
+        if err != nil {
+            return "", err
+        }
+        parts := []string{decoded}
+        if decodeSlashes {
+            parts = strings.Split(decoded, "/")
+        }
+        for _, part := range parts {
+            switch part {
+            case "", ".":
+            case "..":
+                if len(segments) == 0 {
+                    return "", errPathEscapesRoot
+                }
+                segments = segments[:len(segments)-1]
+            default:
+                segments = append(segments, part)
+            }
+        }
+        // A path ending in "/", "/." or "/.." names a directory.
+        if i == len(raw)-1 && (decoded == "" || decoded == "." || decoded == "..") && len(segments) > 0 {
+            return "/" + strings.Join(segments, "/") + "/", nil
+        }
+    }
+    return "/" + strings.Join(segments, "/"), nil
+}
+
+// rawRequestPath returns the path of a request as sent, from RequestURI
+// without the query, for domains with matchRawPath. Nothing is decoded or
+// cleaned, so a backend that decodes paths may see a different path than
+// the rules did; only the hex digits of percent-encodings are upper-cased,
+// since "%2f" and "%2F" are the same octet.
+func rawRequestPath(req *http.Request) string {
+    raw := req.RequestURI
+    if i := strings.IndexByte(raw, '?'); i >= 0 {
+        raw = raw[:i]
+    }
+    if !strings.HasPrefix(raw, "/") {
+        // Absolute-form targets and requests built without RequestURI.
+        raw = req.URL.EscapedPath()
+    }
+    if raw == "" {
+        return "/"
+    }
+    if !strings.Contains(raw, "%") {
+        return raw
+    }
+    b := []byte(raw)
+    for i := 0; i+2 < len(b); i++ {
+        if b[i] == '%' && unhex(b[i+1]) >= 0 && unhex(b[i+2]) >= 0 {
+            b[i+1], b[i+2] = upperHex(b[i+1]), upperHex(b[i+2])
+            i += 2
+        }
+    }
+    return string(b)
+}
+
+func upperHex(c byte) byte {
+    if c >= 'a' && c <= 'f' {
+        return c - 'a' + 'A'
+    }
+    return c
+}
+
+// unescapePathSegment decodes the percent-encodings of a path segment. With
+// keepSlash, an encoded slash is kept as "%2F".
+func unescapePathSegment(s string, keepSlash bool) (string, error) {
+    if !strings.Contains(s, "%") {
+        return s, nil
+    }
+    var b strings.Builder
+    b.Grow(len(s))
+    for i := 0; i < len(s); i++ {
+        if s[i] != '%' {
+            b.WriteByte(s[i])
+            continue
+        }
+        if i+2 >= len(s) || unhex(s[i+1]) < 0 || unhex(s[i+2]) < 0 {
+            return "", errors.New("invalid percent-encoding in path")
+        }
+        c := byte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+        if c == '/' && keepSlash {
+            b.WriteString("%2F")
+        } else {
+            b.WriteByte(c)
+        }
+        i += 2
+    }
+    return b.String(), nil
+}
+
+func unhex(c byte) int {
+    switch {
+    case c >= '0' && c <= '9':
+        return int(c - '0')
+    case c >= 'a' && c <= 'f':
+        return int(c-'a') + 10
+    case c >= 'A' && c <= 'F':
+        return int(c-'A') + 10
+    }
+    return -1
+}
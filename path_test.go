@@ -0,0 +1,611 @@
+package DomainSentinel
+
+import (
+    "context"
+    "fmt"
+    "math/rand"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// specificityRules are overlapping path rules in the order an operator
+// might write them, least specific first; each allows one address.
+func specificityRules() []PathConfig {
+    patterns := []string{"/api/*", "/api/admin/*", "/api/admin/users", "/api/*/admin", "/api/**/private", "/*", "/api/admin"}
+    rules := make([]PathConfig, len(patterns))
+    for i, pattern := range patterns {
+        rules[i] = PathConfig{Path: pattern, SourceIPs: ips(fmt.Sprintf("10.0.0.%d", i+1))}
+    }
+    return rules
+}
+
+// decisions returns the status of every path from every address allowed
+// by one of the rules.
+func decisions(handler http.Handler, rules []PathConfig, paths []string) []int {
+    var statuses []int
+    for _, path := range paths {
+        for i := range rules {
+            statuses = append(statuses, serve(handler, "http://example.com"+path, fmt.Sprintf("10.0.0.%d:1234", i+1)).Code)
+        }
+    }
+    return statuses
+}
+
+func TestMostSpecificPathRuleWins(t *testing.T) {
+    rules := specificityRules()
+    handler := newTestSentinel(t, domainConfig("example.com", DomainConfig{SourceIPs: ips("192.0.2.1"), PathRules: rules}))
+    // The address of the rule that must decide each path.
+    for path, want := range map[string]string{
+        "/api/admin/users":    "10.0.0.3", // exact beats everything
+        "/api/admin":          "10.0.0.7",
+        "/api/admin/settings": "10.0.0.2", // the longer wildcard
+        "/api/v1/admin":       "10.0.0.4", // glob
+        "/api/a/b/private":    "10.0.0.5",
+        "/api/other":          "10.0.0.1",
+        "/static/x":           "10.0.0.6",
+    } {
+        for i := range rules {
+            addr := fmt.Sprintf("10.0.0.%d", i+1)
+            wantStatus := http.StatusForbidden
+            if addr == want {
+                wantStatus = http.StatusOK
+            }
+            if got := serve(handler, "http://example.com"+path, addr+":1234").Code; got != wantStatus {
+                t.Errorf("GET %s from %s: status %d, want %d", path, addr, got, wantStatus)
+            }
+        }
+    }
+}
+
+func TestPathRuleOrderIrrelevant(t *testing.T) {
+    paths := []string{
+        "/", "/api", "/api/", "/api/admin", "/api/admin/", "/api/admin/users", "/api/admin/users/1",
+        "/api/v1/admin", "/api/v1/admin/x", "/api/private", "/api/a/b/private", "/static/x", "/apix",
+    }
+    base := specificityRules()
+    want := decisions(newTestSentinel(t, domainConfig("example.com", DomainConfig{SourceIPs: ips("192.0.2.1"), PathRules: base})), base, paths)
+    rng := rand.New(rand.NewSource(1))
+    for round := 0; round < 20; round++ {
+        order := rng.Perm(len(base))
+        shuffled := make([]PathConfig, len(base))
+        for i, j := range order {
+            shuffled[i] = base[j]
+        }
+        handler := newTestSentinel(t, domainConfig("example.com", DomainConfig{SourceIPs: ips("192.0.2.1"), PathRules: shuffled}))
+        if got := decisions(handler, base, paths); fmt.Sprint(got) != fmt.Sprint(want) {
+            t.Fatalf("order %v: decisions %v, want %v as in the written order", order, got, want)
+        }
+    }
+}
+
+func TestPrefixWildcardBoundary(t *testing.T) {
+    tests := []struct {
+        path          string
+        strict, loose bool
+    }{
+        {"/api", true, true},
+        {"/api/", true, true},
+        {"/api/health", true, true},
+        {"/api/v1/health", true, true},
+        {"/apiv2/health", false, true},
+        {"/api-docs", false, true},
+        {"/ap", false, false},
+        {"/other/api", false, false},
+    }
+    for _, tt := range tests {
+        if got := isPathAllowed(tt.path, "/api/*", false); got != tt.strict {
+            t.Errorf("/api/* matching %q = %v, want %v", tt.path, got, tt.strict)
+        }
+        if got := isPathAllowed(tt.path, "/api/*", true); got != tt.loose {
+            t.Errorf("/api/* matching %q with loosePathPrefix = %v, want %v", tt.path, got, tt.loose)
+        }
+    }
+
+    for _, loose := range []bool{false, true} {
+        config := domainConfig("example.com", DomainConfig{
+            SourceIPs: ips("0.0.0.0/0"),
+            PathRules: []PathConfig{{Path: "/api/*", SourceIPs: ips("10.0.0.1")}},
+        })
+        config.LoosePathPrefix = loose
+        public := http.StatusOK
+        if loose {
+            public = http.StatusForbidden
+        }
+        checkStatuses(t, newTestSentinel(t, config), []statusCase{
+            {"http://example.com/api/admin", "192.0.2.1:1234", http.StatusForbidden},
+            {"http://example.com/api", "192.0.2.1:1234", http.StatusForbidden},
+            {"http://example.com/apiv2/health", "192.0.2.1:1234", public},
+            {"http://example.com/api-docs", "192.0.2.1:1234", public},
+        })
+    }
+}
+
+func TestNormalizeTrailingSlash(t *testing.T) {
+    for path, want := range map[string]string{"/": "/", "/admin": "/admin", "/admin/": "/admin", "/admin//": "/admin/", "": ""} {
+        if got := trimTrailingSlash(path); got != want {
+            t.Errorf("trimTrailingSlash(%q) = %q, want %q", path, got, want)
+        }
+    }
+
+    rules := []PathConfig{
+        {Path: "/admin", SourceIPs: ips("10.0.0.1")},
+        {Path: "/files/", SourceIPs: ips("10.0.0.1")},
+        {Path: "/api/*", SourceIPs: ips("10.0.0.1")},
+        {Path: "/", SourceIPs: ips("10.0.0.1")},
+    }
+    newHandler := func(plugin bool, domain *bool) http.Handler {
+        config := domainConfig("example.com", DomainConfig{SourceIPs: ips("0.0.0.0/0"), PathRules: rules, NormalizeTrailingSlash: domain})
+        config.NormalizeTrailingSlash = plugin
+        return newTestSentinel(t, config)
+    }
+    // Statuses of 192.0.2.1, which only the domain-wide list allows, so a
+    // 403 means a path rule matched.
+    paths := []string{"/admin", "/admin/", "/admin//", "/files", "/files/", "/api", "/api/", "/apix", "/", "/x/"}
+    const rule, domain = http.StatusForbidden, http.StatusOK
+    off := []int{rule, domain, domain, domain, rule, rule, rule, domain, rule, domain}
+    on := []int{rule, rule, rule, rule, rule, rule, rule, domain, rule, domain}
+    enabled, disabled := true, false
+    for _, tc := range []struct {
+        name    string
+        handler http.Handler
+        want    []int
+    }{
+        {"off", newHandler(false, nil), off},
+        {"on", newHandler(true, nil), on},
+        {"on for the domain", newHandler(false, &enabled), on},
+        {"off for the domain", newHandler(true, &disabled), off},
+    } {
+        for i, path := range paths {
+            // "/admin//" is canonicalized to "/admin/" before matching.
+            if got := serve(tc.handler, "http://example.com"+path, "192.0.2.1:1234").Code; got != tc.want[i] {
+                t.Errorf("%s: GET %s: status %d, want %d", tc.name, path, got, tc.want[i])
+            }
+        }
+    }
+}
+
+func TestCanonicalPath(t *testing.T) {
+    tests := []struct {
+        escaped       string
+        decodeSlashes bool
+        want          string // empty if the path is rejected
+    }{
+        {"", false, "/"},
+        {"/", false, "/"},
+        {"/admin/panel", false, "/admin/panel"},
+        {"//admin/panel", false, "/admin/panel"},
+        {"/admin//panel", false, "/admin/panel"},
+        {"///admin///panel///", false, "/admin/panel/"},
+        {"/./admin/./panel", false, "/admin/panel"},
+        {"/admin/../admin/panel", false, "/admin/panel"},
+        {"/x/y/../../admin/panel", false, "/admin/panel"},
+        {"/admin/panel/.", false, "/admin/panel/"},
+        {"/admin/panel/..", false, "/admin/"},
+        {"/admin/x/..", false, "/admin/"},
+        {"/%2e/admin", false, "/admin"},
+        {"/%2E%2E/admin/panel", false, ""},
+        {"/x/%2e%2e/admin/panel", false, "/admin/panel"},
+        {"/x/.%2E/admin", false, "/admin"},
+        {"/%61dmin/panel", false, "/admin/panel"},
+        {"/admin%2Fpanel", false, "/admin%2Fpanel"},
+        {"/admin%2fpanel", false, "/admin%2Fpanel"},
+        {"/admin%2Fpanel", true, "/admin/panel"},
+        {"/x%2F..%2Fadmin", true, "/admin"},
+        {"/x%2F..%2Fadmin", false, "/x%2F..%2Fadmin"},
+        {"/%2F%2Fadmin", true, "/admin"},
+        {"/%252e%252e/admin", false, "/%2e%2e/admin"}, // decoded once only
+        {"/..", false, ""},
+        {"/../admin", false, ""},
+        {"/admin/../../etc", false, ""},
+        {"/%2e%2e%2fadmin", true, ""},
+        {"/admin/%zz", false, ""},
+        {"/admin/%4", false, ""},
+        {"/admin/%", false, ""},
+    }
+    for _, tt := range tests {
+        got, err := canonicalPath(tt.escaped, tt.decodeSlashes)
+        switch {
+        case tt.want == "" && err == nil:
+            t.Errorf("canonicalPath(%q, %v) = %q, want an error", tt.escaped, tt.decodeSlashes, got)
+        case tt.want != "" && (err != nil || got != tt.want):
+            t.Errorf("canonicalPath(%q, %v) = %q, %v; want %q", tt.escaped, tt.decodeSlashes, got, err, tt.want)
+        }
+    }
+}
+
+func TestPathBypassAttempts(t *testing.T) {
+    for _, decodeSlashes := range []bool{false, true} {
+        config := domainConfig("example.com", DomainConfig{
+            SourceIPs: ips("0.0.0.0/0"),
+            PathRules: []PathConfig{{Path: "/admin/*", SourceIPs: ips("10.0.0.1")}},
+        })
+        config.DecodeEncodedSlashes = decodeSlashes
+        handler := newTestSentinel(t, config)
+        for _, target := range []string{
+            "/admin/panel",
+            "//admin/panel",
+            "/./admin/panel",
+            "/admin/../admin/panel",
+            "/x/../admin/panel",
+            "/x/%2e%2e/admin/panel",
+            "/%2e/admin/panel",
+            "/%61dmin/panel",
+            "/admin/./panel",
+        } {
+            if got := serve(handler, "http://example.com"+target, "192.0.2.1:1234").Code; got != http.StatusForbidden {
+                t.Errorf("decodeEncodedSlashes %v: GET %s: status %d, want 403", decodeSlashes, target, got)
+            }
+        }
+        for _, target := range []string{"/../admin/panel", "/%2e%2e/admin/panel", "/admin/../../panel"} {
+            if got := serve(handler, "http://example.com"+target, "192.0.2.1:1234").Code; got != http.StatusBadRequest {
+                t.Errorf("decodeEncodedSlashes %v: GET %s: status %d, want 400", decodeSlashes, target, got)
+            }
+        }
+    }
+
+    // Only with decodeEncodedSlashes is an encoded slash a separator.
+    config := domainConfig("example.com", DomainConfig{
+        SourceIPs: ips("0.0.0.0/0"),
+        PathRules: []PathConfig{{Path: "/admin/*", SourceIPs: ips("10.0.0.1")}},
+    })
+    target := "http://example.com/x%2F..%2Fadmin/panel"
+    if got := serve(newTestSentinel(t, config), target, "192.0.2.1:1234").Code; got != http.StatusOK {
+        t.Errorf("GET %s: status %d, want 200", target, got)
+    }
+    config.DecodeEncodedSlashes = true
+    if got := serve(newTestSentinel(t, config), target, "192.0.2.1:1234").Code; got != http.StatusForbidden {
+        t.Errorf("decodeEncodedSlashes: GET %s: status %d, want 403", target, got)
+    }
+}
+
+func TestMethodSpecificPathRules(t *testing.T) {
+    handler := newTestSentinel(t, domainConfig("example.com", DomainConfig{
+        SourceIPs: ips("10.1.0.0/16"),
+        PathRules: []PathConfig{
+            {Path: "/api/reports/*", Methods: []string{"get", "HEAD"}, SourceIPs: ips("0.0.0.0/0")},
+            {Path: "/api/*", SourceIPs: ips("10.0.0.0/16")},
+            {Path: "/upload", Methods: []string{http.MethodPost}, SourceIPs: ips("10.0.0.0/16")},
+        },
+    }))
+    tests := []struct {
+        method, path, remoteAddr string
+        want                     int
+    }{
+        // The GET-only rule and the catch-all /api/* both match.
+        {http.MethodGet, "/api/reports/q3", "192.0.2.1:1234", http.StatusOK},
+        {http.MethodHead, "/api/reports/q3", "192.0.2.1:1234", http.StatusOK},
+        {http.MethodPost, "/api/reports/q3", "192.0.2.1:1234", http.StatusForbidden},
+        {http.MethodDelete, "/api/reports/q3", "192.0.2.1:1234", http.StatusForbidden},
+        {http.MethodPost, "/api/reports/q3", "10.0.0.5:1234", http.StatusOK},
+        {"post", "/api/reports/q3", "192.0.2.1:1234", http.StatusForbidden},
+        // Without another matching rule, the domain-wide list decides.
+        {http.MethodPost, "/upload", "10.0.0.5:1234", http.StatusOK},
+        {http.MethodPost, "/upload", "10.1.0.5:1234", http.StatusForbidden},
+        {http.MethodGet, "/upload", "10.1.0.5:1234", http.StatusOK},
+        {http.MethodGet, "/upload", "10.0.0.5:1234", http.StatusForbidden},
+    }
+    for _, tt := range tests {
+        req := httptest.NewRequest(tt.method, "http://example.com"+tt.path, nil)
+        req.RemoteAddr = tt.remoteAddr
+        rw := httptest.NewRecorder()
+        handler.ServeHTTP(rw, req)
+        if rw.Code != tt.want {
+            t.Errorf("%s %s from %s: status %d, want %d", tt.method, tt.path, tt.remoteAddr, rw.Code, tt.want)
+        }
+    }
+
+    for _, methods := range [][]string{{"GET POST"}, {""}, {"GE(T"}} {
+        config := domainConfig("example.com", DomainConfig{
+            SourceIPs: ips("10.0.0.1"),
+            PathRules: []PathConfig{{Path: "/a", Methods: methods, SourceIPs: ips("10.0.0.1")}},
+        })
+        if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+            t.Errorf("methods %q: New succeeded, want an error", methods)
+        }
+    }
+}
+
+// decidingRules returns a probe for a domain whose path rules each allow
+// one address of their own, 10.0.0.1, 10.0.0.2, ... in the order of rules,
+// and whose domain-wide list allows 10.0.9.9. The probe reports the rule
+// that decided a request by its path, "domain" for the domain-wide list,
+// or "" if the request was let through without an IP check.
+func decidingRules(t *testing.T, rule DomainConfig, configure ...func(*Config)) (http.Handler, *ruleProbe) {
+    t.Helper()
+    probe := &ruleProbe{addrs: map[string]string{"domain": "10.0.9.9"}}
+    rule.SourceIPs = ips("10.0.9.9")
+    rules := make([]PathConfig, len(rule.PathRules))
+    for i, pathRule := range rule.PathRules {
+        addr := fmt.Sprintf("10.0.0.%d", i+1)
+        pathRule.SourceIPs = ips(addr)
+        rules[i] = pathRule
+        probe.addrs[pathRule.Path] = addr
+    }
+    rule.PathRules = rules
+    config := domainConfig("example.com", rule)
+    for _, f := range configure {
+        f(config)
+    }
+    return newTestSentinel(t, config), probe
+}
+
+func checkDeciding(t *testing.T, handler http.Handler, probe *ruleProbe, want map[string]string) {
+    t.Helper()
+    for path, rule := range want {
+        if got := probe.match(handler, "http://example.com"+path); got != rule {
+            t.Errorf("GET %s decided by %q, want %q", path, got, rule)
+        }
+    }
+}
+
+func TestExceptPaths(t *testing.T) {
+    handler, probe := decidingRules(t, DomainConfig{PathRules: []PathConfig{
+        {Path: "/internal/*", ExceptPaths: []string{"/internal/health", "/internal/public/*"}},
+        {Path: "/internal/debug/*", ExceptPaths: []string{"/internal/debug/open", "~/internal/debug/v[0-9]+"}},
+        {Path: "/internal/public/admin"},
+    }})
+    checkDeciding(t, handler, probe, map[string]string{
+        "/internal/x":        "/internal/*",
+        "/internal":          "/internal/*",
+        "/internal/health":   "domain",
+        "/internal/health/x": "/internal/*",
+        "/internal/public/x": "domain",
+        // A narrower rule covers an exception of a broader one.
+        "/internal/public/admin": "/internal/public/admin",
+        // An exception of the narrower rule falls through to the broader one.
+        "/internal/debug/x":    "/internal/debug/*",
+        "/internal/debug/open": "/internal/*",
+        "/internal/debug/v2":   "/internal/*",
+        "/other":               "domain",
+    })
+}
+
+func TestPublicPathsOrdering(t *testing.T) {
+    rule := DomainConfig{
+        PublicPaths: []string{"/robots.txt", "/hooks/*", "/admin/status"},
+        PathRules: []PathConfig{
+            {Path: "/admin/*"},
+            {Path: "/hooks/internal", Methods: []string{http.MethodPost}},
+        },
+    }
+    handler, probe := decidingRules(t, rule)
+    checkDeciding(t, handler, probe, map[string]string{
+        "/robots.txt":   "",
+        "/hooks/github": "",
+        "/private":      "domain",
+        // By default, an overlapping path rule wins over a public path ...
+        "/admin/status": "/admin/*",
+        // ... unless it does not apply to the request.
+        "/hooks/internal": "",
+    })
+
+    rule.PublicPathsFirst = boolFlag(true)
+    handler, probe = decidingRules(t, rule)
+    checkDeciding(t, handler, probe, map[string]string{
+        "/robots.txt":   "",
+        "/admin/status": "",
+        "/admin/other":  "/admin/*",
+        "/private":      "domain",
+    })
+}
+
+func TestPublicPathsInherited(t *testing.T) {
+    newConfig := func(first bool) *Config {
+        config := domainConfig("example.com", DomainConfig{IncludeSubdomains: boolFlag(true), SourceIPs: ips("10.0.0.0/8")})
+        config.DomainPathRules["app.example.com"] = DomainConfig{
+            Inherit:          boolFlag(true),
+            SourceIPs:        ips("10.1.0.0/16"),
+            PublicPaths:      []string{"/robots.txt"},
+            PublicPathsFirst: boolFlag(first),
+        }
+        return config
+    }
+    checkStatuses(t, newTestSentinel(t, newConfig(false)), []statusCase{
+        {"http://app.example.com/robots.txt", "10.2.0.1:1234", http.StatusOK},
+        // The inherited rules of the parent are still checked.
+        {"http://app.example.com/robots.txt", "192.0.2.1:1234", http.StatusForbidden},
+        {"http://app.example.com/", "10.2.0.1:1234", http.StatusForbidden},
+    })
+    checkStatuses(t, newTestSentinel(t, newConfig(true)), []statusCase{
+        {"http://app.example.com/robots.txt", "192.0.2.1:1234", http.StatusOK},
+        {"http://app.example.com/", "192.0.2.1:1234", http.StatusForbidden},
+    })
+}
+
+func TestSuffixPatterns(t *testing.T) {
+    handler := newTestSentinel(t, domainConfig("example.com", DomainConfig{
+        SourceIPs: ips("0.0.0.0/0"),
+        PathRules: []PathConfig{
+            {Path: "*.php", SourceIPs: ips("10.0.0.1")},
+            {Path: "*.env", DeniedIPs: []string{"0.0.0.0/0", "::/0"}, SourceIPs: ips("0.0.0.0/0")},
+            {Path: "*.bak"}, // empty list: everyone is denied
+            {Path: "*/.git/*", SourceIPs: ips("10.0.0.1")},
+            {Path: "/api/*", SourceIPs: ips("0.0.0.0/0")},
+        },
+    }))
+    const public = "192.0.2.1:1234"
+    checkStatuses(t, handler, []statusCase{
+        {"http://example.com/index.php", public, http.StatusForbidden},
+        {"http://example.com/a/b/c.php", public, http.StatusForbidden},
+        {"http://example.com/index.php", "10.0.0.1:1234", http.StatusOK},
+        // Upper-case extensions and query strings do not get around them.
+        {"http://example.com/INDEX.PHP", public, http.StatusForbidden},
+        {"http://example.com/index.Php", public, http.StatusForbidden},
+        {"http://example.com/index.php?x=1", public, http.StatusForbidden},
+        {"http://example.com/index.php?file=a.txt", public, http.StatusForbidden},
+        {"http://example.com/index.php/", public, http.StatusOK},
+        {"http://example.com/index.phpx", public, http.StatusOK},
+        {"http://example.com/index.txt?f=a.php", public, http.StatusOK},
+        // deniedIPs and empty lists deny everyone, even inside /api/*.
+        {"http://example.com/.env", "10.0.0.1:1234", http.StatusForbidden},
+        {"http://example.com/api/.ENV", public, http.StatusForbidden},
+        {"http://example.com/db.bak", "10.0.0.1:1234", http.StatusForbidden},
+        {"http://example.com/x/DB.BAK?download=1", public, http.StatusForbidden},
+        {"http://example.com/.git/config", public, http.StatusForbidden},
+        {"http://example.com/app/.git/HEAD", public, http.StatusForbidden},
+        {"http://example.com/app/.github/x", public, http.StatusOK},
+        {"http://example.com/api/users", public, http.StatusOK},
+    })
+}
+
+func TestInheritDomainIPs(t *testing.T) {
+    enabled, disabled := true, false
+    newHandler := func(domain bool) http.Handler {
+        return newTestSentinel(t, domainConfig("example.com", DomainConfig{
+            SourceIPs:        ips("10.0.0.0/16"),
+            InheritDomainIPs: boolFlag(domain),
+            PathRules: []PathConfig{
+                {Path: "/reports/*", DeniedIPs: []string{"10.0.5.0/24"}},
+                {Path: "/own/*", SourceIPs: ips("192.0.2.0/24")},
+                {Path: "/own-flag/*", SourceIPs: ips("192.0.2.0/24"), InheritDomainIPs: &enabled},
+                {Path: "/strict/*", InheritDomainIPs: &disabled},
+                {Path: "/opt-in/*", InheritDomainIPs: &enabled},
+            },
+        }))
+    }
+    // Default: an empty path rule list denies everyone.
+    checkStatuses(t, newHandler(false), []statusCase{
+        {"http://example.com/reports/q3", "10.0.1.1:1234", http.StatusForbidden},
+        {"http://example.com/opt-in/x", "10.0.1.1:1234", http.StatusOK},
+        {"http://example.com/opt-in/x", "192.0.2.1:1234", http.StatusForbidden},
+    })
+    checkStatuses(t, newHandler(true), []statusCase{
+        {"http://example.com/reports/q3", "10.0.1.1:1234", http.StatusOK},
+        {"http://example.com/reports/q3", "10.0.5.1:1234", http.StatusForbidden}, // the rule's own deniedIPs
+        {"http://example.com/reports/q3", "192.0.2.1:1234", http.StatusForbidden},
+        {"http://example.com/strict/x", "10.0.1.1:1234", http.StatusForbidden},
+        // A rule's own IPs win over the flag, set on the domain or the rule.
+        {"http://example.com/own/x", "192.0.2.1:1234", http.StatusOK},
+        {"http://example.com/own/x", "10.0.1.1:1234", http.StatusForbidden},
+        {"http://example.com/own-flag/x", "192.0.2.1:1234", http.StatusOK},
+        {"http://example.com/own-flag/x", "10.0.1.1:1234", http.StatusForbidden},
+    })
+
+    // The fallback is logged.
+    out := captureOutput(t, func() { serve(newHandler(true), "http://example.com/reports/q3", "10.0.1.1:1234") })
+    if !strings.Contains(out, "inheritDomainIPs") {
+        t.Errorf("log does not mention the inheritDomainIPs fallback:\n%s", out)
+    }
+}
+
+func TestGlobalPathRules(t *testing.T) {
+    config := CreateConfig()
+    config.GlobalPathRules = []PathConfig{
+        {Name: "git", Path: "/.git/*", SourceIPs: ips("10.9.0.0/16")},
+        {Path: "/server-status", SourceIPs: ips("10.9.0.0/16")},
+    }
+    config.DomainPathRules["example.com"] = DomainConfig{
+        SourceIPs: ips("10.0.0.0/8"),
+        PathRules: []PathConfig{{Path: "/.git/*", SourceIPs: ips("10.1.0.0/16")}},
+    }
+    config.DomainPathRules["git.example.com"] = DomainConfig{
+        SourceIPs:       ips("10.0.0.0/8"),
+        SkipGlobalRules: []string{"git"},
+    }
+    config.DomainPathRules["public.example.com"] = DomainConfig{
+        SourceIPs:   ips("10.0.0.0/8"),
+        PublicPaths: []string{"/server-status"},
+    }
+    handler := newTestSentinel(t, config)
+    checkStatuses(t, handler, []statusCase{
+        // Both the global and the domain rule match: each must allow.
+        {"http://example.com/.git/config", "10.9.1.1:1234", http.StatusForbidden},
+        {"http://example.com/.git/config", "10.1.1.1:1234", http.StatusForbidden},
+        {"http://example.com/server-status", "10.9.1.1:1234", http.StatusOK},
+        {"http://example.com/server-status", "10.2.1.1:1234", http.StatusForbidden},
+        {"http://example.com/", "10.2.1.1:1234", http.StatusOK},
+        // The domain opts out of the named rule only.
+        {"http://git.example.com/.git/config", "10.2.1.1:1234", http.StatusOK},
+        {"http://git.example.com/server-status", "10.2.1.1:1234", http.StatusForbidden},
+        // Public paths of the domain do not lift a global rule.
+        {"http://public.example.com/server-status", "192.0.2.1:1234", http.StatusForbidden},
+        {"http://public.example.com/server-status", "10.9.1.1:1234", http.StatusOK},
+        // Unconfigured hosts are not checked without the flag.
+        {"http://other.example.org/.git/config", "192.0.2.1:1234", http.StatusOK},
+    })
+
+    config.GlobalPathRulesForUnconfigured = true
+    handler = newTestSentinel(t, config)
+    checkStatuses(t, handler, []statusCase{
+        {"http://other.example.org/.git/config", "192.0.2.1:1234", http.StatusForbidden},
+        {"http://other.example.org/.git/config", "10.9.1.1:1234", http.StatusOK},
+        {"http://other.example.org/", "192.0.2.1:1234", http.StatusOK},
+    })
+}
+
+func TestSkipGlobalRulesErrors(t *testing.T) {
+    config := domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.0/8"), SkipGlobalRules: []string{"git"}})
+    if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+        t.Error("skipGlobalRules without globalPathRules: New succeeded, want an error")
+    }
+    config.GlobalPathRules = []PathConfig{{Name: "svn", Path: "/.svn/*", SourceIPs: ips("10.9.0.0/16")}}
+    if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+        t.Error("skipGlobalRules naming an unknown rule: New succeeded, want an error")
+    }
+}
+
+func TestRawRequestPath(t *testing.T) {
+    tests := []struct{ requestURI, want string }{
+        {"/admin%2Fpanel", "/admin%2Fpanel"},
+        {"/admin%2fpanel?x=%2f", "/admin%2Fpanel"},
+        {"/x/%2e%2E/admin", "/x/%2E%2E/admin"},
+        {"/%C3%a4%c3%A4", "/%C3%A4%C3%A4"},
+        {"/a%2", "/a%2"},
+        {"/a%zz", "/a%zz"},
+        {"//admin/./panel", "//admin/./panel"},
+        {"/", "/"},
+    }
+    for _, tt := range tests {
+        req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+        req.RequestURI = tt.requestURI
+        if got := rawRequestPath(req); got != tt.want {
+            t.Errorf("rawRequestPath(%q) = %q, want %q", tt.requestURI, got, tt.want)
+        }
+    }
+}
+
+func TestMatchRawPath(t *testing.T) {
+    rules := []PathConfig{
+        {Path: "/admin%2F*", SourceIPs: ips("10.0.0.0/8")},
+        {Path: "/admin/*", SourceIPs: ips("10.0.0.0/8")},
+    }
+    config := CreateConfig()
+    config.DomainPathRules["raw.example.com"] = DomainConfig{SourceIPs: ips("0.0.0.0/0"), MatchRawPath: boolFlag(true), PathRules: rules}
+    config.DomainPathRules["canonical.example.com"] = DomainConfig{SourceIPs: ips("0.0.0.0/0"), PathRules: rules}
+    handler := newTestSentinel(t, config)
+    tests := []struct {
+        host, requestURI, remoteAddr string
+        want                         int
+    }{
+        // Encoded slashes stay part of the segment.
+        {"raw.example.com", "/admin%2Fpanel", "192.0.2.1:1234", http.StatusForbidden},
+        {"raw.example.com", "/admin%2Fpanel", "10.1.1.1:1234", http.StatusOK},
+        {"raw.example.com", "/admin/panel", "192.0.2.1:1234", http.StatusForbidden},
+        // Mixed-case hex digits are the same octet.
+        {"raw.example.com", "/admin%2fpanel", "192.0.2.1:1234", http.StatusForbidden},
+        // Nothing else is decoded: encoded letters and dots do not match
+        // the rules they would after decoding.
+        {"raw.example.com", "/%61dmin/panel", "192.0.2.1:1234", http.StatusOK},
+        {"raw.example.com", "/x/%2e%2e/admin/panel", "192.0.2.1:1234", http.StatusOK},
+        {"raw.example.com", "/x/%2E%2e/admin/panel", "192.0.2.1:1234", http.StatusOK},
+        // Paths escaping the root are rejected either way.
+        {"raw.example.com", "/%2e%2e/admin/panel", "192.0.2.1:1234", http.StatusBadRequest},
+        {"canonical.example.com", "/%2e%2e/admin/panel", "192.0.2.1:1234", http.StatusBadRequest},
+        // The canonical path decodes letters and dots.
+        {"canonical.example.com", "/%61dmin/panel", "192.0.2.1:1234", http.StatusForbidden},
+        {"canonical.example.com", "/x/%2e%2E/admin/panel", "192.0.2.1:1234", http.StatusForbidden},
+        {"canonical.example.com", "/admin%2fpanel", "192.0.2.1:1234", http.StatusForbidden},
+    }
+    for _, tt := range tests {
+        req := httptest.NewRequest(http.MethodGet, tt.requestURI, nil)
+        req.Host = tt.host
+        req.RemoteAddr = tt.remoteAddr
+        rw := httptest.NewRecorder()
+        handler.ServeHTTP(rw, req)
+        if rw.Code != tt.want {
+            t.Errorf("GET %s%s from %s: status %d, want %d", tt.host, tt.requestURI, tt.remoteAddr, rw.Code, tt.want)
+        }
+    }
+}
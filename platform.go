@@ -0,0 +1,97 @@
+package DomainSentinel
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// platformPreset is the client IP setup of a CDN or hosting platform.
+type platformPreset struct {
+    mode    string
+    headers []string
+    depth   int      // X-Forwarded-For entry counted from the right, 0 to walk the chain
+    ranges  []string // published edge ranges, empty if the vendor has no static list
+}
+
+// platformPresets are the values accepted by IPStrategy.Platform. The edge
+// ranges are snapshots of the vendors' published lists; they change rarely
+// but can be replaced with trustedProxies when they do.
+var platformPresets = map[string]platformPreset{
+    // https://www.cloudflare.com/ips/
+    "cloudflare": {
+        mode:    ipStrategyCDNHeader,
+        headers: []string{"CF-Connecting-IP"},
+        ranges: []string{
+            "173.245.48.0/20", "103.21.244.0/22", "103.22.200.0/22", "103.31.4.0/22",
+            "141.101.64.0/18", "108.162.192.0/18", "190.93.240.0/20", "188.114.96.0/20",
+            "197.234.240.0/22", "198.41.128.0/17", "162.158.0.0/15", "104.16.0.0/13",
+            "104.24.0.0/14", "172.64.0.0/13", "131.0.72.0/22",
+            "2400:cb00::/32", "2606:4700::/32", "2803:f800::/32", "2405:b500::/32",
+            "2405:8100::/32", "2a06:98c0::/29", "2c0f:f248::/32",
+        },
+    },
+    // https://api.fastly.com/public-ip-list
+    "fastly": {
+        mode:    ipStrategyCDNHeader,
+        headers: []string{"Fastly-Client-IP"},
+        ranges: []string{
+            "23.235.32.0/20", "43.249.72.0/22", "103.244.50.0/24", "103.245.222.0/23",
+            "103.245.224.0/24", "104.156.80.0/20", "140.248.64.0/18", "140.248.128.0/17",
+            "146.75.0.0/17", "151.101.0.0/16", "157.52.64.0/18", "167.82.0.0/17",
+            "167.82.128.0/20", "167.82.160.0/20", "167.82.224.0/20", "172.111.64.0/18",
+            "185.31.16.0/22", "199.27.72.0/21", "199.232.0.0/16",
+            "2a04:4e40::/32", "2a04:4e42::/32",
+        },
+    },
+    // Fly.io's proxy reaches apps over the private network, which differs per organization.
+    "flyio": {
+        mode:    ipStrategyCDNHeader,
+        headers: []string{"Fly-Client-IP"},
+    },
+    // CloudFront appends the viewer address to X-Forwarded-For; its
+    // origin-facing ranges are only published as a changing JSON feed.
+    "cloudfront": {
+        mode:  ipStrategyXForwardedFor,
+        depth: 1,
+    },
+}
+
+// platformNames returns the valid platform names, sorted.
+func platformNames() []string {
+    names := make([]string, 0, len(platformPresets))
+    for name := range platformPresets {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// applyPlatform fills in the mode, headers, depth and trusted proxies of an
+// ipStrategy block from its platform preset. Explicit headers, depth and
+// trustedProxies take precedence over the preset.
+func applyPlatform(config IPStrategy) (IPStrategy, error) {
+    preset, ok := platformPresets[config.Platform]
+    if !ok {
+        return config, fmt.Errorf("invalid platform %q: must be one of %s",
+            config.Platform, strings.Join(platformNames(), ", "))
+    }
+    if config.Mode != "" {
+        return config, fmt.Errorf("mode cannot be combined with platform %q", config.Platform)
+    }
+    config.Mode = preset.mode
+    if len(config.Headers) == 0 {
+        config.Headers = preset.headers
+    }
+    if config.Depth == nil && preset.depth > 0 {
+        depth := preset.depth
+        config.Depth = &depth
+    }
+    if len(config.TrustedProxies) == 0 {
+        if len(preset.ranges) == 0 {
+            return config, fmt.Errorf("platform %q publishes no static edge ranges; list them in trustedProxies", config.Platform)
+        }
+        config.TrustedProxies = preset.ranges
+    }
+    return config, nil
+}
@@ -0,0 +1,153 @@
+package DomainSentinel
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// builtinPublicSuffixes is a subset of the Public Suffix List
+// (https://publicsuffix.org/list/) covering the common multi-label
+// suffixes. Every single-label TLD is a public suffix without being
+// listed. publicSuffixList loads the full list instead.
+var builtinPublicSuffixes = []string{
+    "ac.uk", "co.uk", "gov.uk", "ltd.uk", "me.uk", "net.uk", "nhs.uk", "org.uk", "plc.uk", "police.uk", "sch.uk",
+    "asn.au", "com.au", "edu.au", "gov.au", "id.au", "net.au", "org.au",
+    "ac.nz", "co.nz", "geek.nz", "gen.nz", "govt.nz", "net.nz", "org.nz", "school.nz",
+    "ac.jp", "ad.jp", "co.jp", "ed.jp", "go.jp", "gr.jp", "lg.jp", "ne.jp", "or.jp",
+    "ac.kr", "co.kr", "go.kr", "ne.kr", "or.kr", "re.kr",
+    "com.cn", "edu.cn", "gov.cn", "net.cn", "org.cn",
+    "com.hk", "edu.hk", "gov.hk", "net.hk", "org.hk",
+    "com.tw", "edu.tw", "gov.tw", "net.tw", "org.tw",
+    "com.sg", "edu.sg", "gov.sg", "net.sg", "org.sg",
+    "ac.in", "co.in", "edu.in", "firm.in", "gen.in", "gov.in", "ind.in", "net.in", "org.in",
+    "ac.id", "co.id", "go.id", "or.id", "web.id",
+    "ac.th", "co.th", "go.th", "in.th", "or.th",
+    "com.my", "edu.my", "gov.my", "net.my", "org.my",
+    "com.ph", "edu.ph", "gov.ph", "net.ph", "org.ph",
+    "com.vn", "edu.vn", "gov.vn", "net.vn", "org.vn",
+    "com.pk", "edu.pk", "gov.pk", "net.pk", "org.pk",
+    "ac.il", "co.il", "gov.il", "net.il", "org.il",
+    "com.tr", "edu.tr", "gov.tr", "net.tr", "org.tr",
+    "ac.za", "co.za", "gov.za", "net.za", "org.za", "web.za",
+    "com.ng", "edu.ng", "gov.ng", "net.ng", "org.ng",
+    "co.ke", "go.ke", "or.ke", "ac.ke",
+    "com.eg", "edu.eg", "gov.eg", "net.eg", "org.eg",
+    "com.br", "edu.br", "gov.br", "net.br", "org.br",
+    "com.ar", "edu.ar", "gob.ar", "net.ar", "org.ar",
+    "com.mx", "edu.mx", "gob.mx", "net.mx", "org.mx",
+    "com.co", "edu.co", "gov.co", "net.co", "org.co",
+    "com.pe", "edu.pe", "gob.pe", "net.pe", "org.pe",
+    "co.ve", "com.ve", "gob.ve", "net.ve", "org.ve",
+    "com.ua", "edu.ua", "gov.ua", "net.ua", "org.ua",
+    "com.pl", "net.pl", "org.pl",
+    "co.at", "gv.at", "or.at",
+    "com.es", "edu.es", "gob.es", "nom.es", "org.es",
+    "com.pt", "edu.pt", "gov.pt", "org.pt",
+    "com.gr", "edu.gr", "gov.gr", "net.gr", "org.gr",
+    "com.ru", "net.ru", "org.ru",
+    "*.ck", "!www.ck",
+    "*.bd",
+    "*.np",
+    "github.io", "gitlab.io", "herokuapp.com", "netlify.app", "pages.dev", "vercel.app", "workers.dev",
+    "appspot.com", "blogspot.com", "cloudfront.net", "azurewebsites.net", "fly.dev",
+}
+
+// suffixList answers which part of a host name is its public suffix. The
+// names are in the lower-case ASCII form of the domain table keys.
+type suffixList struct {
+    rules      map[string]struct{} // "co.uk"
+    wildcards  map[string]struct{} // "*.ck", keyed by "ck"
+    exceptions map[string]struct{} // "!www.ck", keyed by "www.ck"
+}
+
+// newSuffixList compiles Public Suffix List rules. Unicode rules are
+// converted to punycode.
+func newSuffixList(rules []string) (*suffixList, error) {
+    l := &suffixList{
+        rules:      make(map[string]struct{}),
+        wildcards:  make(map[string]struct{}),
+        exceptions: make(map[string]struct{}),
+    }
+    for _, rule := range rules {
+        set := l.rules
+        switch {
+        case strings.HasPrefix(rule, "!"):
+            set, rule = l.exceptions, rule[1:]
+        case strings.HasPrefix(rule, wildcardPrefix):
+            set, rule = l.wildcards, rule[len(wildcardPrefix):]
+        }
+        name, err := toASCII(rule)
+        if err != nil || name == "" {
+            return nil, fmt.Errorf("invalid public suffix rule %q", rule)
+        }
+        set[name] = struct{}{}
+    }
+    return l, nil
+}
+
+// loadSuffixList reads a file in the format of public_suffix_list.dat:
+// one rule per line, with "//" comments and blank lines ignored.
+func loadSuffixList(path string) (*suffixList, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    var rules []string
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "//") {
+            continue
+        }
+        // Only the part up to the first whitespace is the rule.
+        rules = append(rules, strings.Fields(line)[0])
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    if len(rules) == 0 {
+        return nil, fmt.Errorf("%s: no public suffix rules", path)
+    }
+    l, err := newSuffixList(rules)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    return l, nil
+}
+
+// publicSuffix returns the public suffix of host following the PSL
+// algorithm: an exception rule wins, else the longest matching rule, else
+// the last label.
+func (l *suffixList) publicSuffix(host string) string {
+    for name := host; ; {
+        if _, ok := l.exceptions[name]; ok {
+            return name[strings.IndexByte(name, '.')+1:]
+        }
+        if _, ok := l.rules[name]; ok {
+            return name
+        }
+        i := strings.IndexByte(name, '.')
+        if i < 0 {
+            return name
+        }
+        if _, ok := l.wildcards[name[i+1:]]; ok {
+            return name
+        }
+        name = name[i+1:]
+    }
+}
+
+// registrable returns the registrable domain of host, its public suffix
+// plus one label, or an empty string if host is a public suffix itself.
+func (l *suffixList) registrable(host string) string {
+    suffix := l.publicSuffix(host)
+    if len(suffix) >= len(host) {
+        return ""
+    }
+    rest := host[:len(host)-len(suffix)-1]
+    return rest[strings.LastIndexByte(rest, '.')+1:] + "." + suffix
+}
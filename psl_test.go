@@ -0,0 +1,108 @@
+package DomainSentinel
+
+import (
+    "context"
+    "net/http"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestRegistrableDomain(t *testing.T) {
+    list, err := newSuffixList(append(builtinPublicSuffixes, "*.ck", "!www.ck"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    for host, want := range map[string]string{
+        "example.com":           "example.com",
+        "a.b.example.com":       "example.com",
+        "example.co.uk":         "example.co.uk",
+        "foo.bar.example.co.uk": "example.co.uk",
+        "co.uk":                 "",
+        "uk":                    "",
+        "user.github.io":        "user.github.io",
+        "github.io":             "",
+        "a.b.ck":                "a.b.ck", // *.ck: b.ck is a public suffix
+        "b.ck":                  "",
+        "www.ck":                "www.ck", // !www.ck
+    } {
+        if got := list.registrable(host); got != want {
+            t.Errorf("registrable(%q) = %q, want %q", host, got, want)
+        }
+    }
+}
+
+func TestZoneRules(t *testing.T) {
+    handler, probe := newRuleProbe(t, map[string]DomainConfig{
+        "www.example.co.uk":   {},
+        "*.api.example.co.uk": {},
+        `~^.*\.co\.uk$`:       {},
+        catchAllKey:           {},
+    }, func(config *Config) {
+        config.Zones = map[string]DomainConfig{"example.co.uk": {}, "example.com": {}}
+    })
+    zone := zoneProbeKey("example.co.uk")
+    checkMatches(t, handler, probe, map[string]string{
+        "http://example.co.uk/":         zone,
+        "http://foo.bar.example.co.uk/": zone,
+        "http://Foo.Example.CO.UK/":     zone,
+        "http://other.co.uk/":           `~^.*\.co\.uk$`, // a different registrable domain
+        "http://example.com/":           zoneProbeKey("example.com"),
+        "http://a.example.com/":         zoneProbeKey("example.com"),
+        "http://example.org/":           catchAllKey,
+        // Exact and wildcard keys take precedence over the zone.
+        "http://www.example.co.uk/":    "www.example.co.uk",
+        "http://v1.api.example.co.uk/": "*.api.example.co.uk",
+        "http://api.example.co.uk/":    zone,
+    })
+}
+
+func TestZoneInherit(t *testing.T) {
+    config := domainConfig("admin.example.co.uk", DomainConfig{Inherit: boolFlag(true), SourceIPs: ips("10.1.0.0/16", "192.0.2.1")})
+    config.Zones = map[string]DomainConfig{"example.co.uk": {SourceIPs: ips("10.0.0.0/8")}}
+    checkStatuses(t, newTestSentinel(t, config), []statusCase{
+        {"http://admin.example.co.uk/", "10.1.0.1:1234", http.StatusOK},
+        {"http://admin.example.co.uk/", "192.0.2.1:1234", http.StatusForbidden},
+        {"http://www.example.co.uk/", "10.2.0.1:1234", http.StatusOK},
+    })
+}
+
+func TestZoneKeyErrors(t *testing.T) {
+    for _, key := range []string{"co.uk", "www.example.co.uk", "uk", "*.example.co.uk", "github.io"} {
+        config := CreateConfig()
+        config.Zones = map[string]DomainConfig{key: {SourceIPs: ips("10.0.0.1")}}
+        if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+            t.Errorf("zone %q: New succeeded, want an error", key)
+        }
+    }
+}
+
+func TestPublicSuffixListFile(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "public_suffix_list.dat")
+    data := "// ===BEGIN ICANN DOMAINS===\n\ncom\nexample.com   // a private suffix for the test\n"
+    if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    config := CreateConfig()
+    config.PublicSuffixList = path
+    // example.com is a public suffix in this list, so only its subdomains
+    // are registrable.
+    config.Zones = map[string]DomainConfig{"tenant.example.com": {SourceIPs: ips("10.0.0.1")}}
+    checkStatuses(t, newTestSentinel(t, config), []statusCase{
+        {"http://a.tenant.example.com/", "10.0.0.1:1234", http.StatusOK},
+        {"http://a.tenant.example.com/", "10.0.0.2:1234", http.StatusForbidden},
+        {"http://other.example.com/", "10.0.0.2:1234", http.StatusOK},
+    })
+
+    // With the built-in list, example.co.uk is registrable and co.uk is not;
+    // this list knows neither.
+    config.Zones = map[string]DomainConfig{"example.com": {SourceIPs: ips("10.0.0.1")}}
+    if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+        t.Error("zone example.com under a list with example.com as a suffix: New succeeded, want an error")
+    }
+    config.Zones = nil
+    config.PublicSuffixList = filepath.Join(t.TempDir(), "missing.dat")
+    if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+        t.Error("missing publicSuffixList: New succeeded, want an error")
+    }
+}
@@ -0,0 +1,164 @@
+package DomainSentinel
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net"
+    "net/netip"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Defaults for reverse-DNS verification of sourceHostSuffixes.
+const (
+    defaultReverseDNSCacheTTL = time.Hour
+    reverseDNSFailureTTL      = 30 * time.Second // failures are retried sooner
+    reverseDNSCacheSize       = 10000
+)
+
+// Actions for clients whose reverse-DNS verification fails.
+const (
+    reverseDNSFailureDeny  = "deny"
+    reverseDNSFailureAllow = "allow"
+)
+
+// rdnsVerifier verifies client addresses by forward-confirmed reverse DNS:
+// the PTR names of the address are looked up, and a name only counts if it
+// resolves back to the address. Results are cached per address.
+type rdnsVerifier struct {
+    ttl     time.Duration
+    timeout time.Duration
+
+    mu    sync.Mutex
+    cache map[netip.Addr]rdnsResult
+}
+
+// rdnsResult is a cached verification result.
+type rdnsResult struct {
+    names   []string // forward-confirmed names, lower-case without trailing dot
+    failed  bool     // a lookup failed, so names may be incomplete
+    expires time.Time
+}
+
+func newRDNSVerifier(ttl, timeout time.Duration) *rdnsVerifier {
+    return &rdnsVerifier{ttl: ttl, timeout: timeout, cache: make(map[netip.Addr]rdnsResult)}
+}
+
+// names returns the forward-confirmed names of ip, from the cache if
+// possible. The second result is false if a DNS lookup failed.
+func (v *rdnsVerifier) names(ctx context.Context, ip netip.Addr) ([]string, bool) {
+    now := time.Now()
+    v.mu.Lock()
+    result, ok := v.cache[ip]
+    v.mu.Unlock()
+    if ok && now.Before(result.expires) {
+        return result.names, !result.failed
+    }
+
+    result = v.verify(ctx, ip)
+    if ctx.Err() != nil {
+        // The request went away; do not cache a result it cut short.
+        return result.names, !result.failed
+    }
+    result.expires = now.Add(v.ttl)
+    if result.failed {
+        result.expires = now.Add(reverseDNSFailureTTL)
+    }
+
+    v.mu.Lock()
+    if len(v.cache) >= reverseDNSCacheSize {
+        v.evict(now)
+    }
+    v.cache[ip] = result
+    v.mu.Unlock()
+    return result.names, !result.failed
+}
+
+// evict removes expired results, or an arbitrary one if none has expired.
+// The caller holds mu.
+func (v *rdnsVerifier) evict(now time.Time) {
+    for ip, result := range v.cache {
+        if !now.Before(result.expires) {
+            delete(v.cache, ip)
+        }
+    }
+    for ip := range v.cache {
+        if len(v.cache) < reverseDNSCacheSize {
+            break
+        }
+        delete(v.cache, ip)
+    }
+}
+
+// verify performs the PTR lookup of ip and forward-confirms every name.
+func (v *rdnsVerifier) verify(ctx context.Context, ip netip.Addr) rdnsResult {
+    ctx, cancel := context.WithTimeout(ctx, v.timeout)
+    defer cancel()
+
+    var result rdnsResult
+    ptrs, err := net.DefaultResolver.LookupAddr(ctx, ip.String())
+    if err != nil && !isNotFound(err) {
+        fmt.Printf("Error looking up PTR records of %s: %v\n", ip, err)
+        result.failed = true
+        return result
+    }
+
+    for _, ptr := range ptrs {
+        name := strings.ToLower(strings.TrimSuffix(ptr, "."))
+        addrs, err := net.DefaultResolver.LookupIPAddr(ctx, name)
+        if err != nil {
+            if !isNotFound(err) {
+                fmt.Printf("Error forward-confirming %q for %s: %v\n", name, ip, err)
+                result.failed = true
+            }
+            continue
+        }
+        for _, addr := range addrs {
+            if a, ok := netip.AddrFromSlice(addr.IP); ok && normalizeAddr(a) == ip {
+                result.names = append(result.names, name)
+                break
+            }
+        }
+    }
+    return result
+}
+
+// isNotFound reports whether err means the name does not exist, as opposed
+// to a lookup that could not be completed.
+func isNotFound(err error) bool {
+    var dnsErr *net.DNSError
+    return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// parseHostSuffixes normalizes a sourceHostSuffixes list. "googlebot.com" and
+// ".googlebot.com" both match crawl-1.googlebot.com and googlebot.com itself.
+func parseHostSuffixes(values []string) ([]string, error) {
+    var suffixes []string
+    for _, value := range values {
+        for _, v := range splitListEntry(value) {
+            s := strings.ToLower(strings.Trim(strings.TrimSpace(v), "."))
+            if s == "" {
+                continue
+            }
+            if !isHostname(s) {
+                return nil, fmt.Errorf("invalid host suffix %q", v)
+            }
+            suffixes = append(suffixes, s)
+        }
+    }
+    return suffixes, nil
+}
+
+// matchHostSuffix returns the first name that ends in one of the suffixes.
+func matchHostSuffix(names, suffixes []string) (string, bool) {
+    for _, name := range names {
+        for _, s := range suffixes {
+            if name == s || strings.HasSuffix(name, "."+s) {
+                return name, true
+            }
+        }
+    }
+    return "", false
+}
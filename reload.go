@@ -0,0 +1,232 @@
+package DomainSentinel
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+)
+
+// ruleSet is the compiled form of domainPathRules, rules and zones, which a
+// reload of the rulesFile or rulesURL replaces as a whole. It owns the
+// background tasks of its rules: resolving their hostnames, reporting
+// expired entries and sweeping autoBan state.
+type ruleSet struct {
+    table    *domainTable
+    rules    map[string]*compiledDomain // domains and zones, by key
+    hosts    *hostSet
+    expiring []expiringEntry
+    stop     context.CancelFunc // set by start
+}
+
+// fork returns a compiler for the domain rules that shares the plugin-level
+// settings and databases of c, but collects its own hostnames and expiring
+// entries, so that each ruleSet can refresh and drop them on its own.
+func (c *compiler) fork() *compiler {
+    f := *c
+    f.hosts = newHostSet(c.hosts.timeout)
+    f.expiring = nil
+    return &f
+}
+
+// compileRuleSet compiles the domain rules of config. global holds the
+// globalPathRules that the skipGlobalRules of the domains must name.
+func (c *compiler) compileRuleSet(config *Config, suffixes *suffixList, global *compiledDomain) (*ruleSet, error) {
+    section, ruleConfigs := "domainPathRules", config.DomainPathRules
+    if len(config.Rules) > 0 {
+        section, ruleConfigs = "rules", orderedRuleConfigs(config.Rules)
+    }
+    domains, err := c.compileDomains(section, ruleConfigs)
+    if err != nil {
+        return nil, err
+    }
+    for key := range config.OverrideRules {
+        if cd, ok := domains[key]; ok {
+            cd.override = overrideModeMerge
+            if config.OverrideMode != "" {
+                cd.override = config.OverrideMode
+            }
+        }
+    }
+    for _, zone := range sortedConfigKeys(config.Zones) {
+        if err := validateZoneKey(zone, config.Zones[zone]); err != nil {
+            return nil, entryError("zones", zone, err)
+        }
+    }
+    zones, err := c.compileDomains("zones", config.Zones)
+    if err != nil {
+        return nil, err
+    }
+    var table *domainTable
+    if len(config.Rules) > 0 {
+        table, err = newOrderedTable(domains, len(config.Rules))
+    } else {
+        table, err = newDomainTable(domains, zones, suffixes)
+    }
+    if err != nil {
+        return nil, err
+    }
+    rs := &ruleSet{table: table, rules: make(map[string]*compiledDomain, len(domains)+len(zones)), hosts: c.hosts, expiring: c.expiring}
+    for _, entries := range []struct {
+        section string
+        rules   map[string]*compiledDomain
+    }{{section, domains}, {"zones", zones}} {
+        for _, domain := range sortedDomainKeys(entries.rules) {
+            cd := entries.rules[domain]
+            if err := validateSkipGlobal(global, domain, cd.skipGlobal); err != nil {
+                return nil, entryError(entries.section, domain, err)
+            }
+            rs.rules[domain] = cd
+        }
+    }
+    return rs, nil
+}
+
+// start resolves the hostnames of the rule set and runs its background
+// tasks until ctx is done or stop is called.
+func (rs *ruleSet) start(ctx context.Context, dnsRefreshInterval time.Duration) {
+    ctx, rs.stop = context.WithCancel(ctx)
+    if len(rs.hosts.hosts) > 0 {
+        rs.hosts.resolveAll(ctx)
+        go rs.hosts.refreshLoop(ctx, dnsRefreshInterval)
+    }
+    if len(rs.expiring) > 0 {
+        go reportExpired(ctx, rs.expiring, expiryReportInterval)
+    }
+    for domain, cd := range rs.rules {
+        if cd.bans != nil {
+            go cd.bans.sweepLoop(ctx, domain, autoBanSweepInterval)
+        }
+    }
+}
+
+// rulesReloader recompiles the rules of a rulesFile or rulesURL when they
+// change.
+type rulesReloader struct {
+    config   *Config // as passed to New, without the rules of the source
+    source   *rulesSource
+    rules    map[string]DomainConfig // of the source, as in effect
+    compiler *compiler
+    suffixes *suffixList
+    global   *compiledDomain
+
+    dnsRefreshInterval time.Duration
+}
+
+// watch checks the source for a new version every interval until ctx is
+// done. A new version is compiled and replaces current in ds; requests in
+// flight finish with the rules they started with. A version that fails to
+// load is logged, once for repeated failures, and the previous rules stay
+// in place.
+func (r *rulesReloader) watch(ctx context.Context, ds *DomainSentinel, current *ruleSet) {
+    ticker := time.NewTicker(r.source.interval)
+    defer ticker.Stop()
+    lastErr := ""
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+        rules, changed, err := r.source.fetch(ctx)
+        if err == nil && changed {
+            var next *ruleSet
+            var totals ruleTotals
+            if next, totals, err = r.load(ctx, rules); err == nil {
+                ds.domains.Store(next.table)
+                current.stop()
+                current = next
+                fmt.Printf("Reloaded %s: %s; now %s\n", r.source.name, ruleChanges(r.rules, rules), totals)
+                r.rules = rules
+            }
+        }
+        if err != nil {
+            if ctx.Err() != nil {
+                return
+            }
+            if err.Error() != lastErr {
+                fmt.Printf("Warning: %s cannot be loaded, keeping the current rules: %v\n", r.source.name, err)
+                lastErr = err.Error()
+            }
+            continue
+        }
+        lastErr = ""
+    }
+}
+
+// load compiles the rules of the source together with the inline
+// configuration, and starts the tasks of the new rule set. It also returns
+// the totals of the new rules.
+func (r *rulesReloader) load(ctx context.Context, rules map[string]DomainConfig) (*ruleSet, ruleTotals, error) {
+    config, err := mergeRules(r.config, rules, r.source.name)
+    if err != nil {
+        return nil, ruleTotals{}, err
+    }
+    if config, err = applyOverrides(config); err != nil {
+        return nil, ruleTotals{}, err
+    }
+    if config, err = applyTemplates(config); err != nil {
+        return nil, ruleTotals{}, err
+    }
+    if config, err = mergeDuplicateDomains(config); err != nil {
+        return nil, ruleTotals{}, err
+    }
+    if err := validateConfig(config); err != nil {
+        return nil, ruleTotals{}, err
+    }
+    totals, err := checkLimits(config)
+    if err != nil {
+        return nil, ruleTotals{}, err
+    }
+    rs, err := r.compiler.fork().compileRuleSet(config, r.suffixes, r.global)
+    if err != nil {
+        return nil, ruleTotals{}, err
+    }
+    rs.start(ctx, r.dnsRefreshInterval)
+    return rs, totals, nil
+}
+
+// ruleChanges summarizes which domains differ between two versions of the
+// rules of a source, such as "1 added [a.example], 0 removed, 2 changed
+// [b.example c.example]".
+func ruleChanges(old, rules map[string]DomainConfig) string {
+    var added, removed, changed []string
+    for domain, rule := range rules {
+        prev, ok := old[domain]
+        switch {
+        case !ok:
+            added = append(added, domain)
+        case !sameRule(prev, rule):
+            changed = append(changed, domain)
+        }
+    }
+    for domain := range old {
+        if _, ok := rules[domain]; !ok {
+            removed = append(removed, domain)
+        }
+    }
+    parts := make([]string, 0, 3)
+    for _, group := range []struct {
+        name    string
+        domains []string
+    }{{"added", added}, {"removed", removed}, {"changed", changed}} {
+        part := fmt.Sprintf("%d %s", len(group.domains), group.name)
+        if len(group.domains) > 0 {
+            sort.Strings(group.domains)
+            part += " [" + strings.Join(group.domains, " ") + "]"
+        }
+        parts = append(parts, part)
+    }
+    return strings.Join(parts, ", ")
+}
+
+// sameRule compares two domain configurations by their JSON encoding,
+// which also covers the untyped sourceIPs entries.
+func sameRule(a, b DomainConfig) bool {
+    ja, errA := json.Marshal(a)
+    jb, errB := json.Marshal(b)
+    return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
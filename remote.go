@@ -0,0 +1,109 @@
+package DomainSentinel
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "time"
+)
+
+const (
+    rulesURLUnavailableFail   = "fail"
+    rulesURLUnavailableInline = "inline"
+
+    defaultRulesURLRefreshInterval = 5 * time.Minute
+    defaultRulesURLTimeout         = 10 * time.Second
+
+    // maxRulesURLSize bounds the response read from a rulesURL.
+    maxRulesURLSize = 32 << 20
+)
+
+// newURLSource returns the source for the rulesURL of config. The response
+// holds a JSON object in the form of domainPathRules, as a rulesFile does.
+// Requests are conditional on the ETag and Last-Modified of the last
+// version, and a body identical to it counts as unchanged as well, for
+// servers that send neither.
+func newURLSource(config *Config) (*rulesSource, error) {
+    u, err := url.Parse(config.RulesURL)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return nil, fmt.Errorf("invalid rulesURL %q: must be an http or https URL", config.RulesURL)
+    }
+    interval, err := parseDuration("rulesURLRefreshInterval", config.RulesURLRefreshInterval, defaultRulesURLRefreshInterval)
+    if err != nil {
+        return nil, err
+    }
+    timeout, err := parseDuration("rulesURLTimeout", config.RulesURLTimeout, defaultRulesURLTimeout)
+    if err != nil {
+        return nil, err
+    }
+    switch config.RulesURLUnavailableAction {
+    case "", rulesURLUnavailableFail, rulesURLUnavailableInline:
+    default:
+        return nil, fmt.Errorf("invalid rulesURLUnavailableAction %q: must be %q or %q", config.RulesURLUnavailableAction, rulesURLUnavailableFail, rulesURLUnavailableInline)
+    }
+    token := config.RulesURLBearerToken
+    if token != "" && u.Scheme == "http" {
+        fmt.Println("Warning: rulesURL uses plain http, the rulesURLBearerToken is sent unencrypted")
+    }
+
+    src := &rulesSource{name: "rulesURL " + u.Redacted(), interval: interval}
+    client := &http.Client{Timeout: timeout}
+    var etag, lastModified string
+    var last []byte
+    src.fetch = func(ctx context.Context) (map[string]DomainConfig, bool, error) {
+        req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.RulesURL, nil)
+        if err != nil {
+            return nil, false, fmt.Errorf("%s: %w", src.name, err)
+        }
+        req.Header.Set("Accept", "application/json")
+        if token != "" {
+            req.Header.Set("Authorization", "Bearer "+token)
+        }
+        if etag != "" {
+            req.Header.Set("If-None-Match", etag)
+        }
+        if lastModified != "" {
+            req.Header.Set("If-Modified-Since", lastModified)
+        }
+        resp, err := client.Do(req)
+        if err != nil {
+            // The url.Error would repeat the URL already named by src.
+            var urlErr *url.Error
+            if errors.As(err, &urlErr) {
+                err = urlErr.Err
+            }
+            return nil, false, fmt.Errorf("%s: %w", src.name, err)
+        }
+        defer resp.Body.Close()
+        switch resp.StatusCode {
+        case http.StatusOK:
+        case http.StatusNotModified:
+            return nil, false, nil
+        default:
+            return nil, false, fmt.Errorf("%s: unexpected status %s", src.name, resp.Status)
+        }
+        data, err := io.ReadAll(io.LimitReader(resp.Body, maxRulesURLSize+1))
+        if err != nil {
+            return nil, false, fmt.Errorf("%s: %w", src.name, err)
+        }
+        if len(data) > maxRulesURLSize {
+            return nil, false, fmt.Errorf("%s: response exceeds %d bytes", src.name, maxRulesURLSize)
+        }
+        if last != nil && bytes.Equal(data, last) {
+            return nil, false, nil
+        }
+        rules, err := decodeRules(src.name, data)
+        if err != nil {
+            return nil, false, err
+        }
+        // Only a version that decodes is remembered, so that a broken or
+        // truncated response is fetched again on the next refresh.
+        etag, lastModified, last = resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), data
+        return rules, true, nil
+    }
+    return src, nil
+}
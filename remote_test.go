@@ -0,0 +1,150 @@
+package DomainSentinel
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// eventually waits up to two seconds for cond to hold.
+func eventually(t *testing.T, what string, cond func() bool) {
+    t.Helper()
+    deadline := time.Now().Add(2 * time.Second)
+    for !cond() {
+        if time.Now().After(deadline) {
+            t.Fatalf("timed out waiting for %s", what)
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+}
+
+// rulesServer serves a rulesURL whose response the test changes.
+type rulesServer struct {
+    mu       sync.Mutex
+    status   int
+    body     string
+    etag     string
+    modified string
+    requests int
+    header   http.Header // of the last request
+}
+
+func (s *rulesServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.requests++
+    s.header = req.Header.Clone()
+    if s.status != http.StatusOK {
+        rw.WriteHeader(s.status)
+        return
+    }
+    if s.etag != "" && req.Header.Get("If-None-Match") == s.etag {
+        rw.WriteHeader(http.StatusNotModified)
+        return
+    }
+    rw.Header().Set("ETag", s.etag)
+    rw.Header().Set("Last-Modified", s.modified)
+    fmt.Fprint(rw, s.body)
+}
+
+// set changes the response and returns the number of requests so far.
+func (s *rulesServer) set(status int, body, etag string) int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.status, s.body, s.etag = status, body, etag
+    return s.requests
+}
+
+// served returns the number of requests and the headers of the last one.
+func (s *rulesServer) served() (int, http.Header) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.requests, s.header
+}
+
+func TestRulesURLReload(t *testing.T) {
+    rules := &rulesServer{
+        status:   http.StatusOK,
+        body:     `{"example.com": {"sourceIPs": ["192.0.2.0/24"]}}`,
+        etag:     `"v1"`,
+        modified: "Mon, 01 Jul 2024 12:00:00 GMT",
+    }
+    srv := httptest.NewServer(rules)
+    defer srv.Close()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    config := CreateConfig()
+    config.RulesURL = srv.URL
+    config.RulesURLRefreshInterval = "10ms"
+    handler, err := New(ctx, okHandler, config, "test")
+    if err != nil {
+        t.Fatal(err)
+    }
+    status := func(remoteAddr string) int { return serve(handler, "http://example.com/", remoteAddr).Code }
+    checkStatuses(t, handler, []statusCase{
+        {"http://example.com/", "192.0.2.5:1234", http.StatusOK},
+        {"http://example.com/", "198.51.100.1:1234", http.StatusForbidden},
+    })
+
+    // Refreshes are conditional on the version in effect; 304 changes nothing.
+    eventually(t, "a conditional refresh", func() bool {
+        n, header := rules.served()
+        return n >= 3 && header.Get("If-None-Match") == `"v1"`
+    })
+    if _, header := rules.served(); header.Get("If-Modified-Since") != "Mon, 01 Jul 2024 12:00:00 GMT" {
+        t.Errorf("If-Modified-Since = %q, want the Last-Modified of the rules", header.Get("If-Modified-Since"))
+    }
+    if got := status("192.0.2.5:1234"); got != http.StatusOK {
+        t.Errorf("after 304: status %d, want 200", got)
+    }
+
+    // A new version replaces the rules.
+    rules.set(http.StatusOK, `{"example.com": {"sourceIPs": ["198.51.100.0/24"]}}`, `"v2"`)
+    eventually(t, "the new rules", func() bool { return status("198.51.100.1:1234") == http.StatusOK })
+    if got := status("192.0.2.5:1234"); got != http.StatusForbidden {
+        t.Errorf("address of the old rules: status %d, want 403", got)
+    }
+
+    // Failed refreshes keep the rules in effect.
+    for _, failure := range []struct {
+        status int
+        body   string
+    }{
+        {http.StatusInternalServerError, ""},
+        {http.StatusOK, `{"example.com": {"sourceIPs": [`},
+        {http.StatusOK, `{"example.com": {"sourceIPs": ["198.51.100.0/33"]}}`},
+    } {
+        n := rules.set(failure.status, failure.body, `"broken"`)
+        eventually(t, "a failed refresh", func() bool { served, _ := rules.served(); return served >= n+2 })
+        if got := status("198.51.100.1:1234"); got != http.StatusOK {
+            t.Errorf("after a refresh answered %d %q: status %d, want the previous rules", failure.status, failure.body, got)
+        }
+    }
+}
+
+func TestRulesURLSizeLimit(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+        fmt.Fprint(rw, `{"example.com": {"sourceIPs": ["192.0.2.0/24"]}}`)
+        fmt.Fprint(rw, strings.Repeat(" ", maxRulesURLSize))
+    }))
+    defer srv.Close()
+
+    config := CreateConfig()
+    config.RulesURL = srv.URL
+    src, err := newURLSource(config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, _, err := src.fetch(context.Background()); err == nil || !strings.Contains(err.Error(), "exceeds") {
+        t.Errorf("oversized response: got %v, want a size error", err)
+    }
+    if _, err := New(context.Background(), okHandler, config, "test"); err == nil {
+        t.Error("New accepted an oversized rulesURL response")
+    }
+}
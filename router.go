@@ -0,0 +1,123 @@
+package DomainSentinel
+
+import (
+    "sort"
+    "strings"
+)
+
+// pathRouter finds the path rules of a domain that can match a request path
+// without walking all of them. Exact paths are looked up in maps and
+// trailing "/*" wildcards in a radix tree of their prefixes, so the cost
+// depends on the length of the path rather than on the number of rules.
+// Regex, glob and suffix rules are few in practice and always candidates.
+type pathRouter struct {
+    exact    map[string][]int // exact paths
+    trimmed  map[string][]int // exact paths with normalizeTrailingSlash
+    prefixes radixNode        // bases of "/prefix/*" wildcards
+    others   []int            // regex, glob and suffix rules
+    loose    bool             // loosePathPrefix
+}
+
+// radixNode is a node of a radix tree. The edge from its parent is labeled
+// with prefix.
+type radixNode struct {
+    prefix   string
+    children []*radixNode
+    rules    []int // wildcards whose base ends here
+}
+
+// newPathRouter indexes rules, which must already be sorted. Candidates
+// are indexes into rules.
+func newPathRouter(rules []compiledPathRule, loose bool) *pathRouter {
+    r := &pathRouter{exact: make(map[string][]int), trimmed: make(map[string][]int), loose: loose}
+    for i := range rules {
+        p := &rules[i].pathPattern
+        switch {
+        case p.re != nil || p.glob != nil || p.suffix:
+            r.others = append(r.others, i)
+        case strings.HasSuffix(p.pattern, "/*"):
+            r.prefixes.insert(strings.TrimSuffix(p.pattern, "/*"), i)
+        case p.trimSlash:
+            r.trimmed[p.pattern] = append(r.trimmed[p.pattern], i)
+        default:
+            r.exact[p.pattern] = append(r.exact[p.pattern], i)
+        }
+    }
+    return r
+}
+
+// candidates returns the indexes of the rules that may match path, in
+// ascending order, so that they are tried in the same order as by a walk
+// through all rules. Exact and wildcard candidates match path; the others
+// still have to be checked.
+func (r *pathRouter) candidates(path string) []int {
+    out := append([]int(nil), r.exact[path]...)
+    out = append(out, r.trimmed[trimTrailingSlash(path)]...)
+    r.prefixes.walk(path, func(n int, rules []int) {
+        if r.loose || n == len(path) || path[n] == '/' {
+            out = append(out, rules...)
+        }
+    })
+    out = append(out, r.others...)
+    sort.Ints(out)
+    return out
+}
+
+// insert adds rule under key, splitting edges as needed.
+func (n *radixNode) insert(key string, rule int) {
+    for {
+        if key == "" {
+            n.rules = append(n.rules, rule)
+            return
+        }
+        var child *radixNode
+        for _, c := range n.children {
+            if c.prefix[0] == key[0] {
+                child = c
+                break
+            }
+        }
+        if child == nil {
+            n.children = append(n.children, &radixNode{prefix: key, rules: []int{rule}})
+            return
+        }
+        common := commonPrefixLength(child.prefix, key)
+        if common < len(child.prefix) {
+            // Split the edge: the child keeps the rest of its label.
+            split := &radixNode{prefix: child.prefix[common:], children: child.children, rules: child.rules}
+            child.prefix, child.children, child.rules = child.prefix[:common], []*radixNode{split}, nil
+        }
+        n, key = child, key[common:]
+    }
+}
+
+// walk calls fn for every node on the way to path whose key is a prefix
+// of path, with the length of that prefix.
+func (n *radixNode) walk(path string, fn func(n int, rules []int)) {
+    consumed := 0
+    for {
+        if len(n.rules) > 0 {
+            fn(consumed, n.rules)
+        }
+        rest := path[consumed:]
+        var next *radixNode
+        for _, c := range n.children {
+            if strings.HasPrefix(rest, c.prefix) {
+                next = c
+                break
+            }
+        }
+        if next == nil {
+            return
+        }
+        n, consumed = next, consumed+len(next.prefix)
+    }
+}
+
+func commonPrefixLength(a, b string) int {
+    i := 0
+    for i < len(a) && i < len(b) && a[i] == b[i] {
+        i++
+    }
+    return i
+}
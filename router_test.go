@@ -0,0 +1,136 @@
+package DomainSentinel
+
+import (
+    "fmt"
+    "math/rand"
+    "testing"
+)
+
+// routerRules returns n path rules as in a large tenant configuration:
+// exact paths and "/*" wildcards, some nested, plus a handful of globs,
+// regexes and suffix patterns.
+func routerRules(n int) []PathConfig {
+    rules := []PathConfig{
+        {Path: "/tenant*/api/*/export"},
+        {Path: "~/tenant[0-9]+/v[0-9]+"},
+        {Path: "*.env"},
+    }
+    for i := 0; len(rules) < n; i++ {
+        var path string
+        switch i % 10 {
+        case 0:
+            path = fmt.Sprintf("/tenant%d/*", i)
+        case 1:
+            path = fmt.Sprintf("/tenant%d/admin/*", i-1)
+        default:
+            path = fmt.Sprintf("/tenant%d/page%d", i-i%10, i)
+        }
+        rules = append(rules, PathConfig{Path: path})
+    }
+    for i := range rules {
+        rules[i].SourceIPs = ips("10.0.0.1")
+    }
+    return rules[:n]
+}
+
+// routerPaths returns request paths for the rules of routerRules(n), most
+// of them matching one or more rules.
+func routerPaths(rng *rand.Rand, n, count int) []string {
+    paths := make([]string, count)
+    for i := range paths {
+        tenant := rng.Intn(n/10+1) * 10
+        switch rng.Intn(7) {
+        case 0:
+            paths[i] = fmt.Sprintf("/tenant%d/admin/users", tenant)
+        case 1:
+            paths[i] = fmt.Sprintf("/tenant%d/api/x/export", tenant)
+        case 2:
+            paths[i] = fmt.Sprintf("/tenant%d/v2", tenant)
+        case 3:
+            paths[i] = fmt.Sprintf("/tenant%d/page%d", tenant, tenant+2+rng.Intn(8))
+        case 4:
+            paths[i] = fmt.Sprintf("/tenant%dx/y", tenant)
+        case 5:
+            paths[i] = fmt.Sprintf("/tenant%d/.env", tenant)
+        default:
+            paths[i] = fmt.Sprintf("/tenant%d", tenant)
+        }
+    }
+    return paths
+}
+
+func compileTestDomain(tb testing.TB, rule DomainConfig) *compiledDomain {
+    tb.Helper()
+    c, err := newCompiler(CreateConfig())
+    if err != nil {
+        tb.Fatal(err)
+    }
+    cd, err := c.compileDomain("example.com", rule)
+    if err != nil {
+        tb.Fatal(err)
+    }
+    return cd
+}
+
+// firstMatch returns the first rule of cd among indexes that matches path,
+// or -1.
+func firstMatch(cd *compiledDomain, indexes []int, path string) int {
+    for _, i := range indexes {
+        if ok, _ := cd.pathRules[i].matches(path); ok {
+            return i
+        }
+    }
+    return -1
+}
+
+func allRules(cd *compiledDomain) []int {
+    indexes := make([]int, len(cd.pathRules))
+    for i := range indexes {
+        indexes[i] = i
+    }
+    return indexes
+}
+
+func TestPathRouterMatchesLinearScan(t *testing.T) {
+    for _, loose := range []bool{false, true} {
+        config := CreateConfig()
+        config.LoosePathPrefix = loose
+        c, err := newCompiler(config)
+        if err != nil {
+            t.Fatal(err)
+        }
+        rules := routerRules(300)
+        rules = append(rules, PathConfig{Path: "/", SourceIPs: ips("10.0.0.1")}, PathConfig{Path: "/*", SourceIPs: ips("10.0.0.1")})
+        cd, err := c.compileDomain("example.com", DomainConfig{SourceIPs: ips("10.0.0.1"), PathRules: rules})
+        if err != nil {
+            t.Fatal(err)
+        }
+        rng := rand.New(rand.NewSource(1))
+        all := allRules(cd)
+        for _, path := range append(routerPaths(rng, 300, 5000), "/", "/x", "/tenant", "/tenant0/", "/tenant0/admin") {
+            if got, want := firstMatch(cd, cd.router.candidates(path), path), firstMatch(cd, all, path); got != want {
+                t.Fatalf("loosePathPrefix %v, %s: router picks rule %d, the linear scan %d", loose, path, got, want)
+            }
+        }
+    }
+}
+
+func BenchmarkPathRouter(b *testing.B) {
+    for _, n := range []int{10, 100, 1000} {
+        cd := compileTestDomain(b, DomainConfig{SourceIPs: ips("10.0.0.1"), PathRules: routerRules(n)})
+        paths := routerPaths(rand.New(rand.NewSource(1)), n, 1024)
+        all := allRules(cd)
+        b.Run(fmt.Sprintf("linear/%d", n), func(b *testing.B) {
+            for i := 0; i < b.N; i++ {
+                firstMatch(cd, all, paths[i%len(paths)])
+            }
+        })
+        b.Run(fmt.Sprintf("router/%d", n), func(b *testing.B) {
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                path := paths[i%len(paths)]
+                firstMatch(cd, cd.router.candidates(path), path)
+            }
+        })
+    }
+}
@@ -0,0 +1,212 @@
+package DomainSentinel
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// rulesSource supplies further domainPathRules from outside the static
+// configuration: a rulesFile, a rulesURL or compressedRules.
+type rulesSource struct {
+    name     string        // "rulesFile <path>", "rulesURL <url>" or "compressedRules", for logs and errors
+    interval time.Duration // between checks for a new version, 0 to load only once
+
+    // fetch returns the rules of the source, or changed false if they are
+    // the same version as on the last successful call.
+    fetch func(ctx context.Context) (rules map[string]DomainConfig, changed bool, err error)
+}
+
+// newRulesSource returns the rules source of config, or nil if it has none.
+func newRulesSource(config *Config) (*rulesSource, error) {
+    if config.RulesFile != "" && config.RulesURL != "" {
+        return nil, fmt.Errorf("rulesFile and rulesURL cannot be combined; use one source")
+    }
+    if config.CompressedRules != "" && (config.RulesFile != "" || config.RulesURL != "") {
+        return nil, fmt.Errorf("compressedRules cannot be combined with rulesFile or rulesURL; use one source")
+    }
+    if config.RulesURL == "" && (config.RulesURLRefreshInterval != "" || config.RulesURLTimeout != "" || config.RulesURLBearerToken != "" || config.RulesURLUnavailableAction != "") {
+        return nil, fmt.Errorf("rulesURL options are set without a rulesURL")
+    }
+    switch {
+    case config.RulesFile != "":
+        return newFileSource(config)
+    case config.RulesURL != "":
+        return newURLSource(config)
+    case config.CompressedRules != "":
+        return newCompressedSource(config)
+    }
+    if config.RulesBaseDir != "" && config.OverrideRulesFile == "" {
+        fmt.Println("Warning: rulesBaseDir is set, but there is no rulesFile or overrideRulesFile")
+    }
+    if config.RulesFileReloadInterval != "" {
+        return nil, fmt.Errorf("rulesFileReloadInterval is set without a rulesFile")
+    }
+    return nil, nil
+}
+
+// newFileSource returns the source for the rulesFile of config. The file
+// holds a JSON object in the form of domainPathRules, or the same in YAML
+// if its name ends in .yaml or .yml. It counts as changed when its
+// modification time or size does.
+func newFileSource(config *Config) (*rulesSource, error) {
+    path := rulesFilePath(config)
+    decode := decodeRules
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".yaml", ".yml":
+        decode = decodeYAMLRules
+    }
+    interval, err := parseDuration("rulesFileReloadInterval", config.RulesFileReloadInterval, 0)
+    if err != nil {
+        return nil, err
+    }
+    src := &rulesSource{name: "rulesFile " + path, interval: interval}
+    var loaded os.FileInfo
+    src.fetch = func(ctx context.Context) (map[string]DomainConfig, bool, error) {
+        // Taken before the file is read, so that a change while loading is not missed.
+        info, err := os.Stat(path)
+        if err != nil {
+            return nil, false, fmt.Errorf("rulesFile: %w", err)
+        }
+        if loaded != nil && info.ModTime().Equal(loaded.ModTime()) && info.Size() == loaded.Size() {
+            return nil, false, nil
+        }
+        loaded = info
+        data, err := os.ReadFile(path)
+        if err != nil {
+            return nil, false, fmt.Errorf("rulesFile: %w", err)
+        }
+        rules, err := decode(src.name, data)
+        return rules, err == nil, err
+    }
+    return src, nil
+}
+
+// rulesFilePath returns the path of the rulesFile of config, resolved
+// against rulesBaseDir.
+func rulesFilePath(config *Config) string {
+    if !filepath.IsAbs(config.RulesFile) && config.RulesBaseDir != "" {
+        return filepath.Join(config.RulesBaseDir, config.RulesFile)
+    }
+    return config.RulesFile
+}
+
+// mergeRules returns config with rules, loaded from source, merged into the
+// inline domainPathRules. A domain that is configured both inline and in
+// the source is an error, as either choice could silently drop rules.
+// config is not modified.
+func mergeRules(config *Config, rules map[string]DomainConfig, source string) (*Config, error) {
+    merged := *config
+    merged.DomainPathRules = make(map[string]DomainConfig, len(config.DomainPathRules)+len(rules))
+    for domain, rule := range config.DomainPathRules {
+        merged.DomainPathRules[domain] = rule
+    }
+    for _, domain := range sortedConfigKeys(rules) {
+        rule := rules[domain]
+        if _, ok := merged.DomainPathRules[domain]; ok {
+            loc := at()
+            loc.Section, loc.Domain = "domainPathRules", domain
+            loc.Reason = fmt.Sprintf("also configured in %s", source)
+            return nil, &loc
+        }
+        merged.DomainPathRules[domain] = rule
+    }
+    fmt.Printf("Loaded %d domain rules from %s\n", len(rules), source)
+    return &merged, nil
+}
+
+// unknownFieldError starts the error encoding/json returns for a field that
+// DisallowUnknownFields rejects, followed by the quoted name.
+const unknownFieldError = "json: unknown field "
+
+// decodeRules parses the rules of source, a JSON object in the form of
+// domainPathRules. Unknown fields are rejected, since a misspelled option
+// would otherwise be ignored. Errors carry the line and column they
+// occurred at.
+func decodeRules(source string, data []byte) (map[string]DomainConfig, error) {
+    rules, offset, err := unmarshalRules(data)
+    if err != nil {
+        if offset < 0 {
+            return nil, fmt.Errorf("%s: %w", source, err)
+        }
+        line, column := lineColumn(data, offset)
+        return nil, fmt.Errorf("%s:%d:%d: %w", source, line, column, err)
+    }
+    return rules, nil
+}
+
+// decodeYAMLRules parses the rules of source, a YAML mapping in the form of
+// domainPathRules, like decodeRules. Errors carry the line they occurred at.
+func decodeYAMLRules(source string, data []byte) (map[string]DomainConfig, error) {
+    converted, err := yamlToJSON(data)
+    if err != nil {
+        var syntaxErr *yamlSyntaxError
+        if errors.As(err, &syntaxErr) {
+            return nil, fmt.Errorf("%s:%d: %s", source, syntaxErr.Line, syntaxErr.msg)
+        }
+        return nil, fmt.Errorf("%s: %w", source, err)
+    }
+    // yamlToJSON keeps every value on its line, so the line of a decoding
+    // error is that of the YAML. The column is not.
+    rules, offset, err := unmarshalRules(converted)
+    if err != nil {
+        if offset < 0 {
+            return nil, fmt.Errorf("%s: %w", source, err)
+        }
+        line, _ := lineColumn(converted, offset)
+        return nil, fmt.Errorf("%s:%d: %w", source, line, err)
+    }
+    return rules, nil
+}
+
+// unmarshalRules decodes a JSON object in the form of domainPathRules. On
+// failure it returns the offset of the problem in data, or -1 if data is
+// empty.
+func unmarshalRules(data []byte) (map[string]DomainConfig, int64, error) {
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.DisallowUnknownFields()
+    var rules map[string]DomainConfig
+    err := dec.Decode(&rules)
+    if err == io.EOF {
+        return nil, -1, errors.New("empty file")
+    }
+    if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+        err = errors.New("unexpected data after the rules object")
+    }
+    if err != nil {
+        offset := dec.InputOffset()
+        var syntaxErr *json.SyntaxError
+        var typeErr *json.UnmarshalTypeError
+        switch {
+        case errors.As(err, &syntaxErr):
+            offset = syntaxErr.Offset
+        case errors.As(err, &typeErr):
+            offset = typeErr.Offset
+        case strings.HasPrefix(err.Error(), unknownFieldError):
+            // The decoder has read the whole value by now; point at the first
+            // occurrence of the field name instead.
+            if i := bytes.Index(data, []byte(strings.TrimPrefix(err.Error(), unknownFieldError))); i >= 0 {
+                offset = int64(i)
+            }
+        }
+        return nil, offset, err
+    }
+    return rules, 0, nil
+}
+
+// lineColumn converts a byte offset of data to a 1-based line and column.
+func lineColumn(data []byte, offset int64) (int, int) {
+    if offset > int64(len(data)) {
+        offset = int64(len(data))
+    }
+    before := data[:offset]
+    line := bytes.Count(before, []byte("\n")) + 1
+    return line, len(before) - bytes.LastIndexByte(before, '\n')
+}
@@ -0,0 +1,98 @@
+package DomainSentinel
+
+import (
+    "context"
+    "net/http"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestRulesFileReload(t *testing.T) {
+    for _, tt := range []struct {
+        name, first, second, invalid string
+    }{
+        {"rules.json",
+            `{"example.com": {"sourceIPs": ["192.0.2.0/24"]}}`,
+            `{"example.com": {"sourceIPs": ["198.51.100.0/24"]}, "new.example.com": {"sourceIPs": ["198.51.100.0/24"]}}`,
+            `{"example.com": {"sourceIPs": ["198.51.100.0/24"], "sourceIP": []}}`},
+        {"rules.yaml",
+            "example.com:\n  sourceIPs: [192.0.2.0/24]\n",
+            "example.com:\n  sourceIPs: [198.51.100.0/24]\nnew.example.com:\n  sourceIPs: [198.51.100.0/24]\n",
+            "example.com:\n  sourceIPs: *office\n"},
+    } {
+        t.Run(tt.name, func(t *testing.T) {
+            path := filepath.Join(t.TempDir(), tt.name)
+            version := 0
+            write := func(content string) {
+                t.Helper()
+                if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+                    t.Fatal(err)
+                }
+                // Each version gets its own modification time, however coarse
+                // the file system's clock.
+                version++
+                mtime := time.Now().Add(time.Duration(version) * time.Hour)
+                if err := os.Chtimes(path, mtime, mtime); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            write(tt.first)
+
+            ctx, cancel := context.WithCancel(context.Background())
+            defer cancel()
+            config := CreateConfig()
+            config.RulesFile = path
+            config.RulesFileReloadInterval = "10ms"
+            handler, err := New(ctx, okHandler, config, "test")
+            if err != nil {
+                t.Fatal(err)
+            }
+            status := func(target, remoteAddr string) int { return serve(handler, target, remoteAddr).Code }
+            checkStatuses(t, handler, []statusCase{
+                {"http://example.com/", "192.0.2.5:1234", http.StatusOK},
+                {"http://example.com/", "198.51.100.1:1234", http.StatusForbidden},
+            })
+
+            write(tt.second)
+            eventually(t, "the rewritten rules", func() bool { return status("http://example.com/", "198.51.100.1:1234") == http.StatusOK })
+            checkStatuses(t, handler, []statusCase{
+                {"http://example.com/", "192.0.2.5:1234", http.StatusForbidden},
+                {"http://new.example.com/", "198.51.100.1:1234", http.StatusOK},
+            })
+
+            // An invalid version is logged and the rules stay as they were,
+            // until a valid one follows.
+            write(tt.invalid)
+            time.Sleep(100 * time.Millisecond)
+            checkStatuses(t, handler, []statusCase{
+                {"http://example.com/", "198.51.100.1:1234", http.StatusOK},
+                {"http://new.example.com/", "198.51.100.1:1234", http.StatusOK},
+            })
+            write(tt.first)
+            eventually(t, "the rules after the invalid version", func() bool { return status("http://example.com/", "192.0.2.5:1234") == http.StatusOK })
+
+            // So does a file that disappears.
+            if err := os.Remove(path); err != nil {
+                t.Fatal(err)
+            }
+            time.Sleep(50 * time.Millisecond)
+            if got := status("http://example.com/", "192.0.2.5:1234"); got != http.StatusOK {
+                t.Errorf("after the file was removed: status %d, want the previous rules", got)
+            }
+        })
+    }
+}
+
+func TestRulesFileReloadIntervalErrors(t *testing.T) {
+    for name, config := range map[string]*Config{
+        "without a rulesFile": {RulesFileReloadInterval: "10s"},
+        "invalid interval":    {RulesFile: "rules.json", RulesFileReloadInterval: "often"},
+        "negative interval":   {RulesFile: "rules.json", RulesFileReloadInterval: "-1s"},
+    } {
+        if _, err := newRulesSource(config); err == nil {
+            t.Errorf("%s: accepted", name)
+        }
+    }
+}
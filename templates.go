@@ -0,0 +1,186 @@
+package DomainSentinel
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// notInherited are the DomainConfig fields, by JSON name, that identify an
+// entry or steer the merge, and are never taken from a template.
+var notInherited = map[string]bool{"name": true, "hosts": true, "extends": true, "override": true}
+
+// applyTemplates returns config with the templates that its domainPathRules,
+// zones and rules extend merged into them. config is not modified.
+func applyTemplates(config *Config) (*Config, error) {
+    t := &templateSet{templates: config.Templates, resolved: make(map[string]DomainConfig)}
+    // Resolve every template, so that unknown names and cycles are found
+    // in unused templates too.
+    names := make([]string, 0, len(config.Templates))
+    for name := range config.Templates {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        if _, err := t.resolve(name, nil); err != nil {
+            return nil, extendsError("templates", name, err)
+        }
+    }
+    merged := *config
+    var err error
+    if merged.DomainPathRules, err = t.applyAll("domainPathRules", config.DomainPathRules); err != nil {
+        return nil, err
+    }
+    if merged.Zones, err = t.applyAll("zones", config.Zones); err != nil {
+        return nil, err
+    }
+    if len(config.Rules) > 0 {
+        merged.Rules = make([]DomainConfig, len(config.Rules))
+        for i, rule := range config.Rules {
+            if merged.Rules[i], err = t.apply("rules", strconv.Itoa(i), rule); err != nil {
+                return nil, err
+            }
+        }
+    }
+    if t.extended == 0 && len(config.Templates) > 0 {
+        fmt.Println("Warning: templates are configured, but no rule extends one")
+    }
+    return &merged, nil
+}
+
+// templateSet resolves the templates of a configuration, each once.
+type templateSet struct {
+    templates map[string]DomainConfig
+    resolved  map[string]DomainConfig // with the templates they extend merged in
+    extended  int                     // rules that extend a template
+}
+
+func (t *templateSet) applyAll(section string, rules map[string]DomainConfig) (map[string]DomainConfig, error) {
+    if len(rules) == 0 {
+        return rules, nil
+    }
+    applied := make(map[string]DomainConfig, len(rules))
+    for _, key := range sortedConfigKeys(rules) {
+        merged, err := t.apply(section, key, rules[key])
+        if err != nil {
+            return nil, err
+        }
+        applied[key] = merged
+    }
+    return applied, nil
+}
+
+// apply merges the template that rule, the entry key of section, extends
+// into it.
+func (t *templateSet) apply(section, key string, rule DomainConfig) (DomainConfig, error) {
+    if rule.Extends == "" {
+        return rule, nil
+    }
+    t.extended++
+    base, err := t.resolve(rule.Extends, nil)
+    if err != nil {
+        return rule, extendsError(section, key, err)
+    }
+    merged, err := mergeTemplate(base, rule)
+    if err != nil {
+        return rule, extendsError(section, key, fmt.Errorf("template %q: %w", rule.Extends, err))
+    }
+    return merged, nil
+}
+
+// extendsError locates err at the extends field of the entry key of section.
+func extendsError(section, key string, err error) error {
+    loc := at()
+    loc.Section, loc.Domain, loc.Field = section, key, "extends"
+    loc.Reason, loc.Err = err.Error(), err
+    return &loc
+}
+
+// resolve returns the named template with the templates it extends merged
+// in. stack holds the templates being resolved, to detect cycles.
+func (t *templateSet) resolve(name string, stack []string) (DomainConfig, error) {
+    if resolved, ok := t.resolved[name]; ok {
+        return resolved, nil
+    }
+    for i, entered := range stack {
+        if entered == name {
+            return DomainConfig{}, fmt.Errorf("circular template extends: %s", strings.Join(append(stack[i:], name), " -> "))
+        }
+    }
+    template, ok := t.templates[name]
+    if !ok {
+        return DomainConfig{}, fmt.Errorf("unknown template %q%s", name, knownTemplates(t.templates))
+    }
+    if template.Extends != "" {
+        base, err := t.resolve(template.Extends, append(stack, name))
+        if err != nil {
+            return DomainConfig{}, err
+        }
+        merged, err := mergeTemplate(base, template)
+        if err != nil {
+            return DomainConfig{}, fmt.Errorf("template %q: extends %q: %w", name, template.Extends, err)
+        }
+        template = merged
+    }
+    t.resolved[name] = template
+    return template, nil
+}
+
+// knownTemplates lists the template names for an error message.
+func knownTemplates(templates map[string]DomainConfig) string {
+    if len(templates) == 0 {
+        return " (no templates are configured)"
+    }
+    names := make([]string, 0, len(templates))
+    for name := range templates {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return " (templates: " + strings.Join(names, ", ") + ")"
+}
+
+// mergeTemplate merges base underneath rule: fields that rule sets win,
+// except lists, to which the entries of base are appended unless rule sets
+// override. The merge works on the JSON form, so it covers every field
+// and a field counts as set when it is not empty; the flags of DomainConfig
+// are pointers, so that an explicit false is set as well.
+func mergeTemplate(base, rule DomainConfig) (DomainConfig, error) {
+    var b, r map[string]interface{}
+    if err := roundTrip(base, &b); err != nil {
+        return rule, err
+    }
+    if err := roundTrip(rule, &r); err != nil {
+        return rule, err
+    }
+    for field, value := range b {
+        if notInherited[field] {
+            continue
+        }
+        own, ok := r[field]
+        if !ok {
+            r[field] = value
+            continue
+        }
+        ownList, isList := own.([]interface{})
+        baseList, baseIsList := value.([]interface{})
+        if isList && baseIsList && !rule.Override {
+            r[field] = append(ownList, baseList...)
+        }
+    }
+    var merged DomainConfig
+    if err := roundTrip(r, &merged); err != nil {
+        return rule, err
+    }
+    return merged, nil
+}
+
+// roundTrip converts v to out through its JSON encoding.
+func roundTrip(v, out interface{}) error {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, out)
+}
@@ -0,0 +1,111 @@
+package DomainSentinel
+
+import (
+    "context"
+    "net/http"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestTemplateMerge(t *testing.T) {
+    config := CreateConfig()
+    config.Templates = map[string]DomainConfig{
+        "internal": {
+            SourceIPs:            ips("10.0.0.0/8"),
+            DeniedIPs:            []string{"10.9.9.9"},
+            RequireBothAddresses: boolFlag(true),
+            CaseInsensitivePaths: boolFlag(true),
+            DenyStatus:           404,
+            PathRules:            []PathConfig{{Path: "/admin/*", SourceIPs: ips("10.0.0.0/24")}},
+        },
+        "strict": {Extends: "internal", IncludeSubdomains: boolFlag(true)},
+    }
+    config.DomainPathRules = map[string]DomainConfig{
+        "wiki.example.com": {Extends: "strict", SourceIPs: ips("192.0.2.0/24"), DenyStatus: 403},
+        "shop.example.com": {
+            Extends:              "internal",
+            Override:             true,
+            SourceIPs:            ips("192.0.2.0/24"),
+            RequireBothAddresses: boolFlag(false),
+            CaseInsensitivePaths: boolFlag(false),
+        },
+    }
+    merged, err := applyTemplates(config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(config.DomainPathRules["wiki.example.com"].SourceIPs, ips("192.0.2.0/24")) {
+        t.Error("applyTemplates modified its input")
+    }
+
+    wiki := merged.DomainPathRules["wiki.example.com"]
+    if want := ips("192.0.2.0/24", "10.0.0.0/8"); !reflect.DeepEqual(wiki.SourceIPs, want) {
+        t.Errorf("wiki sourceIPs = %v, want the own entries before the template's: %v", wiki.SourceIPs, want)
+    }
+    if len(wiki.PathRules) != 1 || !reflect.DeepEqual(wiki.DeniedIPs, []string{"10.9.9.9"}) {
+        t.Errorf("wiki did not get the lists of the template: %+v", wiki)
+    }
+    if wiki.DenyStatus != 403 || !isTrue(wiki.RequireBothAddresses) || !isTrue(wiki.IncludeSubdomains) {
+        t.Errorf("wiki: denyStatus %d, requireBothAddresses %v, includeSubdomains %v; want 403 and the flags of both templates",
+            wiki.DenyStatus, isTrue(wiki.RequireBothAddresses), isTrue(wiki.IncludeSubdomains))
+    }
+    if wiki.Extends != "strict" || wiki.Override {
+        t.Errorf("wiki: extends %q, override %v; want them kept", wiki.Extends, wiki.Override)
+    }
+
+    shop := merged.DomainPathRules["shop.example.com"]
+    if !reflect.DeepEqual(shop.SourceIPs, ips("192.0.2.0/24")) {
+        t.Errorf("shop sourceIPs = %v, want the template's list replaced", shop.SourceIPs)
+    }
+    if len(shop.PathRules) != 1 {
+        t.Errorf("shop has %d path rules, want the template's, which it does not set", len(shop.PathRules))
+    }
+    // An explicit false turns off a switch of the template.
+    if isTrue(shop.RequireBothAddresses) || isTrue(shop.CaseInsensitivePaths) {
+        t.Errorf("shop: requireBothAddresses %v, caseInsensitivePaths %v; want both false",
+            isTrue(shop.RequireBothAddresses), isTrue(shop.CaseInsensitivePaths))
+    }
+}
+
+func TestTemplateFlagOverride(t *testing.T) {
+    config := CreateConfig()
+    config.Templates = map[string]DomainConfig{
+        "folded": {SourceIPs: ips("10.0.0.0/8"), CaseInsensitivePaths: boolFlag(true), PathRules: []PathConfig{{Path: "/admin/*", SourceIPs: ips("10.1.0.0/16")}}},
+    }
+    config.DomainPathRules["folded.example.com"] = DomainConfig{Extends: "folded"}
+    config.DomainPathRules["exact.example.com"] = DomainConfig{Extends: "folded", CaseInsensitivePaths: boolFlag(false)}
+    handler := newTestSentinel(t, config)
+    checkStatuses(t, handler, []statusCase{
+        {"http://folded.example.com/Admin/x", "10.2.0.1:1234", http.StatusForbidden},
+        {"http://exact.example.com/Admin/x", "10.2.0.1:1234", http.StatusOK},
+        {"http://exact.example.com/admin/x", "10.2.0.1:1234", http.StatusForbidden},
+    })
+}
+
+func TestTemplateExtendsErrors(t *testing.T) {
+    tests := []struct {
+        name      string
+        templates map[string]DomainConfig
+        extends   string
+        want      string
+    }{
+        {"unknown", map[string]DomainConfig{"internal": {SourceIPs: ips("10.0.0.0/8")}}, "internl", `unknown template "internl" (templates: internal)`},
+        {"no templates", nil, "internal", `unknown template "internal" (no templates are configured)`},
+        {"unknown in an unused template", map[string]DomainConfig{"a": {Extends: "missing"}}, "", `unknown template "missing"`},
+        {"self", map[string]DomainConfig{"a": {Extends: "a"}}, "a", "circular template extends: a -> a"},
+        {"cycle", map[string]DomainConfig{
+            "a": {Extends: "b"},
+            "b": {Extends: "c"},
+            "c": {Extends: "a"},
+        }, "", "circular template extends: a -> b -> c -> a"},
+    }
+    for _, tt := range tests {
+        config := domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.1"), Extends: tt.extends})
+        config.Templates = tt.templates
+        _, err := New(context.Background(), okHandler, config, "test")
+        if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), "extends") {
+            t.Errorf("%s: got %v, want an error at extends containing %q", tt.name, err, tt.want)
+        }
+    }
+}
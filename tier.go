@@ -0,0 +1,72 @@
+package DomainSentinel
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// TierConfig assigns a trust level to a set of addresses. Higher levels are
+// more trusted.
+type TierConfig struct {
+    Level     int      `json:"level,omitempty"`
+    SourceIPs []string `json:"sourceIPs,omitempty"`
+}
+
+// tier is a compiled TierConfig.
+type tier struct {
+    name  string
+    level int
+    list  *ipList
+}
+
+// compileTiers validates the tiers section and returns the tiers ordered from
+// the highest level down, so the first match is the client's tier.
+func (c *compiler) compileTiers(configs map[string]TierConfig) ([]*tier, error) {
+    tiers := make([]*tier, 0, len(configs))
+    levels := make(map[int]string, len(configs))
+    for name, config := range configs {
+        if name == "" || strings.ContainsAny(name, "@ \t") {
+            return nil, fmt.Errorf("invalid tiers name %q", name)
+        }
+        if config.Level < 1 {
+            return nil, fmt.Errorf("tier %q: level must be at least 1", name)
+        }
+        if other, dup := levels[config.Level]; dup {
+            return nil, fmt.Errorf("tiers %q and %q have the same level %d, levels must be distinct", other, name, config.Level)
+        }
+        levels[config.Level] = name
+
+        list, err := c.parseIPList(stringEntries(config.SourceIPs))
+        if err != nil {
+            return nil, fmt.Errorf("tier %q: %w", name, err)
+        }
+        if list.empty() {
+            return nil, fmt.Errorf("tier %q: sourceIPs must not be empty", name)
+        }
+        tiers = append(tiers, &tier{name: name, level: config.Level, list: list})
+    }
+    sort.Slice(tiers, func(i, j int) bool { return tiers[i].level > tiers[j].level })
+    return tiers, nil
+}
+
+// lookupTier returns the tier named by a minTier setting, nil if name is empty.
+func (c *compiler) lookupTier(name string) (*tier, error) {
+    if name == "" {
+        return nil, nil
+    }
+    for _, t := range c.tiers {
+        if t.name == name {
+            return t, nil
+        }
+    }
+    return nil, fmt.Errorf("unknown minTier %q", name)
+}
+
+// String formats the tier for log output.
+func (t *tier) String() string {
+    if t == nil {
+        return "none"
+    }
+    return fmt.Sprintf("%s (%d)", t.name, t.level)
+}
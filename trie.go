@@ -0,0 +1,81 @@
+package DomainSentinel
+
+import "net/netip"
+
+// prefixTrie is a binary trie over address bits, with separate roots for
+// IPv4 and IPv6. Lookups cost O(address length) regardless of how many
+// prefixes the list holds.
+type prefixTrie struct {
+    v4 *trieNode
+    v6 *trieNode
+}
+
+type trieNode struct {
+    children [2]*trieNode
+    index    int  // entry index of the prefix ending here
+    terminal bool // a prefix ends at this node
+}
+
+// newPrefixTrie builds a trie holding all prefixes. When two entries cover
+// the same prefix, the first one is kept.
+func newPrefixTrie(prefixes []ipPrefix) *prefixTrie {
+    t := &prefixTrie{v4: &trieNode{}, v6: &trieNode{}}
+    for _, p := range prefixes {
+        t.insert(p)
+    }
+    return t
+}
+
+func (t *prefixTrie) insert(p ipPrefix) {
+    node := t.v6
+    if p.Addr().Is4() {
+        node = t.v4
+    }
+    bytes, offset := addrBits(p.Addr())
+    for i := 0; i < p.Bits(); i++ {
+        bit := bitAt(bytes[:], offset+i)
+        if node.children[bit] == nil {
+            node.children[bit] = &trieNode{}
+        }
+        node = node.children[bit]
+    }
+    if !node.terminal {
+        node.terminal = true
+        node.index = p.index
+    }
+}
+
+// lookup returns the entry index of the longest prefix containing ip.
+func (t *prefixTrie) lookup(ip netip.Addr) (int, bool) {
+    node := t.v6
+    if ip.Is4() {
+        node = t.v4
+    }
+    bytes, offset := addrBits(ip)
+
+    index, found := 0, false
+    for i := 0; node != nil; i++ {
+        if node.terminal {
+            index, found = node.index, true
+        }
+        if i == ip.BitLen() {
+            break
+        }
+        node = node.children[bitAt(bytes[:], offset+i)]
+    }
+    return index, found
+}
+
+// addrBits returns the address as 16 bytes, IPv4 in its mapped form, and
+// the index of its first bit, 96 for IPv4 and 0 for IPv6. Returning an
+// array rather than a slice keeps lookups free of allocations.
+func addrBits(ip netip.Addr) ([16]byte, int) {
+    if ip.Is4() {
+        return ip.As16(), 96
+    }
+    return ip.As16(), 0
+}
+
+func bitAt(b []byte, i int) int {
+    return int(b[i/8]>>(7-uint(i%8))) & 1
+}
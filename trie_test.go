@@ -0,0 +1,104 @@
+package DomainSentinel
+
+import (
+    "fmt"
+    "math/rand"
+    "net/netip"
+    "testing"
+)
+
+// randomPrefixes returns n random prefixes of both address families.
+func randomPrefixes(rng *rand.Rand, n int) []ipPrefix {
+    prefixes := make([]ipPrefix, n)
+    for i := range prefixes {
+        var addr netip.Addr
+        var bits int
+        if i%2 == 0 {
+            var b [4]byte
+            rng.Read(b[:])
+            addr, bits = netip.AddrFrom4(b), 8+rng.Intn(25)
+        } else {
+            var b [16]byte
+            rng.Read(b[:])
+            addr, bits = netip.AddrFrom16(b), 16+rng.Intn(113)
+        }
+        prefixes[i] = ipPrefix{Prefix: netip.PrefixFrom(addr, bits).Masked(), index: i}
+    }
+    return prefixes
+}
+
+// linearLookup is the scan the trie replaces: the longest prefix containing
+// ip, the first listed among equal ones.
+func linearLookup(prefixes []ipPrefix, ip netip.Addr) (int, bool) {
+    index, bits := 0, -1
+    for _, p := range prefixes {
+        if p.Contains(ip) && p.Bits() > bits {
+            index, bits = p.index, p.Bits()
+        }
+    }
+    return index, bits >= 0
+}
+
+// lookupAddrs returns addresses inside and around prefixes.
+func lookupAddrs(rng *rand.Rand, prefixes []ipPrefix, n int) []netip.Addr {
+    addrs := make([]netip.Addr, n)
+    for i := range addrs {
+        p := prefixes[rng.Intn(len(prefixes))]
+        b := p.Addr().As16()
+        // Flip one of the low bits, which may or may not leave the prefix.
+        bit := rng.Intn(p.Addr().BitLen())
+        offset := 128 - p.Addr().BitLen()
+        b[(offset+bit)/8] ^= 1 << (7 - uint((offset+bit)%8))
+        addrs[i] = netip.AddrFrom16(b)
+        if p.Addr().Is4() {
+            addrs[i] = addrs[i].Unmap()
+        }
+    }
+    return addrs
+}
+
+func TestPrefixTrieMatchesLinearScan(t *testing.T) {
+    rng := rand.New(rand.NewSource(1))
+    prefixes := randomPrefixes(rng, 2000)
+    // Nested and duplicate prefixes.
+    prefixes = append(prefixes,
+        ipPrefix{Prefix: netip.MustParsePrefix("10.0.0.0/8"), index: 2000},
+        ipPrefix{Prefix: netip.MustParsePrefix("10.1.0.0/16"), index: 2001},
+        ipPrefix{Prefix: netip.MustParsePrefix("10.1.0.0/16"), index: 2002},
+        ipPrefix{Prefix: netip.MustParsePrefix("2001:db8::/32"), index: 2003},
+        ipPrefix{Prefix: netip.MustParsePrefix("2001:db8::1/128"), index: 2004},
+        ipPrefix{Prefix: netip.MustParsePrefix("0.0.0.0/0"), index: 2005},
+    )
+    trie := newPrefixTrie(prefixes)
+    addrs := append(lookupAddrs(rng, prefixes, 20000),
+        netip.MustParseAddr("10.1.2.3"), netip.MustParseAddr("10.2.3.4"),
+        netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("2001:db8::2"),
+        netip.MustParseAddr("::"), netip.MustParseAddr("255.255.255.255"))
+    for _, ip := range addrs {
+        wantIndex, wantOK := linearLookup(prefixes, ip)
+        index, ok := trie.lookup(ip)
+        if ok != wantOK || index != wantIndex {
+            t.Fatalf("lookup(%s) = %d, %v; the linear scan gives %d, %v", ip, index, ok, wantIndex, wantOK)
+        }
+    }
+}
+
+func BenchmarkPrefixLookup(b *testing.B) {
+    for _, n := range []int{100, 1000, 10000} {
+        rng := rand.New(rand.NewSource(1))
+        prefixes := randomPrefixes(rng, n)
+        addrs := lookupAddrs(rng, prefixes, 1024)
+        trie := newPrefixTrie(prefixes)
+        b.Run(fmt.Sprintf("linear/%d", n), func(b *testing.B) {
+            for i := 0; i < b.N; i++ {
+                linearLookup(prefixes, addrs[i%len(addrs)])
+            }
+        })
+        b.Run(fmt.Sprintf("trie/%d", n), func(b *testing.B) {
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                trie.lookup(addrs[i%len(addrs)])
+            }
+        })
+    }
+}
@@ -0,0 +1,316 @@
+package DomainSentinel
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// ConfigError is a problem with one setting of the configuration. Every
+// error New returns is a ConfigError or, when validation finds several
+// problems, a ConfigErrors; errors.As extracts either form from both, so
+// that deployment tools can point at the offending entry.
+type ConfigError struct {
+    // Section is the map or list holding the entry: domainPathRules,
+    // zones, rules, templates, ipGroups or globalPathRules. It is empty
+    // for plugin-level options.
+    Section string
+    // Domain is the key of the entry in Section, or for rules its index.
+    Domain string
+    // PathIndex is the index of the path rule in the pathRules of the
+    // entry, or in globalPathRules, and -1 outside path rules.
+    PathIndex int
+    // Field is the option with the problem as named in the configuration,
+    // such as sourceIPs, or tenantIPs["eu"] for a map. It is empty when
+    // the problem concerns the entry as a whole.
+    Field string
+    // Entry is the index in the list Field, and -1 outside lists.
+    Entry  int
+    Reason string
+    // Err is the error that caused the problem, if there is one.
+    Err error
+}
+
+// at returns an unlocated ConfigError to build a location from.
+func at() ConfigError {
+    return ConfigError{PathIndex: -1, Entry: -1}
+}
+
+// Location formats the setting of e, such as
+// domainPathRules["example.com"].pathRules[2].sourceIPs[0].
+func (e *ConfigError) Location() string {
+    var b strings.Builder
+    b.WriteString(e.Section)
+    switch {
+    case e.Section == "rules" && e.Domain != "":
+        fmt.Fprintf(&b, "[%s]", e.Domain)
+    case e.Domain != "":
+        fmt.Fprintf(&b, "[%q]", e.Domain)
+    }
+    if e.PathIndex >= 0 {
+        if e.Section != "globalPathRules" {
+            b.WriteString(".pathRules")
+        }
+        fmt.Fprintf(&b, "[%d]", e.PathIndex)
+    }
+    if e.Field != "" {
+        if b.Len() > 0 {
+            b.WriteByte('.')
+        }
+        b.WriteString(e.Field)
+    }
+    if e.Entry >= 0 {
+        fmt.Fprintf(&b, "[%d]", e.Entry)
+    }
+    return b.String()
+}
+
+func (e *ConfigError) Error() string {
+    if location := e.Location(); location != "" {
+        return location + ": " + e.Reason
+    }
+    return e.Reason
+}
+
+func (e *ConfigError) Unwrap() error {
+    return e.Err
+}
+
+// As lets errors.As extract a ConfigErrors holding only e.
+func (e *ConfigError) As(target interface{}) bool {
+    if t, ok := target.(*ConfigErrors); ok {
+        *t = ConfigErrors{e}
+        return true
+    }
+    return false
+}
+
+// ConfigErrors lists every problem validateConfig found, so that all of them
+// can be fixed before the next restart.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+    if len(e) == 1 {
+        return "invalid configuration: " + e[0].Error()
+    }
+    problems := make([]string, len(e))
+    for i, problem := range e {
+        problems[i] = problem.Error()
+    }
+    return fmt.Sprintf("invalid configuration, %d problems:\n  %s", len(e), strings.Join(problems, "\n  "))
+}
+
+// As lets errors.As extract the first problem as a *ConfigError.
+func (e ConfigErrors) As(target interface{}) bool {
+    if t, ok := target.(**ConfigError); ok && len(e) > 0 {
+        *t = e[0]
+        return true
+    }
+    return false
+}
+
+// asConfigError returns err as a ConfigError without location, unless it
+// already is one or a ConfigErrors.
+func asConfigError(err error) error {
+    var problem *ConfigError
+    if errors.As(err, &problem) {
+        return err
+    }
+    located := at()
+    located.Reason, located.Err = err.Error(), err
+    return &located
+}
+
+// entryError locates err, returned when compiling the entry key of
+// section, at that entry. A ConfigError placed within the entry, as for a
+// path rule, keeps its place; the entry is dropped from the message of
+// other errors, which name it themselves.
+func entryError(section, key string, err error) error {
+    located := at()
+    var problem *ConfigError
+    if errors.As(err, &problem) {
+        located = *problem
+    } else {
+        located.Reason, located.Err = err.Error(), err
+        for _, prefix := range []string{fmt.Sprintf("domain %q: ", key), fmt.Sprintf("zone %q: ", key)} {
+            located.Reason = strings.TrimPrefix(located.Reason, prefix)
+        }
+    }
+    located.Section, located.Domain = section, key
+    if section == "rules" {
+        located.Domain = strings.TrimSuffix(strings.TrimPrefix(key, "rules["), "]")
+    }
+    return &located
+}
+
+// configValidator collects the problems of a configuration, each with its
+// location, such as domainPathRules["example.com"].pathRules[2].
+type configValidator struct {
+    groups   map[string][]string
+    strict   bool
+    problems ConfigErrors
+}
+
+// validateConfig checks the syntax of the whole configuration before it is
+// compiled: domain keys, path patterns and the entries of IP lists. Checks
+// that depend on other settings, such as group cycles or duplicate rule
+// names, are left to the compiler, which stops at the first error.
+func validateConfig(config *Config) error {
+    v := &configValidator{groups: config.IPGroups, strict: config.Strict}
+    if len(config.DomainPathRules) == 0 && len(config.Zones) == 0 && len(config.Rules) == 0 && len(config.GlobalPathRules) == 0 && !config.AllowEmptyConfig {
+        v.add(at(), "no domainPathRules, zones, rules or globalPathRules are configured; set allowEmptyConfig to load without rules")
+    }
+    if len(config.Rules) > 0 && (len(config.DomainPathRules) > 0 || len(config.Zones) > 0) {
+        loc := at()
+        loc.Section = "rules"
+        v.add(loc, "cannot be combined with domainPathRules or zones; use one format")
+    }
+    v.stringIPs(field(at(), "defaultSourceIPs"), config.DefaultSourceIPs)
+    for _, name := range sortedKeys(config.IPGroups) {
+        loc := at()
+        loc.Section, loc.Domain = "ipGroups", name
+        v.stringIPs(loc, config.IPGroups[name])
+    }
+    for _, section := range []struct {
+        name  string
+        rules map[string]DomainConfig
+    }{{"domainPathRules", config.DomainPathRules}, {"zones", config.Zones}} {
+        for _, key := range sortedConfigKeys(section.rules) {
+            loc := at()
+            loc.Section, loc.Domain = section.name, key
+            if strings.TrimSpace(key) == "" {
+                v.add(loc, "empty domain")
+            }
+            v.unset(loc, section.rules[key])
+            v.domain(loc, section.rules[key])
+        }
+    }
+    for i, rule := range config.Rules {
+        loc := at()
+        loc.Section, loc.Domain = "rules", strconv.Itoa(i)
+        if len(hostEntries(rule.Hosts)) == 0 {
+            v.add(field(loc, "hosts"), "a rule needs at least one host")
+        }
+        if isTrue(rule.Inherit) {
+            v.add(field(loc, "inherit"), "not supported by rules, which have no parent domain")
+        }
+        v.unset(loc, rule)
+        v.domain(loc, rule)
+    }
+    for i, rule := range config.GlobalPathRules {
+        loc := at()
+        loc.Section, loc.PathIndex = "globalPathRules", i
+        v.pathRule(loc, rule)
+    }
+    if len(v.problems) > 0 {
+        return v.problems
+    }
+    return nil
+}
+
+// field returns loc narrowed to the option name.
+func field(loc ConfigError, name string) ConfigError {
+    loc.Field = name
+    return loc
+}
+
+func (v *configValidator) add(loc ConfigError, reason string) {
+    loc.Reason = reason
+    v.problems = append(v.problems, &loc)
+}
+
+// unset reports a domain entry that sets nothing besides the fields naming
+// it. Traefik drops field names it does not know before the plugin sees the
+// configuration, so an entry with misspelled names, such as sourceIps,
+// arrives empty and would silently follow emptyListAction.
+func (v *configValidator) unset(loc ConfigError, config DomainConfig) {
+    var fields map[string]interface{}
+    if err := roundTrip(config, &fields); err != nil {
+        return
+    }
+    for field := range fields {
+        if !notInherited[field] {
+            return
+        }
+    }
+    const problem = "sets no fields; check its field names for typos, which are dropped when the configuration is decoded"
+    if v.strict {
+        v.add(loc, problem)
+        return
+    }
+    fmt.Printf("Warning: %s %s (strict=true makes this an error)\n", loc.Location(), problem)
+}
+
+func (v *configValidator) domain(loc ConfigError, config DomainConfig) {
+    v.sourceIPs(field(loc, "sourceIPs"), config.SourceIPs)
+    v.stringIPs(field(loc, "deniedIPs"), config.DeniedIPs)
+    v.stringIPs(field(loc, "exceptIPs"), config.ExceptIPs)
+    for i, rule := range config.PathRules {
+        pathLoc := loc
+        pathLoc.PathIndex = i
+        v.pathRule(pathLoc, rule)
+    }
+}
+
+func (v *configValidator) pathRule(loc ConfigError, config PathConfig) {
+    switch path := config.Path; {
+    case strings.TrimSpace(path) == "":
+        v.add(field(loc, "path"), "empty path")
+    case !strings.HasPrefix(path, "/") && !strings.HasPrefix(path, regexPrefix) && !isSuffixPattern(path):
+        v.add(field(loc, "path"), fmt.Sprintf("path %q must start with \"/\", or with %q for a regex or \"*\" for a suffix pattern", config.Path, regexPrefix))
+    }
+    v.sourceIPs(field(loc, "sourceIPs"), config.SourceIPs)
+    v.stringIPs(field(loc, "deniedIPs"), config.DeniedIPs)
+    v.stringIPs(field(loc, "exceptIPs"), config.ExceptIPs)
+    for _, tenant := range sortedKeys(config.TenantIPs) {
+        v.stringIPs(field(loc, fmt.Sprintf("tenantIPs[%q]", tenant)), config.TenantIPs[tenant])
+    }
+}
+
+// sourceIPs checks a list of strings and SourceIP objects.
+func (v *configValidator) sourceIPs(loc ConfigError, values []interface{}) {
+    for i, value := range values {
+        loc.Entry = i
+        entries, err := sourceIPValue(value)
+        if err != nil {
+            v.add(loc, err.Error())
+            continue
+        }
+        for _, e := range entries {
+            if err := checkIPEntry(e.value, v.groups); err != nil {
+                v.add(loc, err.Error())
+            }
+        }
+    }
+}
+
+func (v *configValidator) stringIPs(loc ConfigError, values []string) {
+    for i, value := range values {
+        loc.Entry = i
+        for _, entry := range splitListEntry(value) {
+            if err := checkIPEntry(entry, v.groups); err != nil {
+                v.add(loc, err.Error())
+            }
+        }
+    }
+}
+
+func sortedConfigKeys(m map[string]DomainConfig) []string {
+    keys := make([]string, 0, len(m))
+    for key := range m {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+func sortedKeys(m map[string][]string) []string {
+    keys := make([]string, 0, len(m))
+    for key := range m {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
@@ -0,0 +1,100 @@
+package DomainSentinel
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "reflect"
+    "sort"
+    "testing"
+)
+
+func TestConfigErrorsExtractable(t *testing.T) {
+    config := CreateConfig()
+    config.DomainPathRules["example.com"] = DomainConfig{
+        SourceIPs: ips("10.0.0.0/8"),
+        PathRules: []PathConfig{
+            {Path: "/ok", SourceIPs: ips("10.0.0.1")},
+            {Path: "admin", SourceIPs: ips("10.0.0.0/33")},
+        },
+    }
+    config.DomainPathRules["shop.example.com"] = DomainConfig{SourceIPs: ips("10.0.0.1"), DeniedIPs: []string{"10.0.0.2", "not an address"}}
+    _, err := New(context.Background(), okHandler, config, "test")
+
+    var problems ConfigErrors
+    if !errors.As(err, &problems) {
+        t.Fatalf("errors.As(%v, ConfigErrors) failed", err)
+    }
+    type location struct {
+        section, domain string
+        pathIndex       int
+        field           string
+        entry           int
+    }
+    want := []location{
+        {"domainPathRules", "example.com", 1, "path", -1},
+        {"domainPathRules", "example.com", 1, "sourceIPs", 0},
+        {"domainPathRules", "shop.example.com", -1, "deniedIPs", 1},
+    }
+    got := make([]location, len(problems))
+    for i, p := range problems {
+        got[i] = location{p.Section, p.Domain, p.PathIndex, p.Field, p.Entry}
+        if p.Reason == "" {
+            t.Errorf("problem %d has no reason", i)
+        }
+        if p.Error() != p.Location()+": "+p.Reason {
+            t.Errorf("problem %d: Error() = %q, want the location, a colon and the reason", i, p.Error())
+        }
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("problems at %+v, want %+v", got, want)
+    }
+    if want := `domainPathRules["example.com"].pathRules[1].sourceIPs[0]`; problems[1].Location() != want {
+        t.Errorf("Location() = %q, want %q", problems[1].Location(), want)
+    }
+
+    var first *ConfigError
+    if !errors.As(err, &first) || first != problems[0] {
+        t.Errorf("errors.As(*ConfigError) = %v, want the first problem", first)
+    }
+}
+
+func TestConfigErrorSingle(t *testing.T) {
+    config := domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.1")})
+    config.OnAddressError = "ignore"
+    _, err := New(context.Background(), okHandler, config, "test")
+    var problem *ConfigError
+    if !errors.As(err, &problem) {
+        t.Fatalf("errors.As(%v, *ConfigError) failed", err)
+    }
+    var problems ConfigErrors
+    if !errors.As(problem, &problems) || len(problems) != 1 || problems[0] != problem {
+        t.Errorf("errors.As(*ConfigError, ConfigErrors) = %v, want a list of the one problem", problems)
+    }
+}
+
+func TestValidateConfigDeterministic(t *testing.T) {
+    config := CreateConfig()
+    for i := 0; i < 20; i++ {
+        config.DomainPathRules[fmt.Sprintf("d%02d.example.com", i)] = DomainConfig{
+            SourceIPs: ips("10.0.0.0/33"),
+            PathRules: []PathConfig{{Path: "admin", SourceIPs: ips("not an address")}},
+        }
+    }
+    first := ValidateConfig(config)
+    var problems ConfigErrors
+    if !errors.As(first, &problems) || len(problems) != 60 {
+        t.Fatalf("got %v, want 60 problems", first)
+    }
+    for i := 0; i < 20; i++ {
+        if err := ValidateConfig(config); err.Error() != first.Error() {
+            t.Fatalf("run %d reported the problems in a different order:\n%v", i, err)
+        }
+    }
+    if !sort.SliceIsSorted(problems, func(i, j int) bool { return problems[i].Domain < problems[j].Domain }) {
+        t.Errorf("problems are not in key order:\n%v", first)
+    }
+    if err := ValidateConfig(domainConfig("example.com", DomainConfig{SourceIPs: ips("10.0.0.1")})); err != nil {
+        t.Errorf("valid configuration: %v", err)
+    }
+}
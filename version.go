@@ -0,0 +1,66 @@
+package DomainSentinel
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// currentConfigVersion is the configuration shape this version of the
+// plugin reads natively. A configuration without a version is in the
+// legacy shape and is migrated to it.
+const currentConfigVersion = 1
+
+// supportedConfigVersions are the versions New accepts, oldest first.
+var supportedConfigVersions = []int{1}
+
+// migrateConfig returns config in the shape of currentConfigVersion. A
+// configuration without version is migrated, and logged as legacy if that
+// changed anything, since most are already valid version 1; a version
+// this plugin does not know is an error, since any reading of it could
+// be wrong. config is not modified.
+func migrateConfig(config *Config, name string) (*Config, error) {
+    switch config.Version {
+    case 0:
+        migrated, changes := migrateLegacyConfig(config)
+        if len(changes) > 0 {
+            fmt.Printf("DomainSentinel %s: legacy configuration without version detected, migrated to version %d\n", name, currentConfigVersion)
+            for _, change := range changes {
+                fmt.Println("  -", change)
+            }
+        }
+        return migrated, nil
+    case 1:
+        if len(config.TrustedProxies) > 0 {
+            loc := field(at(), "trustedProxies")
+            loc.Reason = "not part of config version 1, set ipStrategy.trustedProxies instead"
+            return nil, &loc
+        }
+        return config, nil
+    }
+    versions := make([]string, len(supportedConfigVersions))
+    for i, v := range supportedConfigVersions {
+        versions[i] = strconv.Itoa(v)
+    }
+    loc := field(at(), "version")
+    loc.Reason = fmt.Sprintf("unsupported config version %d: this plugin supports version %s, or no version for the legacy shape",
+        config.Version, strings.Join(versions, ", "))
+    return nil, &loc
+}
+
+// migrateLegacyConfig converts a configuration without version to version
+// 1, and describes each change it made. The result makes the same
+// decisions as the legacy configuration:
+//   - the plugin-level trustedProxies move into ipStrategy.trustedProxies,
+//     ahead of the entries already there, as both lists were combined.
+func migrateLegacyConfig(config *Config) (*Config, []string) {
+    migrated := *config
+    migrated.Version = 1
+    var changes []string
+    if len(config.TrustedProxies) > 0 {
+        migrated.IPStrategy.TrustedProxies = append(append([]string(nil), config.TrustedProxies...), config.IPStrategy.TrustedProxies...)
+        migrated.TrustedProxies = nil
+        changes = append(changes, fmt.Sprintf("trustedProxies moved to ipStrategy.trustedProxies (%d entries)", len(config.TrustedProxies)))
+    }
+    return &migrated, changes
+}
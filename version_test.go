@@ -0,0 +1,77 @@
+package DomainSentinel
+
+import (
+    "context"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestLegacyConfigMigration(t *testing.T) {
+    const rules = `"domainPathRules": {"example.com": {
+        "sourceIPs": ["192.0.2.0/24"],
+        "pathRules": [{"path": "/admin", "sourceIPs": ["192.0.2.5"]}]
+    }}`
+    tests := []struct {
+        name, legacy, current string
+        logged                bool
+    }{
+        {"no legacy fields", `{` + rules + `}`, `{"version": 1, ` + rules + `}`, false},
+        {
+            "trustedProxies",
+            `{"trustedProxies": ["10.0.0.0/8"], ` + rules + `}`,
+            `{"version": 1, "ipStrategy": {"trustedProxies": ["10.0.0.0/8"]}, ` + rules + `}`,
+            true,
+        },
+        {
+            "trustedProxies in both places",
+            `{"trustedProxies": ["10.0.0.0/8"], "ipStrategy": {"trustedProxies": ["172.16.0.0/12"]}, ` + rules + `}`,
+            `{"version": 1, "ipStrategy": {"trustedProxies": ["10.0.0.0/8", "172.16.0.0/12"]}, ` + rules + `}`,
+            true,
+        },
+    }
+    requests := []struct {
+        target, remoteAddr, forwardedFor string
+    }{
+        {"http://example.com/", "192.0.2.1:1234", ""},
+        {"http://example.com/", "198.51.100.1:1234", ""},
+        {"http://example.com/", "10.0.0.1:1234", "192.0.2.1"},
+        {"http://example.com/", "10.0.0.1:1234", "198.51.100.1"},
+        {"http://example.com/admin", "10.0.0.1:1234", "192.0.2.5"},
+        {"http://example.com/admin", "172.16.0.1:1234", "192.0.2.5"},
+        {"http://example.com/admin", "172.16.0.1:1234", "192.0.2.6"},
+        {"http://example.com/admin", "198.51.100.1:1234", "192.0.2.5"},
+    }
+    for _, tt := range tests {
+        var legacy http.Handler
+        out := captureOutput(t, func() { legacy = newTestSentinel(t, decodeTestConfig(t, tt.legacy)) })
+        if logged := strings.Contains(out, "legacy configuration"); logged != tt.logged {
+            t.Errorf("%s: legacy configuration logged %v, want %v:\n%s", tt.name, logged, tt.logged, out)
+        }
+        current := newTestSentinel(t, decodeTestConfig(t, tt.current))
+        for _, r := range requests {
+            var header []string
+            if r.forwardedFor != "" {
+                header = []string{"X-Forwarded-For", r.forwardedFor}
+            }
+            got := serve(legacy, r.target, r.remoteAddr, header...).Code
+            if want := serve(current, r.target, r.remoteAddr, header...).Code; got != want {
+                t.Errorf("%s: GET %s from %s (X-Forwarded-For %q): legacy status %d, version 1 status %d",
+                    tt.name, r.target, r.remoteAddr, r.forwardedFor, got, want)
+            }
+        }
+    }
+}
+
+func TestConfigVersionErrors(t *testing.T) {
+    tests := []struct{ config, want string }{
+        {`{"version": 2, "domainPathRules": {"example.com": {"sourceIPs": ["10.0.0.1"]}}}`, "supports version 1"},
+        {`{"version": 1, "trustedProxies": ["10.0.0.0/8"], "domainPathRules": {"example.com": {"sourceIPs": ["10.0.0.1"]}}}`, "ipStrategy.trustedProxies"},
+    }
+    for _, tt := range tests {
+        _, err := New(context.Background(), okHandler, decodeTestConfig(t, tt.config), "test")
+        if err == nil || !strings.Contains(err.Error(), tt.want) {
+            t.Errorf("%s: got %v, want an error containing %q", tt.config, err, tt.want)
+        }
+    }
+}
@@ -0,0 +1,502 @@
+package DomainSentinel
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// yamlSyntaxError is a YAML document that yamlToJSON cannot convert.
+type yamlSyntaxError struct {
+    Line int
+    msg  string
+}
+
+func (e *yamlSyntaxError) Error() string {
+    return fmt.Sprintf("line %d: %s", e.Line, e.msg)
+}
+
+// yamlLine is a line of a YAML document with content, without its
+// indentation and comment.
+type yamlLine struct {
+    num    int // 1-based
+    indent int
+    text   string
+}
+
+// yamlParser converts a YAML document to JSON. Every value is written on
+// the line it has in the document, so that the line of a JSON decoding
+// error is the line in the YAML.
+type yamlParser struct {
+    lines []yamlLine
+    pos   int
+    out   bytes.Buffer
+    line  int // of out
+}
+
+// yamlToJSON converts a YAML document to JSON, so that YAML rules files are
+// decoded like JSON ones. The standard library has no YAML parser, so this
+// covers the subset rule files need: block mappings and sequences, flow
+// collections written on one line, plain and quoted scalars, and comments.
+// Anchors, aliases, tags, block scalars and multiple documents are
+// rejected. An empty document converts to nothing.
+func yamlToJSON(data []byte) ([]byte, error) {
+    lines, err := yamlLines(data)
+    if err != nil || len(lines) == 0 {
+        return nil, err
+    }
+    p := &yamlParser{lines: lines, line: 1}
+    if err := p.block(lines[0].indent); err != nil {
+        return nil, err
+    }
+    if p.pos < len(p.lines) {
+        return nil, p.errorf(p.lines[p.pos].num, "unexpected indentation")
+    }
+    return p.out.Bytes(), nil
+}
+
+// yamlLines splits data into the lines that have content.
+func yamlLines(data []byte) ([]yamlLine, error) {
+    var lines []yamlLine
+    ended := false
+    for i, raw := range strings.Split(strings.TrimPrefix(string(data), "\ufeff"), "\n") {
+        num := i + 1
+        raw = strings.TrimSuffix(raw, "\r")
+        indent := len(raw) - len(strings.TrimLeft(raw, " "))
+        text := strings.TrimRight(stripYAMLComment(raw[indent:]), " \t")
+        if strings.TrimLeft(text, "\t") == "" {
+            continue
+        }
+        switch {
+        case ended:
+            return nil, &yamlSyntaxError{num, "content after the end of the document"}
+        case text == "---" && indent == 0:
+            if len(lines) > 0 {
+                return nil, &yamlSyntaxError{num, "multiple documents are not supported"}
+            }
+            continue
+        case text == "..." && indent == 0:
+            ended = true
+            continue
+        case text[0] == '\t':
+            return nil, &yamlSyntaxError{num, "tabs cannot be used for indentation"}
+        case text[0] == '%' && indent == 0:
+            return nil, &yamlSyntaxError{num, "directives are not supported"}
+        }
+        lines = append(lines, yamlLine{num: num, indent: indent, text: text})
+    }
+    return lines, nil
+}
+
+// stripYAMLComment removes a comment from a line: a "#" at its start or
+// after whitespace, outside quotes.
+func stripYAMLComment(s string) string {
+    var quote byte
+    for i := 0; i < len(s); i++ {
+        c := s[i]
+        switch {
+        case quote == '"':
+            if c == '\\' {
+                i++
+            } else if c == '"' {
+                quote = 0
+            }
+        case quote == '\'':
+            if c == '\'' {
+                if i+1 < len(s) && s[i+1] == '\'' {
+                    i++
+                } else {
+                    quote = 0
+                }
+            }
+        case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+            return s[:i]
+        case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t[{,:", s[i-1]) >= 0):
+            quote = c
+        }
+    }
+    return s
+}
+
+func (p *yamlParser) errorf(line int, format string, args ...interface{}) error {
+    return &yamlSyntaxError{Line: line, msg: fmt.Sprintf(format, args...)}
+}
+
+// at moves the output to line.
+func (p *yamlParser) at(line int) {
+    for p.line < line {
+        p.out.WriteByte('\n')
+        p.line++
+    }
+}
+
+func isSequenceItem(text string) bool {
+    return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// block converts the node starting at the current line, which is indented
+// by indent.
+func (p *yamlParser) block(indent int) error {
+    l := p.lines[p.pos]
+    if isSequenceItem(l.text) {
+        return p.sequence(indent)
+    }
+    if _, _, ok := splitYAMLKey(l.text); ok {
+        return p.mapping(indent)
+    }
+    p.pos++
+    return p.inline(l.text, l.num)
+}
+
+// mapping converts a block mapping whose keys are indented by indent.
+func (p *yamlParser) mapping(indent int) error {
+    p.at(p.lines[p.pos].num)
+    p.out.WriteByte('{')
+    seen := make(map[string]bool)
+    for p.pos < len(p.lines) {
+        l := p.lines[p.pos]
+        if l.indent < indent {
+            break
+        }
+        if l.indent > indent {
+            return p.errorf(l.num, "unexpected indentation")
+        }
+        key, value, ok := splitYAMLKey(l.text)
+        if !ok {
+            return p.errorf(l.num, "expected \"key: value\", got %q", l.text)
+        }
+        if err := checkPlainYAML(l.text, key); err != nil {
+            return p.errorf(l.num, "%v", err)
+        }
+        if seen[key] {
+            return p.errorf(l.num, "duplicate key %q", key)
+        }
+        if len(seen) > 0 {
+            p.out.WriteByte(',')
+        }
+        seen[key] = true
+        p.at(l.num)
+        writeJSONString(&p.out, key)
+        p.out.WriteByte(':')
+        p.pos++
+        var err error
+        if value != "" {
+            err = p.inline(value, l.num)
+        } else {
+            err = p.nested(indent, true)
+        }
+        if err != nil {
+            return err
+        }
+    }
+    p.out.WriteByte('}')
+    return nil
+}
+
+// sequence converts a block sequence whose "-" items are indented by indent.
+func (p *yamlParser) sequence(indent int) error {
+    p.at(p.lines[p.pos].num)
+    p.out.WriteByte('[')
+    for n := 0; p.pos < len(p.lines); n++ {
+        l := p.lines[p.pos]
+        if l.indent < indent || (l.indent == indent && !isSequenceItem(l.text)) {
+            break
+        }
+        if l.indent > indent {
+            return p.errorf(l.num, "unexpected indentation")
+        }
+        if n > 0 {
+            p.out.WriteByte(',')
+        }
+        content := strings.TrimLeft(l.text[1:], " ")
+        if content == "" {
+            p.pos++
+            p.at(l.num)
+            if err := p.nested(indent, false); err != nil {
+                return err
+            }
+            continue
+        }
+        // The item's content is a node of its own, indented to where it starts.
+        p.lines[p.pos] = yamlLine{num: l.num, indent: indent + len(l.text) - len(content), text: content}
+        if err := p.block(p.lines[p.pos].indent); err != nil {
+            return err
+        }
+    }
+    p.out.WriteByte(']')
+    return nil
+}
+
+// nested converts the value of a key or item with nothing after its
+// indicator: a block on the following, further indented lines, or null.
+// A sequence may also start at indent itself when it is the value of a key.
+func (p *yamlParser) nested(indent int, sequenceAtIndent bool) error {
+    if p.pos < len(p.lines) {
+        next := p.lines[p.pos]
+        if next.indent > indent || (sequenceAtIndent && next.indent == indent && isSequenceItem(next.text)) {
+            return p.block(next.indent)
+        }
+    }
+    p.out.WriteString("null")
+    return nil
+}
+
+// inline converts a value written on one line: a flow collection, a quoted
+// or a plain scalar.
+func (p *yamlParser) inline(text string, line int) error {
+    p.at(line)
+    var end int
+    var err error
+    switch text[0] {
+    case '[', '{':
+        end, err = p.flow(text, 0)
+    case '"', '\'':
+        var s string
+        if s, end, err = unquoteYAML(text, 0); err == nil {
+            writeJSONString(&p.out, s)
+        }
+    default:
+        if err = checkPlainYAML(text, text); err == nil {
+            writeYAMLScalar(&p.out, text)
+            end = len(text)
+        }
+    }
+    if err != nil {
+        return p.errorf(line, "%v", err)
+    }
+    if end < len(text) {
+        return p.errorf(line, "unexpected %q after the value", strings.TrimSpace(text[end:]))
+    }
+    return nil
+}
+
+// flow converts the flow collection or scalar of s starting at i and
+// returns the index after it.
+func (p *yamlParser) flow(s string, i int) (int, error) {
+    i = skipYAMLSpace(s, i)
+    if i == len(s) {
+        return 0, fmt.Errorf("flow collection is not closed; it must end on the line it starts on")
+    }
+    switch s[i] {
+    case '[', '{':
+        open, closing := s[i], byte(']')
+        if open == '{' {
+            closing = '}'
+        }
+        p.out.WriteByte(open)
+        i++
+        for n := 0; ; n++ {
+            i = skipYAMLSpace(s, i)
+            if i < len(s) && s[i] == closing {
+                p.out.WriteByte(closing)
+                return i + 1, nil
+            }
+            if n > 0 {
+                if i == len(s) {
+                    return 0, fmt.Errorf("flow collection is not closed; it must end on the line it starts on")
+                }
+                if s[i] != ',' {
+                    return 0, fmt.Errorf("expected \",\" or %q in flow collection, got %q", closing, s[i:])
+                }
+                if i = skipYAMLSpace(s, i+1); i < len(s) && s[i] == closing {
+                    p.out.WriteByte(closing) // trailing comma
+                    return i + 1, nil
+                }
+                p.out.WriteByte(',')
+            }
+            var err error
+            if open == '{' {
+                var key string
+                if key, i, err = flowKey(s, i); err != nil {
+                    return 0, err
+                }
+                writeJSONString(&p.out, key)
+                p.out.WriteByte(':')
+            }
+            if i, err = p.flow(s, i); err != nil {
+                return 0, err
+            }
+        }
+    case '"', '\'':
+        value, end, err := unquoteYAML(s, i)
+        if err != nil {
+            return 0, err
+        }
+        writeJSONString(&p.out, value)
+        return end, nil
+    }
+    end := i
+    for end < len(s) && strings.IndexByte(",]}", s[end]) < 0 {
+        end++
+    }
+    plain := strings.TrimSpace(s[i:end])
+    if plain == "" {
+        return 0, fmt.Errorf("missing value in flow collection")
+    }
+    if err := checkPlainYAML(plain, plain); err != nil {
+        return 0, err
+    }
+    writeYAMLScalar(&p.out, plain)
+    return end, nil
+}
+
+// flowKey returns the key of a flow mapping entry starting at i and the
+// index after its ":".
+func flowKey(s string, i int) (string, int, error) {
+    var key string
+    if s[i] == '"' || s[i] == '\'' {
+        var err error
+        if key, i, err = unquoteYAML(s, i); err != nil {
+            return "", 0, err
+        }
+        i = skipYAMLSpace(s, i)
+    } else {
+        start := i
+        for i < len(s) && !(s[i] == ':' && (i+1 == len(s) || strings.IndexByte(" ,]}", s[i+1]) >= 0)) {
+            if strings.IndexByte(",]}", s[i]) >= 0 {
+                return "", 0, fmt.Errorf("missing \":\" after flow mapping key %q", strings.TrimSpace(s[start:i]))
+            }
+            i++
+        }
+        key = strings.TrimSpace(s[start:i])
+        if err := checkPlainYAML(key, key); err != nil {
+            return "", 0, err
+        }
+    }
+    if i == len(s) || s[i] != ':' {
+        return "", 0, fmt.Errorf("missing \":\" after flow mapping key %q", key)
+    }
+    return key, i + 1, nil
+}
+
+func skipYAMLSpace(s string, i int) int {
+    for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+        i++
+    }
+    return i
+}
+
+// splitYAMLKey splits a "key: value" line. A plain key ends at the first
+// ":" followed by whitespace or the end of the line.
+func splitYAMLKey(text string) (key, value string, ok bool) {
+    switch text[0] {
+    case '[', '{':
+        return "", "", false
+    case '"', '\'':
+        key, end, err := unquoteYAML(text, 0)
+        if err != nil {
+            return "", "", false
+        }
+        end = skipYAMLSpace(text, end)
+        if end == len(text) || text[end] != ':' || (end+1 < len(text) && text[end+1] != ' ' && text[end+1] != '\t') {
+            return "", "", false
+        }
+        return key, strings.TrimSpace(text[end+1:]), true
+    }
+    for i := 0; i < len(text); i++ {
+        if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ' || text[i+1] == '\t') {
+            key = strings.TrimSpace(text[:i])
+            return key, strings.TrimSpace(text[i+1:]), key != ""
+        }
+    }
+    return "", "", false
+}
+
+// checkPlainYAML rejects a plain scalar of text that starts with a YAML
+// indicator this parser does not support, and that would not be read as
+// the literal value by any YAML parser. Quoted scalars pass.
+func checkPlainYAML(text, plain string) error {
+    if text[0] == '"' || text[0] == '\'' || plain == "" {
+        return nil
+    }
+    switch plain[0] {
+    case '&', '*':
+        return fmt.Errorf("anchors and aliases are not supported; quote %q if it is a value", plain)
+    case '!':
+        return fmt.Errorf("tags are not supported; quote %q if it is a value", plain)
+    case '|', '>':
+        return fmt.Errorf("block scalars are not supported; write the value on one line")
+    case '@', '`', '%':
+        return fmt.Errorf("a plain value cannot start with %q; quote %q", plain[0], plain)
+    }
+    return nil
+}
+
+// unquoteYAML returns the value of the single- or double-quoted scalar of
+// s starting at i and the index after it.
+func unquoteYAML(s string, i int) (string, int, error) {
+    quote := s[i]
+    var b strings.Builder
+    for j := i + 1; j < len(s); j++ {
+        c := s[j]
+        switch {
+        case c == quote && quote == '\'' && j+1 < len(s) && s[j+1] == '\'':
+            b.WriteByte('\'')
+            j++
+        case c == quote:
+            return b.String(), j + 1, nil
+        case c == '\\' && quote == '"':
+            if j+1 == len(s) {
+                return "", 0, fmt.Errorf("unterminated quoted value")
+            }
+            j++
+            if r, ok := yamlEscapes[s[j]]; ok {
+                b.WriteString(r)
+                continue
+            }
+            digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[s[j]]
+            if digits == 0 || j+digits >= len(s) {
+                return "", 0, fmt.Errorf("invalid escape \\%c in quoted value", s[j])
+            }
+            code, err := strconv.ParseUint(s[j+1:j+1+digits], 16, 32)
+            if err != nil {
+                return "", 0, fmt.Errorf("invalid escape \\%s in quoted value", s[j:j+1+digits])
+            }
+            b.WriteRune(rune(code))
+            j += digits
+        default:
+            b.WriteByte(c)
+        }
+    }
+    return "", 0, fmt.Errorf("unterminated quoted value; quoted values must end on the line they start on")
+}
+
+// yamlEscapes are the single-character escapes of double-quoted scalars.
+var yamlEscapes = map[byte]string{
+    '0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n", 'v': "\v", 'f': "\f",
+    'r': "\r", 'e': "\x1b", ' ': " ", '"': "\"", '/': "/", '\\': "\\",
+}
+
+// writeYAMLScalar writes a plain scalar as JSON, resolved like the YAML
+// core schema: booleans, null, integers and floats, and strings otherwise.
+func writeYAMLScalar(out *bytes.Buffer, plain string) {
+    switch plain {
+    case "true", "True", "TRUE":
+        out.WriteString("true")
+        return
+    case "false", "False", "FALSE":
+        out.WriteString("false")
+        return
+    case "null", "Null", "NULL", "~":
+        out.WriteString("null")
+        return
+    }
+    if n, err := strconv.ParseInt(plain, 10, 64); err == nil {
+        out.WriteString(strconv.FormatInt(n, 10))
+        return
+    }
+    if strings.Trim(plain, "0123456789.eE+-") == "" && strings.ContainsAny(plain, "0123456789") {
+        if f, err := strconv.ParseFloat(plain, 64); err == nil {
+            out.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+            return
+        }
+    }
+    writeJSONString(out, plain)
+}
+
+func writeJSONString(out *bytes.Buffer, s string) {
+    b, _ := json.Marshal(s)
+    out.Write(b)
+}
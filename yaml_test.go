@@ -0,0 +1,156 @@
+package DomainSentinel
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestYAMLToJSON(t *testing.T) {
+    tests := []struct {
+        name, yaml, want string
+    }{
+        {"empty", "# nothing here\n\n", ``},
+        {"mapping", "a: 1\nb: text\n", `{"a":1,"b":"text"}`},
+        {"nested mapping", "a:\n  b:\n    c: true\n  d: null\n", `{"a":{"b":{"c":true},"d":null}}`},
+        {"sequence", "- a\n- b\n", `["a","b"]`},
+        {"sequence at the key's indent", "a:\n- x\n- y\nb: 2\n", `{"a":["x","y"],"b":2}`},
+        {"indented sequence", "a:\n  - x\n  - y\n", `{"a":["x","y"]}`},
+        {"mappings in a sequence", "- ip: 10.0.0.1\n  label: vpn\n- 10.0.0.2\n", `[{"ip":"10.0.0.1","label":"vpn"},"10.0.0.2"]`},
+        {"nested sequences", "- - a\n  - b\n- c\n", `[["a","b"],"c"]`},
+        {"empty values", "a:\nb:\n-\n", `{"a":null,"b":[null]}`},
+        {"flow collections", "a: [10.0.0.1, '::1', {ip: 192.0.2.0/24, label: \"office\"}, ]\nb: {}\n",
+            `{"a":["10.0.0.1","::1",{"ip":"192.0.2.0/24","label":"office"}],"b":{}}`},
+        {"quoted scalars", `a: "tab\tand \"quote\" \u00fc"` + "\nb: 'it''s # not a comment'\n\"*.example.com\": x\n",
+            `{"a":"tab\tand \"quote\" ü","b":"it's # not a comment","*.example.com":"x"}`},
+        {"comments", "# rules\na: 1 # one\nb: x#y\n", `{"a":1,"b":"x#y"}`},
+        {"core schema", "a: [True, FALSE, ~, 010, -3, 2.5, 1e3, 1.0.0, 10m, 2024-07-01T00:00:00Z, 0x10]\n",
+            `{"a":[true,false,null,10,-3,2.5,1000,"1.0.0","10m","2024-07-01T00:00:00Z","0x10"]}`},
+        {"plain values with colons", "a: http://example.com/x\nb: 2001:db8::/32\n", `{"a":"http://example.com/x","b":"2001:db8::/32"}`},
+        {"document markers", "---\na: 1\n...\n", `{"a":1}`},
+        {"CRLF", "a:\r\n  - x\r\n", `{"a":["x"]}`},
+    }
+    for _, tt := range tests {
+        out, err := yamlToJSON([]byte(tt.yaml))
+        if err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+            continue
+        }
+        var compact bytes.Buffer
+        if len(out) > 0 {
+            if err := json.Compact(&compact, out); err != nil {
+                t.Errorf("%s: invalid JSON %s: %v", tt.name, out, err)
+                continue
+            }
+        }
+        if compact.String() != tt.want {
+            t.Errorf("%s: got %s, want %s", tt.name, compact.String(), tt.want)
+        }
+    }
+}
+
+func TestYAMLToJSONKeepsLines(t *testing.T) {
+    doc := "# header\n\na:\n  - x\n\n  - y: 1\n    z: [2, 3]\nb: 'q'\n"
+    out, err := yamlToJSON([]byte(doc))
+    if err != nil {
+        t.Fatal(err)
+    }
+    for token, line := range map[string]int{`"a"`: 3, `"x"`: 4, `"y"`: 6, `"z"`: 7, `"b"`: 8, `"q"`: 8} {
+        i := bytes.Index(out, []byte(token))
+        if got, _ := lineColumn(out, int64(i)); i < 0 || got != line {
+            t.Errorf("%s on line %d of %q, want %d", token, got, out, line)
+        }
+    }
+}
+
+func TestYAMLToJSONErrors(t *testing.T) {
+    tests := []struct {
+        name, yaml string
+        line       int
+        want       string
+    }{
+        {"tab indentation", "a:\n\t- x\n", 2, "tabs"},
+        {"bad indentation", "a: 1\n  b: 2\n", 2, "indentation"},
+        {"duplicate key", "a: 1\nb: 2\na: 3\n", 3, "duplicate key"},
+        {"not a key", "a: 1\njust text\n", 2, "key: value"},
+        {"alias", "a: *ref\n", 1, "aliases"},
+        {"unquoted wildcard key", "*.example.com:\n  sourceIPs: []\n", 1, "quote"},
+        {"anchor", "a: &x 1\n", 1, "anchors"},
+        {"tag", "a: !!str 1\n", 1, "tags"},
+        {"block scalar", "a: |\n  text\n", 1, "block scalars"},
+        {"reserved indicator", "a: @office\n", 1, "quote"},
+        {"multi-line flow", "a: [x,\n  y]\n", 1, "not closed"},
+        {"unterminated quote", "a: \"x\n", 1, "unterminated"},
+        {"bad escape", `a: "\q"` + "\n", 1, "escape"},
+        {"text after a quoted value", "a: 'x' y\n", 1, "after the value"},
+        {"multiple documents", "a: 1\n---\nb: 2\n", 2, "multiple documents"},
+        {"content after the end", "a: 1\n...\nb: 2\n", 3, "after the end"},
+        {"missing flow separator", "a: ['x' 'y']\n", 1, "expected \",\""},
+        {"missing flow key colon", "a: {x, y: 1}\n", 1, "missing \":\""},
+    }
+    for _, tt := range tests {
+        _, err := yamlToJSON([]byte(tt.yaml))
+        syntaxErr, ok := err.(*yamlSyntaxError)
+        if !ok || syntaxErr.Line != tt.line || !strings.Contains(err.Error(), tt.want) {
+            t.Errorf("%s: got %v, want an error on line %d about %q", tt.name, err, tt.line, tt.want)
+        }
+    }
+}
+
+func TestYAMLRulesFile(t *testing.T) {
+    dir := t.TempDir()
+    write := func(name, content string) {
+        if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+            t.Fatal(err)
+        }
+    }
+    write("rules.yaml", `# Managed by the infrastructure team.
+example.com:
+  sourceIPs:
+    - 192.0.2.0/24
+    - ip: 203.0.113.88
+      label: monitoring-vm
+  pathRules:
+    - path: /admin
+      sourceIPs: [192.0.2.1]
+"*.example.org":
+  sourceIPs: [10.0.0.0/8]
+  denyStatus: 404
+`)
+
+    config := CreateConfig()
+    config.RulesFile = "rules.yaml"
+    config.RulesBaseDir = dir
+    checkStatuses(t, newTestSentinel(t, config), []statusCase{
+        {"http://example.com/", "192.0.2.5:1234", http.StatusOK},
+        {"http://example.com/", "203.0.113.88:1234", http.StatusOK},
+        {"http://example.com/", "198.51.100.1:1234", http.StatusForbidden},
+        {"http://example.com/admin", "192.0.2.1:1234", http.StatusOK},
+        {"http://example.com/admin", "192.0.2.5:1234", http.StatusForbidden},
+        {"http://www.example.org/", "10.1.2.3:1234", http.StatusOK},
+        {"http://www.example.org/", "198.51.100.1:1234", http.StatusNotFound},
+    })
+
+    tests := []struct {
+        name, content, want string
+    }{
+        {"unknown field", "example.com:\n  sourceIPs: [192.0.2.0/24]\n  sourceIP: [10.0.0.1]\n", `rules.yml:3: json: unknown field "sourceIP"`},
+        {"wrong type", "example.com:\n  sourceIPs: [192.0.2.0/24]\n  denyStatus: forbidden\n", "rules.yml:3: json: cannot unmarshal"},
+        {"syntax", "example.com:\n  sourceIPs:\n  \t- 192.0.2.1\n", "rules.yml:3: tabs"},
+        {"empty", "# nothing yet\n", "rules.yml: empty file"},
+        {"not a mapping", "- example.com\n", "rules.yml:1: json: cannot unmarshal array"},
+    }
+    for _, tt := range tests {
+        write("rules.yml", tt.content)
+        config := CreateConfig()
+        config.RulesFile = filepath.Join(dir, "rules.yml")
+        if _, err := New(context.Background(), okHandler, config, "test"); err == nil || !strings.Contains(err.Error(), tt.want) {
+            t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+        }
+    }
+}